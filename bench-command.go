@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"writing-in-interpreter-in-go/src/monkey/benchmark"
+	"writing-in-interpreter-in-go/src/monkey/engine"
+)
+
+func benchCommand(args []string) error {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	engines := flags.String("engine", "", "comma-separated `engines` to run, among eval, vm -O0, vm -O1, vm -O2 and regvm (default all)")
+	warmup := flags.Int("warmup", 1, "untimed runs before the timed ones")
+	count := flags.Int("count", 5, "timed runs of each script")
+	asJSON := flags.Bool("json", false, "print results as JSON, one per line, with durations in nanoseconds")
+	_ = flags.Parse(args)
+
+	selected, err := selectEngines(*engines)
+	if err != nil {
+		return err
+	}
+	scripts := benchmark.Suite()
+	if flags.NArg() > 0 {
+		scripts, err = findScripts(flags.Args())
+		if err != nil {
+			return err
+		}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	options := benchmark.Options{Warmup: *warmup, Iterations: *count}
+	for _, script := range scripts {
+		for _, engine := range selected {
+			result, err := benchmark.Run(script, engine, options)
+			if err != nil {
+				return err
+			}
+			if *asJSON {
+				_ = encoder.Encode(result)
+				continue
+			}
+			fmt.Printf("%-20s\t%-6s\t%5d\t%12s ± %s\n", result.Script, result.Engine, result.Iterations,
+				result.Mean.Round(time.Microsecond), result.StdDev.Round(time.Microsecond))
+		}
+	}
+	return nil
+}
+
+// selectEngines returns the engines named in list, or all of them when it
+// is empty.
+func selectEngines(list string) ([]engine.Engine, error) {
+	if list == "" {
+		return engine.Engines, nil
+	}
+	var selected []engine.Engine
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, engine := range engine.Engines {
+			if engine.Name == name {
+				selected = append(selected, engine)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown engine %q", name)
+		}
+	}
+	return selected, nil
+}
+
+// findScripts reads the files among paths and the .mk files in the
+// directories under them.
+func findScripts(roots []string) ([]benchmark.Script, error) {
+	var scripts []benchmark.Script
+	for _, root := range roots {
+		err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() || (path != root && filepath.Ext(path) != ".mk") {
+				return nil
+			}
+			source, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			scripts = append(scripts, benchmark.Script{Name: path, Source: string(source)})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return scripts, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"writing-in-interpreter-in-go/src/monkey/compiler"
+	"writing-in-interpreter-in-go/src/monkey/vm"
+)
+
+func buildCommand(args []string) error {
+	flags := flag.NewFlagSet("build", flag.ExitOnError)
+	optimizationLevel := flags.Int("O", 0, "optimization level (0, 1 or 2)")
+	output := flags.String("o", "", "output file (default: the source file with the extension .mbc)")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: monkey build [-O level] [-o output] file")
+	}
+	path := flags.Arg(0)
+	if *output == "" {
+		*output = strings.TrimSuffix(path, filepath.Ext(path)) + ".mbc"
+	}
+
+	program, err := parseFile(path)
+	if err != nil {
+		return err
+	}
+	c := compiler.New()
+	c.SetOptimizationLevel(*optimizationLevel)
+	err = c.Compile(program)
+	if err != nil {
+		return fmt.Errorf("%s: compilation failed:\n%s", path, err)
+	}
+
+	return writeFile(*output, c.ByteCode().Encode)
+}
+
+// writeFile creates the file at path and writes it with write.
+func writeFile(path string, write func(w io.Writer) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = write(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// isByteCodeFile reports whether path names a file written by monkey build.
+func isByteCodeFile(path string) bool {
+	return filepath.Ext(path) == ".mbc"
+}
+
+// loadByteCode reads and verifies a bytecode file.
+func loadByteCode(path string) (*compiler.ByteCode, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	byteCode, err := vm.Load(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return byteCode, nil
+}
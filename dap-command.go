@@ -0,0 +1,13 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"writing-in-interpreter-in-go/src/monkey/dap"
+)
+
+func dapCommand(args []string) error {
+	flags := flag.NewFlagSet("dap", flag.ExitOnError)
+	_ = flags.Parse(args)
+	return dap.NewServer(os.Stdin, os.Stdout).Serve()
+}
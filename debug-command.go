@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"writing-in-interpreter-in-go/src/monkey/compiler"
+	"writing-in-interpreter-in-go/src/monkey/vm"
+)
+
+const debugHelp = `commands:
+  break [line]     set a breakpoint, or list them
+  clear line       remove a breakpoint
+  continue, c      run until a breakpoint
+  step, s          run to the next line, entering calls
+  next, n          run to the next line of this function or a caller
+  out, o           run to the next line of a caller
+  backtrace, bt    list the calls in progress
+  frame, f n       select the nth call of the backtrace
+  list, l          show the source around the selected call
+  locals           show the locals and free variables of the selected call
+  globals          show the globals
+  print, p expr    evaluate an expression in the selected call
+  quit, q          end the program
+`
+
+func debugCommand(args []string) error {
+	flags := flag.NewFlagSet("debug", flag.ExitOnError)
+	optimizationLevel := flags.Int("O", 0, "optimization level (0, 1 or 2)")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: monkey debug [-O level] file")
+	}
+	path := flags.Arg(0)
+
+	var byteCode *compiler.ByteCode
+	var source []string
+	if isByteCodeFile(path) {
+		var err error
+		byteCode, err = loadByteCode(path)
+		if err != nil {
+			return err
+		}
+	} else {
+		program, err := parseFile(path)
+		if err != nil {
+			return err
+		}
+		c := compiler.New()
+		c.SetOptimizationLevel(*optimizationLevel)
+		err = c.Compile(program)
+		if err != nil {
+			return fmt.Errorf("%s: compilation failed:\n%s", path, err)
+		}
+		byteCode = c.ByteCode()
+		text, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		source = strings.Split(string(text), "\n")
+	}
+
+	session := &debugSession{path: path, source: source, in: bufio.NewScanner(os.Stdin), out: os.Stdout}
+	session.debugger = vm.NewDebugger(byteCode, session.pause)
+	session.debugger.Interrupt()
+	virtualMachine := vm.New(byteCode)
+	virtualMachine.SetDebugger(session.debugger)
+	err := virtualMachine.Run()
+	if err != nil && !errors.Is(err, vm.ErrTerminated) {
+		return fmt.Errorf("%s: executing bytecode failed: %s", path, err)
+	}
+	return nil
+}
+
+// debugSession reads debugger commands whenever the program pauses.
+type debugSession struct {
+	path     string
+	source   []string
+	in       *bufio.Scanner
+	out      io.Writer
+	debugger *vm.Debugger
+	stop     *vm.Stop
+	// frame is the call selected in the backtrace.
+	frame int
+}
+
+func (session *debugSession) pause(stop *vm.Stop) vm.StepMode {
+	session.stop = stop
+	session.frame = 0
+	frame := stop.Frames()[0]
+	fmt.Fprintf(session.out, "%s at %s:%d in %s\n", stop.Reason, session.path, frame.Position.Line, frame.Function)
+	session.showLine(frame.Position.Line)
+
+	for {
+		fmt.Fprint(session.out, "(debug) ")
+		if !session.in.Scan() {
+			fmt.Fprintln(session.out)
+			return vm.Terminate
+		}
+		command, argument, _ := strings.Cut(strings.TrimSpace(session.in.Text()), " ")
+		argument = strings.TrimSpace(argument)
+		switch command {
+		case "":
+		case "continue", "c":
+			return vm.Continue
+		case "step", "s":
+			return vm.StepIn
+		case "next", "n":
+			return vm.StepOver
+		case "out", "o":
+			return vm.StepOut
+		case "quit", "q":
+			return vm.Terminate
+		case "break", "b":
+			session.setBreakpoint(argument)
+		case "clear":
+			line, err := strconv.Atoi(argument)
+			if err != nil {
+				fmt.Fprintln(session.out, "usage: clear line")
+				continue
+			}
+			session.debugger.ClearBreakpoint(line)
+		case "backtrace", "bt":
+			for i, frame := range stop.Frames() {
+				fmt.Fprintf(session.out, "#%d %s at %s:%d\n", i, frame.Function, session.path, frame.Position.Line)
+			}
+		case "frame", "f":
+			index, err := strconv.Atoi(argument)
+			if err != nil || index < 0 || index >= len(stop.Frames()) {
+				fmt.Fprintf(session.out, "usage: frame n, with n from 0 to %d\n", len(stop.Frames())-1)
+				continue
+			}
+			session.frame = index
+			frame := stop.Frames()[index]
+			fmt.Fprintf(session.out, "#%d %s at %s:%d\n", index, frame.Function, session.path, frame.Position.Line)
+		case "list", "l":
+			line := stop.Frames()[session.frame].Position.Line
+			for number := max(line-5, 1); number <= line+5; number++ {
+				session.showLine(number)
+			}
+		case "locals":
+			for _, variable := range stop.Locals(session.frame) {
+				fmt.Fprintf(session.out, "%s = %s\n", variable.Name, variable.Value.Inspect())
+			}
+			for _, variable := range stop.FreeVariables(session.frame) {
+				fmt.Fprintf(session.out, "%s = %s (free)\n", variable.Name, variable.Value.Inspect())
+			}
+		case "globals":
+			for _, variable := range stop.Globals() {
+				fmt.Fprintf(session.out, "%s = %s\n", variable.Name, variable.Value.Inspect())
+			}
+		case "print", "p":
+			value, err := stop.Evaluate(session.frame, argument)
+			if err != nil {
+				fmt.Fprintf(session.out, "error: %s\n", err)
+				continue
+			}
+			fmt.Fprintln(session.out, value.Inspect())
+		case "help", "h":
+			fmt.Fprint(session.out, debugHelp)
+		default:
+			fmt.Fprintf(session.out, "unknown command %q; type help for a list\n", command)
+		}
+	}
+}
+
+func (session *debugSession) setBreakpoint(argument string) {
+	if argument == "" {
+		for _, line := range session.debugger.Breakpoints() {
+			fmt.Fprintf(session.out, "%s:%d\n", session.path, line)
+		}
+		return
+	}
+	line, err := strconv.Atoi(argument)
+	if err != nil || line < 1 {
+		fmt.Fprintln(session.out, "usage: break [line]")
+		return
+	}
+	session.debugger.SetBreakpoint(line)
+}
+
+// showLine prints a line of the source, marking the one the selected call
+// is on.
+func (session *debugSession) showLine(number int) {
+	if number < 1 || number > len(session.source) {
+		return
+	}
+	marker := " "
+	if number == session.stop.Frames()[session.frame].Position.Line {
+		marker = ">"
+	}
+	fmt.Fprintf(session.out, "%s%4d\t%s\n", marker, number, session.source[number-1])
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"writing-in-interpreter-in-go/src/monkey/compiler"
+)
+
+func disasmCommand(args []string) error {
+	flags := flag.NewFlagSet("disasm", flag.ExitOnError)
+	optimizationLevel := flags.Int("O", 0, "optimization level (0, 1 or 2)")
+	_ = flags.Parse(args)
+
+	for _, path := range flags.Args() {
+		if isByteCodeFile(path) {
+			byteCode, err := loadByteCode(path)
+			if err != nil {
+				return err
+			}
+			fmt.Print(compiler.Disassemble(byteCode))
+			continue
+		}
+
+		program, err := parseFile(path)
+		if err != nil {
+			return err
+		}
+
+		c := compiler.New()
+		c.SetOptimizationLevel(*optimizationLevel)
+		err = c.Compile(program)
+		if err != nil {
+			return fmt.Errorf("%s: compilation failed:\n%s", path, err)
+		}
+		fmt.Print(compiler.Disassemble(c.ByteCode()))
+	}
+	return nil
+}
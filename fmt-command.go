@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"writing-in-interpreter-in-go/src/monkey/format"
+)
+
+func fmtCommand(args []string) error {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := flags.Bool("w", false, "write the result to the source file instead of stdout")
+	_ = flags.Parse(args)
+
+	for _, path := range flags.Args() {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		formatted, err := format.Format(string(source))
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+
+		if !*write {
+			fmt.Print(formatted)
+			continue
+		}
+		if formatted == string(source) {
+			continue
+		}
+		err = os.WriteFile(path, []byte(formatted), 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
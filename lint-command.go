@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"writing-in-interpreter-in-go/src/monkey/lint"
+)
+
+func lintCommand(args []string) error {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	disable := flags.String("disable", "", "comma-separated rule IDs to skip: "+strings.Join(lint.Rules, ", "))
+	_ = flags.Parse(args)
+
+	disabled := make(map[string]bool)
+	for _, rule := range strings.Split(*disable, ",") {
+		disabled[strings.TrimSpace(rule)] = true
+	}
+
+	found := 0
+	for _, path := range flags.Args() {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		diagnostics, err := lint.LintSource(string(source))
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+
+		for _, diagnostic := range diagnostics {
+			if disabled[diagnostic.Rule] {
+				continue
+			}
+			fmt.Printf("%s:%s\n", path, diagnostic)
+			found++
+		}
+	}
+
+	if found > 0 {
+		return fmt.Errorf("found %d problem(s)", found)
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"writing-in-interpreter-in-go/src/monkey/lsp"
+)
+
+func lspCommand(args []string) error {
+	flags := flag.NewFlagSet("lsp", flag.ExitOnError)
+	_ = flags.Parse(args)
+	return lsp.NewServer(os.Stdin, os.Stdout).Serve()
+}
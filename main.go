@@ -7,7 +7,40 @@ import (
 	"writing-in-interpreter-in-go/src/monkey/repl"
 )
 
+var commands = map[string]func(args []string) error{
+	"bench":  benchCommand,
+	"build":  buildCommand,
+	"dap":    dapCommand,
+	"debug":  debugCommand,
+	"disasm": disasmCommand,
+	"fmt":    fmtCommand,
+	"lint":   lintCommand,
+	"lsp":    lspCommand,
+	"parse":  parseCommand,
+	"run":    runCommand,
+	"test":   testCommand,
+}
+
 func main() {
+	if len(os.Args) < 2 {
+		startRepl()
+		return
+	}
+
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		_, _ = fmt.Fprintf(os.Stderr, "unknown command %q\n", os.Args[1])
+		os.Exit(2)
+	}
+
+	err := command(os.Args[2:])
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func startRepl() {
 	currentUser, err := user.Current()
 	if err != nil {
 		panic(err)
@@ -15,5 +48,5 @@ func main() {
 	fmt.Printf("Hello %s! This is the Monkey programming language!\n",
 		currentUser.Username)
 	fmt.Printf("Feel free to type in commands\n")
-	repl.StartRepl(os.Stdout, os.Stdin)
+	repl.StartRepl(os.Stdin, os.Stdout)
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"writing-in-interpreter-in-go/src/monkey/ast"
+	"writing-in-interpreter-in-go/src/monkey/lexer"
+	"writing-in-interpreter-in-go/src/monkey/parser"
+)
+
+func parseCommand(args []string) error {
+	flags := flag.NewFlagSet("parse", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the syntax tree as JSON")
+	_ = flags.Parse(args)
+
+	for _, path := range flags.Args() {
+		program, err := parseFile(path)
+		if err != nil {
+			return err
+		}
+
+		if !*asJSON {
+			fmt.Println(program.String())
+			continue
+		}
+
+		encoded, err := ast.EncodeJSON(program)
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		fmt.Println(string(encoded))
+	}
+	return nil
+}
+
+func parseFile(path string) (*ast.Program, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := parser.New(lexer.New(string(source)))
+	program := p.ParseProgram()
+	if len(p.Errors) != 0 {
+		return nil, fmt.Errorf("%s: parser errors:\n\t%s", path, strings.Join(p.Errors, "\n\t"))
+	}
+	return program, nil
+}
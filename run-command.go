@@ -0,0 +1,154 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"writing-in-interpreter-in-go/src/monkey/ast"
+	"writing-in-interpreter-in-go/src/monkey/compiler"
+	"writing-in-interpreter-in-go/src/monkey/evaluator"
+	"writing-in-interpreter-in-go/src/monkey/object"
+	"writing-in-interpreter-in-go/src/monkey/vm"
+	"writing-in-interpreter-in-go/src/monkey/vm/register"
+)
+
+func runCommand(args []string) error {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	optimizationLevel := flags.Int("O", 0, "optimization level for the vm engine (0, 1 or 2)")
+	engine := flags.String("engine", "vm", "execution engine: vm, regvm or eval")
+	var profiles profiles
+	flags.StringVar(&profiles.cpu, "cpuprofile", "", "write a pprof profile of time and calls per function to `file` (vm engine)")
+	flags.StringVar(&profiles.memory, "memprofile", "", "write a pprof profile of allocations by object type to `file` (vm engine)")
+	trace := flags.Bool("trace", false, "print every instruction or node executed, call and return to standard error (vm and eval engines)")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: monkey run [-O level] [-engine vm|regvm|eval] [-cpuprofile file] [-memprofile file] [-trace] file")
+	}
+	path := flags.Arg(0)
+	if *engine != "vm" && profiles.enabled() {
+		return fmt.Errorf("profiles are only recorded by the vm engine")
+	}
+	if *engine == "regvm" && *trace {
+		return fmt.Errorf("the regvm engine cannot be traced")
+	}
+
+	if isByteCodeFile(path) {
+		if *engine != "vm" {
+			return fmt.Errorf("%s: bytecode files run on the vm engine", path)
+		}
+		return runByteCodeFile(path, profiles, *trace)
+	}
+
+	program, err := parseFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch *engine {
+	case "vm":
+		return runVm(path, program, *optimizationLevel, profiles, *trace)
+	case "regvm":
+		return runRegisterVm(path, program)
+	case "eval":
+		return runEvaluator(path, program, *trace)
+	default:
+		return fmt.Errorf("unknown engine %q", *engine)
+	}
+}
+
+// profiles names the files monkey run writes profiles to.
+type profiles struct {
+	cpu    string
+	memory string
+}
+
+func (profiles profiles) enabled() bool {
+	return profiles.cpu != "" || profiles.memory != ""
+}
+
+func runVm(path string, program *ast.Program, optimizationLevel int, profiles profiles, trace bool) error {
+	c := compiler.New()
+	c.SetOptimizationLevel(optimizationLevel)
+	err := c.Compile(program)
+	if err != nil {
+		return fmt.Errorf("%s: compilation failed:\n%s", path, err)
+	}
+	return runByteCode(path, c.ByteCode(), profiles, trace)
+}
+
+func runByteCodeFile(path string, profiles profiles, trace bool) error {
+	byteCode, err := loadByteCode(path)
+	if err != nil {
+		return err
+	}
+	return runByteCode(path, byteCode, profiles, trace)
+}
+
+// runByteCode runs byteCode compiled from path, writing the profiles asked
+// for even when the program fails.
+func runByteCode(path string, byteCode *compiler.ByteCode, profiles profiles, trace bool) error {
+	virtualMachine := vm.New(byteCode)
+	profiler := vm.NewProfiler()
+	profiler.File = path
+	if profiles.enabled() {
+		virtualMachine.SetProfiler(profiler)
+	}
+	if trace {
+		virtualMachine.SetTracer(vm.NewTraceWriter(os.Stderr))
+	}
+	runErr := virtualMachine.Run()
+
+	for _, profile := range []struct {
+		path  string
+		write func(w io.Writer) error
+	}{
+		{profiles.cpu, profiler.WriteCPUProfile},
+		{profiles.memory, profiler.WriteAllocationProfile},
+	} {
+		if profile.path == "" {
+			continue
+		}
+		err := writeFile(profile.path, profile.write)
+		if err != nil {
+			return err
+		}
+	}
+	if runErr != nil {
+		return fmt.Errorf("%s: executing bytecode failed: %s", path, runErr)
+	}
+	return nil
+}
+
+func runRegisterVm(path string, program *ast.Program) error {
+	c := register.NewCompiler()
+	err := c.Compile(program)
+	if err != nil {
+		return fmt.Errorf("%s: compilation failed:\n%s", path, err)
+	}
+
+	err = register.New(c.Program()).Run()
+	if err != nil {
+		return fmt.Errorf("%s: executing register code failed: %s", path, err)
+	}
+	return nil
+}
+
+func runEvaluator(path string, program *ast.Program, trace bool) error {
+	macroEnvironment := object.NewEnvironment()
+	evaluator.DefineMacros(program, macroEnvironment)
+	expanded := evaluator.ExpandMacros(program, macroEnvironment)
+	environment := object.NewEnvironment()
+	if trace {
+		evaluator.SetTracer(environment, evaluator.NewTraceWriter(os.Stderr))
+	}
+
+	switch result := evaluator.Eval(expanded, environment).(type) {
+	case *object.Error:
+		return fmt.Errorf("%s: %s", path, result.Message)
+	case *object.Stop:
+		return fmt.Errorf("%s: stopped: %s", path, result.Message)
+	}
+	return nil
+}
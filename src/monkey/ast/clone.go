@@ -0,0 +1,142 @@
+package ast
+
+import "writing-in-interpreter-in-go/src/monkey/token"
+
+// Clone returns a deep copy of node, so the copy can be modified without
+// touching the original tree.
+func Clone(node Node) Node {
+	if isNilNode(node) {
+		return node
+	}
+
+	switch node := node.(type) {
+	case *Program:
+		return &Program{Statements: cloneStatements(node.Statements)}
+	case *BlockStatement:
+		return cloneBlockStatement(node)
+	case *ExpressionStatement:
+		return &ExpressionStatement{
+			Token:      cloneToken(node.Token),
+			Expression: cloneExpression(node.Expression),
+		}
+	case *LetStatement:
+		identifier, _ := Clone(node.Identifier).(*Identifier)
+		return &LetStatement{
+			Token:      cloneToken(node.Token),
+			Identifier: identifier,
+			Value:      cloneExpression(node.Value),
+		}
+	case *ReturnStatement:
+		return &ReturnStatement{
+			Token:       cloneToken(node.Token),
+			ReturnValue: cloneExpression(node.ReturnValue),
+		}
+	case *Identifier:
+		return &Identifier{Token: cloneToken(node.Token), Value: node.Value}
+	case *IntegerLiteral:
+		return &IntegerLiteral{Token: cloneToken(node.Token), Value: node.Value}
+	case *StringLiteral:
+		return &StringLiteral{Token: cloneToken(node.Token), Value: node.Value}
+	case *BooleanExpression:
+		return &BooleanExpression{Token: cloneToken(node.Token), Value: node.Value}
+	case *PrefixExpression:
+		return &PrefixExpression{
+			Token:    cloneToken(node.Token),
+			Operator: node.Operator,
+			Operand:  cloneExpression(node.Operand),
+		}
+	case *InfixExpression:
+		return &InfixExpression{
+			Token:    cloneToken(node.Token),
+			Left:     cloneExpression(node.Left),
+			Operator: node.Operator,
+			Right:    cloneExpression(node.Right),
+		}
+	case *IfExpression:
+		return &IfExpression{
+			Token:       cloneToken(node.Token),
+			Condition:   cloneExpression(node.Condition),
+			Consequence: cloneBlockStatement(node.Consequence),
+			Alternative: cloneBlockStatement(node.Alternative),
+		}
+	case *FunctionLiteral:
+		return &FunctionLiteral{
+			Token:      node.Token,
+			Parameters: cloneExpressions(node.Parameters),
+			Body:       cloneBlockStatement(node.Body),
+			Name:       node.Name,
+		}
+	case *MacroLiteral:
+		return &MacroLiteral{
+			Token:      cloneToken(node.Token),
+			Parameters: cloneExpressions(node.Parameters),
+			Body:       cloneBlockStatement(node.Body),
+		}
+	case *CallExpression:
+		return &CallExpression{
+			Function:  cloneExpression(node.Function),
+			Token:     node.Token,
+			Arguments: cloneExpressions(node.Arguments),
+		}
+	case *ArrayLiteral:
+		return &ArrayLiteral{
+			Token:    cloneToken(node.Token),
+			Elements: cloneExpressions(node.Elements),
+		}
+	case *IndexExpression:
+		return &IndexExpression{
+			Token:      cloneToken(node.Token),
+			Expression: cloneExpression(node.Expression),
+			Index:      cloneExpression(node.Index),
+		}
+	}
+
+	return node
+}
+
+func cloneToken(t *token.Token) *token.Token {
+	if t == nil {
+		return nil
+	}
+	cloned := *t
+	return &cloned
+}
+
+func cloneBlockStatement(block *BlockStatement) *BlockStatement {
+	if block == nil {
+		return nil
+	}
+	return &BlockStatement{Token: block.Token, Statements: cloneStatements(block.Statements)}
+}
+
+func cloneExpression(expression Expression) Expression {
+	if expression == nil {
+		return nil
+	}
+	cloned, _ := Clone(expression).(Expression)
+	return cloned
+}
+
+func cloneExpressions(expressions []Expression) []Expression {
+	if expressions == nil {
+		return nil
+	}
+	cloned := make([]Expression, len(expressions))
+	for i, expression := range expressions {
+		cloned[i] = cloneExpression(expression)
+	}
+	return cloned
+}
+
+func cloneStatements(statements []Statement) []Statement {
+	if statements == nil {
+		return nil
+	}
+	cloned := make([]Statement, len(statements))
+	for i, statement := range statements {
+		if statement != nil {
+			cloned[i], _ = Clone(statement).(Statement)
+		}
+	}
+	return cloned
+}
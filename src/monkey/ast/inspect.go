@@ -0,0 +1,58 @@
+package ast
+
+// Inspect walks the tree depth-first and calls visit for every node. The
+// children of a node are skipped when visit returns false.
+func Inspect(node Node, visit func(node Node) bool) {
+	if isNilNode(node) || !visit(node) {
+		return
+	}
+
+	switch node := node.(type) {
+	case *Program:
+		for _, statement := range node.Statements {
+			Inspect(statement, visit)
+		}
+	case *BlockStatement:
+		for _, statement := range node.Statements {
+			Inspect(statement, visit)
+		}
+	case *ExpressionStatement:
+		Inspect(node.Expression, visit)
+	case *LetStatement:
+		Inspect(node.Identifier, visit)
+		Inspect(node.Value, visit)
+	case *ReturnStatement:
+		Inspect(node.ReturnValue, visit)
+	case *PrefixExpression:
+		Inspect(node.Operand, visit)
+	case *InfixExpression:
+		Inspect(node.Left, visit)
+		Inspect(node.Right, visit)
+	case *IfExpression:
+		Inspect(node.Condition, visit)
+		Inspect(node.Consequence, visit)
+		Inspect(node.Alternative, visit)
+	case *FunctionLiteral:
+		for _, parameter := range node.Parameters {
+			Inspect(parameter, visit)
+		}
+		Inspect(node.Body, visit)
+	case *MacroLiteral:
+		for _, parameter := range node.Parameters {
+			Inspect(parameter, visit)
+		}
+		Inspect(node.Body, visit)
+	case *CallExpression:
+		Inspect(node.Function, visit)
+		for _, argument := range node.Arguments {
+			Inspect(argument, visit)
+		}
+	case *ArrayLiteral:
+		for _, element := range node.Elements {
+			Inspect(element, visit)
+		}
+	case *IndexExpression:
+		Inspect(node.Expression, visit)
+		Inspect(node.Index, visit)
+	}
+}
@@ -0,0 +1,462 @@
+package ast
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"writing-in-interpreter-in-go/src/monkey/token"
+)
+
+type jsonToken struct {
+	Type    token.Type `json:"type"`
+	Literal string     `json:"literal"`
+	Line    int        `json:"line"`
+	Column  int        `json:"column"`
+}
+
+// jsonNode is the serialised form of every node kind. Only the fields that
+// belong to Kind are set; Value holds a literal for Identifier, IntegerLiteral,
+// StringLiteral and BooleanExpression and a child node for LetStatement.
+type jsonNode struct {
+	Kind        string          `json:"kind"`
+	Token       *jsonToken      `json:"token,omitempty"`
+	Name        string          `json:"name,omitempty"`
+	Operator    string          `json:"operator,omitempty"`
+	Identifier  *jsonNode       `json:"identifier,omitempty"`
+	Value       json.RawMessage `json:"value,omitempty"`
+	Statements  []*jsonNode     `json:"statements,omitempty"`
+	Expression  *jsonNode       `json:"expression,omitempty"`
+	ReturnValue *jsonNode       `json:"returnValue,omitempty"`
+	Operand     *jsonNode       `json:"operand,omitempty"`
+	Left        *jsonNode       `json:"left,omitempty"`
+	Right       *jsonNode       `json:"right,omitempty"`
+	Condition   *jsonNode       `json:"condition,omitempty"`
+	Consequence *jsonNode       `json:"consequence,omitempty"`
+	Alternative *jsonNode       `json:"alternative,omitempty"`
+	Parameters  []*jsonNode     `json:"parameters,omitempty"`
+	Body        *jsonNode       `json:"body,omitempty"`
+	Function    *jsonNode       `json:"function,omitempty"`
+	Arguments   []*jsonNode     `json:"arguments,omitempty"`
+	Elements    []*jsonNode     `json:"elements,omitempty"`
+	Index       *jsonNode       `json:"index,omitempty"`
+}
+
+// EncodeJSON encodes a node with the kind, fields and token position of
+// every node. The result can be turned back into a program with
+// DecodeJSON.
+func EncodeJSON(node Node) ([]byte, error) {
+	encoded, err := toJSONNode(node)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(encoded, "", "  ")
+}
+
+// DecodeJSON decodes the output of EncodeJSON. Tokens may be
+// omitted by generating tools, in which case they are derived from the node.
+func DecodeJSON(data []byte) (*Program, error) {
+	var decoded jsonNode
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+	node, err := fromJSONNode(&decoded)
+	if err != nil {
+		return nil, err
+	}
+	program, ok := node.(*Program)
+	if !ok {
+		return nil, fmt.Errorf("expected Program node, got %s", decoded.Kind)
+	}
+	return program, nil
+}
+
+func toJSONNode(node Node) (*jsonNode, error) {
+	if isNilNode(node) {
+		return nil, nil
+	}
+
+	var err error
+	switch node := node.(type) {
+	case *Program:
+		encoded := &jsonNode{Kind: "Program"}
+		encoded.Statements, err = toJSONStatements(node.Statements)
+		return encoded, err
+	case *BlockStatement:
+		encoded := &jsonNode{Kind: "BlockStatement", Token: toJSONToken(&node.Token)}
+		encoded.Statements, err = toJSONStatements(node.Statements)
+		return encoded, err
+	case *ExpressionStatement:
+		encoded := &jsonNode{Kind: "ExpressionStatement", Token: toJSONToken(node.Token)}
+		encoded.Expression, err = toJSONNode(node.Expression)
+		return encoded, err
+	case *LetStatement:
+		encoded := &jsonNode{Kind: "LetStatement", Token: toJSONToken(node.Token)}
+		if encoded.Identifier, err = toJSONNode(node.Identifier); err != nil {
+			return nil, err
+		}
+		value, err := toJSONNode(node.Value)
+		if err != nil || value == nil {
+			return encoded, err
+		}
+		encoded.Value, err = json.Marshal(value)
+		return encoded, err
+	case *ReturnStatement:
+		encoded := &jsonNode{Kind: "ReturnStatement", Token: toJSONToken(node.Token)}
+		encoded.ReturnValue, err = toJSONNode(node.ReturnValue)
+		return encoded, err
+	case *Identifier:
+		return toJSONLiteral("Identifier", node.Token, node.Value)
+	case *IntegerLiteral:
+		return toJSONLiteral("IntegerLiteral", node.Token, node.Value)
+	case *StringLiteral:
+		return toJSONLiteral("StringLiteral", node.Token, node.Value)
+	case *BooleanExpression:
+		return toJSONLiteral("BooleanExpression", node.Token, node.Value)
+	case *PrefixExpression:
+		encoded := &jsonNode{Kind: "PrefixExpression", Token: toJSONToken(node.Token), Operator: node.Operator}
+		encoded.Operand, err = toJSONNode(node.Operand)
+		return encoded, err
+	case *InfixExpression:
+		encoded := &jsonNode{Kind: "InfixExpression", Token: toJSONToken(node.Token), Operator: node.Operator}
+		if encoded.Left, err = toJSONNode(node.Left); err != nil {
+			return nil, err
+		}
+		encoded.Right, err = toJSONNode(node.Right)
+		return encoded, err
+	case *IfExpression:
+		encoded := &jsonNode{Kind: "IfExpression", Token: toJSONToken(node.Token)}
+		if encoded.Condition, err = toJSONNode(node.Condition); err != nil {
+			return nil, err
+		}
+		if encoded.Consequence, err = toJSONNode(node.Consequence); err != nil {
+			return nil, err
+		}
+		encoded.Alternative, err = toJSONNode(node.Alternative)
+		return encoded, err
+	case *FunctionLiteral:
+		encoded := &jsonNode{Kind: "FunctionLiteral", Token: toJSONToken(&node.Token), Name: node.Name}
+		if encoded.Parameters, err = toJSONExpressions(node.Parameters); err != nil {
+			return nil, err
+		}
+		encoded.Body, err = toJSONNode(node.Body)
+		return encoded, err
+	case *MacroLiteral:
+		encoded := &jsonNode{Kind: "MacroLiteral", Token: toJSONToken(node.Token)}
+		if encoded.Parameters, err = toJSONExpressions(node.Parameters); err != nil {
+			return nil, err
+		}
+		encoded.Body, err = toJSONNode(node.Body)
+		return encoded, err
+	case *CallExpression:
+		encoded := &jsonNode{Kind: "CallExpression", Token: toJSONToken(&node.Token)}
+		if encoded.Function, err = toJSONNode(node.Function); err != nil {
+			return nil, err
+		}
+		encoded.Arguments, err = toJSONExpressions(node.Arguments)
+		return encoded, err
+	case *ArrayLiteral:
+		encoded := &jsonNode{Kind: "ArrayLiteral", Token: toJSONToken(node.Token)}
+		encoded.Elements, err = toJSONExpressions(node.Elements)
+		return encoded, err
+	case *IndexExpression:
+		encoded := &jsonNode{Kind: "IndexExpression", Token: toJSONToken(node.Token)}
+		if encoded.Expression, err = toJSONNode(node.Expression); err != nil {
+			return nil, err
+		}
+		encoded.Index, err = toJSONNode(node.Index)
+		return encoded, err
+	}
+
+	return nil, fmt.Errorf("cannot encode node of type %T", node)
+}
+
+func toJSONToken(t *token.Token) *jsonToken {
+	if t == nil {
+		return nil
+	}
+	return &jsonToken{Type: t.Type, Literal: t.Literal, Line: t.Line, Column: t.Column}
+}
+
+func toJSONLiteral(kind string, t *token.Token, value interface{}) (*jsonNode, error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonNode{Kind: kind, Token: toJSONToken(t), Value: raw}, nil
+}
+
+func toJSONStatements(statements []Statement) ([]*jsonNode, error) {
+	var encoded []*jsonNode
+	for _, statement := range statements {
+		node, err := toJSONNode(statement)
+		if err != nil {
+			return nil, err
+		}
+		if node != nil {
+			encoded = append(encoded, node)
+		}
+	}
+	return encoded, nil
+}
+
+func toJSONExpressions(expressions []Expression) ([]*jsonNode, error) {
+	encoded := make([]*jsonNode, 0, len(expressions))
+	for _, expression := range expressions {
+		node, err := toJSONNode(expression)
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, node)
+	}
+	return encoded, nil
+}
+
+func fromJSONNode(encoded *jsonNode) (Node, error) {
+	if encoded == nil {
+		return nil, nil
+	}
+
+	switch encoded.Kind {
+	case "Program":
+		statements, err := fromJSONStatements(encoded.Statements)
+		return &Program{Statements: statements}, err
+	case "BlockStatement":
+		return fromJSONBlockStatement(encoded)
+	case "ExpressionStatement":
+		expression, err := fromJSONRequiredExpression(encoded, "expression", encoded.Expression)
+		if err != nil {
+			return nil, err
+		}
+		return &ExpressionStatement{Token: fromJSONToken(encoded.Token, "", ""), Expression: expression}, nil
+	case "LetStatement":
+		identifierNode, err := fromJSONNode(encoded.Identifier)
+		if err != nil {
+			return nil, err
+		}
+		identifier, ok := identifierNode.(*Identifier)
+		if !ok {
+			return nil, fmt.Errorf("LetStatement needs an Identifier, got %T", identifierNode)
+		}
+		var valueNode *jsonNode
+		if len(encoded.Value) > 0 {
+			if err := json.Unmarshal(encoded.Value, &valueNode); err != nil {
+				return nil, err
+			}
+		}
+		value, err := fromJSONRequiredExpression(encoded, "value", valueNode)
+		if err != nil {
+			return nil, err
+		}
+		if function, ok := value.(*FunctionLiteral); ok && function.Name == "" {
+			function.Name = identifier.Value
+		}
+		return &LetStatement{
+			Token:      fromJSONToken(encoded.Token, token.LET, "let"),
+			Identifier: identifier,
+			Value:      value,
+		}, nil
+	case "ReturnStatement":
+		returnValue, err := fromJSONRequiredExpression(encoded, "returnValue", encoded.ReturnValue)
+		return &ReturnStatement{
+			Token:       fromJSONToken(encoded.Token, token.RETURN, "return"),
+			ReturnValue: returnValue,
+		}, err
+	case "Identifier":
+		var value string
+		if err := json.Unmarshal(encoded.Value, &value); err != nil {
+			return nil, fmt.Errorf("invalid Identifier value: %s", err)
+		}
+		return &Identifier{Token: fromJSONToken(encoded.Token, token.LookUpTokenType(value), value), Value: value}, nil
+	case "IntegerLiteral":
+		var value int64
+		if err := json.Unmarshal(encoded.Value, &value); err != nil {
+			return nil, fmt.Errorf("invalid IntegerLiteral value: %s", err)
+		}
+		literal := strconv.FormatInt(value, 10)
+		return &IntegerLiteral{Token: fromJSONToken(encoded.Token, token.INT, literal), Value: value}, nil
+	case "StringLiteral":
+		var value string
+		if err := json.Unmarshal(encoded.Value, &value); err != nil {
+			return nil, fmt.Errorf("invalid StringLiteral value: %s", err)
+		}
+		return &StringLiteral{Token: fromJSONToken(encoded.Token, token.STRING, value), Value: value}, nil
+	case "BooleanExpression":
+		var value bool
+		if err := json.Unmarshal(encoded.Value, &value); err != nil {
+			return nil, fmt.Errorf("invalid BooleanExpression value: %s", err)
+		}
+		literal := strconv.FormatBool(value)
+		return &BooleanExpression{Token: fromJSONToken(encoded.Token, token.LookUpTokenType(literal), literal), Value: value}, nil
+	case "PrefixExpression":
+		operand, err := fromJSONRequiredExpression(encoded, "operand", encoded.Operand)
+		return &PrefixExpression{
+			Token:    fromJSONToken(encoded.Token, token.Type(encoded.Operator), encoded.Operator),
+			Operator: encoded.Operator,
+			Operand:  operand,
+		}, err
+	case "InfixExpression":
+		left, err := fromJSONRequiredExpression(encoded, "left", encoded.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := fromJSONRequiredExpression(encoded, "right", encoded.Right)
+		return &InfixExpression{
+			Token:    fromJSONToken(encoded.Token, token.Type(encoded.Operator), encoded.Operator),
+			Left:     left,
+			Operator: encoded.Operator,
+			Right:    right,
+		}, err
+	case "IfExpression":
+		condition, err := fromJSONRequiredExpression(encoded, "condition", encoded.Condition)
+		if err != nil {
+			return nil, err
+		}
+		consequence, err := fromJSONRequiredBlockStatement(encoded, "consequence", encoded.Consequence)
+		if err != nil {
+			return nil, err
+		}
+		alternative, err := fromJSONBlockStatement(encoded.Alternative)
+		return &IfExpression{
+			Token:       fromJSONToken(encoded.Token, token.IF, "if"),
+			Condition:   condition,
+			Consequence: consequence,
+			Alternative: alternative,
+		}, err
+	case "FunctionLiteral":
+		parameters, err := fromJSONExpressions(encoded.Parameters)
+		if err != nil {
+			return nil, err
+		}
+		body, err := fromJSONRequiredBlockStatement(encoded, "body", encoded.Body)
+		return &FunctionLiteral{
+			Token:      *fromJSONToken(encoded.Token, token.FUNCTION, "fn"),
+			Parameters: parameters,
+			Body:       body,
+			Name:       encoded.Name,
+		}, err
+	case "MacroLiteral":
+		parameters, err := fromJSONExpressions(encoded.Parameters)
+		if err != nil {
+			return nil, err
+		}
+		body, err := fromJSONRequiredBlockStatement(encoded, "body", encoded.Body)
+		return &MacroLiteral{
+			Token:      fromJSONToken(encoded.Token, token.MACRO, "macro"),
+			Parameters: parameters,
+			Body:       body,
+		}, err
+	case "CallExpression":
+		function, err := fromJSONRequiredExpression(encoded, "function", encoded.Function)
+		if err != nil {
+			return nil, err
+		}
+		arguments, err := fromJSONExpressions(encoded.Arguments)
+		return &CallExpression{
+			Function:  function,
+			Token:     *fromJSONToken(encoded.Token, token.LPAREN, "("),
+			Arguments: arguments,
+		}, err
+	case "ArrayLiteral":
+		elements, err := fromJSONExpressions(encoded.Elements)
+		return &ArrayLiteral{
+			Token:    fromJSONToken(encoded.Token, token.LBRACKET, "["),
+			Elements: elements,
+		}, err
+	case "IndexExpression":
+		expression, err := fromJSONRequiredExpression(encoded, "expression", encoded.Expression)
+		if err != nil {
+			return nil, err
+		}
+		index, err := fromJSONRequiredExpression(encoded, "index", encoded.Index)
+		return &IndexExpression{
+			Token:      fromJSONToken(encoded.Token, token.LBRACKET, "["),
+			Expression: expression,
+			Index:      index,
+		}, err
+	}
+
+	return nil, fmt.Errorf("unknown node kind %q", encoded.Kind)
+}
+
+func fromJSONToken(encoded *jsonToken, tokenType token.Type, literal string) *token.Token {
+	if encoded == nil {
+		return &token.Token{Type: tokenType, Literal: literal}
+	}
+	return &token.Token{Type: encoded.Type, Literal: encoded.Literal, Line: encoded.Line, Column: encoded.Column}
+}
+
+func fromJSONBlockStatement(encoded *jsonNode) (*BlockStatement, error) {
+	if encoded == nil {
+		return nil, nil
+	}
+	if encoded.Kind != "BlockStatement" {
+		return nil, fmt.Errorf("expected BlockStatement, got %s", encoded.Kind)
+	}
+	statements, err := fromJSONStatements(encoded.Statements)
+	if err != nil {
+		return nil, err
+	}
+	return &BlockStatement{Token: *fromJSONToken(encoded.Token, token.LBRACE, "{"), Statements: statements}, nil
+}
+
+func fromJSONExpression(encoded *jsonNode) (Expression, error) {
+	node, err := fromJSONNode(encoded)
+	if err != nil || node == nil {
+		return nil, err
+	}
+	expression, ok := node.(Expression)
+	if !ok {
+		return nil, fmt.Errorf("expected an expression, got %s", encoded.Kind)
+	}
+	return expression, nil
+}
+
+// fromJSONRequiredExpression decodes the field of parent that every node of
+// its kind has.
+func fromJSONRequiredExpression(parent *jsonNode, field string, encoded *jsonNode) (Expression, error) {
+	if encoded == nil {
+		return nil, fmt.Errorf("%s has no %s", parent.Kind, field)
+	}
+	return fromJSONExpression(encoded)
+}
+
+func fromJSONRequiredBlockStatement(parent *jsonNode, field string, encoded *jsonNode) (*BlockStatement, error) {
+	if encoded == nil {
+		return nil, fmt.Errorf("%s has no %s", parent.Kind, field)
+	}
+	return fromJSONBlockStatement(encoded)
+}
+
+func fromJSONExpressions(encoded []*jsonNode) ([]Expression, error) {
+	var expressions []Expression
+	for _, node := range encoded {
+		if node == nil {
+			return nil, fmt.Errorf("expected an expression, got null")
+		}
+		expression, err := fromJSONExpression(node)
+		if err != nil {
+			return nil, err
+		}
+		expressions = append(expressions, expression)
+	}
+	return expressions, nil
+}
+
+func fromJSONStatements(encoded []*jsonNode) ([]Statement, error) {
+	statements := []Statement{}
+	for _, node := range encoded {
+		if node == nil {
+			return nil, fmt.Errorf("expected a statement, got null")
+		}
+		decoded, err := fromJSONNode(node)
+		if err != nil {
+			return nil, err
+		}
+		statement, ok := decoded.(Statement)
+		if !ok {
+			return nil, fmt.Errorf("expected a statement, got %s", node.Kind)
+		}
+		statements = append(statements, statement)
+	}
+	return statements, nil
+}
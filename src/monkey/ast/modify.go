@@ -22,7 +22,9 @@ func Modify(node Node, modifier ModifierFunc) Node {
 	case *IfExpression:
 		node.Condition = Modify(node.Condition, modifier).(Expression)
 		node.Consequence = Modify(node.Consequence, modifier).(*BlockStatement)
-		node.Alternative = Modify(node.Alternative, modifier).(*BlockStatement)
+		if node.Alternative != nil {
+			node.Alternative = Modify(node.Alternative, modifier).(*BlockStatement)
+		}
 	case *ReturnStatement:
 		node.ReturnValue, _ = Modify(node.ReturnValue, modifier).(Expression)
 	case *LetStatement:
@@ -32,6 +34,14 @@ func Modify(node Node, modifier ModifierFunc) Node {
 			node.Parameters[i] = Modify(node.Parameters[i], modifier).(Expression)
 		}
 		node.Body, _ = Modify(node.Body, modifier).(*BlockStatement)
+	case *CallExpression:
+		node.Function, _ = Modify(node.Function, modifier).(Expression)
+		for i := range node.Arguments {
+			node.Arguments[i], _ = Modify(node.Arguments[i], modifier).(Expression)
+		}
+	case *IndexExpression:
+		node.Expression, _ = Modify(node.Expression, modifier).(Expression)
+		node.Index, _ = Modify(node.Index, modifier).(Expression)
 	case *ArrayLiteral:
 		for i := range node.Elements {
 			node.Elements[i], _ = Modify(node.Elements[i], modifier).(Expression)
@@ -0,0 +1,75 @@
+package test
+
+import (
+	"testing"
+	"writing-in-interpreter-in-go/src/monkey/ast"
+	"writing-in-interpreter-in-go/src/monkey/lexer"
+	"writing-in-interpreter-in-go/src/monkey/parser"
+)
+
+func TestClone(t *testing.T) {
+	inputs := []string{
+		`let x = 5; x;`,
+		`return -a * b;`,
+		`if (x < y) { x } else { y }`,
+		`let add = fn(a, b) { a + b }; add(1, 2 * 3);`,
+		`let m = macro(a) { quote(unquote(a)) };`,
+		`[1, "two", true][0];`,
+	}
+
+	for _, input := range inputs {
+		program := parse(input)
+		cloned := ast.Clone(program)
+
+		if !ast.Equal(program, cloned) {
+			t.Errorf("clone not equal. got=%q, want=%q", cloned.String(), program.String())
+		}
+
+		ast.Modify(cloned, func(node ast.Node) ast.Node {
+			if identifier, ok := node.(*ast.Identifier); ok {
+				identifier.Value = "changed"
+			}
+			if integer, ok := node.(*ast.IntegerLiteral); ok {
+				integer.Value = 42
+			}
+			return node
+		})
+
+		if program.String() != parse(input).String() {
+			t.Errorf("modifying clone changed original. got=%q", program.String())
+		}
+	}
+}
+
+func TestEqual(t *testing.T) {
+	tests := []struct {
+		left     string
+		right    string
+		expected bool
+	}{
+		{`1 + 2`, `1 + 2`, true},
+		{`1 + 2`, `1  +  2;`, true},
+		{`1 + 2`, `1 - 2`, false},
+		{`1 + 2`, `2 + 1`, false},
+		{`let x = 1;`, `let y = 1;`, false},
+		{`fn(a) { a }`, `fn(a) { a }`, true},
+		{`fn(a) { a }`, `fn(a, b) { a }`, false},
+		{`if (a) { b }`, `if (a) { b } else { c }`, false},
+		{`f(1, 2)`, `f(1, 2)`, true},
+		{`"a"`, `"b"`, false},
+		{`x[0]`, `x[1]`, false},
+	}
+
+	for _, tt := range tests {
+		actual := ast.Equal(parse(tt.left), parse(tt.right))
+		if actual != tt.expected {
+			t.Errorf("Equal(%q, %q) wrong. got=%t, want=%t", tt.left, tt.right, actual, tt.expected)
+		}
+	}
+}
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
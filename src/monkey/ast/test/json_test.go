@@ -0,0 +1,132 @@
+package test
+
+import (
+	"strings"
+	"testing"
+	"writing-in-interpreter-in-go/src/monkey/ast"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	inputs := []string{
+		`let x = 5; x;`,
+		`return -a * b;`,
+		`if (x < y) { x } else { y }`,
+		`let add = fn(a, b) { a + b }; add(1, 2 * 3);`,
+		`let m = macro(a) { quote(unquote(a)) };`,
+		`[1, "two", true][0];`,
+		`let big = 9223372036854775807;`,
+	}
+
+	for _, input := range inputs {
+		program := parse(input)
+		encoded, err := ast.EncodeJSON(program)
+		if err != nil {
+			t.Fatalf("EncodeJSON(%q) failed: %s", input, err)
+		}
+
+		decoded, err := ast.DecodeJSON(encoded)
+		if err != nil {
+			t.Fatalf("DecodeJSON(%q) failed: %s", input, err)
+		}
+
+		if !ast.Equal(program, decoded) {
+			t.Errorf("round trip not equal. got=%q, want=%q", decoded.String(), program.String())
+		}
+		if decoded.String() != program.String() {
+			t.Errorf("round trip string wrong. got=%q, want=%q", decoded.String(), program.String())
+		}
+	}
+}
+
+func TestJSONPositions(t *testing.T) {
+	program := parse("let x = 1;\nx + 2;")
+	encoded, err := ast.EncodeJSON(program)
+	if err != nil {
+		t.Fatalf("EncodeJSON failed: %s", err)
+	}
+
+	decoded, err := ast.DecodeJSON(encoded)
+	if err != nil {
+		t.Fatalf("DecodeJSON failed: %s", err)
+	}
+
+	statement, ok := decoded.Statements[1].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("statement is not *ast.ExpressionStatement. got=%T", decoded.Statements[1])
+	}
+	infix, ok := statement.Expression.(*ast.InfixExpression)
+	if !ok {
+		t.Fatalf("expression is not *ast.InfixExpression. got=%T", statement.Expression)
+	}
+	if infix.Token.Line != 2 || infix.Token.Column != 3 {
+		t.Errorf("wrong position. got=%d:%d, want=2:3", infix.Token.Line, infix.Token.Column)
+	}
+}
+
+func TestJSONDecodeWithoutTokens(t *testing.T) {
+	input := `{
+  "kind": "Program",
+  "statements": [
+    {"kind": "LetStatement",
+     "identifier": {"kind": "Identifier", "value": "double"},
+     "value": {"kind": "FunctionLiteral",
+               "parameters": [{"kind": "Identifier", "value": "x"}],
+               "body": {"kind": "BlockStatement", "statements": [
+                 {"kind": "ExpressionStatement", "expression":
+                   {"kind": "InfixExpression", "operator": "*",
+                    "left": {"kind": "Identifier", "value": "x"},
+                    "right": {"kind": "IntegerLiteral", "value": 2}}}]}}},
+    {"kind": "ExpressionStatement", "expression":
+      {"kind": "CallExpression",
+       "function": {"kind": "Identifier", "value": "double"},
+       "arguments": [{"kind": "IntegerLiteral", "value": 21}]}}
+  ]
+}`
+
+	decoded, err := ast.DecodeJSON([]byte(input))
+	if err != nil {
+		t.Fatalf("DecodeJSON failed: %s", err)
+	}
+
+	expected := parse(`let double = fn(x) { x * 2 }; double(21);`)
+	if !ast.Equal(decoded, expected) {
+		t.Errorf("decoded program wrong. got=%q, want=%q", decoded.String(), expected.String())
+	}
+}
+
+func TestJSONDecodeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"kind": "Nonsense"}`, `unknown node kind "Nonsense"`},
+		{`{"kind": "IntegerLiteral", "value": 1}`, `expected Program node, got IntegerLiteral`},
+		{`{"kind": "Program", "statements": [{"kind": "IntegerLiteral", "value": 1}]}`, `expected a statement, got IntegerLiteral`},
+		{`{"kind": "Program", "statements": [{"kind": "ExpressionStatement", "expression": {"kind": "IntegerLiteral", "value": "x"}}]}`, `invalid IntegerLiteral value`},
+		{`{"kind": "Program", "statements": [null]}`, `expected a statement, got null`},
+		{`{"kind": "Program", "statements": [{"kind": "ExpressionStatement", "expression": {"kind": "ArrayLiteral", "elements": [null]}}]}`, `expected an expression, got null`},
+		{`{"kind": "Program", "statements": [{}]}`, `unknown node kind ""`},
+		{`{"kind": "Program", "statements": [{"kind": "ExpressionStatement"}]}`, `ExpressionStatement has no expression`},
+		{`{"kind": "Program", "statements": [{"kind": "ExpressionStatement", "expression": {"kind": "InfixExpression", "operator": "+"}}]}`, `InfixExpression has no left`},
+		{`{"kind": "Program", "statements": [{"kind": "ExpressionStatement", "expression": {"kind": "InfixExpression", "operator": "+", "left": {"kind": "IntegerLiteral", "value": 1}}}]}`, `InfixExpression has no right`},
+		{`{"kind": "Program", "statements": [{"kind": "ExpressionStatement", "expression": {"kind": "PrefixExpression", "operator": "-"}}]}`, `PrefixExpression has no operand`},
+		{`{"kind": "Program", "statements": [{"kind": "ExpressionStatement", "expression": {"kind": "IfExpression", "consequence": {"kind": "BlockStatement"}}}]}`, `IfExpression has no condition`},
+		{`{"kind": "Program", "statements": [{"kind": "ExpressionStatement", "expression": {"kind": "IfExpression", "condition": {"kind": "BooleanExpression", "value": true}}}]}`, `IfExpression has no consequence`},
+		{`{"kind": "Program", "statements": [{"kind": "ExpressionStatement", "expression": {"kind": "FunctionLiteral"}}]}`, `FunctionLiteral has no body`},
+		{`{"kind": "Program", "statements": [{"kind": "ExpressionStatement", "expression": {"kind": "MacroLiteral"}}]}`, `MacroLiteral has no body`},
+		{`{"kind": "Program", "statements": [{"kind": "ExpressionStatement", "expression": {"kind": "CallExpression"}}]}`, `CallExpression has no function`},
+		{`{"kind": "Program", "statements": [{"kind": "ExpressionStatement", "expression": {"kind": "IndexExpression", "expression": {"kind": "ArrayLiteral"}}}]}`, `IndexExpression has no index`},
+		{`{"kind": "Program", "statements": [{"kind": "LetStatement", "identifier": {"kind": "Identifier", "value": "x"}}]}`, `LetStatement has no value`},
+		{`{"kind": "Program", "statements": [{"kind": "ReturnStatement"}]}`, `ReturnStatement has no returnValue`},
+	}
+
+	for _, tt := range tests {
+		_, err := ast.DecodeJSON([]byte(tt.input))
+		if err == nil {
+			t.Fatalf("expected error for %s", tt.input)
+		}
+		if !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("wrong error. got=%q, want=%q", err.Error(), tt.expected)
+		}
+	}
+}
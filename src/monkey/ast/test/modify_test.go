@@ -114,6 +114,14 @@ func TestModify(t *testing.T) {
 			&ast.ArrayLiteral{Elements: []ast.Expression{one(), one()}},
 			&ast.ArrayLiteral{Elements: []ast.Expression{two(), two()}},
 		},
+		{
+			&ast.CallExpression{Function: one(), Arguments: []ast.Expression{one(), two()}},
+			&ast.CallExpression{Function: two(), Arguments: []ast.Expression{two(), two()}},
+		},
+		{
+			&ast.IndexExpression{Expression: one(), Index: one()},
+			&ast.IndexExpression{Expression: two(), Index: two()},
+		},
 	}
 
 	for _, tt := range tests {
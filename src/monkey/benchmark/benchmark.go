@@ -0,0 +1,117 @@
+// Package benchmark times Monkey programs under each execution engine.
+package benchmark
+
+import (
+	"embed"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strings"
+	"time"
+	"writing-in-interpreter-in-go/src/monkey/engine"
+	"writing-in-interpreter-in-go/src/monkey/lexer"
+	"writing-in-interpreter-in-go/src/monkey/object"
+	"writing-in-interpreter-in-go/src/monkey/parser"
+)
+
+//go:embed scripts/*.mk
+var scripts embed.FS
+
+// Script is a benchmark program.
+type Script struct {
+	Name   string
+	Source string
+}
+
+// Suite returns the benchmark scripts that come with the interpreter,
+// ordered by name.
+func Suite() []Script {
+	entries, _ := scripts.ReadDir("scripts")
+	suite := make([]Script, 0, len(entries))
+	for _, entry := range entries {
+		source, _ := scripts.ReadFile(path.Join("scripts", entry.Name()))
+		suite = append(suite, Script{Name: entry.Name(), Source: string(source)})
+	}
+	return suite
+}
+
+// Options says how many untimed runs precede the timed ones.
+type Options struct {
+	Warmup     int
+	Iterations int
+}
+
+// Result summarizes the timed runs of a script under an engine. Durations
+// are encoded in JSON as nanoseconds.
+type Result struct {
+	Script     string
+	Engine     string
+	Iterations int
+	Mean       time.Duration
+	StdDev     time.Duration
+	Min        time.Duration
+	Max        time.Duration
+}
+
+// Run times script under engine. What the script prints with puts is
+// discarded; Run redirects object.Output and must not be called
+// concurrently.
+func Run(script Script, engine engine.Engine, options Options) (Result, error) {
+	if options.Iterations < 1 {
+		return Result{}, fmt.Errorf("at least one iteration is needed, got %d", options.Iterations)
+	}
+	p := parser.New(lexer.New(script.Source))
+	program := p.ParseProgram()
+	if len(p.Errors) > 0 {
+		return Result{}, fmt.Errorf("%s: parse errors: %s", script.Name, strings.Join(p.Errors, "; "))
+	}
+	run, err := engine.Prepare(program)
+	if err != nil {
+		return Result{}, fmt.Errorf("%s: %s: %w", script.Name, engine.Name, err)
+	}
+
+	previous := object.Output
+	object.Output = io.Discard
+	defer func() { object.Output = previous }()
+
+	durations := make([]time.Duration, options.Iterations)
+	for i := -options.Warmup; i < options.Iterations; i++ {
+		start := time.Now()
+		err := run()
+		elapsed := time.Since(start)
+		if err != nil {
+			return Result{}, fmt.Errorf("%s: %s: %w", script.Name, engine.Name, err)
+		}
+		if i >= 0 {
+			durations[i] = elapsed
+		}
+	}
+
+	result := summarize(durations)
+	result.Script = script.Name
+	result.Engine = engine.Name
+	return result, nil
+}
+
+// summarize computes the mean, the sample standard deviation and the range
+// of durations.
+func summarize(durations []time.Duration) Result {
+	result := Result{Iterations: len(durations), Min: durations[0], Max: durations[0]}
+	var total float64
+	for _, duration := range durations {
+		total += float64(duration)
+		result.Min = min(result.Min, duration)
+		result.Max = max(result.Max, duration)
+	}
+	mean := total / float64(len(durations))
+	result.Mean = time.Duration(mean)
+	if len(durations) > 1 {
+		var squares float64
+		for _, duration := range durations {
+			squares += (float64(duration) - mean) * (float64(duration) - mean)
+		}
+		result.StdDev = time.Duration(math.Sqrt(squares / float64(len(durations)-1)))
+	}
+	return result
+}
@@ -0,0 +1,60 @@
+package benchmark
+
+import (
+	"testing"
+	"time"
+	"writing-in-interpreter-in-go/src/monkey/engine"
+)
+
+func TestSummarize(t *testing.T) {
+	durations := []time.Duration{2, 4, 4, 4, 5, 5, 7, 9}
+	result := summarize(durations)
+	// The sample variance is 32/7.
+	expected := Result{Iterations: 8, Mean: 5, StdDev: 2, Min: 2, Max: 9}
+	if result != expected {
+		t.Errorf("wrong summary.\nwant=%+v\ngot =%+v", expected, result)
+	}
+
+	result = summarize([]time.Duration{3})
+	expected = Result{Iterations: 1, Mean: 3, Min: 3, Max: 3}
+	if result != expected {
+		t.Errorf("wrong summary of one run.\nwant=%+v\ngot =%+v", expected, result)
+	}
+}
+
+func TestSuite(t *testing.T) {
+	suite := Suite()
+	if len(suite) == 0 {
+		t.Fatal("no scripts in the suite")
+	}
+	for _, script := range suite {
+		for _, engine := range engine.Engines {
+			result, err := Run(script, engine, Options{Iterations: 1})
+			if err != nil {
+				t.Errorf("%s on %s: %s", script.Name, engine.Name, err)
+				continue
+			}
+			if result.Script != script.Name || result.Engine != engine.Name || result.Iterations != 1 {
+				t.Errorf("%s on %s: wrong result %+v", script.Name, engine.Name, result)
+			}
+		}
+	}
+}
+
+func TestRunError(t *testing.T) {
+	tests := []struct {
+		script   Script
+		options  Options
+		expected string
+	}{
+		{Script{"error.mk", `1 + "a"`}, Options{Iterations: 1}, "error.mk: vm -O0: type mismatch: INTEGER + STRING"},
+		{Script{"parse.mk", `let = 1;`}, Options{Iterations: 1}, "parse.mk: parse errors: expected next token to be IDENTIFIER, got = instead; no prefix parse function for = found"},
+		{Script{"empty.mk", `1`}, Options{}, "at least one iteration is needed, got 0"},
+	}
+	for _, tt := range tests {
+		_, err := Run(tt.script, engine.Engines[1], tt.options)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%v", tt.expected, err)
+		}
+	}
+}
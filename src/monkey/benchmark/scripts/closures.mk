@@ -0,0 +1,14 @@
+// Creating and calling closures that capture their environment.
+let adder = fn(x) { fn(y) { x + y } };
+let compose = fn(f, g) { fn(x) { g(f(x)) } };
+
+let counter = fn(i, count, total) {
+  if (i == count) {
+    total
+  } else {
+    let step = compose(adder(i), adder(1));
+    counter(i + 1, count, step(total))
+  }
+};
+
+counter(0, 5000, 0);
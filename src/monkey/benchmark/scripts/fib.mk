@@ -0,0 +1,5 @@
+// Naive recursion: calls, integer arithmetic and conditionals.
+let fibonacci = fn(x) {
+  if (x < 2) { x } else { fibonacci(x - 1) + fibonacci(x - 2) }
+};
+fibonacci(22);
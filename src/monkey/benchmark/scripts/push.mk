@@ -0,0 +1,14 @@
+// Growing arrays one element at a time with push.
+let fill = fn(array, count) {
+  if (len(array) == count) { array } else { fill(push(array, len(array)), count) }
+};
+
+let sum = fn(array, i, total) {
+  if (i == len(array)) { total } else { sum(array, i + 1, total + array[i]) }
+};
+
+let repeat = fn(times, total) {
+  if (times == 0) { total } else { repeat(times - 1, total + sum(fill([], 500), 0, 0)) }
+};
+
+repeat(10, 0);
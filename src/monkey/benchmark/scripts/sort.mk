@@ -0,0 +1,44 @@
+// Merge sort of pseudo-random integers: array indexing and building.
+let modulo = fn(a, m) { a - (a / m) * m };
+
+let random = fn(count, seed, numbers) {
+  if (count == 0) {
+    numbers
+  } else {
+    let next = modulo(seed * 1103515245 + 12345, 2147483648);
+    random(count - 1, next, push(numbers, next / 65536))
+  }
+};
+
+let slice = fn(array, from, to, result) {
+  if (from >= to) { result } else { slice(array, from + 1, to, push(result, array[from])) }
+};
+
+let merge = fn(left, right, i, j, result) {
+  if (i == len(left)) {
+    slice(right, j, len(right), result)
+  } else {
+    if (j == len(right)) {
+      slice(left, i, len(left), result)
+    } else {
+      if (left[i] <= right[j]) {
+        merge(left, right, i + 1, j, push(result, left[i]))
+      } else {
+        merge(left, right, i, j + 1, push(result, right[j]))
+      }
+    }
+  }
+};
+
+let sort = fn(array) {
+  if (len(array) < 2) {
+    array
+  } else {
+    let middle = len(array) / 2;
+    let left = sort(slice(array, 0, middle, []));
+    let right = sort(slice(array, middle, len(array), []));
+    merge(left, right, 0, 0, [])
+  }
+};
+
+sort(random(300, 42, []));
@@ -0,0 +1,12 @@
+// String building by repeated concatenation.
+let digits = ["0", "1", "2", "3", "4", "5", "6", "7", "8", "9"];
+
+let itoa = fn(n) {
+  if (n < 10) { digits[n] } else { itoa(n / 10) + digits[n - (n / 10) * 10] }
+};
+
+let build = fn(i, count, result) {
+  if (i == count) { result } else { build(i + 1, count, result + itoa(i) + ",") }
+};
+
+len(build(0, 2000, ""));
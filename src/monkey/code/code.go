@@ -36,6 +36,17 @@ const (
 	OpClosure
 	OpGetFree
 	OpCurrentClosure
+	OpAddLocals
+	OpGetLocalConst
+	OpJumpIfNotEqual
+	OpJumpIfNotGreater
+	OpTailCall
+	OpWide
+	OpLessThan
+	OpLessEqual
+	OpGreaterEqual
+	OpJumpIfNotLess
+	OpCloseUpvalue
 )
 
 type Instructions []byte
@@ -48,35 +59,67 @@ type Definition struct {
 }
 
 var definitions = map[Opcode]*Definition{
-	OpConstant:       {"OpConstant", []int{2}},
-	OpAdd:            {"OpAdd", []int{}},
-	OpPop:            {"OpPop", []int{}},
-	OpSub:            {"OpSub", []int{}},
-	OpMul:            {"OpMul", []int{}},
-	OpDiv:            {"OpDiv", []int{}},
-	OpTrue:           {"OpTrue", []int{}},
-	OpFalse:          {"OpFalse", []int{}},
-	OpEqual:          {"OpEqual", []int{}},
-	OpNotEqual:       {"OpNotEqual", []int{}},
-	OpGreaterThan:    {"OpGreaterThan", []int{}},
-	OpNegate:         {"OpNegate", []int{}},
-	OpBang:           {"OpBang", []int{}},
-	OpJumpIfFalse:    {"OpJumpIfFalse", []int{2}},
-	OpJump:           {"OpJump", []int{2}},
-	OpNull:           {"OpNull", []int{}},
-	OpGetGlobal:      {"OpGetGlobal", []int{2}},
-	OpSetGlobal:      {"OpSetGlobal", []int{2}},
-	OpArray:          {"OpArray", []int{2}},
-	OpIndex:          {"OpIndex", []int{}},
-	OpCall:           {"OpCall", []int{1}},
+	OpConstant:     {"OpConstant", []int{2}},
+	OpAdd:          {"OpAdd", []int{}},
+	OpPop:          {"OpPop", []int{}},
+	OpSub:          {"OpSub", []int{}},
+	OpMul:          {"OpMul", []int{}},
+	OpDiv:          {"OpDiv", []int{}},
+	OpTrue:         {"OpTrue", []int{}},
+	OpFalse:        {"OpFalse", []int{}},
+	OpEqual:        {"OpEqual", []int{}},
+	OpNotEqual:     {"OpNotEqual", []int{}},
+	OpGreaterThan:  {"OpGreaterThan", []int{}},
+	OpLessThan:     {"OpLessThan", []int{}},
+	OpLessEqual:    {"OpLessEqual", []int{}},
+	OpGreaterEqual: {"OpGreaterEqual", []int{}},
+	OpNegate:       {"OpNegate", []int{}},
+	OpBang:         {"OpBang", []int{}},
+	OpJumpIfFalse:  {"OpJumpIfFalse", []int{2}},
+	OpJump:         {"OpJump", []int{2}},
+	OpNull:         {"OpNull", []int{}},
+	OpGetGlobal:    {"OpGetGlobal", []int{2}},
+	OpSetGlobal:    {"OpSetGlobal", []int{2}},
+	OpArray:        {"OpArray", []int{2}},
+	OpIndex:        {"OpIndex", []int{}},
+	OpCall:         {"OpCall", []int{1}},
+	// OpTailCall calls a closure in place of the current frame.
+	OpTailCall: {"OpTailCall", []int{1}},
+	// OpWide doubles the operand widths of the instruction that follows it.
+	OpWide:           {"OpWide", []int{}},
 	OpReturnValue:    {"OpReturnValue", []int{}},
 	OpReturnVoid:     {"OpReturnVoid", []int{}},
 	OpGetLocal:       {"OpGetLocal", []int{1}},
 	OpSetLocal:       {"OpSetLocal", []int{1}},
 	OpGetBuiltin:     {"OpGetBuiltin", []int{1}},
-	OpClosure:        {"OpClosure", []int{2, 1}},
+	OpClosure:        {"OpClosure", []int{2}},
 	OpGetFree:        {"OpGetFree", []int{1}},
 	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+	OpAddLocals:      {"OpAddLocals", []int{1, 1}},
+	// Superinstructions emitted at optimisation level 2. OpGetLocalConst
+	// pushes a local and a constant and the conditional jumps fuse a
+	// comparison with OpJumpIfFalse.
+	OpGetLocalConst:    {"OpGetLocalConst", []int{1, 2}},
+	OpJumpIfNotEqual:   {"OpJumpIfNotEqual", []int{2}},
+	OpJumpIfNotGreater: {"OpJumpIfNotGreater", []int{2}},
+	OpJumpIfNotLess:    {"OpJumpIfNotLess", []int{2}},
+	// OpCloseUpvalue closes the upvalues open on the current frame's locals.
+	OpCloseUpvalue: {"OpCloseUpvalue", []int{}},
+}
+
+// Operators maps the opcodes of binary operations to the operators they
+// implement, for error messages.
+var Operators = map[Opcode]string{
+	OpAdd:          "+",
+	OpSub:          "-",
+	OpMul:          "*",
+	OpDiv:          "/",
+	OpEqual:        "==",
+	OpNotEqual:     "!=",
+	OpGreaterThan:  ">",
+	OpLessThan:     "<",
+	OpLessEqual:    "<=",
+	OpGreaterEqual: ">=",
 }
 
 func LookUp(opcode byte) (*Definition, error) {
@@ -87,17 +130,63 @@ func LookUp(opcode byte) (*Definition, error) {
 	return definition, nil
 }
 
+// Make encodes an instruction. When an operand does not fit its width the
+// instruction is prefixed with OpWide and encoded with doubled widths.
+// Operands that do not fit those either are truncated, so the compiler
+// rejects them with FitsWide first.
 func Make(opcode Opcode, operands ...int) Instructions {
 	definition, ok := definitions[opcode]
 	if !ok {
 		return []byte{}
 	}
+	if !fits(definition, operands) {
+		return append(Instructions{byte(OpWide)}, makeInstruction(opcode, definition.Widened(), operands)...)
+	}
+	return makeInstruction(opcode, definition, operands)
+}
 
-	instructionLength := 1
-	for _, value := range definition.OperandWidths {
-		instructionLength += value
+func fits(definition *Definition, operands []int) bool {
+	for index, operand := range operands {
+		if index < len(definition.OperandWidths) && uint64(operand) >= 1<<(8*definition.OperandWidths[index]) {
+			return false
+		}
 	}
-	instruction := make([]byte, instructionLength)
+	return true
+}
+
+// Fits reports whether an instruction can be encoded without OpWide.
+func Fits(opcode Opcode, operands ...int) bool {
+	definition, ok := definitions[opcode]
+	return ok && fits(definition, operands)
+}
+
+// FitsWide reports whether an instruction can be encoded at all, with
+// OpWide if need be.
+func FitsWide(opcode Opcode, operands ...int) bool {
+	definition, ok := definitions[opcode]
+	return ok && fits(definition.Widened(), operands)
+}
+
+// Widened returns the definition used after an OpWide prefix.
+func (definition *Definition) Widened() *Definition {
+	widths := make([]int, len(definition.OperandWidths))
+	for i, width := range definition.OperandWidths {
+		widths[i] = 2 * width
+	}
+	return &Definition{Name: definition.Name, OperandWidths: widths}
+}
+
+// operandsWidth is the number of bytes the operands of an instruction take.
+func (definition *Definition) operandsWidth() int {
+	width := 0
+	for _, operandWidth := range definition.OperandWidths {
+		width += operandWidth
+	}
+	return width
+}
+
+func makeInstruction(opcode Opcode, definition *Definition, operands []int) Instructions {
+	instruction := make([]byte, 1+definition.operandsWidth())
 	instruction[0] = byte(opcode)
 	offset := 1
 	for index, operand := range operands {
@@ -107,6 +196,8 @@ func Make(opcode Opcode, operands ...int) Instructions {
 			instruction[offset] = byte(operand)
 		case 2:
 			binary.BigEndian.PutUint16(instruction[offset:], uint16(operand))
+		case 4:
+			binary.BigEndian.PutUint32(instruction[offset:], uint32(operand))
 		}
 		offset += width
 	}
@@ -119,27 +210,98 @@ func ReadOperands(def *Definition, instruction Instructions) ([]int, int) {
 	for i, width := range def.OperandWidths {
 		switch width {
 		case 1:
-			operands[i] = int(instruction[offset])
+			operands[i] = int(ReadUint8(instruction[offset:]))
 		case 2:
-			operands[i] = int(binary.BigEndian.Uint16(instruction[offset:]))
+			operands[i] = int(ReadUint16(instruction[offset:]))
+		case 4:
+			operands[i] = int(ReadUint32(instruction[offset:]))
 		}
 		offset += width
 	}
 	return operands, offset
 }
 
+// Instruction is an instruction as ReadInstruction decodes it.
+type Instruction struct {
+	Opcode Opcode
+	// Definition is that of Opcode, widened when the instruction is Wide.
+	Definition *Definition
+	// Wide is set when the instruction is prefixed with OpWide.
+	Wide     bool
+	Operands []int
+	// Width is the number of bytes the instruction takes, prefix included.
+	Width int
+}
+
+// ReadInstruction decodes the instruction at offset, reading the operands
+// of one prefixed with OpWide with their doubled widths. It fails on
+// undefined opcodes and on instructions cut short by the end of ins.
+func ReadInstruction(ins Instructions, offset int) (Instruction, error) {
+	start := offset
+	definition, err := LookUp(ins[offset])
+	if err != nil {
+		return Instruction{}, err
+	}
+	wide := Opcode(ins[offset]) == OpWide
+	if wide {
+		offset++
+		if offset == len(ins) {
+			return Instruction{}, fmt.Errorf("truncated OpWide")
+		}
+		definition, err = LookUp(ins[offset])
+		if err != nil {
+			return Instruction{}, err
+		}
+		definition = definition.Widened()
+	}
+	if offset+1+definition.operandsWidth() > len(ins) {
+		if wide {
+			return Instruction{}, fmt.Errorf("truncated OpWide %s", definition.Name)
+		}
+		return Instruction{}, fmt.Errorf("truncated %s", definition.Name)
+	}
+	operands, read := ReadOperands(definition, ins[offset+1:])
+	return Instruction{
+		Opcode:     Opcode(ins[offset]),
+		Definition: definition,
+		Wide:       wide,
+		Operands:   operands,
+		Width:      offset + 1 + read - start,
+	}, nil
+}
+
+func ReadUint32(instructions Instructions) uint32 {
+	return binary.BigEndian.Uint32(instructions)
+}
+
+func ReadUint16(instructions Instructions) uint16 {
+	return uint16(instructions[0])<<8 | uint16(instructions[1])
+}
+
+func ReadUint8(instructions Instructions) uint8 {
+	return instructions[0]
+}
+
 func (ins Instructions) String() string {
 	var out bytes.Buffer
 	i := 0
 	for i < len(ins) {
-		def, err := LookUp(ins[i])
-		if err != nil {
+		instruction, err := ReadInstruction(ins, i)
+		if _, undefined := LookUp(ins[i]); undefined != nil {
 			_, _ = fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
 			continue
 		}
-		operands, read := ReadOperands(def, ins[i+1:])
-		_, _ = fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
-		i += 1 + read
+		if err != nil {
+			_, _ = fmt.Fprintf(&out, "%04d ERROR: %s\n", i, err)
+			break
+		}
+		if instruction.Wide {
+			_, _ = fmt.Fprintf(&out, "%04d OpWide %s\n", i, ins.fmtInstruction(instruction.Definition, instruction.Operands))
+		} else {
+			_, _ = fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(instruction.Definition, instruction.Operands))
+		}
+		i += instruction.Width
 	}
 	return out.String()
 }
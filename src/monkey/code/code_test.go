@@ -1,6 +1,7 @@
 package code
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -26,9 +27,24 @@ func TestMake(t *testing.T) {
 			[]byte{byte(OpGetLocal), 255},
 		},
 		{
-			OpClosure,
-			[]int{65534, 255},
-			[]byte{byte(OpClosure), 255, 254, 255},
+			OpGetLocalConst,
+			[]int{255, 65534},
+			[]byte{byte(OpGetLocalConst), 255, 255, 254},
+		},
+		{
+			OpGetLocal,
+			[]int{256},
+			[]byte{byte(OpWide), byte(OpGetLocal), 1, 0},
+		},
+		{
+			OpConstant,
+			[]int{65536},
+			[]byte{byte(OpWide), byte(OpConstant), 0, 1, 0, 0},
+		},
+		{
+			OpGetLocalConst,
+			[]int{300, 1},
+			[]byte{byte(OpWide), byte(OpGetLocalConst), 1, 44, 0, 0, 0, 1},
 		},
 	}
 	for _, tt := range tests {
@@ -50,14 +66,18 @@ func TestInstructionsString(t *testing.T) {
 		Make(OpGetLocal, 1),
 		Make(OpConstant, 2),
 		Make(OpConstant, 65535),
-		Make(OpClosure, 65535, 255),
+		Make(OpGetLocalConst, 255, 65535),
+		Make(OpConstant, 65536),
+		Make(OpCall, 300),
 	}
 
 	expected := `0000 OpAdd
 0001 OpGetLocal 1
 0003 OpConstant 2
 0006 OpConstant 65535
-0009 OpClosure 65535 255
+0009 OpGetLocalConst 255 65535
+0013 OpWide OpConstant 65536
+0019 OpWide OpCall 300
 `
 	concatted := Instructions{}
 	for _, ins := range instructions {
@@ -85,10 +105,15 @@ func TestReadOperands(t *testing.T) {
 			1,
 		},
 		{
-			OpClosure,
-			[]int{65535, 255},
+			OpGetLocalConst,
+			[]int{255, 65535},
 			3,
 		},
+		{
+			OpGetLocalConst,
+			[]int{256, 65536},
+			6,
+		},
 	}
 	for _, tt := range tests {
 		instruction := Make(tt.op, tt.operands...)
@@ -96,6 +121,10 @@ func TestReadOperands(t *testing.T) {
 		if err != nil {
 			t.Fatalf("definition not found: %q\n", err)
 		}
+		if !Fits(tt.op, tt.operands...) {
+			def = def.Widened()
+			instruction = instruction[1:]
+		}
 		operandsRead, n := ReadOperands(def, instruction[1:])
 		if n != tt.bytesRead {
 			t.Fatalf("n wrong. want=%d, got=%d", tt.bytesRead, n)
@@ -107,3 +136,63 @@ func TestReadOperands(t *testing.T) {
 		}
 	}
 }
+
+func TestMalformedInstructionsString(t *testing.T) {
+	tests := []struct {
+		instructions Instructions
+		expected     string
+	}{
+		{Instructions{255, byte(OpAdd)}, "ERROR: opcode 255 undefined\n0001 OpAdd\n"},
+		{Instructions{byte(OpConstant), 1}, "0000 ERROR: truncated OpConstant\n"},
+		{Instructions{byte(OpWide), byte(OpConstant), 0, 0}, "0000 ERROR: truncated OpWide OpConstant\n"},
+	}
+	for _, tt := range tests {
+		if actual := tt.instructions.String(); actual != tt.expected {
+			t.Errorf("wrong string for %v.\nwant=%q\ngot=%q", []byte(tt.instructions), tt.expected, actual)
+		}
+	}
+}
+
+func TestReadInstruction(t *testing.T) {
+	instructions := Instructions{}
+	instructions = append(instructions, Make(OpConstant, 65535)...)
+	instructions = append(instructions, Make(OpGetLocalConst, 256, 65536)...)
+	instructions = append(instructions, Make(OpPop)...)
+	tests := []struct {
+		offset   int
+		opcode   Opcode
+		wide     bool
+		operands []int
+		width    int
+	}{
+		{0, OpConstant, false, []int{65535}, 3},
+		{3, OpGetLocalConst, true, []int{256, 65536}, 8},
+		{11, OpPop, false, []int{}, 1},
+	}
+	for _, tt := range tests {
+		instruction, err := ReadInstruction(instructions, tt.offset)
+		if err != nil {
+			t.Fatalf("ReadInstruction at %d failed: %s", tt.offset, err)
+		}
+		if instruction.Opcode != tt.opcode || instruction.Wide != tt.wide || instruction.Width != tt.width ||
+			!reflect.DeepEqual(instruction.Operands, tt.operands) {
+			t.Errorf("wrong instruction at %d. want=%v %t %v %d, got=%v %t %v %d", tt.offset,
+				tt.opcode, tt.wide, tt.operands, tt.width,
+				instruction.Opcode, instruction.Wide, instruction.Operands, instruction.Width)
+		}
+	}
+
+	_, err := ReadInstruction(Instructions{byte(OpWide)}, 0)
+	if err == nil || err.Error() != "truncated OpWide" {
+		t.Errorf("wrong error for a lone OpWide. got=%v", err)
+	}
+}
+
+func FuzzInstructionsString(f *testing.F) {
+	f.Add([]byte(Make(OpConstant, 65535)))
+	f.Add([]byte(Make(OpGetLocalConst, 256, 65536)))
+	f.Add([]byte{255})
+	f.Fuzz(func(t *testing.T, instructions []byte) {
+		_ = Instructions(instructions).String()
+	})
+}
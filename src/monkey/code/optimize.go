@@ -0,0 +1,329 @@
+package code
+
+// instruction is a decoded instruction. Jump operands hold the index of the
+// target instruction rather than its byte offset, so instructions can be
+// removed or fused without breaking jumps; an index equal to the number of
+// instructions points past the end.
+type instruction struct {
+	opcode   Opcode
+	operands []int
+	position Position
+}
+
+type pass func(instructions []instruction) ([]instruction, bool)
+
+var passes = []pass{
+	threadJumps,
+	removeUnreachable,
+	removeJumpsToNext,
+	removeNullPops,
+	fuseInstructions,
+}
+
+// fusion replaces a run of instructions matching pattern by a single
+// superinstruction.
+type fusion struct {
+	pattern []Opcode
+	fuse    func(matched []instruction) instruction
+}
+
+var fusions = []fusion{
+	{
+		[]Opcode{OpGetLocal, OpGetLocal, OpAdd},
+		func(matched []instruction) instruction {
+			return instruction{opcode: OpAddLocals, operands: []int{matched[0].operands[0], matched[1].operands[0]}}
+		},
+	},
+	{
+		[]Opcode{OpGetLocal, OpConstant},
+		func(matched []instruction) instruction {
+			return instruction{opcode: OpGetLocalConst, operands: []int{matched[0].operands[0], matched[1].operands[0]}}
+		},
+	},
+	{
+		[]Opcode{OpEqual, OpJumpIfFalse},
+		func(matched []instruction) instruction {
+			return instruction{opcode: OpJumpIfNotEqual, operands: matched[1].operands}
+		},
+	},
+	{
+		[]Opcode{OpGreaterThan, OpJumpIfFalse},
+		func(matched []instruction) instruction {
+			return instruction{opcode: OpJumpIfNotGreater, operands: matched[1].operands}
+		},
+	},
+	{
+		[]Opcode{OpLessThan, OpJumpIfFalse},
+		func(matched []instruction) instruction {
+			return instruction{opcode: OpJumpIfNotLess, operands: matched[1].operands}
+		},
+	},
+}
+
+// Optimize returns a peephole-optimised copy of ins: jump chains are
+// collapsed, unreachable code is dropped, a pushed null that is immediately
+// popped is removed and common sequences are fused into superinstructions.
+// Jump targets are relocated accordingly. Instructions that cannot be
+// decoded are returned unchanged.
+func Optimize(ins Instructions) Instructions {
+	optimized, _ := OptimizeWithSourceMap(ins, nil)
+	return optimized
+}
+
+// OptimizeWithSourceMap optimises ins like Optimize and returns the source
+// map of the result. Fused instructions take the position of the first
+// instruction they replace.
+func OptimizeWithSourceMap(ins Instructions, sourceMap SourceMap) (Instructions, SourceMap) {
+	instructions, ok := decode(ins, sourceMap)
+	if !ok {
+		return ins, sourceMap
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, optimize := range passes {
+			var optimized bool
+			instructions, optimized = optimize(instructions)
+			changed = changed || optimized
+		}
+	}
+	return encode(instructions)
+}
+
+func isJump(opcode Opcode) bool {
+	return opcode == OpJump || isConditionalJump(opcode)
+}
+
+func isConditionalJump(opcode Opcode) bool {
+	switch opcode {
+	case OpJumpIfFalse, OpJumpIfNotEqual, OpJumpIfNotGreater, OpJumpIfNotLess:
+		return true
+	}
+	return false
+}
+
+func decode(ins Instructions, sourceMap SourceMap) ([]instruction, bool) {
+	var instructions []instruction
+	indexAt := make(map[int]int)
+	for offset := 0; offset < len(ins); {
+		decoded, err := ReadInstruction(ins, offset)
+		if err != nil {
+			return nil, false
+		}
+		indexAt[offset] = len(instructions)
+		instructions = append(instructions, instruction{
+			opcode:   decoded.Opcode,
+			operands: decoded.Operands,
+			position: sourceMap.PositionAt(offset),
+		})
+		offset += decoded.Width
+	}
+	indexAt[len(ins)] = len(instructions)
+
+	for i := range instructions {
+		if !isJump(instructions[i].opcode) {
+			continue
+		}
+		target, ok := indexAt[instructions[i].operands[0]]
+		if !ok {
+			return nil, false
+		}
+		instructions[i].operands[0] = target
+	}
+	return instructions, true
+}
+
+func encode(instructions []instruction) (Instructions, SourceMap) {
+	offsets := make([]int, len(instructions)+1)
+	for i, instruction := range instructions {
+		offsets[i+1] = offsets[i] + len(Make(instruction.opcode, instruction.operands...))
+	}
+
+	ins := Instructions{}
+	sourceMap := make(SourceMap, len(instructions))
+	for i, instruction := range instructions {
+		operands := instruction.operands
+		if isJump(instruction.opcode) {
+			operands = []int{offsets[operands[0]]}
+		}
+		ins = append(ins, Make(instruction.opcode, operands...)...)
+		sourceMap[i] = SourceMapping{Offset: offsets[i], Position: instruction.position}
+	}
+	return ins, sourceMap
+}
+
+func jumpTargets(instructions []instruction) map[int]bool {
+	targets := make(map[int]bool)
+	for _, instruction := range instructions {
+		if isJump(instruction.opcode) {
+			targets[instruction.operands[0]] = true
+		}
+	}
+	return targets
+}
+
+// compact drops the instructions not marked in keep. Jumps to a dropped
+// instruction are moved to the next instruction that is kept.
+func compact(instructions []instruction, keep []bool) []instruction {
+	relocated := make([]int, len(instructions)+1)
+	kept := 0
+	for i := range instructions {
+		relocated[i] = kept
+		if keep[i] {
+			kept++
+		}
+	}
+	relocated[len(instructions)] = kept
+
+	compacted := make([]instruction, 0, kept)
+	for i, instruction := range instructions {
+		if !keep[i] {
+			continue
+		}
+		if isJump(instruction.opcode) {
+			instruction.operands = []int{relocated[instruction.operands[0]]}
+		}
+		compacted = append(compacted, instruction)
+	}
+	return compacted
+}
+
+// threadJumps points jumps whose target is an unconditional jump at that
+// jump's target instead.
+func threadJumps(instructions []instruction) ([]instruction, bool) {
+	changed := false
+	for i := range instructions {
+		if !isJump(instructions[i].opcode) {
+			continue
+		}
+		target := instructions[i].operands[0]
+		for hops := 0; hops < len(instructions) && target < len(instructions) && instructions[target].opcode == OpJump; hops++ {
+			if instructions[target].operands[0] == target {
+				break
+			}
+			target = instructions[target].operands[0]
+		}
+		if target != instructions[i].operands[0] {
+			instructions[i].operands = []int{target}
+			changed = true
+		}
+	}
+	return instructions, changed
+}
+
+func removeUnreachable(instructions []instruction) ([]instruction, bool) {
+	reachable := make([]bool, len(instructions))
+	pending := []int{0}
+	for len(pending) > 0 {
+		i := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if i >= len(instructions) || reachable[i] {
+			continue
+		}
+		reachable[i] = true
+
+		switch instructions[i].opcode {
+		case OpJump:
+			pending = append(pending, instructions[i].operands[0])
+		case OpReturnValue, OpReturnVoid:
+		default:
+			pending = append(pending, i+1)
+			if isConditionalJump(instructions[i].opcode) {
+				pending = append(pending, instructions[i].operands[0])
+			}
+		}
+	}
+
+	for _, isReachable := range reachable {
+		if !isReachable {
+			return compact(instructions, reachable), true
+		}
+	}
+	return instructions, false
+}
+
+// removeJumpsToNext drops jumps to the instruction that follows them. A
+// conditional jump still has to discard its condition, so it becomes OpPop.
+func removeJumpsToNext(instructions []instruction) ([]instruction, bool) {
+	keep := make([]bool, len(instructions))
+	changed := false
+	for i, jump := range instructions {
+		keep[i] = true
+		if jump.opcode != OpJump && jump.opcode != OpJumpIfFalse || jump.operands[0] != i+1 {
+			continue
+		}
+		changed = true
+		if jump.opcode == OpJump {
+			keep[i] = false
+		} else {
+			instructions[i] = instruction{opcode: OpPop, operands: []int{}, position: jump.position}
+		}
+	}
+	if !changed {
+		return instructions, false
+	}
+	return compact(instructions, keep), true
+}
+
+// removeNullPops drops OpNull immediately followed by OpPop. The pair is
+// kept at the very end, where the popped null is the program's result.
+func removeNullPops(instructions []instruction) ([]instruction, bool) {
+	targets := jumpTargets(instructions)
+	keep := make([]bool, len(instructions))
+	changed := false
+	for i := 0; i < len(instructions); i++ {
+		keep[i] = true
+		if i+2 < len(instructions) &&
+			instructions[i].opcode == OpNull &&
+			instructions[i+1].opcode == OpPop &&
+			!targets[i+1] {
+			keep[i] = false
+			i++
+			changed = true
+		}
+	}
+	if !changed {
+		return instructions, false
+	}
+	return compact(instructions, keep), true
+}
+
+// fuseInstructions applies fusions to runs that no jump enters midway.
+func fuseInstructions(instructions []instruction) ([]instruction, bool) {
+	targets := jumpTargets(instructions)
+	keep := make([]bool, len(instructions))
+	changed := false
+	for i := 0; i < len(instructions); i++ {
+		keep[i] = true
+		for _, fusion := range fusions {
+			if !matches(instructions[i:], fusion.pattern, targets, i) {
+				continue
+			}
+			fused := fusion.fuse(instructions[i : i+len(fusion.pattern)])
+			fused.position = instructions[i].position
+			if !Fits(fused.opcode, fused.operands...) {
+				continue
+			}
+			instructions[i] = fused
+			i += len(fusion.pattern) - 1
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return instructions, false
+	}
+	return compact(instructions, keep), true
+}
+
+func matches(instructions []instruction, pattern []Opcode, targets map[int]bool, start int) bool {
+	if len(instructions) < len(pattern) {
+		return false
+	}
+	for j, opcode := range pattern {
+		if instructions[j].opcode != opcode || j > 0 && targets[start+j] {
+			return false
+		}
+	}
+	return true
+}
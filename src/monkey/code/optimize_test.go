@@ -0,0 +1,220 @@
+package code
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []Instructions
+		expected []Instructions
+	}{
+		{
+			"code after return is removed",
+			[]Instructions{
+				Make(OpConstant, 0),
+				Make(OpReturnValue),
+				Make(OpConstant, 1),
+				Make(OpReturnValue),
+			},
+			[]Instructions{
+				Make(OpConstant, 0),
+				Make(OpReturnValue),
+			},
+		},
+		{
+			"jump chains are collapsed",
+			[]Instructions{
+				Make(OpTrue),            // 0000
+				Make(OpJumpIfFalse, 10), // 0001
+				Make(OpConstant, 0),     // 0004
+				Make(OpJump, 13),        // 0007
+				Make(OpJump, 16),        // 0010
+				Make(OpJump, 10),        // 0013
+				Make(OpPop),             // 0016
+				Make(OpConstant, 1),     // 0017
+				Make(OpPop),             // 0020
+			},
+			[]Instructions{
+				Make(OpTrue),           // 0000
+				Make(OpJumpIfFalse, 7), // 0001
+				Make(OpConstant, 0),    // 0004
+				Make(OpPop),            // 0007
+				Make(OpConstant, 1),    // 0008
+				Make(OpPop),            // 0011
+			},
+		},
+		{
+			"jumps to the next instruction are removed",
+			[]Instructions{
+				Make(OpConstant, 0),
+				Make(OpJump, 6),
+				Make(OpPop),
+			},
+			[]Instructions{
+				Make(OpConstant, 0),
+				Make(OpPop),
+			},
+		},
+		{
+			"null followed by pop is dropped unless it ends the program",
+			[]Instructions{
+				Make(OpNull),
+				Make(OpPop),
+				Make(OpConstant, 0),
+				Make(OpPop),
+				Make(OpNull),
+				Make(OpPop),
+			},
+			[]Instructions{
+				Make(OpConstant, 0),
+				Make(OpPop),
+				Make(OpNull),
+				Make(OpPop),
+			},
+		},
+		{
+			"local additions are fused and jumps relocated",
+			[]Instructions{
+				Make(OpTrue),            // 0000
+				Make(OpJumpIfFalse, 12), // 0001
+				Make(OpGetLocal, 0),     // 0004
+				Make(OpGetLocal, 1),     // 0006
+				Make(OpAdd),             // 0008
+				Make(OpJump, 13),        // 0009
+				Make(OpNull),            // 0012
+				Make(OpReturnValue),     // 0013
+			},
+			[]Instructions{
+				Make(OpTrue),            // 0000
+				Make(OpJumpIfFalse, 10), // 0001
+				Make(OpAddLocals, 0, 1), // 0004
+				Make(OpJump, 11),        // 0007
+				Make(OpNull),            // 0010
+				Make(OpReturnValue),     // 0011
+			},
+		},
+		{
+			"fusion stops at jump targets",
+			[]Instructions{
+				Make(OpTrue),           // 0000
+				Make(OpJumpIfFalse, 6), // 0001
+				Make(OpGetLocal, 0),    // 0004
+				Make(OpGetLocal, 1),    // 0006
+				Make(OpAdd),            // 0008
+				Make(OpReturnValue),    // 0009
+			},
+			[]Instructions{
+				Make(OpTrue),
+				Make(OpJumpIfFalse, 6),
+				Make(OpGetLocal, 0),
+				Make(OpGetLocal, 1),
+				Make(OpAdd),
+				Make(OpReturnValue),
+			},
+		},
+		{
+			"comparisons are fused with the jump that follows",
+			[]Instructions{
+				Make(OpGetLocal, 0),     // 0000
+				Make(OpConstant, 0),     // 0002
+				Make(OpEqual),           // 0005
+				Make(OpJumpIfFalse, 13), // 0006
+				Make(OpConstant, 1),     // 0009
+				Make(OpReturnValue),     // 0012
+				Make(OpConstant, 2),     // 0013
+				Make(OpGetLocal, 0),     // 0016
+				Make(OpGreaterThan),     // 0018
+				Make(OpJumpIfFalse, 26), // 0019
+				Make(OpConstant, 3),     // 0022
+				Make(OpReturnValue),     // 0025
+				Make(OpNull),            // 0026
+				Make(OpReturnValue),     // 0027
+			},
+			[]Instructions{
+				Make(OpGetLocalConst, 0, 0),  // 0000
+				Make(OpJumpIfNotEqual, 11),   // 0004
+				Make(OpConstant, 1),          // 0007
+				Make(OpReturnValue),          // 0010
+				Make(OpConstant, 2),          // 0011
+				Make(OpGetLocal, 0),          // 0014
+				Make(OpJumpIfNotGreater, 23), // 0016
+				Make(OpConstant, 3),          // 0019
+				Make(OpReturnValue),          // 0022
+				Make(OpNull),                 // 0023
+				Make(OpReturnValue),          // 0024
+			},
+		},
+		{
+			"less-than comparisons are fused with the jump that follows",
+			[]Instructions{
+				Make(OpGetLocal, 0),     // 0000
+				Make(OpGetLocal, 1),     // 0002
+				Make(OpLessThan),        // 0004
+				Make(OpJumpIfFalse, 12), // 0005
+				Make(OpConstant, 0),     // 0008
+				Make(OpReturnValue),     // 0011
+				Make(OpNull),            // 0012
+				Make(OpReturnValue),     // 0013
+			},
+			[]Instructions{
+				Make(OpGetLocal, 0),       // 0000
+				Make(OpGetLocal, 1),       // 0002
+				Make(OpJumpIfNotLess, 11), // 0004
+				Make(OpConstant, 0),       // 0007
+				Make(OpReturnValue),       // 0010
+				Make(OpNull),              // 0011
+				Make(OpReturnValue),       // 0012
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		actual := Optimize(concat(tt.input))
+		expected := concat(tt.expected)
+		if !bytes.Equal(actual, expected) {
+			t.Errorf("%s: wrong instructions.\nwant=%q\ngot =%q", tt.name, expected, actual)
+		}
+	}
+}
+
+func concat(instructions []Instructions) Instructions {
+	out := Instructions{}
+	for _, instruction := range instructions {
+		out = append(out, instruction...)
+	}
+	return out
+}
+
+func TestOptimizeWithSourceMap(t *testing.T) {
+	input := concat([]Instructions{
+		Make(OpGetLocal, 0), // 0000
+		Make(OpGetLocal, 1), // 0002
+		Make(OpAdd),         // 0004
+		Make(OpReturnValue), // 0005
+		Make(OpConstant, 0), // 0006
+	})
+	sourceMap := SourceMap{
+		{Offset: 0, Position: Position{Line: 1, Column: 12}},
+		{Offset: 2, Position: Position{Line: 1, Column: 16}},
+		{Offset: 4, Position: Position{Line: 1, Column: 14}},
+		{Offset: 5, Position: Position{Line: 2, Column: 1}},
+		{Offset: 6, Position: Position{Line: 3, Column: 1}},
+	}
+
+	actual, actualSourceMap := OptimizeWithSourceMap(input, sourceMap)
+	expected := concat([]Instructions{Make(OpAddLocals, 0, 1), Make(OpReturnValue)})
+	if !bytes.Equal(actual, expected) {
+		t.Fatalf("wrong instructions.\nwant=%q\ngot =%q", expected, actual)
+	}
+	expectedSourceMap := SourceMap{
+		{Offset: 0, Position: Position{Line: 1, Column: 12}},
+		{Offset: 3, Position: Position{Line: 2, Column: 1}},
+	}
+	if !reflect.DeepEqual(actualSourceMap, expectedSourceMap) {
+		t.Errorf("wrong source map.\nwant=%v\ngot =%v", expectedSourceMap, actualSourceMap)
+	}
+}
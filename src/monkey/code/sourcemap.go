@@ -0,0 +1,29 @@
+package code
+
+import "sort"
+
+// Position is a line and column of source code, both counted from 1. The
+// zero Position stands for an unknown position.
+type Position struct {
+	Line   int
+	Column int
+}
+
+// SourceMapping locates the instruction at Offset in the source code.
+type SourceMapping struct {
+	Offset   int
+	Position Position
+}
+
+// SourceMap locates instructions in the source code they were compiled from.
+// It holds a mapping for every instruction, ordered by offset.
+type SourceMap []SourceMapping
+
+// PositionAt returns the position of the instruction containing offset.
+func (sourceMap SourceMap) PositionAt(offset int) Position {
+	i := sort.Search(len(sourceMap), func(i int) bool { return sourceMap[i].Offset > offset })
+	if i == 0 {
+		return Position{}
+	}
+	return sourceMap[i-1].Position
+}
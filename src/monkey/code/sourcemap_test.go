@@ -0,0 +1,29 @@
+package code
+
+import "testing"
+
+func TestPositionAt(t *testing.T) {
+	sourceMap := SourceMap{
+		{Offset: 0, Position: Position{Line: 1, Column: 1}},
+		{Offset: 3, Position: Position{Line: 1, Column: 5}},
+		{Offset: 4, Position: Position{Line: 2, Column: 3}},
+	}
+	tests := []struct {
+		offset   int
+		expected Position
+	}{
+		{-1, Position{}},
+		{0, Position{Line: 1, Column: 1}},
+		{2, Position{Line: 1, Column: 1}},
+		{3, Position{Line: 1, Column: 5}},
+		{9, Position{Line: 2, Column: 3}},
+	}
+	for _, tt := range tests {
+		if actual := sourceMap.PositionAt(tt.offset); actual != tt.expected {
+			t.Errorf("PositionAt(%d) wrong. want=%v, got=%v", tt.offset, tt.expected, actual)
+		}
+	}
+	if actual := SourceMap(nil).PositionAt(0); actual != (Position{}) {
+		t.Errorf("empty source map has position %v", actual)
+	}
+}
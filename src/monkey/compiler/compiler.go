@@ -2,27 +2,45 @@ package compiler
 
 import (
 	"fmt"
+	"math"
 	"writing-in-interpreter-in-go/src/monkey/ast"
 	"writing-in-interpreter-in-go/src/monkey/code"
 	"writing-in-interpreter-in-go/src/monkey/object"
+	"writing-in-interpreter-in-go/src/monkey/token"
 )
 
 type Compiler struct {
-	constants   []object.Object
-	symbolTable *SymbolTable
-	scopes      []CompilationScope
-	scopeIndex  int
+	constants         []object.Object
+	constantIndexes   map[ConstantKey]int
+	symbolTable       *SymbolTable
+	scopes            []CompilationScope
+	scopeIndex        int
+	resolutionErrors  ResolutionErrors
+	optimizationLevel int
+	// tailPosition tells the next node compiled that its value is returned
+	// from the enclosing function.
+	tailPosition bool
+	// position is the position of the innermost node being compiled, which
+	// the instructions emitted are mapped to.
+	position code.Position
 }
 
 type ByteCode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	SourceMap    code.SourceMap
+	// GlobalNames names the globals by index, for debuggers.
+	GlobalNames []string
 }
 
 type CompilationScope struct {
 	instructions           code.Instructions
+	sourceMap              code.SourceMap
 	lastInstruction        EmittedInstruction
 	penultimateInstruction EmittedInstruction
+	// capturesLocals is set once a closure capturing one of the function's
+	// locals has been emitted.
+	capturesLocals bool
 }
 
 type EmittedInstruction struct {
@@ -41,10 +59,11 @@ func New() *Compiler {
 		symbolTable.DefineBuiltin(index, builtin.Name)
 	}
 	return &Compiler{
-		constants:   []object.Object{},
-		symbolTable: symbolTable,
-		scopes:      []CompilationScope{mainScope},
-		scopeIndex:  0,
+		constants:       []object.Object{},
+		constantIndexes: make(map[ConstantKey]int),
+		symbolTable:     symbolTable,
+		scopes:          []CompilationScope{mainScope},
+		scopeIndex:      0,
 	}
 }
 
@@ -52,34 +71,68 @@ func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
 	compiler := New()
 	compiler.symbolTable = s
 	compiler.constants = constants
+	for index, constant := range constants {
+		if key, ok := ConstantKeyOf(constant); ok {
+			compiler.constantIndexes[key] = index
+		}
+	}
 	return compiler
 }
 
 func (compiler *Compiler) Compile(node ast.Node) error {
+	compiler.resolutionErrors = nil
+	if compiler.optimizationLevel >= 1 {
+		node = Fold(node)
+	}
+	err := compiler.compile(node)
+	if err != nil {
+		return err
+	}
+	if len(compiler.resolutionErrors) > 0 {
+		return compiler.resolutionErrors
+	}
+	return nil
+}
+
+func (compiler *Compiler) compile(node ast.Node) error {
+	tail := compiler.tailPosition
+	compiler.tailPosition = false
+	if position, ok := positionOf(node); ok {
+		enclosing := compiler.position
+		compiler.position = position
+		defer func() { compiler.position = enclosing }()
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
 		for _, s := range node.Statements {
-			err := compiler.Compile(s)
+			err := compiler.compile(s)
 			if err != nil {
 				return err
 			}
 		}
 	case *ast.BlockStatement:
-		for _, statement := range node.Statements {
-			err := compiler.Compile(statement)
+		for i, statement := range node.Statements {
+			compiler.tailPosition = tail && i == len(node.Statements)-1
+			err := compiler.compile(statement)
 			if err != nil {
 				return err
 			}
 		}
 	case *ast.ExpressionStatement:
-		err := compiler.Compile(node.Expression)
+		compiler.tailPosition = tail
+		err := compiler.compile(node.Expression)
 		if err != nil {
 			return err
 		}
 		compiler.emit(code.OpPop)
 	case *ast.LetStatement:
+		err := compiler.compile(node.Value)
+		if err != nil {
+			return err
+		}
 		symbol := compiler.symbolTable.Define(node.Identifier.Value)
-		err := compiler.Compile(node.Value)
+		err = checkBinding(symbol)
 		if err != nil {
 			return err
 		}
@@ -93,12 +146,14 @@ func (compiler *Compiler) Compile(node ast.Node) error {
 	case *ast.Identifier:
 		symbol, ok := compiler.symbolTable.Resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("undefined variable %s", node.Value)
+			compiler.resolutionErrors = append(compiler.resolutionErrors, NewResolutionError(node, compiler.symbolTable.Names()))
+			compiler.emit(code.OpNull)
+			return nil
 		}
 
 		compiler.loadSymbol(symbol)
 	case *ast.PrefixExpression:
-		err := compiler.Compile(node.Operand)
+		err := compiler.compile(node.Operand)
 		if err != nil {
 			return err
 		}
@@ -111,23 +166,11 @@ func (compiler *Compiler) Compile(node ast.Node) error {
 			return fmt.Errorf("unknown operator %s", node.Operator)
 		}
 	case *ast.InfixExpression:
-		if node.Operator == "<" {
-			err := compiler.Compile(node.Right)
-			if err != nil {
-				return err
-			}
-			err = compiler.Compile(node.Left)
-			if err != nil {
-				return err
-			}
-			compiler.emit(code.OpGreaterThan)
-			return nil
-		}
-		err := compiler.Compile(node.Left)
+		err := compiler.compile(node.Left)
 		if err != nil {
 			return err
 		}
-		err = compiler.Compile(node.Right)
+		err = compiler.compile(node.Right)
 		if err != nil {
 			return err
 		}
@@ -140,8 +183,14 @@ func (compiler *Compiler) Compile(node ast.Node) error {
 			compiler.emit(code.OpMul)
 		case "/":
 			compiler.emit(code.OpDiv)
+		case "<":
+			compiler.emit(code.OpLessThan)
+		case "<=":
+			compiler.emit(code.OpLessEqual)
 		case ">":
 			compiler.emit(code.OpGreaterThan)
+		case ">=":
+			compiler.emit(code.OpGreaterEqual)
 		case "==":
 			compiler.emit(code.OpEqual)
 		case "!=":
@@ -150,42 +199,51 @@ func (compiler *Compiler) Compile(node ast.Node) error {
 			return fmt.Errorf("unknown operator %s", node.Operator)
 		}
 	case *ast.IfExpression:
-		err := compiler.Compile(node.Condition)
+		if truthy, ok := staticCondition(node.Condition); ok && compiler.optimizationLevel >= 1 {
+			if truthy {
+				err := compiler.compileBranch(node.Consequence, tail)
+				if err != nil {
+					return err
+				}
+				return compiler.defineLetStatements(node.Alternative)
+			}
+			err := compiler.defineLetStatements(node.Consequence)
+			if err != nil {
+				return err
+			}
+			return compiler.compileBranch(node.Alternative, tail)
+		}
+
+		err := compiler.compile(node.Condition)
 		if err != nil {
 			return err
 		}
 
-		jumpIfFalsePosition := compiler.emit(code.OpJumpIfFalse, -1)
+		jumpIfFalsePosition := compiler.emit(code.OpJumpIfFalse, jumpPlaceholder)
 
-		err = compiler.Compile(node.Consequence)
+		err = compiler.compileBranch(node.Consequence, tail)
 		if err != nil {
 			return err
 		}
 
-		if compiler.lastInstructionIs(code.OpPop) {
-			compiler.removeLastInstruction()
-		}
-
-		jumpPosition := compiler.emit(code.OpJump, -1)
+		jumpPosition := compiler.emit(code.OpJump, jumpPlaceholder)
 
 		afterConsequencePosition := len(compiler.currentInstructions())
-		compiler.replaceOperand(jumpIfFalsePosition, afterConsequencePosition)
-
-		if node.Alternative != nil {
-			err := compiler.Compile(node.Alternative)
-			if err != nil {
-				return err
-			}
+		err = compiler.replaceOperand(jumpIfFalsePosition, afterConsequencePosition)
+		if err != nil {
+			return err
+		}
 
-			if compiler.lastInstructionIs(code.OpPop) {
-				compiler.removeLastInstruction()
-			}
-		} else {
-			compiler.emit(code.OpNull)
+		err = compiler.compileBranch(node.Alternative, tail)
+		if err != nil {
+			return err
 		}
 
 		alternativePosition := len(compiler.currentInstructions())
-		compiler.replaceOperand(jumpPosition, alternativePosition)
+		err = compiler.replaceOperand(jumpPosition, alternativePosition)
+		if err != nil {
+			return err
+		}
 	case *ast.BooleanExpression:
 		boolean := object.Boolean{Value: node.Value}
 		if boolean.Value {
@@ -197,27 +255,36 @@ func (compiler *Compiler) Compile(node ast.Node) error {
 		integer := object.Integer{Value: node.Value}
 		compiler.emit(code.OpConstant, compiler.addConstant(&integer))
 	case *ast.StringLiteral:
-		stringLiteral := object.String{Value: node.Value}
-		compiler.emit(code.OpConstant, compiler.addConstant(&stringLiteral))
+		compiler.emit(code.OpConstant, compiler.addConstant(&object.String{Value: node.Value}))
 	case *ast.ReturnStatement:
-		err := compiler.Compile(node.ReturnValue)
+		compiler.tailPosition = compiler.scopeIndex > 0
+		err := compiler.compile(node.ReturnValue)
 		if err != nil {
 			return err
 		}
+		compiler.closeUpvalues()
 		compiler.emit(code.OpReturnValue)
 	case *ast.CallExpression:
-		err := compiler.Compile(node.Function)
+		err := compiler.compile(node.Function)
 		if err != nil {
 			return err
 		}
 		for _, argument := range node.Arguments {
-			err := compiler.Compile(argument)
+			err := compiler.compile(argument)
 			if err != nil {
 				return err
 			}
 		}
 
-		compiler.emit(code.OpCall, len(node.Arguments))
+		if !code.FitsWide(code.OpCall, len(node.Arguments)) {
+			return fmt.Errorf("too many arguments: %d", len(node.Arguments))
+		}
+		if tail {
+			compiler.closeUpvalues()
+			compiler.emit(code.OpTailCall, len(node.Arguments))
+		} else {
+			compiler.emit(code.OpCall, len(node.Arguments))
+		}
 	case *ast.FunctionLiteral:
 		compiler.enterScope()
 
@@ -226,10 +293,15 @@ func (compiler *Compiler) Compile(node ast.Node) error {
 		}
 
 		for _, parameter := range node.Parameters {
-			compiler.symbolTable.Define(parameter.(*ast.Identifier).Value)
+			symbol := compiler.symbolTable.DefineParameter(parameter.(*ast.Identifier).Value)
+			err := checkBinding(symbol)
+			if err != nil {
+				return err
+			}
 		}
 
-		err := compiler.Compile(node.Body)
+		compiler.tailPosition = true
+		err := compiler.compile(node.Body)
 		if err != nil {
 			return err
 		}
@@ -238,36 +310,49 @@ func (compiler *Compiler) Compile(node ast.Node) error {
 			compiler.replaceLastPopWithReturn()
 		}
 		if !compiler.lastInstructionIs(code.OpReturnValue) {
+			compiler.closeUpvalues()
 			compiler.emit(code.OpReturnVoid)
 		}
 
-		symbolTable, instruction := compiler.leaveScope()
+		symbolTable, instructions, sourceMap := compiler.leaveScope()
+		if free := len(symbolTable.FreeSymbols); free > 0 && !code.FitsWide(code.OpGetFree, free-1) {
+			return fmt.Errorf("too many free variables: function captures %d", free)
+		}
 
-		for _, symbol := range symbolTable.FreeSymbols {
-			compiler.loadSymbol(symbol)
+		captures := make([]object.Capture, len(symbolTable.FreeSymbols))
+		freeNames := make([]string, len(symbolTable.FreeSymbols))
+		for i, symbol := range symbolTable.FreeSymbols {
+			captures[i] = compiler.capture(symbol)
+			freeNames[i] = symbol.Name
 		}
 
+		instructions, sourceMap = compiler.optimize(instructions, sourceMap)
 		function := &object.CompiledFunction{
-			Instructions:       instruction,
+			Name:               node.Name,
+			Instructions:       instructions,
 			LocalVariableArity: symbolTable.numDefinitions,
 			ParameterArity:     len(node.Parameters),
+			Captures:           captures,
+			SourceMap:          sourceMap,
+			LocalNames:         symbolTable.DefinedNames(),
+			FreeNames:          freeNames,
 		}
-		compiler.emit(code.OpClosure, compiler.addConstant(function), len(symbolTable.FreeSymbols))
+		compiler.emit(code.OpClosure, compiler.addConstant(function))
 	case *ast.ArrayLiteral:
 		for _, el := range node.Elements {
-			err := compiler.Compile(el)
+			err := compiler.compile(el)
 			if err != nil {
 				return err
 			}
 		}
 		compiler.emit(code.OpArray, len(node.Elements))
 	case *ast.IndexExpression:
-		err := compiler.Compile(node.Expression)
+		err := compiler.compile(node.Expression)
 		if err != nil {
 			return err
 		}
 
-		err = compiler.Compile(node.Index)
+		err = compiler.compile(node.Index)
 		if err != nil {
 			return err
 		}
@@ -277,10 +362,126 @@ func (compiler *Compiler) Compile(node ast.Node) error {
 	return nil
 }
 
+// SetOptimizationLevel selects the optimisations Compile applies. Level 0
+// compiles the program as written; level 1 folds constant expressions and
+// drops if branches that can never run; level 2 also runs the peephole
+// optimiser over the emitted bytecode.
+func (compiler *Compiler) SetOptimizationLevel(level int) {
+	compiler.optimizationLevel = level
+}
+
+func (compiler *Compiler) optimize(instructions code.Instructions, sourceMap code.SourceMap) (code.Instructions, code.SourceMap) {
+	if compiler.optimizationLevel >= 2 {
+		return code.OptimizeWithSourceMap(instructions, sourceMap)
+	}
+	return instructions, sourceMap
+}
+
+// positionOf returns the position node's instructions are mapped to: that of
+// its token, or for a call that of the function called when it is named, as
+// the evaluator reports call errors.
+func positionOf(node ast.Node) (code.Position, bool) {
+	var tok *token.Token
+	switch node := node.(type) {
+	case *ast.CallExpression:
+		tok = &node.Token
+		if identifier, ok := node.Function.(*ast.Identifier); ok && identifier.Token != nil {
+			tok = identifier.Token
+		}
+	case *ast.FunctionLiteral:
+		tok = &node.Token
+	case *ast.BlockStatement:
+		tok = &node.Token
+	case *ast.Identifier:
+		tok = node.Token
+	case *ast.IntegerLiteral:
+		tok = node.Token
+	case *ast.StringLiteral:
+		tok = node.Token
+	case *ast.BooleanExpression:
+		tok = node.Token
+	case *ast.ArrayLiteral:
+		tok = node.Token
+	case *ast.IndexExpression:
+		tok = node.Token
+	case *ast.PrefixExpression:
+		tok = node.Token
+	case *ast.InfixExpression:
+		tok = node.Token
+	case *ast.IfExpression:
+		tok = node.Token
+	case *ast.ExpressionStatement:
+		tok = node.Token
+	case *ast.LetStatement:
+		tok = node.Token
+	case *ast.ReturnStatement:
+		tok = node.Token
+	}
+	if tok == nil || tok.Line == 0 {
+		return code.Position{}, false
+	}
+	return code.Position{Line: tok.Line, Column: tok.Column}, true
+}
+
+// compileBranch compiles the only branch of an if expression that can run,
+// leaving its value on the stack.
+func (compiler *Compiler) compileBranch(branch *ast.BlockStatement, tail bool) error {
+	before := len(compiler.currentInstructions())
+	if branch != nil {
+		compiler.tailPosition = tail
+		err := compiler.compile(branch)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(compiler.currentInstructions()) > before && compiler.lastInstructionIs(code.OpPop) {
+		compiler.removeLastInstruction()
+		return nil
+	}
+	compiler.emit(code.OpNull)
+	return nil
+}
+
+// checkBinding returns an error when the instructions reading and setting
+// symbol cannot encode its index.
+func checkBinding(symbol Symbol) error {
+	switch {
+	case symbol.Scope == GlobalScope && !code.Fits(code.OpSetGlobal, symbol.Index):
+		return fmt.Errorf("too many global bindings: %s is binding %d", symbol.Name, symbol.Index+1)
+	case symbol.Scope == LocalScope && !code.FitsWide(code.OpSetLocal, symbol.Index):
+		return fmt.Errorf("too many local bindings: %s is binding %d", symbol.Name, symbol.Index+1)
+	}
+	return nil
+}
+
+// defineLetStatements defines the names bound in a branch that is folded
+// away, as compiling it would, so that reading one fails the same way at
+// every optimization level.
+func (compiler *Compiler) defineLetStatements(branch *ast.BlockStatement) error {
+	var err error
+	ast.Inspect(branch, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.LetStatement:
+			symbol := compiler.symbolTable.Define(node.Identifier.Value)
+			if err == nil {
+				err = checkBinding(symbol)
+			}
+		case *ast.FunctionLiteral, *ast.MacroLiteral:
+			return false
+		}
+		return true
+	})
+	return err
+}
+
 func (compiler *Compiler) ByteCode() *ByteCode {
+	instructions, sourceMap := compiler.optimize(compiler.currentInstructions(), compiler.currentScope().sourceMap)
 	return &ByteCode{
-		Instructions: compiler.currentInstructions(),
+		Instructions: instructions,
 		Constants:    compiler.constants,
+		SourceMap:    sourceMap,
+		GlobalNames:  compiler.symbolTable.DefinedNames(),
 	}
 }
 
@@ -295,12 +496,43 @@ func (compiler *Compiler) addInstruction(instructions []byte) int {
 	position := len(compiler.currentInstructions())
 	updatedInstructions := append(compiler.currentInstructions(), instructions...)
 	compiler.setCurrentInstruction(updatedInstructions)
+	scope := &compiler.scopes[compiler.scopeIndex]
+	scope.sourceMap = append(scope.sourceMap, code.SourceMapping{Offset: position, Position: compiler.position})
 	return position
 }
 
+// ConstantKey identifies integer and string constants by value, so that
+// compilers add each value to their pool once.
+type ConstantKey struct {
+	objectType object.Type
+	integer    int64
+	str        string
+}
+
+// ConstantKeyOf returns the key of constant, if it is an integer or a
+// string.
+func ConstantKeyOf(constant object.Object) (ConstantKey, bool) {
+	switch constant := constant.(type) {
+	case *object.Integer:
+		return ConstantKey{objectType: object.INTEGER, integer: constant.Value}, true
+	case *object.String:
+		return ConstantKey{objectType: object.STRING, str: constant.Value}, true
+	}
+	return ConstantKey{}, false
+}
+
 func (compiler *Compiler) addConstant(node object.Object) int {
+	key, ok := ConstantKeyOf(node)
+	if ok {
+		if position, found := compiler.constantIndexes[key]; found {
+			return position
+		}
+	}
 	position := len(compiler.constants)
 	compiler.constants = append(compiler.constants, node)
+	if ok {
+		compiler.constantIndexes[key] = position
+	}
 	return position
 }
 
@@ -326,13 +558,24 @@ func (compiler *Compiler) removeLastInstruction() {
 	newInstructions := compiler.currentInstructions()[:last.Position]
 
 	compiler.setCurrentInstruction(newInstructions)
+	scope := &compiler.scopes[compiler.scopeIndex]
+	scope.sourceMap = scope.sourceMap[:len(scope.sourceMap)-1]
 	compiler.setLastEmittedInstruction(previous)
 }
 
-func (compiler *Compiler) replaceOperand(opcodePosition int, operand int) {
+// jumpPlaceholder is the target jumps are emitted with before the target
+// is known. It fits the 2-byte operand of a jump, which replaceOperand then
+// patches in place, so it must not need OpWide.
+const jumpPlaceholder = math.MaxUint16
+
+func (compiler *Compiler) replaceOperand(opcodePosition int, operand int) error {
 	opcode := code.Opcode(compiler.currentInstructions()[opcodePosition])
+	if !code.Fits(opcode, operand) {
+		return fmt.Errorf("function too large: jump target %d exceeds %d", operand, math.MaxUint16)
+	}
 	instructions := code.Make(opcode, operand)
 	compiler.replaceInstruction(opcodePosition, instructions)
+	return nil
 }
 
 func (compiler *Compiler) replaceInstruction(position int, instruction []byte) {
@@ -368,21 +611,52 @@ func (compiler *Compiler) enterScope() {
 	compiler.scopes = append(compiler.scopes, scope)
 	compiler.scopeIndex++
 }
-func (compiler *Compiler) leaveScope() (*SymbolTable, code.Instructions) {
+func (compiler *Compiler) leaveScope() (*SymbolTable, code.Instructions, code.SourceMap) {
 	instructions := compiler.currentInstructions()
+	sourceMap := compiler.currentScope().sourceMap
 	localSymbolTable := compiler.symbolTable
 	compiler.symbolTable = localSymbolTable.Enclosing
 	compiler.scopes = compiler.scopes[:len(compiler.scopes)-1]
 	compiler.scopeIndex--
-	return localSymbolTable, instructions
+	return localSymbolTable, instructions, sourceMap
 }
 
 func (compiler *Compiler) replaceLastPopWithReturn() {
+	if compiler.currentScope().capturesLocals {
+		compiler.removeLastInstruction()
+		compiler.closeUpvalues()
+		compiler.emit(code.OpReturnValue)
+		return
+	}
 	lastPos := compiler.currentScope().lastInstruction.Position
 	compiler.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
 	compiler.scopes[compiler.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
 }
 
+// closeUpvalues emits OpCloseUpvalue ahead of a return once a closure has
+// captured one of the function's locals. Jumps only go forward, so a return
+// emitted before the first capture never runs with an upvalue open. A tail
+// call has closed them already.
+func (compiler *Compiler) closeUpvalues() {
+	if compiler.currentScope().capturesLocals && !compiler.lastInstructionIs(code.OpTailCall) {
+		compiler.emit(code.OpCloseUpvalue)
+	}
+}
+
+// capture describes where a closure created in the current scope finds the
+// free variable symbol.
+func (compiler *Compiler) capture(symbol Symbol) object.Capture {
+	switch symbol.Scope {
+	case LocalScope:
+		compiler.scopes[compiler.scopeIndex].capturesLocals = true
+		return object.Capture{Kind: object.CaptureLocal, Index: symbol.Index}
+	case FunctionScope:
+		return object.Capture{Kind: object.CaptureClosure}
+	default:
+		return object.Capture{Kind: object.CaptureFree, Index: symbol.Index}
+	}
+}
+
 func (compiler *Compiler) loadSymbol(symbol Symbol) {
 	if symbol.Scope == GlobalScope {
 		compiler.emit(code.OpGetGlobal, symbol.Index)
@@ -2,6 +2,8 @@ package compiler
 
 import (
 	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 	"writing-in-interpreter-in-go/src/monkey/ast"
 	"writing-in-interpreter-in-go/src/monkey/code"
@@ -107,11 +109,27 @@ func TestBooleanExpressions(t *testing.T) {
 		},
 		{
 			input:             "1 < 2",
-			expectedConstants: []interface{}{2, 1},
+			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpGreaterThan),
+				code.Make(code.OpLessThan),
+				code.Make(code.OpPop)}},
+		{
+			input:             "1 <= 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpLessEqual),
+				code.Make(code.OpPop)}},
+		{
+			input:             "1 >= 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterEqual),
 				code.Make(code.OpPop)}},
 		{
 			input:             "1 == 2",
@@ -318,14 +336,14 @@ func TestIndexExpressions(t *testing.T) {
 	tests := []compilerTestCase{
 		{
 			input:             "[1, 2, 3][1 + 1]",
-			expectedConstants: []interface{}{1, 2, 3, 1, 1},
+			expectedConstants: []interface{}{1, 2, 3},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpConstant, 2),
 				code.Make(code.OpArray, 3),
-				code.Make(code.OpConstant, 3),
-				code.Make(code.OpConstant, 4),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpAdd),
 				code.Make(code.OpIndex),
 				code.Make(code.OpPop),
@@ -352,7 +370,7 @@ fn() { num }
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpSetGlobal, 0),
-				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpClosure, 1),
 				code.Make(code.OpPop),
 			},
 		},
@@ -373,7 +391,7 @@ num
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpClosure, 1),
 				code.Make(code.OpPop),
 			},
 		},
@@ -400,7 +418,7 @@ a + b
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpClosure, 2),
 				code.Make(code.OpPop),
 			},
 		},
@@ -423,7 +441,7 @@ func TestFunctions(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpClosure, 2),
 				code.Make(code.OpPop),
 			},
 		},
@@ -440,7 +458,7 @@ func TestFunctions(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpClosure, 2),
 				code.Make(code.OpPop),
 			},
 		},
@@ -457,7 +475,7 @@ func TestFunctions(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpClosure, 2),
 				code.Make(code.OpPop),
 			},
 		},
@@ -529,7 +547,7 @@ func TestFunctionCalls(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpClosure, 1),
 				code.Make(code.OpCall, 0),
 				code.Make(code.OpPop),
 			},
@@ -544,7 +562,7 @@ func TestFunctionCalls(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpClosure, 1),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpCall, 0),
@@ -564,7 +582,7 @@ oneArg(24);
 				24,
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpClosure, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpConstant, 1),
@@ -591,7 +609,7 @@ manyArg(24, 25, 26);
 				26,
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpClosure, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpConstant, 1),
@@ -616,7 +634,7 @@ func TestFunctionsWithoutReturnValue(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpClosure, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -650,12 +668,12 @@ push([], 1);
 				[]code.Instructions{
 					code.Make(code.OpGetBuiltin, 0),
 					code.Make(code.OpArray, 0),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpClosure, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -681,13 +699,13 @@ a + b
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpClosure, 0),
+					code.Make(code.OpCloseUpvalue),
 					code.Make(code.OpReturnValue),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpClosure, 1),
 				code.Make(code.OpPop),
 			},
 		},
@@ -711,19 +729,18 @@ a + b + c
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpClosure, 0, 2),
+					code.Make(code.OpClosure, 0),
+					code.Make(code.OpCloseUpvalue),
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpClosure, 1, 1),
+					code.Make(code.OpClosure, 1),
+					code.Make(code.OpCloseUpvalue),
 					code.Make(code.OpReturnValue),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpClosure, 2),
 				code.Make(code.OpPop),
 			},
 		},
@@ -761,23 +778,22 @@ global + a + b + c;
 				[]code.Instructions{
 					code.Make(code.OpConstant, 2),
 					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpClosure, 4, 2),
+					code.Make(code.OpClosure, 4),
+					code.Make(code.OpCloseUpvalue),
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
 					code.Make(code.OpConstant, 1),
 					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpClosure, 5, 1),
+					code.Make(code.OpClosure, 5),
+					code.Make(code.OpCloseUpvalue),
 					code.Make(code.OpReturnValue),
 				},
 			},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpSetGlobal, 0),
-				code.Make(code.OpClosure, 6, 0),
+				code.Make(code.OpClosure, 6),
 				code.Make(code.OpPop),
 			},
 		},
@@ -785,6 +801,59 @@ global + a + b + c;
 	runCompilerTests(t, tests)
 }
 
+// Closures capture variables rather than copy them: rebinding a captured
+// local reuses its slot and the function closes its upvalues before it
+// returns.
+func TestUpvalues(t *testing.T) {
+	input := `
+fn() {
+let a = 1;
+let f = fn() { a };
+let a = 2;
+f()
+}
+`
+	compiler := New()
+	err := compiler.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	constants := compiler.ByteCode().Constants
+
+	err = testConstants([]interface{}{
+		1,
+		[]code.Instructions{
+			code.Make(code.OpGetFree, 0),
+			code.Make(code.OpReturnValue),
+		},
+		2,
+		[]code.Instructions{
+			code.Make(code.OpConstant, 0),
+			code.Make(code.OpSetLocal, 0),
+			code.Make(code.OpClosure, 1),
+			code.Make(code.OpSetLocal, 1),
+			code.Make(code.OpConstant, 2),
+			code.Make(code.OpSetLocal, 0),
+			code.Make(code.OpGetLocal, 1),
+			code.Make(code.OpCloseUpvalue),
+			code.Make(code.OpTailCall, 0),
+			code.Make(code.OpReturnValue),
+		},
+	}, constants)
+	if err != nil {
+		t.Fatalf("testConstants failed: %s", err)
+	}
+
+	captures := constants[1].(*object.CompiledFunction).Captures
+	expected := []object.Capture{{Kind: object.CaptureLocal, Index: 0}}
+	if len(captures) != 1 || captures[0] != expected[0] {
+		t.Errorf("wrong captures. want=%+v, got=%+v", expected, captures)
+	}
+	if arity := constants[3].(*object.CompiledFunction).LocalVariableArity; arity != 2 {
+		t.Errorf("wrong number of locals. want=2, got=%d", arity)
+	}
+}
+
 func TestRecursiveFunctions(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -799,16 +868,15 @@ countDown(1);
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 0),
 					code.Make(code.OpSub),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
-				1,
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpClosure, 1),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
-				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpCall, 1),
 				code.Make(code.OpPop),
 			},
@@ -828,21 +896,20 @@ wrapper();
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 0),
 					code.Make(code.OpSub),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
-				1,
 				[]code.Instructions{
-					code.Make(code.OpClosure, 1, 0),
+					code.Make(code.OpClosure, 1),
 					code.Make(code.OpSetLocal, 0),
 					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpConstant, 2),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpClosure, 2),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpCall, 0),
@@ -854,11 +921,84 @@ wrapper();
 	runCompilerTests(t, tests)
 }
 
+func TestPeepholeOptimization(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `fn(a, b) { return a + b; 99 }`,
+			expectedConstants: []interface{}{
+				99,
+				[]code.Instructions{
+					code.Make(code.OpAddLocals, 0, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn(x) { if (x) { return 10; } else { 20 } }`,
+			expectedConstants: []interface{}{
+				10,
+				20,
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpGetLocal, 0),
+					// 0002
+					code.Make(code.OpJumpIfFalse, 9),
+					// 0005
+					code.Make(code.OpConstant, 0),
+					// 0008
+					code.Make(code.OpReturnValue),
+					// 0009
+					code.Make(code.OpConstant, 1),
+					// 0012
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `let one = fn() { 1 }; one(); len([])`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetBuiltin, 0),
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTestsWithOptimizationLevel(t, tests, 2)
+}
+
 func runCompilerTests(t *testing.T, tests []compilerTestCase) {
+	t.Helper()
+	runCompilerTestsWithOptimizationLevel(t, tests, 0)
+}
+
+func runCompilerTestsWithOptimizationLevel(t *testing.T, tests []compilerTestCase, level int) {
 	t.Helper()
 	for _, tt := range tests {
 		program := parse(tt.input)
 		compiler := New()
+		compiler.SetOptimizationLevel(level)
 		err := compiler.Compile(program)
 		if err != nil {
 			t.Fatalf("compiler error: %s", err)
@@ -957,3 +1097,235 @@ func parse(input string) *ast.Program {
 	p := parser.New(l)
 	return p.ParseProgram()
 }
+
+func TestResolutionErrors(t *testing.T) {
+	input := `let fibonacci = fn(x) { fibonaci(x - 1) + y };
+let result = fibonacci(10);
+resutl + lenn([]);`
+
+	comp := New()
+	err := comp.Compile(parse(input))
+	if err == nil {
+		t.Fatalf("expected compiler error but resulted in none")
+	}
+
+	resolutionErrors, ok := AsResolutionErrors(err)
+	if !ok {
+		t.Fatalf("error is not ResolutionErrors. got=%T (%+v)", err, err)
+	}
+
+	expected := []struct {
+		name       string
+		span       Span
+		suggestion string
+	}{
+		{"fibonaci", Span{Line: 1, Column: 25, EndLine: 1, EndColumn: 33}, "fibonacci"},
+		{"y", Span{Line: 1, Column: 43, EndLine: 1, EndColumn: 44}, ""},
+		{"resutl", Span{Line: 3, Column: 1, EndLine: 3, EndColumn: 7}, "result"},
+		{"lenn", Span{Line: 3, Column: 10, EndLine: 3, EndColumn: 14}, "len"},
+	}
+
+	if len(resolutionErrors) != len(expected) {
+		t.Fatalf("wrong number of errors. want=%d, got=%d (%s)", len(expected), len(resolutionErrors), err)
+	}
+	for i, tt := range expected {
+		actual := resolutionErrors[i]
+		if actual.Name != tt.name || actual.Span != tt.span || actual.Suggestion != tt.suggestion {
+			t.Errorf("errors[%d] wrong. want=%+v, got=%+v", i, tt, *actual)
+		}
+	}
+
+	expectedMessage := "1:25: undefined variable fibonaci, did you mean `fibonacci`?\n" +
+		"1:43: undefined variable y\n" +
+		"3:1: undefined variable resutl, did you mean `result`?\n" +
+		"3:10: undefined variable lenn, did you mean `len`?"
+	if err.Error() != expectedMessage {
+		t.Errorf("wrong error message.\nwant=%q\ngot= %q", expectedMessage, err.Error())
+	}
+}
+
+func TestJumpTargetOutOfRange(t *testing.T) {
+	var body strings.Builder
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&body, "%d; ", i)
+	}
+
+	comp := New()
+	err := comp.Compile(parse(fmt.Sprintf("if (true) { %s }", body.String())))
+	if err == nil {
+		t.Fatalf("expected compiler error but resulted in none")
+	}
+	expected := "function too large: jump target 80006 exceeds 65535"
+	if err.Error() != expected {
+		t.Errorf("wrong error. want=%q, got=%q", expected, err)
+	}
+}
+
+func TestOperandOutOfRange(t *testing.T) {
+	var lets strings.Builder
+	var arguments []string
+	for i := 0; i <= 65536; i++ {
+		fmt.Fprintf(&lets, "let %s = %d; ", localName(i), i)
+		arguments = append(arguments, "1")
+	}
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{fmt.Sprintf("fn() { %s }", lets.String()), "too many local bindings: " + localName(65536) + " is binding 65537"},
+		{fmt.Sprintf("len(%s)", strings.Join(arguments, ", ")), "too many arguments: 65537"},
+	}
+	for _, tt := range tests {
+		err := New().Compile(parse(tt.input))
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%v", tt.expected, err)
+		}
+	}
+}
+
+// localName spells i in letters, as identifiers cannot contain digits,
+// after a v that keeps it from being a keyword.
+func localName(i int) string {
+	name := ""
+	for {
+		name = string(rune('a'+i%26)) + name
+		i /= 26
+		if i == 0 {
+			return "v" + name
+		}
+	}
+}
+
+func TestConstantDeduplication(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `"a"; "a"; 1; 1; "1"`,
+			expectedConstants: []interface{}{"a", 1, "1"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestConstantsSharedAcrossCompilations(t *testing.T) {
+	symbolTable := NewSymbolTable()
+	first := NewWithState(symbolTable, nil)
+	err := first.Compile(parse(`let greeting = "monkey"; 7`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	constants := first.ByteCode().Constants
+
+	second := NewWithState(symbolTable, constants)
+	err = second.Compile(parse(`"monkey"; 7; greeting`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	err = testConstants([]interface{}{"monkey", 7}, second.ByteCode().Constants)
+	if err != nil {
+		t.Fatalf("testConstants failed: %s", err)
+	}
+	if second.ByteCode().Constants[0] != constants[0] {
+		t.Errorf("string constant is not shared across the compilations of a session")
+	}
+}
+
+func FuzzCompile(f *testing.F) {
+	f.Add("let add = fn(x, y) { x + y; }; add(1, 2);")
+	f.Add("let f = fn(n) { if (n < 2) { return n; } f(n - 1) }; f(10)")
+	f.Add("let a = 1; let g = fn() { let a = a + 1; fn() { a } }; g()()")
+	f.Add(`if (1 > 2) { "a" } else { [1, 2][0] <= -3 }`)
+	f.Fuzz(func(t *testing.T, input string) {
+		p := parser.New(lexer.New(input))
+		program := p.ParseProgram()
+		if len(p.Errors) > 0 {
+			return
+		}
+		for _, level := range []int{0, 1, 2} {
+			compiler := New()
+			compiler.SetOptimizationLevel(level)
+			_ = compiler.Compile(program)
+		}
+	})
+}
+
+func TestSourceMap(t *testing.T) {
+	input := "let x = 1;\nif (x > 0) {\n  puts(x)\n}\nlet f = fn(a) {\n  a + 1 };"
+	comp := New()
+	err := comp.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	byteCode := comp.ByteCode()
+
+	expected := code.SourceMap{
+		{Offset: 0, Position: code.Position{Line: 1, Column: 9}},  // OpConstant 1
+		{Offset: 3, Position: code.Position{Line: 1, Column: 1}},  // OpSetGlobal x
+		{Offset: 6, Position: code.Position{Line: 2, Column: 5}},  // OpGetGlobal x
+		{Offset: 9, Position: code.Position{Line: 2, Column: 9}},  // OpConstant 0
+		{Offset: 12, Position: code.Position{Line: 2, Column: 7}}, // OpGreaterThan
+		{Offset: 13, Position: code.Position{Line: 2, Column: 1}}, // OpJumpIfFalse
+		{Offset: 16, Position: code.Position{Line: 3, Column: 3}}, // OpGetBuiltin puts
+		{Offset: 18, Position: code.Position{Line: 3, Column: 8}}, // OpGetGlobal x
+		{Offset: 21, Position: code.Position{Line: 3, Column: 3}}, // OpCall
+		{Offset: 23, Position: code.Position{Line: 2, Column: 1}}, // OpJump
+		{Offset: 26, Position: code.Position{Line: 2, Column: 1}}, // OpNull
+		{Offset: 27, Position: code.Position{Line: 2, Column: 1}}, // OpPop
+		{Offset: 28, Position: code.Position{Line: 5, Column: 9}}, // OpClosure
+		{Offset: 31, Position: code.Position{Line: 5, Column: 1}}, // OpSetGlobal f
+	}
+	if !reflect.DeepEqual(byteCode.SourceMap, expected) {
+		t.Errorf("wrong source map.\nwant=%v\ngot =%v", expected, byteCode.SourceMap)
+	}
+
+	function := byteCode.Constants[2].(*object.CompiledFunction)
+	expected = code.SourceMap{
+		{Offset: 0, Position: code.Position{Line: 6, Column: 3}}, // OpGetLocal a
+		{Offset: 2, Position: code.Position{Line: 6, Column: 7}}, // OpConstant 1
+		{Offset: 5, Position: code.Position{Line: 6, Column: 5}}, // OpAdd
+		{Offset: 6, Position: code.Position{Line: 6, Column: 3}}, // OpReturnValue
+	}
+	if !reflect.DeepEqual(function.SourceMap, expected) {
+		t.Errorf("wrong function source map.\nwant=%v\ngot =%v", expected, function.SourceMap)
+	}
+}
+
+func TestDebugNames(t *testing.T) {
+	input := `let x = 1;
+let f = fn(a, a, b) {
+  let c = a + b;
+  let d = fn() { c + x };
+};`
+	comp := New()
+	err := comp.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	byteCode := comp.ByteCode()
+	if expected := []string{"x", "f"}; !reflect.DeepEqual(byteCode.GlobalNames, expected) {
+		t.Errorf("wrong global names. want=%q, got=%q", expected, byteCode.GlobalNames)
+	}
+
+	// The first a is shadowed by the second.
+	outer := byteCode.Constants[2].(*object.CompiledFunction)
+	if expected := []string{"", "a", "b", "c", "d"}; !reflect.DeepEqual(outer.LocalNames, expected) {
+		t.Errorf("wrong local names. want=%q, got=%q", expected, outer.LocalNames)
+	}
+	inner := byteCode.Constants[1].(*object.CompiledFunction)
+	if expected := []string{"c"}; !reflect.DeepEqual(inner.FreeNames, expected) {
+		t.Errorf("wrong free names. want=%q, got=%q", expected, inner.FreeNames)
+	}
+}
@@ -0,0 +1,42 @@
+package compiler
+
+import (
+	"bytes"
+	"fmt"
+	"writing-in-interpreter-in-go/src/monkey/object"
+)
+
+// Disassemble renders byteCode as text: the main instructions, the
+// instructions of every compiled function in the constant pool and a report
+// of the pool's size.
+func Disassemble(byteCode *ByteCode) string {
+	var out bytes.Buffer
+	out.WriteString("main:\n")
+	out.WriteString(byteCode.Instructions.String())
+
+	counts := make(map[object.Type]int)
+	for index, constant := range byteCode.Constants {
+		counts[constant.Type()]++
+		function, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+		_, _ = fmt.Fprintf(&out, "\nconstant %d: function (parameters %d, locals %d):\n",
+			index, function.ParameterArity, function.LocalVariableArity)
+		out.WriteString(function.Instructions.String())
+	}
+
+	_, _ = fmt.Fprintf(&out, "\nconstant pool: %d (integers %d, strings %d, functions %d)\n",
+		len(byteCode.Constants), counts[object.INTEGER], counts[object.STRING], counts[object.COMPILED_FUNCTION])
+	for index, constant := range byteCode.Constants {
+		switch constant := constant.(type) {
+		case *object.String:
+			_, _ = fmt.Fprintf(&out, "%4d %s %q\n", index, constant.Type(), constant.Value)
+		case *object.CompiledFunction:
+			_, _ = fmt.Fprintf(&out, "%4d %s\n", index, constant.Type())
+		default:
+			_, _ = fmt.Fprintf(&out, "%4d %s %s\n", index, constant.Type(), constant.Inspect())
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,37 @@
+package compiler
+
+import "testing"
+
+func TestDisassemble(t *testing.T) {
+	comp := New()
+	err := comp.Compile(parse(`let greet = fn(name) { "hi " + name }; greet("hi "); 2`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	expected := `main:
+0000 OpClosure 1
+0003 OpSetGlobal 0
+0006 OpGetGlobal 0
+0009 OpConstant 0
+0012 OpCall 1
+0014 OpPop
+0015 OpConstant 2
+0018 OpPop
+
+constant 1: function (parameters 1, locals 1):
+0000 OpConstant 0
+0003 OpGetLocal 0
+0005 OpAdd
+0006 OpReturnValue
+
+constant pool: 3 (integers 1, strings 1, functions 1)
+   0 STRING "hi "
+   1 COMPILED_FUNCTION
+   2 INTEGER 2
+`
+	actual := Disassemble(comp.ByteCode())
+	if actual != expected {
+		t.Errorf("wrong disassembly.\nwant=%q\ngot =%q", expected, actual)
+	}
+}
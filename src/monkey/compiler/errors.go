@@ -0,0 +1,112 @@
+package compiler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"writing-in-interpreter-in-go/src/monkey/ast"
+)
+
+// Span is the source range of a node. Lines and columns start at 1; the end
+// column is exclusive.
+type Span struct {
+	Line      int
+	Column    int
+	EndLine   int
+	EndColumn int
+}
+
+type ResolutionError struct {
+	Name       string
+	Span       Span
+	Suggestion string
+}
+
+func (err *ResolutionError) Error() string {
+	message := fmt.Sprintf("%d:%d: undefined variable %s", err.Span.Line, err.Span.Column, err.Name)
+	if err.Suggestion != "" {
+		message += fmt.Sprintf(", did you mean `%s`?", err.Suggestion)
+	}
+	return message
+}
+
+// ResolutionErrors holds every undefined identifier found while compiling a
+// program, in source order.
+type ResolutionErrors []*ResolutionError
+
+func (errs ResolutionErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+func (errs ResolutionErrors) Unwrap() []error {
+	unwrapped := make([]error, len(errs))
+	for i, err := range errs {
+		unwrapped[i] = err
+	}
+	return unwrapped
+}
+
+// AsResolutionErrors extracts the resolution errors from an error returned by
+// Compile.
+func AsResolutionErrors(err error) (ResolutionErrors, bool) {
+	var resolutionErrors ResolutionErrors
+	ok := errors.As(err, &resolutionErrors)
+	return resolutionErrors, ok
+}
+
+// NewResolutionError reports identifier as undefined, suggesting the name
+// closest to it among names. Every engine reports undefined variables with
+// it, so that they fail with the same message.
+func NewResolutionError(identifier *ast.Identifier, names []string) *ResolutionError {
+	err := &ResolutionError{
+		Name:       identifier.Value,
+		Suggestion: suggest(identifier.Value, names),
+	}
+	if identifier.Token != nil {
+		err.Span = Span{
+			Line:      identifier.Token.Line,
+			Column:    identifier.Token.Column,
+			EndLine:   identifier.Token.Line,
+			EndColumn: identifier.Token.Column + len(identifier.Value),
+		}
+	}
+	return err
+}
+
+// suggest returns the candidate closest to name, if it is close enough to be
+// a likely typo.
+func suggest(name string, candidates []string) string {
+	best := ""
+	bestDistance := len(name)/3 + 1
+	for _, candidate := range candidates {
+		distance := editDistance(name, candidate)
+		if distance < bestDistance {
+			best, bestDistance = candidate, distance
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,151 @@
+package compiler
+
+import (
+	"strconv"
+	"writing-in-interpreter-in-go/src/monkey/ast"
+	"writing-in-interpreter-in-go/src/monkey/token"
+)
+
+// Fold returns a copy of node in which arithmetic and comparisons on integer,
+// string and boolean literals are replaced by their result. Only operations
+// whose outcome the VM would compute the same way at runtime are folded, so
+// errors such as division by zero are left for the VM to report.
+func Fold(node ast.Node) ast.Node {
+	return ast.Modify(ast.Clone(node), func(node ast.Node) ast.Node {
+		switch node := node.(type) {
+		case *ast.PrefixExpression:
+			if folded := foldPrefix(node); folded != nil {
+				return folded
+			}
+		case *ast.InfixExpression:
+			if folded := foldInfix(node); folded != nil {
+				return folded
+			}
+		}
+		return node
+	})
+}
+
+func foldPrefix(node *ast.PrefixExpression) ast.Expression {
+	switch operand := node.Operand.(type) {
+	case *ast.IntegerLiteral:
+		switch node.Operator {
+		case "-":
+			return newIntegerLiteral(node.Token, -operand.Value)
+		case "!":
+			return newBooleanLiteral(node.Token, false)
+		}
+	case *ast.BooleanExpression:
+		if node.Operator == "!" {
+			return newBooleanLiteral(node.Token, !operand.Value)
+		}
+	case *ast.StringLiteral:
+		if node.Operator == "!" {
+			return newBooleanLiteral(node.Token, false)
+		}
+	}
+	return nil
+}
+
+func foldInfix(node *ast.InfixExpression) ast.Expression {
+	switch left := node.Left.(type) {
+	case *ast.IntegerLiteral:
+		right, ok := node.Right.(*ast.IntegerLiteral)
+		if !ok {
+			return nil
+		}
+		switch node.Operator {
+		case "+":
+			return newIntegerLiteral(node.Token, left.Value+right.Value)
+		case "-":
+			return newIntegerLiteral(node.Token, left.Value-right.Value)
+		case "*":
+			return newIntegerLiteral(node.Token, left.Value*right.Value)
+		case "/":
+			if right.Value == 0 {
+				return nil
+			}
+			return newIntegerLiteral(node.Token, left.Value/right.Value)
+		case "<":
+			return newBooleanLiteral(node.Token, left.Value < right.Value)
+		case "<=":
+			return newBooleanLiteral(node.Token, left.Value <= right.Value)
+		case ">":
+			return newBooleanLiteral(node.Token, left.Value > right.Value)
+		case ">=":
+			return newBooleanLiteral(node.Token, left.Value >= right.Value)
+		case "==":
+			return newBooleanLiteral(node.Token, left.Value == right.Value)
+		case "!=":
+			return newBooleanLiteral(node.Token, left.Value != right.Value)
+		}
+	case *ast.StringLiteral:
+		right, ok := node.Right.(*ast.StringLiteral)
+		if !ok {
+			return nil
+		}
+		switch node.Operator {
+		case "+":
+			return newStringLiteral(node.Token, left.Value+right.Value)
+		case "<":
+			return newBooleanLiteral(node.Token, left.Value < right.Value)
+		case "<=":
+			return newBooleanLiteral(node.Token, left.Value <= right.Value)
+		case ">":
+			return newBooleanLiteral(node.Token, left.Value > right.Value)
+		case ">=":
+			return newBooleanLiteral(node.Token, left.Value >= right.Value)
+		case "==":
+			return newBooleanLiteral(node.Token, left.Value == right.Value)
+		case "!=":
+			return newBooleanLiteral(node.Token, left.Value != right.Value)
+		}
+	case *ast.BooleanExpression:
+		right, ok := node.Right.(*ast.BooleanExpression)
+		if !ok {
+			return nil
+		}
+		switch node.Operator {
+		case "==":
+			return newBooleanLiteral(node.Token, left.Value == right.Value)
+		case "!=":
+			return newBooleanLiteral(node.Token, left.Value != right.Value)
+		}
+	}
+	return nil
+}
+
+// staticCondition reports whether an if condition is a literal whose
+// truthiness is known at compile time.
+func staticCondition(condition ast.Expression) (truthy bool, ok bool) {
+	switch condition := condition.(type) {
+	case *ast.BooleanExpression:
+		return condition.Value, true
+	case *ast.IntegerLiteral, *ast.StringLiteral:
+		return true, true
+	}
+	return false, false
+}
+
+func newIntegerLiteral(at *token.Token, value int64) *ast.IntegerLiteral {
+	literal := strconv.FormatInt(value, 10)
+	return &ast.IntegerLiteral{Token: foldedToken(at, token.INT, literal), Value: value}
+}
+
+func newStringLiteral(at *token.Token, value string) *ast.StringLiteral {
+	return &ast.StringLiteral{Token: foldedToken(at, token.STRING, value), Value: value}
+}
+
+func newBooleanLiteral(at *token.Token, value bool) *ast.BooleanExpression {
+	literal := strconv.FormatBool(value)
+	return &ast.BooleanExpression{Token: foldedToken(at, token.LookUpTokenType(literal), literal), Value: value}
+}
+
+// foldedToken keeps the position of the folded expression's operator.
+func foldedToken(at *token.Token, tokenType token.Type, literal string) *token.Token {
+	folded := &token.Token{Type: tokenType, Literal: literal}
+	if at != nil {
+		folded.Line, folded.Column = at.Line, at.Column
+	}
+	return folded
+}
@@ -0,0 +1,229 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"writing-in-interpreter-in-go/src/monkey/code"
+	"writing-in-interpreter-in-go/src/monkey/object"
+)
+
+// magic starts every bytecode (.mbc) file. Its last byte is the version of
+// the format.
+var magic = []byte("MBC\x06")
+
+const (
+	integerConstant byte = iota + 1
+	stringConstant
+	functionConstant
+)
+
+// Encode writes byteCode in the .mbc format: magic, the main instructions,
+// their source map and the names of the globals, and the constant pool. Numbers are varints and byte
+// strings are prefixed with their length.
+func (byteCode *ByteCode) Encode(w io.Writer) error {
+	var out bytes.Buffer
+	out.Write(magic)
+	writeBytes(&out, byteCode.Instructions)
+	writeSourceMap(&out, byteCode.SourceMap)
+	writeNames(&out, byteCode.GlobalNames)
+	writeUvarint(&out, len(byteCode.Constants))
+	for index, constant := range byteCode.Constants {
+		switch constant := constant.(type) {
+		case *object.Integer:
+			out.WriteByte(integerConstant)
+			out.Write(binary.AppendVarint(nil, constant.Value))
+		case *object.String:
+			out.WriteByte(stringConstant)
+			writeBytes(&out, []byte(constant.Value))
+		case *object.CompiledFunction:
+			out.WriteByte(functionConstant)
+			writeBytes(&out, []byte(constant.Name))
+			writeUvarint(&out, constant.ParameterArity)
+			writeUvarint(&out, constant.LocalVariableArity)
+			writeUvarint(&out, len(constant.Captures))
+			for _, capture := range constant.Captures {
+				writeUvarint(&out, int(capture.Kind))
+				writeUvarint(&out, capture.Index)
+			}
+			writeBytes(&out, constant.Instructions)
+			writeSourceMap(&out, constant.SourceMap)
+			writeNames(&out, constant.LocalNames)
+			writeNames(&out, constant.FreeNames)
+		default:
+			return fmt.Errorf("constant %d: cannot encode %s", index, constant.Type())
+		}
+	}
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+func writeUvarint(out *bytes.Buffer, value int) {
+	out.Write(binary.AppendUvarint(nil, uint64(value)))
+}
+
+func writeBytes(out *bytes.Buffer, value []byte) {
+	writeUvarint(out, len(value))
+	out.Write(value)
+}
+
+func writeNames(out *bytes.Buffer, names []string) {
+	writeUvarint(out, len(names))
+	for _, name := range names {
+		writeBytes(out, []byte(name))
+	}
+}
+
+// writeSourceMap writes the number of mappings followed by each mapping's
+// distance from the previous offset, line and column.
+func writeSourceMap(out *bytes.Buffer, sourceMap code.SourceMap) {
+	writeUvarint(out, len(sourceMap))
+	previous := 0
+	for _, mapping := range sourceMap {
+		writeUvarint(out, mapping.Offset-previous)
+		writeUvarint(out, mapping.Position.Line)
+		writeUvarint(out, mapping.Position.Column)
+		previous = mapping.Offset
+	}
+}
+
+// Decode reads bytecode written by Encode. It only checks that the file is
+// well formed: the instructions may still be invalid, see vm.Verify.
+func Decode(r io.Reader) (*ByteCode, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, magic) {
+		return nil, fmt.Errorf("not a bytecode file")
+	}
+	decoder := &decoder{data: data[len(magic):]}
+	byteCode := &ByteCode{Instructions: decoder.bytes(), SourceMap: decoder.sourceMap(), GlobalNames: decoder.names()}
+	count := decoder.count()
+	byteCode.Constants = make([]object.Object, 0, count)
+	for i := 0; i < count && decoder.err == nil; i++ {
+		byteCode.Constants = append(byteCode.Constants, decoder.constant())
+	}
+	if decoder.err == nil && len(decoder.data) > 0 {
+		decoder.fail("%d bytes after the constant pool", len(decoder.data))
+	}
+	if decoder.err != nil {
+		return nil, decoder.err
+	}
+	return byteCode, nil
+}
+
+// decoder consumes data, recording the first error. Once it has failed
+// every read returns a zero value.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (decoder *decoder) fail(format string, a ...interface{}) {
+	if decoder.err == nil {
+		decoder.err = fmt.Errorf("malformed bytecode file: "+format, a...)
+	}
+	decoder.data = nil
+}
+
+func (decoder *decoder) constant() object.Object {
+	tag := decoder.byte()
+	switch tag {
+	case integerConstant:
+		value, read := binary.Varint(decoder.data)
+		if read <= 0 {
+			decoder.fail("bad integer")
+			return nil
+		}
+		decoder.data = decoder.data[read:]
+		return &object.Integer{Value: value}
+	case stringConstant:
+		return &object.String{Value: string(decoder.bytes())}
+	case functionConstant:
+		function := &object.CompiledFunction{
+			Name:               string(decoder.bytes()),
+			ParameterArity:     decoder.uvarint(),
+			LocalVariableArity: decoder.uvarint(),
+		}
+		count := decoder.count()
+		function.Captures = make([]object.Capture, 0, count)
+		for i := 0; i < count && decoder.err == nil; i++ {
+			kind := object.CaptureKind(decoder.uvarint())
+			function.Captures = append(function.Captures, object.Capture{Kind: kind, Index: decoder.uvarint()})
+		}
+		function.Instructions = decoder.bytes()
+		function.SourceMap = decoder.sourceMap()
+		function.LocalNames = decoder.names()
+		function.FreeNames = decoder.names()
+		return function
+	default:
+		decoder.fail("unknown constant tag %d", tag)
+		return nil
+	}
+}
+
+func (decoder *decoder) byte() byte {
+	if len(decoder.data) == 0 {
+		decoder.fail("unexpected end of file")
+		return 0
+	}
+	value := decoder.data[0]
+	decoder.data = decoder.data[1:]
+	return value
+}
+
+func (decoder *decoder) uvarint() int {
+	value, read := binary.Uvarint(decoder.data)
+	if read <= 0 || value > math.MaxInt32 {
+		decoder.fail("bad length or index")
+		return 0
+	}
+	decoder.data = decoder.data[read:]
+	return int(value)
+}
+
+// count reads the number of items that follow. Every item takes at least a
+// byte, which bounds what a corrupt count can make Decode allocate.
+func (decoder *decoder) count() int {
+	count := decoder.uvarint()
+	if count > len(decoder.data) {
+		decoder.fail("%d items but %d bytes left", count, len(decoder.data))
+		return 0
+	}
+	return count
+}
+
+func (decoder *decoder) sourceMap() code.SourceMap {
+	count := decoder.count()
+	if count == 0 {
+		return nil
+	}
+	sourceMap := make(code.SourceMap, 0, count)
+	offset := 0
+	for i := 0; i < count && decoder.err == nil; i++ {
+		offset += decoder.uvarint()
+		position := code.Position{Line: decoder.uvarint(), Column: decoder.uvarint()}
+		sourceMap = append(sourceMap, code.SourceMapping{Offset: offset, Position: position})
+	}
+	return sourceMap
+}
+
+func (decoder *decoder) names() []string {
+	count := decoder.count()
+	names := make([]string, 0, count)
+	for i := 0; i < count && decoder.err == nil; i++ {
+		names = append(names, string(decoder.bytes()))
+	}
+	return names
+}
+
+func (decoder *decoder) bytes() code.Instructions {
+	length := decoder.count()
+	value := make([]byte, length)
+	copy(value, decoder.data)
+	decoder.data = decoder.data[length:]
+	return value
+}
@@ -0,0 +1,67 @@
+package compiler
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"writing-in-interpreter-in-go/src/monkey/code"
+	"writing-in-interpreter-in-go/src/monkey/object"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	comp := New()
+	err := comp.Compile(parse(`let add = fn(a) { fn(b) { a + b } }; puts(add(-7)(40), "monkey", "")`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	byteCode := comp.ByteCode()
+	byteCode.Constants = append(byteCode.Constants, &object.Integer{Value: 1 << 40}, &object.Integer{Value: -1 << 62})
+
+	var file bytes.Buffer
+	err = byteCode.Encode(&file)
+	if err != nil {
+		t.Fatalf("encoding failed: %s", err)
+	}
+	decoded, err := Decode(&file)
+	if err != nil {
+		t.Fatalf("decoding failed: %s", err)
+	}
+	if !reflect.DeepEqual(decoded, byteCode) {
+		t.Errorf("decoded bytecode differs.\nwant=%s\ngot =%s", Disassemble(byteCode), Disassemble(decoded))
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	var valid bytes.Buffer
+	_ = (&ByteCode{
+		Instructions: code.Make(code.OpConstant, 0),
+		Constants:    []object.Object{&object.String{Value: "monkey"}},
+	}).Encode(&valid)
+
+	tests := []struct {
+		file     []byte
+		expected string
+	}{
+		{[]byte("MK\x01"), "not a bytecode file"},
+		{valid.Bytes()[:len(valid.Bytes())-2], "malformed bytecode file: 6 items but 4 bytes left"},
+		{append(append([]byte{}, valid.Bytes()...), 0), "malformed bytecode file: 1 bytes after the constant pool"},
+		{[]byte("MBC\x06\x00\x00\x00\x01\x09"), "malformed bytecode file: unknown constant tag 9"},
+		{[]byte("MBC\x06\x00\x00\x00\x01\x01"), "malformed bytecode file: bad integer"},
+		{[]byte("MBC\x06\xff\xff\xff\xff\xff\xff"), "malformed bytecode file: bad length or index"},
+	}
+	for _, tt := range tests {
+		_, err := Decode(bytes.NewReader(tt.file))
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%v", tt.file, tt.expected, err)
+		}
+	}
+}
+
+func TestEncodeUnsupportedConstant(t *testing.T) {
+	byteCode := &ByteCode{Instructions: code.Instructions{}, Constants: []object.Object{object.TRUE}}
+	err := byteCode.Encode(&strings.Builder{})
+	if err == nil || err.Error() != "constant 0: cannot encode BOOLEAN" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+}
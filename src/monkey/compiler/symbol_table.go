@@ -1,5 +1,7 @@
 package compiler
 
+import "sort"
+
 type SymbolScope string
 
 const (
@@ -34,18 +36,42 @@ func NewEnclosedSymbolTable(enclosing *SymbolTable) *SymbolTable {
 	return symbolTable
 }
 
+// Define binds identifier in this table. Rebinding a name already defined
+// here reuses its slot, so closures that captured it see the new value as
+// they would in the evaluator.
 func (symbolTable *SymbolTable) Define(identifier string) Symbol {
-	symbol := Symbol{Name: identifier, Scope: GlobalScope, Index: symbolTable.numDefinitions}
-	if symbolTable.Enclosing == nil {
-		symbol.Scope = GlobalScope
-	} else {
-		symbol.Scope = LocalScope
+	symbol := symbolTable.Peek(identifier)
+	if symbol.Index == symbolTable.numDefinitions {
+		symbolTable.store[identifier] = symbol
+		symbolTable.numDefinitions++
 	}
+	return symbol
+}
+
+// DefineParameter binds identifier to a new local, even when an earlier
+// parameter has the same name, so that every argument has a slot and the
+// last of them is the one the name refers to.
+func (symbolTable *SymbolTable) DefineParameter(identifier string) Symbol {
+	symbol := Symbol{Name: identifier, Scope: LocalScope, Index: symbolTable.numDefinitions}
 	symbolTable.store[identifier] = symbol
 	symbolTable.numDefinitions++
 	return symbol
 }
 
+// Peek returns the symbol Define would bind identifier to without binding
+// it. The value of a let statement is compiled before its name is defined,
+// since it refers to the enclosing binding, and Peek tells where it goes.
+func (symbolTable *SymbolTable) Peek(identifier string) Symbol {
+	symbol := Symbol{Name: identifier, Scope: GlobalScope, Index: symbolTable.numDefinitions}
+	if symbolTable.Enclosing != nil {
+		symbol.Scope = LocalScope
+	}
+	if existing, ok := symbolTable.store[identifier]; ok && existing.Scope == symbol.Scope {
+		return existing
+	}
+	return symbol
+}
+
 func (symbolTable *SymbolTable) DefineBuiltin(index int, name string) Symbol {
 	symbol := Symbol{Name: name, Index: index, Scope: BuiltinScope}
 	symbolTable.store[name] = symbol
@@ -59,6 +85,7 @@ func (symbolTable *SymbolTable) DefineFree(symbol Symbol) Symbol {
 		Scope: FreeScope,
 		Index: len(symbolTable.FreeSymbols) - 1,
 	}
+	symbolTable.store[symbol.Name] = freeSymbol
 	return freeSymbol
 }
 
@@ -84,3 +111,47 @@ func (symbolTable *SymbolTable) DefineFunctionName(identifier string) Symbol {
 	symbolTable.store[identifier] = symbol
 	return symbol
 }
+
+// Copy returns a table binding the same names as this one, so that what is
+// defined in either afterwards does not change the other.
+func (symbolTable *SymbolTable) Copy() *SymbolTable {
+	copied := &SymbolTable{
+		Enclosing:      symbolTable.Enclosing,
+		FreeSymbols:    append([]Symbol{}, symbolTable.FreeSymbols...),
+		store:          make(map[string]Symbol, len(symbolTable.store)),
+		numDefinitions: symbolTable.numDefinitions,
+	}
+	for name, symbol := range symbolTable.store {
+		copied.store[name] = symbol
+	}
+	return copied
+}
+
+// DefinedNames returns the names defined in this table by index, leaving
+// empty the slots no name refers to any more.
+func (symbolTable *SymbolTable) DefinedNames() []string {
+	names := make([]string, symbolTable.numDefinitions)
+	for name, symbol := range symbolTable.store {
+		if (symbol.Scope == GlobalScope || symbol.Scope == LocalScope) && symbol.Index < len(names) {
+			names[symbol.Index] = name
+		}
+	}
+	return names
+}
+
+// Names returns every name visible from this table, including the ones
+// defined in enclosing tables.
+func (symbolTable *SymbolTable) Names() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for table := symbolTable; table != nil; table = table.Enclosing {
+		for name := range table.store {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
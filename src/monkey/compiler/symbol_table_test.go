@@ -44,6 +44,61 @@ func TestDefine(t *testing.T) {
 	}
 }
 
+func TestPeek(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	local := NewEnclosedSymbolTable(global)
+	local.Define("b")
+
+	tests := []struct {
+		table    *SymbolTable
+		name     string
+		expected Symbol
+	}{
+		{global, "a", Symbol{Name: "a", Scope: GlobalScope, Index: 0}},
+		{global, "c", Symbol{Name: "c", Scope: GlobalScope, Index: 1}},
+		{local, "a", Symbol{Name: "a", Scope: LocalScope, Index: 1}},
+		{local, "b", Symbol{Name: "b", Scope: LocalScope, Index: 0}},
+	}
+	for _, tt := range tests {
+		if symbol := tt.table.Peek(tt.name); symbol != tt.expected {
+			t.Errorf("expected %s=%+v, got=%+v", tt.name, tt.expected, symbol)
+		}
+	}
+	if symbol := local.Define("c"); symbol.Index != 1 {
+		t.Errorf("Peek defined a symbol: c has index %d", symbol.Index)
+	}
+}
+
+func TestDefineParameter(t *testing.T) {
+	local := NewEnclosedSymbolTable(NewSymbolTable())
+	local.DefineParameter("x")
+	local.DefineParameter("x")
+	symbol, ok := local.Resolve("x")
+	if !ok || symbol != (Symbol{Name: "x", Scope: LocalScope, Index: 1}) {
+		t.Errorf("x resolves to %+v, want the second parameter", symbol)
+	}
+	if local.numDefinitions != 2 {
+		t.Errorf("wrong number of locals. got=%d", local.numDefinitions)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	copied := global.Copy()
+	global.Define("b")
+	if _, ok := copied.Resolve("b"); ok {
+		t.Errorf("b resolves in the copy")
+	}
+	if symbol := copied.Define("c"); symbol.Index != 1 {
+		t.Errorf("wrong index for c. want=1, got=%d", symbol.Index)
+	}
+	if _, ok := global.Resolve("c"); ok {
+		t.Errorf("c resolves in the original")
+	}
+}
+
 func TestResolveGlobal(t *testing.T) {
 	global := NewSymbolTable()
 	global.Define("a")
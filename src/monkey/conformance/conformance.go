@@ -0,0 +1,128 @@
+// Package conformance runs Monkey programs under every execution engine and
+// reports where their behaviour differs.
+package conformance
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"writing-in-interpreter-in-go/src/monkey/engine"
+	"writing-in-interpreter-in-go/src/monkey/lexer"
+	"writing-in-interpreter-in-go/src/monkey/object"
+	"writing-in-interpreter-in-go/src/monkey/parser"
+)
+
+// Result is what a program printed with puts and the message of the error
+// that stopped it, if any.
+type Result struct {
+	Output string
+	Error  string
+}
+
+// String renders the result the way it is written in a corpus file: the
+// output followed by an ERROR line when the program failed.
+func (result Result) String() string {
+	if result.Error == "" {
+		return result.Output
+	}
+	return result.Output + "ERROR: " + result.Error + "\n"
+}
+
+// Run executes source with engine. Panics are reported as errors, so a
+// crashing engine shows up as a divergence. Run redirects object.Output and
+// must not be called concurrently.
+func Run(engine engine.Engine, source string) (result Result) {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors) > 0 {
+		return Result{Error: "parse errors: " + strings.Join(p.Errors, "; ")}
+	}
+
+	var output bytes.Buffer
+	previous := object.Output
+	object.Output = &output
+	defer func() {
+		object.Output = previous
+		result.Output = output.String()
+		if r := recover(); r != nil {
+			result.Error = fmt.Sprintf("panic: %v", r)
+		}
+	}()
+
+	if err := engine.Run(program); err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// Check runs source under every engine and returns an error describing the
+// results when they are not all the same.
+func Check(source string) error {
+	results := make([]Result, len(engine.Engines))
+	diverged := false
+	for i, engine := range engine.Engines {
+		results[i] = Run(engine, source)
+		if results[i] != results[0] {
+			diverged = true
+		}
+	}
+	if !diverged {
+		return nil
+	}
+	return fmt.Errorf("engines disagree:\n%s", describe(results))
+}
+
+// CheckExpected runs source under every engine and returns an error when
+// any of them does not produce expected.
+func CheckExpected(source, expected string) error {
+	results := make([]Result, len(engine.Engines))
+	failed := false
+	for i, engine := range engine.Engines {
+		results[i] = Run(engine, source)
+		if results[i].String() != expected {
+			failed = true
+		}
+	}
+	if !failed {
+		return nil
+	}
+	return fmt.Errorf("want:\n%s\ngot:\n%s", indent(expected), describe(results))
+}
+
+// Expected extracts the expected result of a corpus program from the
+// comment block starting with a "// Output:" line, in the manner of Go
+// examples. Every following comment line is a line of the expected output.
+func Expected(source string) (string, bool) {
+	lines := strings.Split(source, "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) != "// Output:" {
+			continue
+		}
+		var expected strings.Builder
+		for _, line := range lines[i+1:] {
+			line = strings.TrimSpace(line)
+			if !strings.HasPrefix(line, "//") {
+				break
+			}
+			line = strings.TrimPrefix(strings.TrimPrefix(line, "//"), " ")
+			expected.WriteString(line + "\n")
+		}
+		return expected.String(), true
+	}
+	return "", false
+}
+
+func describe(results []Result) string {
+	var out strings.Builder
+	for i, result := range results {
+		fmt.Fprintf(&out, "%s:\n%s", engine.Engines[i].Name, indent(result.String()))
+	}
+	return out.String()
+}
+
+func indent(text string) string {
+	if text == "" {
+		return "\t(nothing)\n"
+	}
+	return "\t" + strings.ReplaceAll(strings.TrimSuffix(text, "\n"), "\n", "\n\t") + "\n"
+}
@@ -0,0 +1,63 @@
+package conformance
+
+import (
+	"flag"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"writing-in-interpreter-in-go/src/monkey/lexer"
+	"writing-in-interpreter-in-go/src/monkey/parser"
+)
+
+var programs = flag.Int("programs", 300, "number of generated programs to check")
+var seed = flag.Int64("seed", 1, "seed of the first generated program")
+
+func TestCorpus(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.mk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no programs in testdata")
+	}
+	for _, path := range paths {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, ok := Expected(string(source))
+		if !ok {
+			t.Errorf("%s: no // Output: block", path)
+			continue
+		}
+		if err := CheckExpected(string(source), expected); err != nil {
+			t.Errorf("%s: %s", path, err)
+		}
+	}
+}
+
+func TestGeneratedPrograms(t *testing.T) {
+	for i := int64(0); i < int64(*programs); i++ {
+		source := Generate(rand.New(rand.NewSource(*seed + i)))
+		p := parser.New(lexer.New(source))
+		p.ParseProgram()
+		if len(p.Errors) > 0 {
+			t.Fatalf("seed %d: generated program does not parse:\n%s\n%s", *seed+i, source, p.Errors)
+		}
+		if err := Check(source); err != nil {
+			t.Fatalf("seed %d:\n%s\n%s", *seed+i, source, err)
+		}
+	}
+}
+
+func TestExpected(t *testing.T) {
+	source := "puts(1);\n// Output:\n// 1\n//\n//   indented\nputs(2);\n// 2\n"
+	expected, ok := Expected(source)
+	if !ok {
+		t.Fatal("no expected output found")
+	}
+	if expected != "1\n\n  indented\n" {
+		t.Errorf("wrong expected output. got=%q", expected)
+	}
+}
@@ -0,0 +1,297 @@
+package conformance
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+type kind int
+
+const (
+	integerKind kind = iota
+	booleanKind
+	stringKind
+	arrayKind
+	functionKind
+)
+
+// variable is a binding visible to the code being generated. Functions take
+// arity integer arguments and return an integer.
+type variable struct {
+	name  string
+	kind  kind
+	arity int
+}
+
+// generator builds well-typed programs, so that most of them run to the end
+// and exercise more than their first statement. Names are never shadowed:
+// the evaluator resolves a name when it is evaluated and the compilers when
+// it is compiled, which only agree when each name has a single binding
+// visible at a time.
+type generator struct {
+	random *rand.Rand
+	// scopes holds the variables of the enclosing function bodies, the
+	// global scope first.
+	scopes [][]variable
+	names  int
+	depth  int
+}
+
+const (
+	maxDepth         = 4
+	maxStatements    = 12
+	maxBodyStatement = 3
+	maxParameters    = 3
+)
+
+// Generate returns a random program that prints the values it computes with
+// puts.
+func Generate(random *rand.Rand) string {
+	generator := &generator{random: random, scopes: [][]variable{nil}}
+	var out strings.Builder
+	statements := 1 + random.Intn(maxStatements)
+	for i := 0; i < statements; i++ {
+		out.WriteString(generator.statement())
+		out.WriteString("\n")
+	}
+	return out.String()
+}
+
+func (generator *generator) statement() string {
+	switch generator.random.Intn(4) {
+	case 0:
+		return fmt.Sprintf("puts(%s);", generator.expression(generator.anyKind()))
+	case 1:
+		return generator.function()
+	default:
+		return generator.let(generator.anyKind())
+	}
+}
+
+// let binds a new name or, now and then, rebinds one of the current scope.
+func (generator *generator) let(kind kind) string {
+	value := generator.expression(kind)
+	scope := generator.scopes[len(generator.scopes)-1]
+	if generator.random.Intn(3) == 0 {
+		if existing, ok := generator.pick(scope, kind); ok {
+			return fmt.Sprintf("let %s = %s;", existing.name, value)
+		}
+	}
+	name := generator.define(variable{kind: kind})
+	return fmt.Sprintf("let %s = %s;", name, value)
+}
+
+// function defines a named function whose body can read the parameters and
+// every variable visible where it is defined.
+func (generator *generator) function() string {
+	arity := generator.random.Intn(maxParameters + 1)
+	literal := generator.functionLiteral(arity)
+	name := generator.define(variable{kind: functionKind, arity: arity})
+	return fmt.Sprintf("let %s = %s;", name, literal)
+}
+
+func (generator *generator) functionLiteral(arity int) string {
+	generator.scopes = append(generator.scopes, nil)
+	defer func() { generator.scopes = generator.scopes[:len(generator.scopes)-1] }()
+
+	parameters := make([]string, arity)
+	for i := range parameters {
+		parameters[i] = generator.define(variable{kind: integerKind})
+	}
+	var body []string
+	statements := generator.random.Intn(maxBodyStatement + 1)
+	for i := 0; i < statements; i++ {
+		switch generator.random.Intn(5) {
+		case 0:
+			body = append(body, generator.function())
+		case 1:
+			body = append(body, fmt.Sprintf("puts(%s);", generator.expression(generator.anyKind())))
+		case 2:
+			body = append(body, fmt.Sprintf("if (%s) { return %s; };",
+				generator.expression(booleanKind), generator.expression(integerKind)))
+		default:
+			body = append(body, generator.let(generator.anyKind()))
+		}
+	}
+	result := generator.expression(integerKind)
+	if generator.random.Intn(4) == 0 {
+		result = "return " + result + ";"
+	}
+	body = append(body, result)
+	return fmt.Sprintf("fn(%s) { %s }", strings.Join(parameters, ", "), strings.Join(body, " "))
+}
+
+func (generator *generator) expression(kind kind) string {
+	generator.depth++
+	defer func() { generator.depth-- }()
+	if generator.depth > maxDepth || generator.random.Intn(4) == 0 {
+		return generator.leaf(kind)
+	}
+	switch kind {
+	case integerKind:
+		return generator.integer()
+	case booleanKind:
+		return generator.boolean()
+	case stringKind:
+		return generator.string()
+	default:
+		return generator.array()
+	}
+}
+
+func (generator *generator) leaf(kind kind) string {
+	if variable, ok := generator.visible(kind); ok && generator.random.Intn(2) == 0 {
+		return variable.name
+	}
+	switch kind {
+	case integerKind:
+		return fmt.Sprint(generator.random.Intn(201) - 100)
+	case booleanKind:
+		return fmt.Sprint(generator.random.Intn(2) == 0)
+	case stringKind:
+		return generator.choose(`""`, `"a"`, `"b"`, `"ab"`, `"monkey"`)
+	default:
+		return fmt.Sprintf("[%s]", generator.leaf(integerKind))
+	}
+}
+
+func (generator *generator) integer() string {
+	switch generator.random.Intn(9) {
+	case 0:
+		return fmt.Sprintf("-%s", generator.expression(integerKind))
+	case 1:
+		return fmt.Sprintf("(%s / %d)", generator.expression(integerKind), 1+generator.random.Intn(9))
+	case 2:
+		return fmt.Sprintf("len(%s)", generator.expression(choose(generator.random, stringKind, arrayKind)))
+	case 3:
+		return fmt.Sprintf("%s[0]", generator.expression(arrayKind))
+	case 4:
+		return generator.call()
+	case 5:
+		return generator.conditional(integerKind)
+	default:
+		return fmt.Sprintf("(%s %s %s)", generator.expression(integerKind), generator.choose("+", "-", "*"),
+			generator.expression(integerKind))
+	}
+}
+
+func (generator *generator) boolean() string {
+	switch generator.random.Intn(6) {
+	case 0:
+		return fmt.Sprintf("!%s", generator.expression(generator.anyKind()))
+	case 1:
+		return fmt.Sprintf("(%s %s %s)", generator.expression(booleanKind), generator.choose("==", "!="),
+			generator.expression(booleanKind))
+	case 2:
+		return fmt.Sprintf("(%s %s %s)", generator.expression(stringKind), generator.comparison(),
+			generator.expression(stringKind))
+	case 3:
+		return generator.conditional(booleanKind)
+	default:
+		return fmt.Sprintf("(%s %s %s)", generator.expression(integerKind), generator.comparison(),
+			generator.expression(integerKind))
+	}
+}
+
+func (generator *generator) string() string {
+	switch generator.random.Intn(3) {
+	case 0:
+		return generator.conditional(stringKind)
+	default:
+		return fmt.Sprintf("(%s + %s)", generator.expression(stringKind), generator.expression(stringKind))
+	}
+}
+
+func (generator *generator) array() string {
+	switch generator.random.Intn(3) {
+	case 0:
+		return fmt.Sprintf("push(%s, %s)", generator.expression(arrayKind), generator.expression(integerKind))
+	case 1:
+		return generator.conditional(arrayKind)
+	default:
+		elements := make([]string, 1+generator.random.Intn(3))
+		for i := range elements {
+			elements[i] = generator.expression(integerKind)
+		}
+		return fmt.Sprintf("[%s]", strings.Join(elements, ", "))
+	}
+}
+
+// call calls a visible function or, when there is none or on a whim, a
+// function literal on the spot.
+func (generator *generator) call() string {
+	function, ok := generator.visible(functionKind)
+	callee := function.name
+	if !ok || generator.random.Intn(4) == 0 {
+		function.arity = generator.random.Intn(maxParameters + 1)
+		callee = generator.functionLiteral(function.arity)
+	}
+	arguments := make([]string, function.arity)
+	for i := range arguments {
+		arguments[i] = generator.expression(integerKind)
+	}
+	return fmt.Sprintf("%s(%s)", callee, strings.Join(arguments, ", "))
+}
+
+func (generator *generator) conditional(kind kind) string {
+	return fmt.Sprintf("if (%s) { %s } else { %s }", generator.expression(booleanKind),
+		generator.expression(kind), generator.expression(kind))
+}
+
+func (generator *generator) comparison() string {
+	return generator.choose("<", "<=", ">", ">=", "==", "!=")
+}
+
+func (generator *generator) anyKind() kind {
+	return kind(generator.random.Intn(int(arrayKind) + 1))
+}
+
+func (generator *generator) define(variable variable) string {
+	variable.name = name(generator.names)
+	generator.names++
+	scope := len(generator.scopes) - 1
+	generator.scopes[scope] = append(generator.scopes[scope], variable)
+	return variable.name
+}
+
+// name returns the nth of the names va, vb, ..., vz, vba, ... Identifiers
+// cannot contain digits, and the v keeps them clear of keywords and builtins.
+func name(n int) string {
+	letters := []byte{byte('a' + n%26)}
+	for n /= 26; n > 0; n /= 26 {
+		letters = append([]byte{byte('a' + n%26)}, letters...)
+	}
+	return "v" + string(letters)
+}
+
+// visible picks a random variable of kind from every enclosing scope.
+func (generator *generator) visible(kind kind) (variable, bool) {
+	var all []variable
+	for _, scope := range generator.scopes {
+		all = append(all, scope...)
+	}
+	return generator.pick(all, kind)
+}
+
+// pick returns a random variable of kind from variables.
+func (generator *generator) pick(variables []variable, kind kind) (variable, bool) {
+	var candidates []variable
+	for _, variable := range variables {
+		if variable.kind == kind {
+			candidates = append(candidates, variable)
+		}
+	}
+	if len(candidates) == 0 {
+		return variable{}, false
+	}
+	return candidates[generator.random.Intn(len(candidates))], true
+}
+
+func choose[T any](random *rand.Rand, choices ...T) T {
+	return choices[random.Intn(len(choices))]
+}
+
+func (generator *generator) choose(choices ...string) string {
+	return choose(generator.random, choices...)
+}
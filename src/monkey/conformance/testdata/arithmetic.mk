@@ -0,0 +1,14 @@
+puts(1 + 2 * 3);
+puts((1 + 2) * 3);
+puts(10 / 3);
+puts(-7 / 2);
+puts(-(5 - 8));
+puts(9223372036854775807 + 1);
+
+// Output:
+// 7
+// 9
+// 3
+// -3
+// 3
+// -9223372036854775808
@@ -0,0 +1,5 @@
+puts(len(1));
+puts("unreachable");
+
+// Output:
+// ERROR: argument to `len` not supported, got INTEGER
@@ -0,0 +1,5 @@
+let x = 1;
+x(2);
+
+// Output:
+// ERROR: not a function: INTEGER
@@ -0,0 +1,37 @@
+let adder = fn(a) { fn(b) { a + b } };
+let addTwo = adder(2);
+puts(addTwo(3), adder(10)(-1));
+
+let counter = fn() {
+  let value = 0;
+  let get = fn() { value };
+  let value = value + 1;
+  let value = value + 1;
+  get
+};
+puts(counter()());
+
+let pair = fn(x) {
+  let left = fn() { x * 2 };
+  let right = fn() { x * 4 };
+  [left, right]
+};
+let functions = pair(10);
+puts(functions[0](), functions[1]());
+
+let outer = fn(a) { fn(b) { fn(c) { a + b + c } } };
+puts(outer(1)(2)(3));
+
+let global = 1;
+let readGlobal = fn() { global };
+let global = 2;
+puts(readGlobal());
+
+// Output:
+// 5
+// 9
+// 2
+// 20
+// 40
+// 6
+// 2
@@ -0,0 +1,4 @@
+true > false;
+
+// Output:
+// ERROR: type mismatch: BOOLEAN > BOOLEAN
@@ -0,0 +1,23 @@
+puts(1 < 2, 2 <= 2, 3 > 4, 4 >= 5);
+puts(1 == 1, 1 != 1);
+puts("a" < "b", "b" <= "a", "ab" == "a" + "b");
+puts(true == true, true != false, !true, !!5, !first([]));
+puts(1 == true, [1] == [1]);
+
+// Output:
+// true
+// true
+// false
+// false
+// true
+// false
+// true
+// false
+// true
+// true
+// true
+// false
+// true
+// true
+// false
+// false
@@ -0,0 +1,14 @@
+puts(if (true) { 1 });
+puts(if (false) { 1 });
+puts(if (1 > 2) { 1 } else { 2 });
+puts(if (0) { "zero is truthy" } else { "zero is falsy" });
+puts(if (first([])) { 1 } else { 2 });
+puts(if (true) { });
+
+// Output:
+// 1
+// null
+// 2
+// zero is truthy
+// 2
+// null
@@ -0,0 +1,5 @@
+let zero = 0;
+puts(1 / zero);
+
+// Output:
+// ERROR: division by zero
@@ -0,0 +1,7 @@
+let second = fn(x, x) { x };
+puts(second(1, 2));
+puts(fn(a, b, a) { a + b }(1, 2, 3));
+
+// Output:
+// 2
+// 5
@@ -0,0 +1,23 @@
+let add = fn(a, b) { a + b };
+let apply = fn(f, x, y) { f(x, y) };
+puts(apply(add, 2, 3));
+puts(fn() { }());
+puts(fn() { return 1; 2 }());
+puts(fn(x) { if (x > 0) { return "positive"; } "not positive" }(-1));
+puts(len, puts);
+
+let fibonacci = fn(n) { if (n < 2) { n } else { fibonacci(n - 1) + fibonacci(n - 2) } };
+puts(fibonacci(15));
+
+let count = fn(n, total) { if (n == 0) { total } else { count(n - 1, total + n) } };
+puts(count(100000, 0));
+
+// Output:
+// 5
+// null
+// 1
+// not positive
+// builtin function
+// builtin function
+// 610
+// 5000050000
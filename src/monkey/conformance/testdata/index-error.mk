@@ -0,0 +1,4 @@
+"abc"[0];
+
+// Output:
+// ERROR: index operator not supported: STRING
@@ -0,0 +1,4 @@
+-true;
+
+// Output:
+// ERROR: unknown operator: -BOOLEAN
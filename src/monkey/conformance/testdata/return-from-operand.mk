@@ -0,0 +1,11 @@
+let sign = fn(x) {
+  if (x < 0) { return "negative"; } else { "" } + "";
+  let zero = if (x == 0) { return "zero"; } else { "positive" };
+  zero
+};
+puts(sign(-1), sign(0), sign(1));
+
+// Output:
+// negative
+// zero
+// positive
@@ -0,0 +1,21 @@
+let greeting = "Hello" + ", " + "monkey";
+puts(greeting, len(greeting));
+let numbers = [1, 2 + 3, 4 * 5];
+puts(numbers, len(numbers), numbers[1], numbers[3], numbers[-1]);
+puts(first(numbers), last(numbers), push(numbers, 6), numbers);
+puts(first([]), last([]));
+
+// Output:
+// Hello, monkey
+// 13
+// [1, 5, 20]
+// 3
+// 5
+// null
+// null
+// 1
+// 20
+// [1, 5, 20, 6]
+// [1, 5, 20]
+// null
+// null
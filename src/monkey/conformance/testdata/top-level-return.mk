@@ -0,0 +1,6 @@
+puts("before");
+if (len("monkey") > 3) { return 1; };
+puts("after");
+
+// Output:
+// before
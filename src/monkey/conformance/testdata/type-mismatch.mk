@@ -0,0 +1,7 @@
+puts("before");
+puts(5 + true);
+puts("after");
+
+// Output:
+// before
+// ERROR: type mismatch: INTEGER + BOOLEAN
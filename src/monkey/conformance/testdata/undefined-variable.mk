@@ -0,0 +1,5 @@
+let list = [1, 2, 3];
+rest(list);
+
+// Output:
+// ERROR: 2:1: undefined variable rest
@@ -0,0 +1,4 @@
+puts("a" - "b");
+
+// Output:
+// ERROR: unknown operator: STRING - STRING
@@ -0,0 +1,5 @@
+if (false) { let y = 1; };
+puts(y + 1);
+
+// Output:
+// ERROR: variable used before it is set
@@ -0,0 +1,7 @@
+let f = fn(set) { if (set) { let x = 1; }; x };
+puts(f(true));
+puts(fn() { if (false) { let x = 1; }; x }());
+
+// Output:
+// 1
+// ERROR: variable used before it is set
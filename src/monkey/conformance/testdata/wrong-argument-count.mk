@@ -0,0 +1,4 @@
+fn(a, b) { a + b }(1);
+
+// Output:
+// ERROR: wrong number of arguments: want=2, got=1
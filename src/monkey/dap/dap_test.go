@@ -0,0 +1,266 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"writing-in-interpreter-in-go/src/monkey/framing"
+)
+
+// client is a scripted debug adapter client.
+type client struct {
+	t   *testing.T
+	in  *bufio.Reader
+	out io.Writer
+	seq int
+	// events holds the events received and not expected yet.
+	events []map[string]any
+}
+
+func startServer(t *testing.T) *client {
+	clientIn, serverOut := io.Pipe()
+	serverIn, clientOut := io.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- NewServer(serverIn, serverOut).Serve()
+		serverOut.Close()
+	}()
+	t.Cleanup(func() {
+		// Whatever the server still sends is ignored.
+		go func() { _, _ = io.Copy(io.Discard, clientIn) }()
+		clientOut.Close()
+		if err := <-done; err != nil {
+			t.Errorf("server failed: %s", err)
+		}
+	})
+	return &client{t: t, in: bufio.NewReader(clientIn), out: clientOut}
+}
+
+func (client *client) read() map[string]any {
+	client.t.Helper()
+	content, err := framing.ReadMessage(client.in)
+	if err != nil {
+		client.t.Fatalf("reading a message failed: %s", err)
+	}
+	var message map[string]any
+	err = json.Unmarshal(content, &message)
+	if err != nil {
+		client.t.Fatalf("malformed message %s: %s", content, err)
+	}
+	return message
+}
+
+// request sends a request and returns the body of its response, keeping the
+// events received meanwhile.
+func (client *client) request(command string, arguments any) map[string]any {
+	client.t.Helper()
+	client.seq++
+	message := map[string]any{"seq": client.seq, "type": "request", "command": command}
+	if arguments != nil {
+		message["arguments"] = arguments
+	}
+	err := framing.WriteMessage(client.out, message)
+	if err != nil {
+		client.t.Fatalf("sending %s failed: %s", command, err)
+	}
+	for {
+		message := client.read()
+		if message["type"] == "event" {
+			client.events = append(client.events, message)
+			continue
+		}
+		if message["command"] != command || message["request_seq"] != float64(client.seq) {
+			client.t.Fatalf("unexpected response %v to %s", message, command)
+		}
+		if message["success"] != true {
+			client.t.Fatalf("%s failed: %v", command, message["message"])
+		}
+		body, _ := message["body"].(map[string]any)
+		return body
+	}
+}
+
+// expectEvent returns the body of the next event, which must be name.
+func (client *client) expectEvent(name string) map[string]any {
+	client.t.Helper()
+	var message map[string]any
+	if len(client.events) > 0 {
+		message, client.events = client.events[0], client.events[1:]
+	} else {
+		message = client.read()
+	}
+	if message["type"] != "event" || message["event"] != name {
+		client.t.Fatalf("got %v, want a %s event", message, name)
+	}
+	body, _ := message["body"].(map[string]any)
+	return body
+}
+
+// variables returns the variables under reference as name=value strings.
+func (client *client) variables(reference any) []string {
+	client.t.Helper()
+	body := client.request("variables", map[string]any{"variablesReference": reference})
+	var variables []string
+	for _, variable := range body["variables"].([]any) {
+		variable := variable.(map[string]any)
+		variables = append(variables, variable["name"].(string)+"="+variable["value"].(string))
+	}
+	return variables
+}
+
+func (client *client) expectStop(reason string, line float64) []any {
+	client.t.Helper()
+	if stopped := client.expectEvent("stopped"); stopped["reason"] != reason {
+		client.t.Errorf("wrong stop reason. want=%q, got=%v", reason, stopped["reason"])
+	}
+	frames := client.request("stackTrace", map[string]any{"threadId": threadID})["stackFrames"].([]any)
+	if got := frames[0].(map[string]any)["line"]; got != line {
+		client.t.Errorf("stopped on the wrong line. want=%v, got=%v", line, got)
+	}
+	return frames
+}
+
+func TestSession(t *testing.T) {
+	program := filepath.Join(t.TempDir(), "program.mk")
+	err := os.WriteFile(program, []byte(`let scale = 10;
+let weigh = fn(values, factor) {
+  let total = values[0] + values[1];
+  total * factor
+};
+puts(weigh([1, 2], scale));
+puts("done");
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := startServer(t)
+	capabilities := client.request("initialize", map[string]any{"adapterID": "monkey"})
+	if capabilities["supportsConfigurationDoneRequest"] != true {
+		t.Errorf("configurationDone is not supported: %v", capabilities)
+	}
+	client.request("launch", map[string]any{"program": program, "stopOnEntry": true})
+	client.expectEvent("initialized")
+	breakpoints := client.request("setBreakpoints", map[string]any{
+		"source":      map[string]any{"path": program},
+		"breakpoints": []any{map[string]any{"line": 4}, map[string]any{"line": 5}},
+	})["breakpoints"].([]any)
+	verified := []bool{breakpoints[0].(map[string]any)["verified"].(bool), breakpoints[1].(map[string]any)["verified"].(bool)}
+	if !reflect.DeepEqual(verified, []bool{true, false}) {
+		t.Errorf("wrong breakpoints verified. want=[true false], got=%v", verified)
+	}
+	client.request("configurationDone", nil)
+
+	client.expectStop("entry", 1)
+	client.request("continue", map[string]any{"threadId": threadID})
+
+	frames := client.expectStop("breakpoint", 4)
+	names := []string{frames[0].(map[string]any)["name"].(string), frames[1].(map[string]any)["name"].(string)}
+	if !reflect.DeepEqual(names, []string{"weigh", "main"}) {
+		t.Errorf("wrong frames. want=[weigh main], got=%v", names)
+	}
+	scopes := client.request("scopes", map[string]any{"frameId": 1})["scopes"].([]any)
+	locals := scopes[0].(map[string]any)
+	if locals["name"] != "Locals" {
+		t.Fatalf("wrong first scope %v", locals)
+	}
+	body := client.request("variables", map[string]any{"variablesReference": locals["variablesReference"]})
+	values := body["variables"].([]any)[0].(map[string]any)
+	if expected := []string{"values=[1, 2]", "factor=10", "total=3"}; !reflect.DeepEqual(client.variables(locals["variablesReference"]), expected) {
+		t.Errorf("wrong locals. want=%v, got=%v", expected, client.variables(locals["variablesReference"]))
+	}
+	if expected := []string{"0=1", "1=2"}; !reflect.DeepEqual(client.variables(values["variablesReference"]), expected) {
+		t.Errorf("wrong elements. want=%v, got=%v", expected, client.variables(values["variablesReference"]))
+	}
+	globals := scopes[2].(map[string]any)["variablesReference"]
+	if got := client.variables(globals); len(got) != 2 || got[0] != "scale=10" {
+		t.Errorf("wrong globals. want scale=10 and weigh, got=%v", got)
+	}
+	result := client.request("evaluate", map[string]any{"expression": "total * factor + 1", "frameId": 1})
+	if result["result"] != "31" {
+		t.Errorf("wrong evaluation. want=31, got=%v", result["result"])
+	}
+
+	client.request("next", map[string]any{"threadId": threadID})
+	if output := client.expectEvent("output"); output["output"] != "30\n" {
+		t.Errorf("wrong output. want=%q, got=%v", "30\n", output["output"])
+	}
+	client.expectStop("step", 7)
+	client.request("continue", map[string]any{"threadId": threadID})
+	client.expectEvent("output")
+	if exited := client.expectEvent("exited"); exited["exitCode"] != float64(0) {
+		t.Errorf("wrong exit code. want=0, got=%v", exited["exitCode"])
+	}
+	client.expectEvent("terminated")
+	client.request("disconnect", nil)
+}
+
+func TestRuntimeError(t *testing.T) {
+	program := filepath.Join(t.TempDir(), "error.mk")
+	err := os.WriteFile(program, []byte("let x = 1;\nx + \"a\";\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := startServer(t)
+	client.request("initialize", nil)
+	client.request("launch", map[string]any{"program": program})
+	client.expectEvent("initialized")
+	client.request("configurationDone", nil)
+	output := client.expectEvent("output")
+	if expected := program + ":2:3: type mismatch: INTEGER + STRING\n"; output["output"] != expected {
+		t.Errorf("wrong error output. want=%q, got=%v", expected, output["output"])
+	}
+	if exited := client.expectEvent("exited"); exited["exitCode"] != float64(1) {
+		t.Errorf("wrong exit code. want=1, got=%v", exited["exitCode"])
+	}
+	client.expectEvent("terminated")
+	client.request("disconnect", nil)
+}
+
+func TestDisconnectWhilePaused(t *testing.T) {
+	program := filepath.Join(t.TempDir(), "program.mk")
+	err := os.WriteFile(program, []byte("puts(1);\nputs(2);\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := startServer(t)
+	client.request("initialize", nil)
+	client.request("launch", map[string]any{"program": program, "stopOnEntry": true})
+	client.expectEvent("initialized")
+	client.request("configurationDone", nil)
+	client.expectStop("entry", 1)
+	client.request("disconnect", nil)
+	client.expectEvent("exited")
+	client.expectEvent("terminated")
+}
+
+func TestRespondsBeforeRunning(t *testing.T) {
+	program := filepath.Join(t.TempDir(), "program.mk")
+	err := os.WriteFile(program, []byte("puts(1);\nputs(2);\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := startServer(t)
+	client.request("initialize", nil)
+	client.request("launch", map[string]any{"program": program, "stopOnEntry": true})
+	client.expectEvent("initialized")
+	for _, command := range []string{"configurationDone", "next", "continue"} {
+		client.request(command, map[string]any{"threadId": threadID})
+		if len(client.events) != 0 {
+			t.Fatalf("events sent before the response to %s: %v", command, client.events)
+		}
+		if command == "next" {
+			client.expectEvent("output")
+		}
+		if command != "continue" {
+			client.expectEvent("stopped")
+		}
+	}
+	client.expectEvent("output")
+	client.expectEvent("exited")
+	client.expectEvent("terminated")
+}
@@ -0,0 +1,484 @@
+// Package dap serves the Debug Adapter Protocol, with which editors launch
+// Monkey programs on the virtual machine and debug them.
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"writing-in-interpreter-in-go/src/monkey/code"
+	"writing-in-interpreter-in-go/src/monkey/compiler"
+	"writing-in-interpreter-in-go/src/monkey/framing"
+	"writing-in-interpreter-in-go/src/monkey/lexer"
+	"writing-in-interpreter-in-go/src/monkey/object"
+	"writing-in-interpreter-in-go/src/monkey/parser"
+	"writing-in-interpreter-in-go/src/monkey/vm"
+)
+
+// threadID identifies the only thread a program has.
+const threadID = 1
+
+// Server debugs one program for a client. Requests are handled one at a
+// time as they are read; the program runs in its own goroutine and waits
+// for the next resuming request whenever it pauses.
+type Server struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	// mutex guards seq and the writes to out, and stop.
+	mutex sync.Mutex
+	seq   int
+
+	path     string
+	byteCode *compiler.ByteCode
+	// lines holds the lines with code, where breakpoints can be set.
+	lines       map[int]bool
+	debugger    *vm.Debugger
+	stopOnEntry bool
+	launched    bool
+	configured  bool
+	running     bool
+	terminating bool
+
+	stop   *vm.Stop
+	resume chan vm.StepMode
+	done   chan struct{}
+	// references holds the variables of the scopes and arrays shown since
+	// the program paused; variablesReference n is references[n-1].
+	references []func() []variable
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{in: bufio.NewReader(in), out: out, resume: make(chan vm.StepMode), done: make(chan struct{})}
+}
+
+// Serve handles requests until the client disconnects or closes its end.
+// The output of the program is sent to the client as output events.
+func (server *Server) Serve() error {
+	for {
+		content, err := framing.ReadMessage(server.in)
+		if err == io.EOF {
+			server.terminate()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var request request
+		err = json.Unmarshal(content, &request)
+		if err != nil {
+			return fmt.Errorf("malformed message: %s", err)
+		}
+		if request.Type != "request" {
+			continue
+		}
+		body, err := server.handle(request)
+		server.respond(request, body, err)
+		if request.Command == "disconnect" {
+			return nil
+		}
+		if err != nil {
+			continue
+		}
+		// The program starts or resumes only once the request is answered,
+		// so that the client hears of it after the response.
+		switch request.Command {
+		case "launch":
+			server.event("initialized", nil)
+			server.start()
+		case "configurationDone":
+			server.start()
+		case "continue", "next", "stepIn", "stepOut":
+			server.resume <- stepModes[request.Command]
+		}
+	}
+}
+
+// stepModes are the step modes in which the resuming requests resume the
+// program.
+var stepModes = map[string]vm.StepMode{
+	"continue": vm.Continue,
+	"next":     vm.StepOver,
+	"stepIn":   vm.StepIn,
+	"stepOut":  vm.StepOut,
+}
+
+func (server *Server) handle(request request) (any, error) {
+	switch request.Command {
+	case "initialize":
+		return map[string]bool{
+			"supportsConfigurationDoneRequest": true,
+			"supportsTerminateRequest":         true,
+		}, nil
+	case "launch":
+		var arguments launchArguments
+		err := json.Unmarshal(request.Arguments, &arguments)
+		if err != nil {
+			return nil, err
+		}
+		return nil, server.launch(arguments)
+	case "setBreakpoints":
+		var arguments setBreakpointsArguments
+		err := json.Unmarshal(request.Arguments, &arguments)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"breakpoints": server.setBreakpoints(arguments)}, nil
+	case "setExceptionBreakpoints":
+		return map[string]any{"breakpoints": []breakpoint{}}, nil
+	case "configurationDone":
+		server.configured = true
+		return nil, nil
+	case "threads":
+		return map[string]any{"threads": []thread{{ID: threadID, Name: "main"}}}, nil
+	case "stackTrace":
+		stop, err := server.paused()
+		if err != nil {
+			return nil, err
+		}
+		frames := server.stackFrames(stop)
+		return map[string]any{"stackFrames": frames, "totalFrames": len(frames)}, nil
+	case "scopes":
+		var arguments frameArguments
+		err := json.Unmarshal(request.Arguments, &arguments)
+		if err != nil {
+			return nil, err
+		}
+		stop, err := server.paused()
+		if err != nil {
+			return nil, err
+		}
+		scopes, err := server.scopes(stop, arguments.FrameID)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"scopes": scopes}, nil
+	case "variables":
+		var arguments variablesArguments
+		err := json.Unmarshal(request.Arguments, &arguments)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := server.paused(); err != nil {
+			return nil, err
+		}
+		if arguments.VariablesReference < 1 || arguments.VariablesReference > len(server.references) {
+			return nil, fmt.Errorf("unknown variables reference %d", arguments.VariablesReference)
+		}
+		return map[string]any{"variables": server.references[arguments.VariablesReference-1]()}, nil
+	case "evaluate":
+		var arguments evaluateArguments
+		err := json.Unmarshal(request.Arguments, &arguments)
+		if err != nil {
+			return nil, err
+		}
+		stop, err := server.paused()
+		if err != nil {
+			return nil, err
+		}
+		frame, err := frameIndex(stop, arguments.FrameID)
+		if err != nil {
+			return nil, err
+		}
+		value, err := stop.Evaluate(frame, arguments.Expression)
+		if err != nil {
+			return nil, err
+		}
+		result := server.describe("", value)
+		return map[string]any{"result": result.Value, "type": result.Type,
+			"variablesReference": result.VariablesReference, "indexedVariables": result.IndexedVariables}, nil
+	case "continue":
+		return map[string]bool{"allThreadsContinued": true}, server.resuming()
+	case "next", "stepIn", "stepOut":
+		return nil, server.resuming()
+	case "pause":
+		if server.debugger != nil {
+			server.debugger.Interrupt()
+		}
+		return nil, nil
+	case "terminate", "disconnect":
+		server.terminate()
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unsupported request %q", request.Command)
+	}
+}
+
+func (server *Server) launch(arguments launchArguments) error {
+	if server.launched {
+		return fmt.Errorf("a program is already launched")
+	}
+	path, err := filepath.Abs(arguments.Program)
+	if err != nil {
+		return err
+	}
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	p := parser.New(lexer.New(string(text)))
+	program := p.ParseProgram()
+	if len(p.Errors) > 0 {
+		return fmt.Errorf("%s: parser errors:\n\t%s", path, strings.Join(p.Errors, "\n\t"))
+	}
+	c := compiler.New()
+	err = c.Compile(program)
+	if err != nil {
+		return fmt.Errorf("%s: compilation failed:\n%s", path, err)
+	}
+
+	server.path = path
+	server.byteCode = c.ByteCode()
+	server.lines = linesWithCode(server.byteCode)
+	if !arguments.NoDebug {
+		server.debugger = vm.NewDebugger(server.byteCode, server.pause)
+	}
+	server.stopOnEntry = arguments.StopOnEntry
+	server.launched = true
+	return nil
+}
+
+func linesWithCode(byteCode *compiler.ByteCode) map[int]bool {
+	lines := make(map[int]bool)
+	sourceMaps := []code.SourceMap{byteCode.SourceMap}
+	for _, constant := range byteCode.Constants {
+		if function, ok := constant.(*object.CompiledFunction); ok {
+			sourceMaps = append(sourceMaps, function.SourceMap)
+		}
+	}
+	for _, sourceMap := range sourceMaps {
+		for _, mapping := range sourceMap {
+			lines[mapping.Position.Line] = true
+		}
+	}
+	return lines
+}
+
+// setBreakpoints replaces the breakpoints of the program, which are only
+// verified on lines with code.
+func (server *Server) setBreakpoints(arguments setBreakpointsArguments) []breakpoint {
+	path, _ := filepath.Abs(arguments.Source.Path)
+	breakpoints := make([]breakpoint, len(arguments.Breakpoints))
+	for i, requested := range arguments.Breakpoints {
+		breakpoints[i] = breakpoint{Line: requested.Line}
+		switch {
+		case server.debugger == nil || path != server.path:
+			breakpoints[i].Message = "not in the program being debugged"
+		case !server.lines[requested.Line]:
+			breakpoints[i].Message = "no code on this line"
+		default:
+			breakpoints[i].Verified = true
+		}
+	}
+	if server.debugger == nil || path != server.path {
+		return breakpoints
+	}
+	for _, line := range server.debugger.Breakpoints() {
+		server.debugger.ClearBreakpoint(line)
+	}
+	for _, breakpoint := range breakpoints {
+		if breakpoint.Verified {
+			server.debugger.SetBreakpoint(breakpoint.Line)
+		}
+	}
+	return breakpoints
+}
+
+// start runs the program once it is launched and the client is done
+// configuring breakpoints.
+func (server *Server) start() {
+	if !server.launched || !server.configured || server.running {
+		return
+	}
+	server.running = true
+	virtualMachine := vm.New(server.byteCode)
+	if server.debugger != nil {
+		if server.stopOnEntry {
+			server.debugger.Interrupt()
+		}
+		virtualMachine.SetDebugger(server.debugger)
+	}
+	go func() {
+		defer close(server.done)
+		previous := object.Output
+		object.Output = &outputWriter{server: server}
+		err := virtualMachine.Run()
+		object.Output = previous
+
+		exitCode := 0
+		if err != nil && !errors.Is(err, vm.ErrTerminated) {
+			exitCode = 1
+			message := fmt.Sprintf("%s: %s\n", server.path, err)
+			var runtimeError *vm.RuntimeError
+			if errors.As(err, &runtimeError) && runtimeError.Position.Line > 0 {
+				message = fmt.Sprintf("%s:%d:%d: %s\n", server.path, runtimeError.Position.Line,
+					runtimeError.Position.Column, err)
+			}
+			server.event("output", map[string]string{"category": "stderr", "output": message})
+		}
+		server.event("exited", map[string]int{"exitCode": exitCode})
+		server.event("terminated", nil)
+	}()
+}
+
+// pause is called by the program's goroutine when it pauses, and waits for
+// a request to resume it.
+func (server *Server) pause(stop *vm.Stop) vm.StepMode {
+	server.mutex.Lock()
+	if server.terminating {
+		server.mutex.Unlock()
+		return vm.Terminate
+	}
+	server.stop = stop
+	server.references = nil
+	server.mutex.Unlock()
+
+	reason := stop.Reason
+	if server.stopOnEntry {
+		server.stopOnEntry = false
+		reason = "entry"
+	}
+	server.event("stopped", map[string]any{"reason": reason, "threadId": threadID, "allThreadsStopped": true})
+	return <-server.resume
+}
+
+// paused returns the pause of the program, or an error when it is running.
+func (server *Server) paused() (*vm.Stop, error) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if server.stop == nil {
+		return nil, fmt.Errorf("the program is not paused")
+	}
+	return server.stop, nil
+}
+
+// resuming takes the pause of the program, which Serve ends once it has
+// answered the resuming request.
+func (server *Server) resuming() error {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if server.stop == nil {
+		return fmt.Errorf("the program is not paused")
+	}
+	server.stop = nil
+	return nil
+}
+
+// terminate ends the program, if it runs, and waits for it to end.
+func (server *Server) terminate() {
+	if !server.running {
+		return
+	}
+	server.mutex.Lock()
+	server.terminating = true
+	paused := server.stop != nil
+	server.stop = nil
+	server.mutex.Unlock()
+	if paused {
+		server.resume <- vm.Terminate
+	} else if server.debugger != nil {
+		server.debugger.Interrupt()
+	}
+	<-server.done
+}
+
+// Frame ids start at 1 with the innermost frame.
+func (server *Server) stackFrames(stop *vm.Stop) []stackFrame {
+	frames := stop.Frames()
+	stackFrames := make([]stackFrame, len(frames))
+	for i, frame := range frames {
+		stackFrames[i] = stackFrame{
+			ID:     i + 1,
+			Name:   frame.Function,
+			Source: source{Name: filepath.Base(server.path), Path: server.path},
+			Line:   frame.Position.Line,
+			Column: frame.Position.Column,
+		}
+	}
+	return stackFrames
+}
+
+func frameIndex(stop *vm.Stop, id int) (int, error) {
+	if id < 1 || id > len(stop.Frames()) {
+		return 0, fmt.Errorf("unknown frame %d", id)
+	}
+	return id - 1, nil
+}
+
+func (server *Server) scopes(stop *vm.Stop, frameID int) ([]scope, error) {
+	frame, err := frameIndex(stop, frameID)
+	if err != nil {
+		return nil, err
+	}
+	return []scope{
+		{Name: "Locals", VariablesReference: server.reference(server.variables(stop.Locals(frame)))},
+		{Name: "Closure", VariablesReference: server.reference(server.variables(stop.FreeVariables(frame)))},
+		{Name: "Globals", VariablesReference: server.reference(server.variables(stop.Globals()))},
+	}, nil
+}
+
+// reference makes variables expandable by the client until the program
+// resumes.
+func (server *Server) reference(variables func() []variable) int {
+	server.references = append(server.references, variables)
+	return len(server.references)
+}
+
+func (server *Server) variables(variables []vm.Variable) func() []variable {
+	return func() []variable {
+		described := make([]variable, len(variables))
+		for i, variable := range variables {
+			described[i] = server.describe(variable.Name, variable.Value)
+		}
+		return described
+	}
+}
+
+// describe renders a value, giving arrays a reference to their elements.
+func (server *Server) describe(name string, value object.Object) variable {
+	described := variable{Name: name, Value: value.Inspect(), Type: string(value.Type())}
+	if array, ok := value.(*object.Array); ok && len(array.Elements) > 0 {
+		elements := make([]vm.Variable, len(array.Elements))
+		for i, element := range array.Elements {
+			elements[i] = vm.Variable{Name: fmt.Sprint(i), Value: element}
+		}
+		described.VariablesReference = server.reference(server.variables(elements))
+		described.IndexedVariables = len(elements)
+	}
+	return described
+}
+
+func (server *Server) respond(request request, body any, err error) {
+	message := response{Type: "response", RequestSeq: request.Seq, Success: err == nil, Command: request.Command, Body: body}
+	if err != nil {
+		message.Message = err.Error()
+	}
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.seq++
+	message.Seq = server.seq
+	_ = framing.WriteMessage(server.out, message)
+}
+
+func (server *Server) event(name string, body any) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.seq++
+	_ = framing.WriteMessage(server.out, event{Seq: server.seq, Type: "event", Event: name, Body: body})
+}
+
+// outputWriter sends what the program prints as output events.
+type outputWriter struct {
+	server *Server
+}
+
+func (writer *outputWriter) Write(data []byte) (int, error) {
+	writer.server.event("output", map[string]string{"category": "stdout", "output": string(data)})
+	return len(data), nil
+}
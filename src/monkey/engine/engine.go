@@ -0,0 +1,71 @@
+// Package engine lists the engines that execute Monkey programs, for the
+// tools that run a program under each of them.
+package engine
+
+import (
+	"fmt"
+	"writing-in-interpreter-in-go/src/monkey/ast"
+	"writing-in-interpreter-in-go/src/monkey/compiler"
+	"writing-in-interpreter-in-go/src/monkey/evaluator"
+	"writing-in-interpreter-in-go/src/monkey/object"
+	"writing-in-interpreter-in-go/src/monkey/vm"
+	"writing-in-interpreter-in-go/src/monkey/vm/register"
+)
+
+// Engine prepares a parsed program to be run, returning the error that
+// stopped it. Compilation happens in Prepare so that running can be timed
+// on its own.
+type Engine struct {
+	Name    string
+	Prepare func(program *ast.Program) (run func() error, err error)
+}
+
+// Run prepares and runs program.
+func (engine Engine) Run(program *ast.Program) error {
+	run, err := engine.Prepare(program)
+	if err != nil {
+		return err
+	}
+	return run()
+}
+
+var Engines = []Engine{
+	{"eval", prepareEvaluator},
+	{"vm -O0", vmWithOptimizationLevel(0)},
+	{"vm -O1", vmWithOptimizationLevel(1)},
+	{"vm -O2", vmWithOptimizationLevel(2)},
+	{"regvm", prepareRegisterVm},
+}
+
+func prepareEvaluator(program *ast.Program) (func() error, error) {
+	return func() error {
+		result := evaluator.Eval(program, object.NewEnvironment())
+		if err, ok := result.(*object.Error); ok {
+			return fmt.Errorf("%s", err.Message)
+		}
+		return nil
+	}, nil
+}
+
+func vmWithOptimizationLevel(level int) func(program *ast.Program) (func() error, error) {
+	return func(program *ast.Program) (func() error, error) {
+		c := compiler.New()
+		c.SetOptimizationLevel(level)
+		err := c.Compile(program)
+		if err != nil {
+			return nil, err
+		}
+		byteCode := c.ByteCode()
+		return func() error { return vm.New(byteCode).Run() }, nil
+	}
+}
+
+func prepareRegisterVm(program *ast.Program) (func() error, error) {
+	c := register.NewCompiler()
+	err := c.Compile(program)
+	if err != nil {
+		return nil, err
+	}
+	compiled := c.Program()
+	return func() error { return register.New(compiled).Run() }, nil
+}
@@ -3,17 +3,31 @@ package evaluator
 import (
 	"fmt"
 	"writing-in-interpreter-in-go/src/monkey/ast"
+	"writing-in-interpreter-in-go/src/monkey/compiler"
 	"writing-in-interpreter-in-go/src/monkey/object"
 )
 
-var Builtins = map[string]*object.Builtin{
-	"len":   object.GetBuiltinByName("len"),
-	"first": object.GetBuiltinByName("first"),
-	"last":  object.GetBuiltinByName("last"),
-	"push":  object.GetBuiltinByName("push"),
-}
+var Builtins = func() map[string]*object.Builtin {
+	builtins := make(map[string]*object.Builtin, len(object.Builtins))
+	for _, definition := range object.Builtins {
+		builtins[definition.Name] = definition.Builtin
+	}
+	return builtins
+}()
 
 func Eval(node ast.Node, environment *object.Environment) object.Object {
+	if trace := traceOf(environment); trace != nil {
+		return trace.outermost(func() object.Object {
+			if stop := trace.node(node, environment); stop != nil {
+				return stop
+			}
+			return eval(node, environment)
+		})
+	}
+	return eval(node, environment)
+}
+
+func eval(node ast.Node, environment *object.Environment) object.Object {
 	switch node := node.(type) {
 	case *ast.Program:
 		return evalStatements(node.Statements, environment)
@@ -24,22 +38,22 @@ func Eval(node ast.Node, environment *object.Environment) object.Object {
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
 	case *ast.BooleanExpression:
-		return nativeBoolToBooleanObject(node.Value)
+		return object.NativeBoolToBooleanObject(node.Value)
 	case *ast.Identifier:
 		return evalIdentifier(node, environment)
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements, environment)
-		if len(elements) == 1 && isError(elements[0]) {
+		if len(elements) == 1 && interrupts(elements[0]) {
 			return elements[0]
 		}
 		return &object.Array{Elements: elements}
 	case *ast.IndexExpression:
 		left := Eval(node.Expression, environment)
-		if isError(left) {
+		if interrupts(left) {
 			return left
 		}
 		index := Eval(node.Index, environment)
-		if isError(index) {
+		if interrupts(index) {
 			return index
 		}
 		return evalIndexExpression(left, index)
@@ -49,14 +63,14 @@ func Eval(node ast.Node, environment *object.Environment) object.Object {
 		return evalIfExpression(node, environment)
 	case *ast.LetStatement:
 		expression := Eval(node.Value, environment)
-		if isError(expression) {
+		if interrupts(expression) {
 			return expression
 		}
 		environment.Set(node.Identifier.Value, expression)
 		return expression
 	case *ast.ReturnStatement:
 		returnValue := Eval(node.ReturnValue, environment)
-		if isError(returnValue) {
+		if interrupts(returnValue) {
 			return returnValue
 		}
 		return &object.ReturnValue{Value: returnValue}
@@ -65,36 +79,37 @@ func Eval(node ast.Node, environment *object.Environment) object.Object {
 			Parameters:  node.Parameters,
 			Body:        node.Body,
 			Environment: environment,
+			Name:        node.Name,
 		}
 	case *ast.CallExpression:
 		if node.Function.TokenLiteral() == "quote" {
 			return quote(node.Arguments[0], environment)
 		}
 		function := Eval(node.Function, environment)
-		if isError(function) {
+		if interrupts(function) {
 			return function
 		}
 
 		args := evalExpressions(node.Arguments, environment)
-		if len(args) == 1 && isError(args[0]) {
+		if len(args) == 1 && interrupts(args[0]) {
 			return args[0]
 		}
 
-		return applyArguments(function, args)
+		return applyArguments(traceOf(environment), node, function, args)
 	case *ast.PrefixExpression:
 		operand := Eval(node.Operand, environment)
-		if isError(operand) {
+		if interrupts(operand) {
 			return operand
 		}
 		return evalPrefixOperator(operand, node.Operator)
 	case *ast.InfixExpression:
 		leftOperand := Eval(node.Left, environment)
-		if isError(leftOperand) {
+		if interrupts(leftOperand) {
 			return leftOperand
 		}
 
 		rightOperand := Eval(node.Right, environment)
-		if isError(rightOperand) {
+		if interrupts(rightOperand) {
 			return rightOperand
 		}
 		return evalInfixExpression(node.Operator, leftOperand, rightOperand)
@@ -109,7 +124,7 @@ func evalStatements(stmts []ast.Statement, environment *object.Environment) obje
 		switch result := result.(type) {
 		case *object.ReturnValue:
 			return result.Value
-		case *object.Error:
+		case *object.Error, *object.Stop:
 			return result
 		}
 	}
@@ -118,6 +133,9 @@ func evalStatements(stmts []ast.Statement, environment *object.Environment) obje
 
 func evalIdentifier(identifier *ast.Identifier, environment *object.Environment) object.Object {
 	if value, ok := environment.Get(identifier.Value); ok {
+		if value == nil {
+			return newError("variable used before it is set")
+		}
 		return value
 	}
 
@@ -126,7 +144,11 @@ func evalIdentifier(identifier *ast.Identifier, environment *object.Environment)
 		return builtin
 	}
 
-	return newError("Identifier not found: %s", identifier.Value)
+	names := environment.Names()
+	for _, definition := range object.Builtins {
+		names = append(names, definition.Name)
+	}
+	return newError("%s", compiler.NewResolutionError(identifier, names))
 }
 
 func evalIndexExpression(left, index object.Object) object.Object {
@@ -169,21 +191,35 @@ func evalInfixExpression(
 	case leftOperand.Type() == object.STRING && rightOperand.Type() == object.STRING:
 		return evalStringInfixExpression(operator, leftOperand, rightOperand)
 	case operator == "==":
-		return nativeBoolToBooleanObject(leftOperand == rightOperand)
+		return object.NativeBoolToBooleanObject(leftOperand == rightOperand)
 	case operator == "!=":
-		return nativeBoolToBooleanObject(leftOperand != rightOperand)
+		return object.NativeBoolToBooleanObject(leftOperand != rightOperand)
 	default:
 		return newError("type mismatch: %s %s %s", leftOperand.Type(), operator, rightOperand.Type())
 	}
 }
 
 func evalStringInfixExpression(operator string, leftOperand object.Object, rightOperand object.Object) object.Object {
-	if operator != "+" {
+	left := leftOperand.(*object.String).Value
+	right := rightOperand.(*object.String).Value
+	switch operator {
+	case "+":
+		return &object.String{Value: left + right}
+	case "<":
+		return object.NativeBoolToBooleanObject(left < right)
+	case "<=":
+		return object.NativeBoolToBooleanObject(left <= right)
+	case ">":
+		return object.NativeBoolToBooleanObject(left > right)
+	case ">=":
+		return object.NativeBoolToBooleanObject(left >= right)
+	case "==":
+		return object.NativeBoolToBooleanObject(left == right)
+	case "!=":
+		return object.NativeBoolToBooleanObject(left != right)
+	default:
 		return newError("unknown operator: %s %s %s", leftOperand.Type(), operator, rightOperand.Type())
 	}
-	left := leftOperand.(*object.String)
-	right := rightOperand.(*object.String)
-	return &object.String{Value: left.Value + right.Value}
 }
 
 func evalBangOperator(operand object.Object) object.Object {
@@ -226,15 +262,22 @@ func evalIntegerInfixExpression(
 	case "*":
 		return &object.Integer{Value: leftVal * rightVal}
 	case "/":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
 		return &object.Integer{Value: leftVal / rightVal}
 	case "<":
-		return nativeBoolToBooleanObject(leftVal < rightVal)
+		return object.NativeBoolToBooleanObject(leftVal < rightVal)
+	case "<=":
+		return object.NativeBoolToBooleanObject(leftVal <= rightVal)
 	case ">":
-		return nativeBoolToBooleanObject(leftVal > rightVal)
+		return object.NativeBoolToBooleanObject(leftVal > rightVal)
+	case ">=":
+		return object.NativeBoolToBooleanObject(leftVal >= rightVal)
 	case "==":
-		return nativeBoolToBooleanObject(leftVal == rightVal)
+		return object.NativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
-		return nativeBoolToBooleanObject(leftVal != rightVal)
+		return object.NativeBoolToBooleanObject(leftVal != rightVal)
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
@@ -251,20 +294,34 @@ func evaluateBlockStatement(node *ast.BlockStatement, environment *object.Enviro
 			}
 		}
 	}
+	return blockValue(node, result)
+}
+
+// blockValue is the value of a block whose statements evaluated to result:
+// null unless the block ends with an expression, as in the VM.
+func blockValue(node *ast.BlockStatement, result object.Object) object.Object {
+	if len(node.Statements) == 0 {
+		return object.NULL
+	}
+	if _, ok := node.Statements[len(node.Statements)-1].(*ast.ExpressionStatement); !ok {
+		return object.NULL
+	}
 	return result
 }
 
 func evalIfExpression(node *ast.IfExpression, environment *object.Environment) object.Object {
 	condition := Eval(node.Condition, environment)
 
-	if isError(condition) {
+	if interrupts(condition) {
 		return condition
 	}
 
-	if isTruthy(condition) {
+	if object.IsTruthy(condition) {
+		declareLetStatements(node.Alternative, environment)
 		return Eval(node.Consequence, environment)
 	}
 
+	declareLetStatements(node.Consequence, environment)
 	if node.Alternative != nil {
 		return Eval(node.Alternative, environment)
 	}
@@ -272,11 +329,27 @@ func evalIfExpression(node *ast.IfExpression, environment *object.Environment) o
 	return object.NULL
 }
 
+// declareLetStatements declares the names bound in a branch that is not
+// taken. The compilers define them all the same, so reading one fails as
+// a variable used before it is set rather than finding an enclosing
+// binding.
+func declareLetStatements(block *ast.BlockStatement, environment *object.Environment) {
+	ast.Inspect(block, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.LetStatement:
+			environment.Declare(node.Identifier.Value)
+		case *ast.FunctionLiteral, *ast.MacroLiteral:
+			return false
+		}
+		return true
+	})
+}
+
 func evalExpressions(expressions []ast.Expression, environment *object.Environment) []object.Object {
 	var result []object.Object
 	for _, expression := range expressions {
 		evaluated := Eval(expression, environment)
-		if isError(evaluated) {
+		if interrupts(evaluated) {
 			return []object.Object{evaluated}
 		}
 		result = append(result, evaluated)
@@ -284,20 +357,149 @@ func evalExpressions(expressions []ast.Expression, environment *object.Environme
 	return result
 }
 
-func applyArguments(function object.Object, args []object.Object) object.Object {
-	switch fn := function.(type) {
-	case *object.Function:
-		env := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, env)
-		return unwrapReturnValue(evaluated)
-	case *object.Builtin:
-		if result := fn.Function(args...); result != nil {
+// Apply calls function with args, traced like the environment function was
+// defined in.
+func Apply(function object.Object, args []object.Object) object.Object {
+	fn, ok := function.(*object.Function)
+	if !ok {
+		return applyArguments(nil, nil, function, args)
+	}
+	trace := traceOf(fn.Environment)
+	if trace == nil {
+		return applyArguments(nil, nil, function, args)
+	}
+	return trace.outermost(func() object.Object { return applyArguments(trace, nil, function, args) })
+}
+
+// applyArguments calls function with args, reporting to trace if it is not
+// nil. Errors raised by the call itself rather than by the body of a Monkey
+// function, such as those returned by builtins, are given the position of
+// call.
+func applyArguments(trace *tracing, call *ast.CallExpression, function object.Object, args []object.Object) object.Object {
+	if trace != nil {
+		trace.depth++
+		defer func() { trace.depth-- }()
+	}
+	for {
+		switch fn := function.(type) {
+		case *object.Function:
+			if len(args) != len(fn.Parameters) {
+				return locate(newError("wrong number of arguments: want=%d, got=%d", len(fn.Parameters), len(args)), call)
+			}
+			if stop := trace.call(fn, args); stop != nil {
+				return stop
+			}
+			env := extendFunctionEnv(fn, args)
+			evaluated := unwrapReturnValue(evalTail(fn.Body, env, true))
+			tail, ok := evaluated.(*tailCall)
+			if !ok {
+				trace.returned(fn, evaluated)
+				return evaluated
+			}
+			call, function, args = tail.call, tail.function, tail.arguments
+		case *object.Builtin:
+			if stop := trace.call(fn, args); stop != nil {
+				return stop
+			}
+			result := fn.Function(args...)
+			if err, ok := result.(*object.Error); ok {
+				return locate(err, call)
+			}
+			if result == nil {
+				result = object.NULL
+			}
+			trace.returned(fn, result)
 			return result
+		default:
+			return locate(newError("not a function: %s", function.Type()), call)
+		}
+	}
+}
+
+// locate gives err the position of call, unless it has one already.
+func locate(err *object.Error, call *ast.CallExpression) *object.Error {
+	if call == nil || err.Line != 0 {
+		return err
+	}
+	err.Line, err.Column = call.Token.Line, call.Token.Column
+	if identifier, ok := call.Function.(*ast.Identifier); ok && identifier.Token != nil {
+		err.Line, err.Column = identifier.Token.Line, identifier.Token.Column
+	}
+	return err
+}
+
+// tailCall is a call in tail position that has not been made yet.
+// applyArguments makes it in its own loop, so tail recursion does not grow
+// the Go stack.
+type tailCall struct {
+	call      *ast.CallExpression
+	function  object.Object
+	arguments []object.Object
+}
+
+func (call *tailCall) Type() object.Type { return object.FUNCTION }
+
+func (call *tailCall) Inspect() string { return "tail call" }
+
+// evalTail evaluates a node of a function body. Calls whose value the
+// function returns, either from a return statement or as the last
+// expression when last is set, are returned as a *tailCall.
+func evalTail(node ast.Node, environment *object.Environment, last bool) object.Object {
+	if trace := traceOf(environment); trace != nil {
+		if stop := trace.node(node, environment); stop != nil {
+			return stop
+		}
+	}
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		var result object.Object
+		for i, statement := range node.Statements {
+			result = evalTail(statement, environment, last && i == len(node.Statements)-1)
+			if result != nil {
+				rt := result.Type()
+				if rt == object.RETURN || rt == object.ERROR {
+					return result
+				}
+			}
+		}
+		return blockValue(node, result)
+	case *ast.ExpressionStatement:
+		return evalTail(node.Expression, environment, last)
+	case *ast.ReturnStatement:
+		returnValue := evalTail(node.ReturnValue, environment, true)
+		if interrupts(returnValue) {
+			return returnValue
+		}
+		return &object.ReturnValue{Value: returnValue}
+	case *ast.IfExpression:
+		condition := Eval(node.Condition, environment)
+		if interrupts(condition) {
+			return condition
+		}
+		if object.IsTruthy(condition) {
+			declareLetStatements(node.Alternative, environment)
+			return evalTail(node.Consequence, environment, last)
+		}
+		declareLetStatements(node.Consequence, environment)
+		if node.Alternative != nil {
+			return evalTail(node.Alternative, environment, last)
 		}
 		return object.NULL
-	default:
-		return newError("unsupported function type: %T", function)
+	case *ast.CallExpression:
+		if !last || node.Function.TokenLiteral() == "quote" {
+			return eval(node, environment)
+		}
+		function := Eval(node.Function, environment)
+		if interrupts(function) {
+			return function
+		}
+		args := evalExpressions(node.Arguments, environment)
+		if len(args) == 1 && interrupts(args[0]) {
+			return args[0]
+		}
+		return &tailCall{call: node, function: function, arguments: args}
 	}
+	return eval(node, environment)
 }
 
 func unwrapReturnValue(obj object.Object) object.Object {
@@ -317,33 +519,16 @@ func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Enviro
 	return env
 }
 
-func nativeBoolToBooleanObject(input bool) object.Object {
-	if input {
-		return object.TRUE
-	}
-	return object.FALSE
-}
-
-func isTruthy(condition object.Object) bool {
-	switch condition {
-	case object.NULL:
-		return false
-	case object.TRUE:
-		return true
-	case object.FALSE:
-		return false
-	default:
-		return true
-	}
-}
-
 func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
-func isError(obj object.Object) bool {
+// interrupts reports whether obj stops the evaluation of the expression that
+// produced it: errors, and return values from an if expression used as an
+// operand, which end the enclosing function as they do in the VM.
+func interrupts(obj object.Object) bool {
 	if obj != nil {
-		return obj.Type() == object.ERROR
+		return obj.Type() == object.ERROR || obj.Type() == object.RETURN
 	}
 	return false
 }
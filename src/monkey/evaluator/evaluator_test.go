@@ -84,6 +84,16 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{"1 <= 1", true},
+		{"2 <= 1", false},
+		{"1 >= 1", true},
+		{"1 >= 2", false},
+		{`"a" < "b"`, true},
+		{`"b" <= "a"`, false},
+		{`"b" > "a"`, true},
+		{`"a" >= "b"`, false},
+		{`"a" == "a"`, true},
+		{`"a" != "a"`, false},
 	}
 
 	for _, tt := range tests {
@@ -123,6 +133,9 @@ func TestIfElseExpression(t *testing.T) {
 		{"if (1 > 2) { 10 }", nil},
 		{"if (1 > 2) { 10 } else { 20 }", 20},
 		{"if (1 < 2) { 10 } else { 20 }", 10},
+		{"if (true) { }", nil},
+		{"if (true) { let x = 10; }", nil},
+		{"fn() { }()", nil},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -158,6 +171,8 @@ func TestReturnStatements(t *testing.T) {
 			}
 		return 1;
 		}`, 1},
+		{"fn() { if (true) { return 10; } - 1 }()", 10},
+		{"fn() { let x = if (true) { return 10; }; 1 }()", 10},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -264,6 +279,43 @@ func TestClosures(t *testing.T) {
 	testIntegerObject(t, testEval(input), 4)
 }
 
+func TestClosuresSeeRebinding(t *testing.T) {
+	input := `let make = fn() { let count = 0; let get = fn() { count }; let count = count + 1; get }; make()();`
+	testIntegerObject(t, testEval(input), 1)
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{
+			"let countDown = fn(x) { if (x == 0) { 0 } else { countDown(x - 1) } }; countDown(1000000);",
+			0,
+		},
+		{
+			`let sum = fn(n, total) {
+				if (n == 0) { return total; }
+				return sum(n - 1, total + n);
+			};
+			sum(1000000, 0);`,
+			500000500000,
+		},
+		{
+			`let isEven = fn(n) { if (n == 0) { 1 } else { isOdd(n - 1) } };
+			let isOdd = fn(n) { if (n == 0) { 0 } else { isEven(n - 1) } };
+			isEven(1000001);`,
+			0,
+		},
+		{"let size = fn(array) { len(array) }; size([1, 2, 3]) + 1;", 4},
+		{"let add = fn(x, y) { x + y }; let apply = fn(f) { f(1, 2) }; apply(add) * 2;", 6},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
 func TestQuote(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -375,6 +427,24 @@ func TestQuoteUnquote(t *testing.T) {
 	}
 }
 
+func TestQuoteDoesNotModifySource(t *testing.T) {
+	input := `
+	let quoter = fn(x) { quote(unquote(x) + 1) };
+	quoter(1);
+	quoter(2);
+	`
+
+	evaluated := testEval(input)
+	quote, ok := evaluated.(*object.Quote)
+	if !ok {
+		t.Fatalf("expected *object.Quote. got=%T (%+v)", evaluated, evaluated)
+	}
+
+	if quote.Node.String() != "(2 + 1)" {
+		t.Errorf("not equal. got=%q, want=%q", quote.Node.String(), "(2 + 1)")
+	}
+}
+
 func TestDefineMacros(t *testing.T) {
 	input := `
     let number = 1;
@@ -466,6 +536,27 @@ func TestExpandMacros(t *testing.T) {
 	}
 }
 
+func TestExpandMacrosDoesNotModifySource(t *testing.T) {
+	input := `
+	let unless = macro(condition, consequence) { quote(if (!(unquote(condition))) { unquote(consequence) }); };
+	unless(10 > 5, puts("not greater"));
+	`
+
+	program := parseProgram(input)
+	env := object.NewEnvironment()
+	DefineMacros(&program, env)
+	original := ast.Clone(&program)
+
+	expanded := ExpandMacros(&program, env)
+
+	if !ast.Equal(&program, original) {
+		t.Errorf("source program changed. got=%q, want=%q", program.String(), original.String())
+	}
+	if ast.Equal(expanded, original) {
+		t.Errorf("macro was not expanded. got=%q", expanded.String())
+	}
+}
+
 func TestBuiltinFunctions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -507,7 +598,15 @@ func TestErrorHandling(t *testing.T) {
 		{"5; true + false; 5", "type mismatch: BOOLEAN + BOOLEAN"},
 		{"if (10 > 1) { true + false; }", "type mismatch: BOOLEAN + BOOLEAN"},
 		{` if (10 > 1) { if (10 > 1) { return true + false; } return 1; } `, "type mismatch: BOOLEAN + BOOLEAN"},
-		{"foobar", "Identifier not found: foobar"},
+		{"foobar", "1:1: undefined variable foobar"},
+		{"let total = 1; totl", "1:16: undefined variable totl, did you mean `total`?"},
+		{"if (false) { let y = 1; }; y", "variable used before it is set"},
+		{"let a = 1; fn() { if (true) { 1 } else { let a = 2; }; a }()", "variable used before it is set"},
+		{`(1 + true) < ("a" - "b")`, "type mismatch: INTEGER + BOOLEAN"},
+		{`"a" < 1`, "type mismatch: STRING < INTEGER"},
+		{"1 / 0", "division by zero"},
+		{"fn(a, b) { a }(1)", "wrong number of arguments: want=2, got=1"},
+		{"let x = 1; x()", "not a function: INTEGER"},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -522,6 +621,30 @@ func TestErrorHandling(t *testing.T) {
 	}
 }
 
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input  string
+		line   int
+		column int
+	}{
+		{"1 + true", 0, 0},
+		{"len(1)", 1, 1},
+		{"let f = fn() {\n  first(1)\n};\nf()", 2, 3},
+		{"let x = 1;\nx()", 2, 1},
+		{"fn(a) { a }()", 1, 12},
+	}
+	for _, tt := range tests {
+		errObj, ok := testEval(tt.input).(*object.Error)
+		if !ok {
+			t.Errorf("%q: no error object returned", tt.input)
+			continue
+		}
+		if errObj.Line != tt.line || errObj.Column != tt.column {
+			t.Errorf("%q: wrong position. want=%d:%d, got=%d:%d", tt.input, tt.line, tt.column, errObj.Line, errObj.Column)
+		}
+	}
+}
+
 func testNullObject(t *testing.T, obj object.Object) {
 	if obj != object.NULL {
 		t.Errorf("object is not NULL. got=%T (%+v)", obj, obj)
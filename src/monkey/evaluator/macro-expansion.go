@@ -6,7 +6,7 @@ import (
 )
 
 func ExpandMacros(node ast.Node, env *object.Environment) ast.Node {
-	return ast.Modify(node, func(node ast.Node) ast.Node {
+	return ast.Modify(ast.Clone(node), func(node ast.Node) ast.Node {
 		callExpression, ok := node.(*ast.CallExpression)
 		if !ok {
 			return node
@@ -27,7 +27,7 @@ func ExpandMacros(node ast.Node, env *object.Environment) ast.Node {
 			panic("we only support returning AST-nodes from macros")
 		}
 
-		return quote.Node
+		return ast.Clone(quote.Node)
 	})
 }
 
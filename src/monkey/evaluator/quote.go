@@ -8,7 +8,7 @@ import (
 )
 
 func quote(node ast.Node, environment *object.Environment) object.Object {
-	node = evalUnquoteCalls(node, environment)
+	node = evalUnquoteCalls(ast.Clone(node), environment)
 	return &object.Quote{Node: node}
 }
 
@@ -0,0 +1,166 @@
+package evaluator
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"writing-in-interpreter-in-go/src/monkey/ast"
+	"writing-in-interpreter-in-go/src/monkey/object"
+)
+
+// Tracer is told what the evaluator does as it runs, to log it, measure it
+// or limit it. An error returned by OnNode or OnCall stops the program,
+// which evaluates to an *object.Stop with its message.
+type Tracer interface {
+	// OnNode is called before each node is evaluated. depth is the number
+	// of calls in progress, the top level being the first.
+	OnNode(node ast.Node, environment *object.Environment, depth int) error
+	// OnCall is called when a function or builtin is called. A tail call
+	// replaces the call it is made from, so it has the same depth and the
+	// call it replaces gets no OnReturn.
+	OnCall(function object.Object, arguments []object.Object, depth int) error
+	// OnReturn is called when a call returns value.
+	OnReturn(function object.Object, value object.Object, depth int)
+	// OnError is called with the error that the code Eval or Apply was
+	// given fails with.
+	OnError(err *object.Error)
+}
+
+// tracing is the tracer of an environment and the number of calls in
+// progress in it.
+type tracing struct {
+	tracer Tracer
+	depth  int
+	// evaluating is set while Eval or Apply is running, so that only the
+	// outermost one reports the error the code fails with.
+	evaluating bool
+}
+
+// SetTracer makes Eval report what it does in environment, and in the
+// environments of the functions defined there, to tracer, or stop reporting
+// if tracer is nil.
+func SetTracer(environment *object.Environment, tracer Tracer) {
+	if tracer == nil {
+		environment.SetTracer(nil)
+		return
+	}
+	environment.SetTracer(&tracing{tracer: tracer})
+}
+
+// traceOf returns the tracing of environment, if it is traced.
+func traceOf(environment *object.Environment) *tracing {
+	trace, _ := environment.Tracer().(*tracing)
+	return trace
+}
+
+// outermost evaluates with evaluate and, unless it is nested in another
+// Eval or Apply, reports the error it fails with.
+func (trace *tracing) outermost(evaluate func() object.Object) object.Object {
+	if trace.evaluating {
+		return evaluate()
+	}
+	trace.evaluating = true
+	defer func() { trace.evaluating = false }()
+	result := evaluate()
+	if err, ok := result.(*object.Error); ok {
+		trace.tracer.OnError(err)
+	}
+	return result
+}
+
+func (trace *tracing) node(node ast.Node, environment *object.Environment) object.Object {
+	if err := trace.tracer.OnNode(node, environment, trace.depth+1); err != nil {
+		return &object.Stop{Message: err.Error()}
+	}
+	return nil
+}
+
+func (trace *tracing) call(function object.Object, arguments []object.Object) object.Object {
+	if trace == nil {
+		return nil
+	}
+	if err := trace.tracer.OnCall(function, arguments, trace.depth+1); err != nil {
+		return &object.Stop{Message: err.Error()}
+	}
+	return nil
+}
+
+func (trace *tracing) returned(function object.Object, value object.Object) {
+	if trace == nil || value.Type() == object.ERROR {
+		return
+	}
+	trace.tracer.OnReturn(function, value, trace.depth+1)
+}
+
+// traceWriter writes a trace a line per event, indented by call depth.
+type traceWriter struct {
+	out io.Writer
+}
+
+// NewTraceWriter returns a tracer writing every node, and every call,
+// return and error to out.
+func NewTraceWriter(out io.Writer) Tracer {
+	return &traceWriter{out: out}
+}
+
+// maxNodeText is how much of the code of a node a trace shows.
+const maxNodeText = 60
+
+func (writer *traceWriter) OnNode(node ast.Node, environment *object.Environment, depth int) error {
+	text := strings.ReplaceAll(node.String(), "\n", " ")
+	if len(text) > maxNodeText {
+		text = text[:maxNodeText-3] + "..."
+	}
+	kind := strings.TrimPrefix(fmt.Sprintf("%T", node), "*ast.")
+	_, err := fmt.Fprintf(writer.out, "%s%-19s %s\n", indent(depth), kind, text)
+	return err
+}
+
+func (writer *traceWriter) OnCall(function object.Object, arguments []object.Object, depth int) error {
+	_, err := fmt.Fprintf(writer.out, "%s-> %s(%s)\n", indent(depth), calleeName(function), inspectAll(arguments))
+	return err
+}
+
+func (writer *traceWriter) OnReturn(function object.Object, value object.Object, depth int) {
+	_, _ = fmt.Fprintf(writer.out, "%s<- %s = %s\n", indent(depth), calleeName(function), value.Inspect())
+}
+
+func (writer *traceWriter) OnError(err *object.Error) {
+	if err.Line > 0 {
+		_, _ = fmt.Fprintf(writer.out, "error at %d:%d: %s\n", err.Line, err.Column, err.Message)
+		return
+	}
+	_, _ = fmt.Fprintf(writer.out, "error: %s\n", err.Message)
+}
+
+// calleeName names a function or builtin in traces.
+func calleeName(function object.Object) string {
+	if builtin, ok := function.(*object.Builtin); ok {
+		for _, definition := range object.Builtins {
+			if definition.Builtin == builtin {
+				return definition.Name
+			}
+		}
+		return "builtin"
+	}
+	literal, ok := function.(*object.Function)
+	if !ok {
+		return "fn"
+	}
+	if literal.Name != "" {
+		return literal.Name
+	}
+	return fmt.Sprintf("fn@%d", literal.Body.Token.Line)
+}
+
+func indent(depth int) string {
+	return strings.Repeat("  ", max(depth-1, 0))
+}
+
+func inspectAll(values []object.Object) string {
+	inspected := make([]string, len(values))
+	for i, value := range values {
+		inspected[i] = value.Inspect()
+	}
+	return strings.Join(inspected, ", ")
+}
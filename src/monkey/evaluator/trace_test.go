@@ -0,0 +1,153 @@
+package evaluator
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"writing-in-interpreter-in-go/src/monkey/ast"
+	"writing-in-interpreter-in-go/src/monkey/lexer"
+	"writing-in-interpreter-in-go/src/monkey/object"
+	"writing-in-interpreter-in-go/src/monkey/parser"
+)
+
+// recordingTracer records calls, returns and errors, and fails the program
+// once it has seen limit nodes, when limit is positive.
+type recordingTracer struct {
+	events []string
+	nodes  int
+	limit  int
+}
+
+func (tracer *recordingTracer) OnNode(node ast.Node, environment *object.Environment, depth int) error {
+	tracer.nodes++
+	if tracer.limit > 0 && tracer.nodes >= tracer.limit {
+		return errors.New("node limit reached")
+	}
+	return nil
+}
+
+func (tracer *recordingTracer) OnCall(function object.Object, arguments []object.Object, depth int) error {
+	tracer.events = append(tracer.events, fmt.Sprintf("call %s(%s) %d", calleeName(function), inspectAll(arguments), depth))
+	return nil
+}
+
+func (tracer *recordingTracer) OnReturn(function object.Object, value object.Object, depth int) {
+	tracer.events = append(tracer.events, fmt.Sprintf("return %s %s %d", calleeName(function), value.Inspect(), depth))
+}
+
+func (tracer *recordingTracer) OnError(err *object.Error) {
+	tracer.events = append(tracer.events, fmt.Sprintf("error %d:%d %s", err.Line, err.Column, err.Message))
+}
+
+func testTracedEval(input string, tracer Tracer) object.Object {
+	program := parser.New(lexer.New(input)).ParseProgram()
+	environment := object.NewEnvironment()
+	SetTracer(environment, tracer)
+	return Eval(program, environment)
+}
+
+func TestTracer(t *testing.T) {
+	input := `let count = fn(n) { if (n == 0) { len("ab") } else { count(n - 1) } };
+let twice = fn(x) { count(x) * 2 };
+twice(1);
+twice(first([]));`
+	tracer := &recordingTracer{}
+	testTracedEval(input, tracer)
+	// The evaluator makes calls to builtins in tail position as tail calls
+	// too, unlike the virtual machine.
+	expected := []string{
+		"call twice(1) 2",
+		"call count(1) 3",
+		"call count(0) 3",
+		"call len(ab) 3",
+		"return len 2 3",
+		"return twice 4 2",
+		"call first([]) 2",
+		"return first null 2",
+		"call twice(null) 2",
+		"call count(null) 3",
+		"error 0:0 type mismatch: NULL_OBJ - INTEGER",
+	}
+	if !reflect.DeepEqual(tracer.events, expected) {
+		t.Errorf("wrong events.\nwant=%q\ngot=%q", expected, tracer.events)
+	}
+}
+
+func TestTracerLimit(t *testing.T) {
+	tracer := &recordingTracer{limit: 100}
+	result := testTracedEval("let loop = fn(n) { loop(n + 1) }; loop(0);", tracer)
+	stop, ok := result.(*object.Stop)
+	if !ok || stop.Message != "node limit reached" {
+		t.Fatalf("wrong result. want the limit error, got=%v", result)
+	}
+	if tracer.nodes != 100 {
+		t.Errorf("wrong number of nodes. want=100, got=%d", tracer.nodes)
+	}
+}
+
+func TestTraceWriter(t *testing.T) {
+	var out bytes.Buffer
+	testTracedEval("let add = fn(a, b) { a + b };\nadd(1, 2);", NewTraceWriter(&out))
+	expected := `Program             let add = fn<add>(a, b) (a + b)add(1, 2)
+LetStatement        let add = fn<add>(a, b) (a + b)
+FunctionLiteral     fn<add>(a, b) (a + b)
+ExpressionStatement add(1, 2)
+CallExpression      add(1, 2)
+Identifier          add
+IntegerLiteral      1
+IntegerLiteral      2
+  -> add(1, 2)
+  BlockStatement      (a + b)
+  ExpressionStatement (a + b)
+  InfixExpression     (a + b)
+  Identifier          a
+  Identifier          b
+  <- add = 3
+`
+	if got := out.String(); got != expected {
+		t.Errorf("wrong trace.\nwant=\n%s\ngot=\n%s", expected, got)
+	}
+}
+
+func TestTracerErrorOutsideProgram(t *testing.T) {
+	program := parser.New(lexer.New("let f = fn() { 1 + true }; f();")).ParseProgram()
+	environment := object.NewEnvironment()
+	tracer := &recordingTracer{}
+	SetTracer(environment, tracer)
+	Eval(program.Statements[0], environment)
+	function, _ := environment.Get("f")
+	Apply(function, nil)
+	Eval(program.Statements[1], environment)
+	expected := []string{
+		"call f() 2",
+		"error 0:0 type mismatch: INTEGER + BOOLEAN",
+		"call f() 2",
+		"error 0:0 type mismatch: INTEGER + BOOLEAN",
+	}
+	if !reflect.DeepEqual(tracer.events, expected) {
+		t.Errorf("wrong events.\nwant=%q\ngot=%q", expected, tracer.events)
+	}
+}
+
+func TestTracerPerEnvironment(t *testing.T) {
+	input := "let count = fn(n) { if (n == 0) { 0 } else { count(n - 1) } }; count(50);"
+	tracers := []*recordingTracer{{}, {}}
+	var group sync.WaitGroup
+	for _, tracer := range tracers {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			testTracedEval(input, tracer)
+		}()
+	}
+	testEval(input)
+	group.Wait()
+	for _, tracer := range tracers {
+		if len(tracer.events) != 52 || tracer.events[51] != "return count 0 2" {
+			t.Errorf("wrong events. got=%q", tracer.events)
+		}
+	}
+}
@@ -0,0 +1,471 @@
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+	"writing-in-interpreter-in-go/src/monkey/ast"
+	"writing-in-interpreter-in-go/src/monkey/lexer"
+	"writing-in-interpreter-in-go/src/monkey/parser"
+	"writing-in-interpreter-in-go/src/monkey/token"
+)
+
+const indentation = "    "
+
+// lineWidth is the width past which the elements of a call, an array or a
+// parameter list are put one per line.
+const lineWidth = 80
+
+// Format returns the canonical form of a Monkey program. Comments are kept
+// next to the token they precede or trail, and the elements of calls,
+// arrays and parameter lists too long for a line go one per line.
+// Formatting the output again returns it unchanged.
+func Format(source string) (string, error) {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors) != 0 {
+		return "", fmt.Errorf("parser errors:\n\t%s", strings.Join(p.Errors, "\n\t"))
+	}
+
+	printer := newPrinter(source)
+	printer.statements(program.Statements, position{math.MaxInt, 0})
+	return printer.out.String(), nil
+}
+
+type position struct {
+	line   int
+	column int
+}
+
+func positionOf(t *token.Token) position {
+	if t == nil {
+		return position{}
+	}
+	return position{t.Line, t.Column}
+}
+
+func (p position) before(other position) bool {
+	return p.line < other.line || (p.line == other.line && p.column < other.column)
+}
+
+type printer struct {
+	out      bytes.Buffer
+	depth    int
+	tokens   []*token.Token
+	comments []*token.Token
+	// closing maps the position of every opening parenthesis, bracket and
+	// brace to the position of the one closing it.
+	closing  map[position]position
+	lastLine int
+	// lineStart is set at the start of a line broken inside an expression,
+	// where the space an operator is written with is dropped.
+	lineStart bool
+	// commented is set after a comment inside an expression, which the next
+	// token must not follow on the same line.
+	commented bool
+	// measuring is set in printers that only measure how wide expressions
+	// are on one line.
+	measuring bool
+}
+
+func newPrinter(source string) *printer {
+	l := lexer.New(source)
+	printer := &printer{closing: make(map[position]position)}
+
+	var open []position
+	for t := l.NextToken(); t.Type != token.EOF; t = l.NextToken() {
+		printer.tokens = append(printer.tokens, t)
+		switch t.Type {
+		case token.LPAREN, token.LBRACKET, token.LBRACE:
+			open = append(open, positionOf(t))
+		case token.RPAREN, token.RBRACKET, token.RBRACE:
+			if len(open) > 0 {
+				printer.closing[open[len(open)-1]] = positionOf(t)
+				open = open[:len(open)-1]
+			}
+		}
+	}
+	printer.comments = l.Comments()
+	return printer
+}
+
+// statements prints a statement list whose enclosing block ends at end.
+func (printer *printer) statements(statements []ast.Statement, end position) {
+	for i, statement := range statements {
+		start := statementStart(statement)
+		next := end
+		var following ast.Statement
+		if i+1 < len(statements) {
+			following = statements[i+1]
+			next = statementStart(following)
+		}
+
+		printer.flushComments(start)
+		printer.separate(start.line)
+		printer.writeIndent()
+		printer.statement(statement, following)
+
+		last := printer.lastTokenBefore(next)
+		for len(printer.comments) > 0 {
+			comment := printer.comments[0]
+			if comment.Line != last.line || !positionOf(comment).before(next) {
+				break
+			}
+			printer.out.WriteString(" " + commentText(comment))
+			printer.comments = printer.comments[1:]
+		}
+		printer.out.WriteString("\n")
+		printer.commented = false
+		printer.lastLine = last.line
+	}
+	printer.flushComments(end)
+}
+
+// statement prints a statement, which following, if any, comes after.
+func (printer *printer) statement(statement ast.Statement, following ast.Statement) {
+	switch statement := statement.(type) {
+	case *ast.LetStatement:
+		printer.write("let " + statement.Identifier.Value + " = ")
+		printer.expression(statement.Value)
+		printer.write(";")
+	case *ast.ReturnStatement:
+		printer.write("return")
+		if statement.ReturnValue != nil {
+			printer.write(" ")
+			printer.expression(statement.ReturnValue)
+		}
+		printer.write(";")
+	case *ast.ExpressionStatement:
+		printer.expression(statement.Expression)
+		if _, ok := statement.Expression.(*ast.IfExpression); !ok || printer.continues(following) {
+			printer.write(";")
+		}
+	}
+}
+
+func (printer *printer) expression(expression ast.Expression) {
+	printer.commentsBefore(expressionStart(expression))
+	switch expression := expression.(type) {
+	case *ast.Identifier:
+		printer.write(expression.Value)
+	case *ast.IntegerLiteral:
+		printer.write(strconv.FormatInt(expression.Value, 10))
+	case *ast.StringLiteral:
+		printer.write(`"` + expression.Value + `"`)
+	case *ast.BooleanExpression:
+		printer.write(strconv.FormatBool(expression.Value))
+	case *ast.PrefixExpression:
+		printer.write(expression.Operator)
+		printer.operand(expression.Operand, precedence(expression.Operand) < ast.PREFIX)
+	case *ast.InfixExpression:
+		operatorPrecedence := ast.Precedences[token.Type(expression.Operator)]
+		printer.operand(expression.Left, precedence(expression.Left) < operatorPrecedence)
+		printer.commentsBefore(positionOf(expression.Token))
+		printer.write(" " + expression.Operator + " ")
+		printer.operand(expression.Right, precedence(expression.Right) <= operatorPrecedence)
+	case *ast.IfExpression:
+		printer.write("if (")
+		depth := printer.lineDepth()
+		printer.expression(expression.Condition)
+		printer.write(") ")
+		printer.block(expression.Consequence, depth)
+		if expression.Alternative != nil {
+			printer.write(" else ")
+			printer.block(expression.Alternative, depth)
+		}
+	case *ast.FunctionLiteral:
+		printer.write("fn")
+		depth := printer.lineDepth()
+		printer.list("(", ")", expression.Parameters, printer.tokenAfter(positionOf(&expression.Token)))
+		printer.write(" ")
+		printer.block(expression.Body, depth)
+	case *ast.MacroLiteral:
+		printer.write("macro")
+		depth := printer.lineDepth()
+		printer.list("(", ")", expression.Parameters, printer.tokenAfter(positionOf(expression.Token)))
+		printer.write(" ")
+		printer.block(expression.Body, depth)
+	case *ast.CallExpression:
+		printer.operand(expression.Function, precedence(expression.Function) < ast.CALL)
+		printer.list("(", ")", expression.Arguments, positionOf(&expression.Token))
+	case *ast.ArrayLiteral:
+		printer.list("[", "]", expression.Elements, positionOf(expression.Token))
+	case *ast.IndexExpression:
+		printer.operand(expression.Expression, precedence(expression.Expression) < ast.CALL)
+		printer.commentsBefore(positionOf(expression.Token))
+		printer.write("[")
+		printer.expression(expression.Index)
+		printer.commentsBefore(printer.closing[positionOf(expression.Token)])
+		printer.write("]")
+	}
+}
+
+func (printer *printer) operand(expression ast.Expression, parenthesize bool) {
+	if parenthesize {
+		printer.write("(")
+	}
+	printer.expression(expression)
+	if parenthesize {
+		printer.write(")")
+	}
+}
+
+// list prints expressions between open and close, which is at start in the
+// source. They stay on one line if it is not too long and has no comment,
+// and are otherwise put one per line.
+func (printer *printer) list(open, close string, expressions []ast.Expression, start position) {
+	end := printer.closing[start]
+	if !printer.hasCommentBefore(end) && printer.fits(open, close, expressions) {
+		printer.write(open)
+		for i, expression := range expressions {
+			if i > 0 {
+				printer.write(", ")
+			}
+			printer.expression(expression)
+		}
+		printer.write(close)
+		return
+	}
+
+	printer.write(open)
+	depth := printer.depth
+	printer.depth = printer.lineDepth() + 1
+	for i, expression := range expressions {
+		if i > 0 {
+			printer.write(",")
+		}
+		for printer.hasCommentBefore(expressionStart(expression)) {
+			printer.comment(printer.depth)
+		}
+		printer.newline(printer.depth)
+		printer.expression(expression)
+	}
+	for printer.hasCommentBefore(end) {
+		printer.comment(printer.depth)
+	}
+	printer.newline(printer.depth - 1)
+	printer.write(close)
+	printer.depth = depth
+}
+
+// fits reports whether a list fits on the current line, with a character to
+// spare for the comma or semicolon after it.
+func (printer *printer) fits(open, close string, expressions []ast.Expression) bool {
+	if printer.measuring {
+		return true
+	}
+	measure := measuringPrinter(printer)
+	measure.list(open, close, expressions, position{})
+	flat, _, _ := strings.Cut(measure.out.String(), "\n")
+	return printer.column()+utf8.RuneCountInString(flat) < lineWidth
+}
+
+// continues reports whether statement would continue an if expression
+// before it with no semicolon between them, as an operand or call.
+func (printer *printer) continues(statement ast.Statement) bool {
+	if statement == nil {
+		return false
+	}
+	measure := measuringPrinter(printer)
+	measure.statement(statement, nil)
+	return strings.IndexAny(measure.out.String(), "-([") == 0
+}
+
+func measuringPrinter(of *printer) *printer {
+	return &printer{tokens: of.tokens, closing: of.closing, measuring: true}
+}
+
+// block prints a block closed at depth, with the comments before its
+// opening brace as the first in the block.
+func (printer *printer) block(block *ast.BlockStatement, depth int) {
+	end := printer.closing[positionOf(&block.Token)]
+	if len(block.Statements) == 0 && !printer.hasCommentBefore(end) {
+		printer.write("{}")
+		return
+	}
+
+	printer.write("{\n")
+	outer := printer.depth
+	printer.depth = depth + 1
+	printer.lastLine = 0
+	printer.statements(block.Statements, end)
+	printer.depth--
+	printer.writeIndent()
+	printer.write("}")
+	printer.depth = outer
+}
+
+// flushComments prints every pending comment that starts before end on a
+// line of its own.
+func (printer *printer) flushComments(end position) {
+	for printer.hasCommentBefore(end) {
+		comment := printer.comments[0]
+		printer.separate(comment.Line)
+		printer.writeIndent()
+		printer.out.WriteString(commentText(comment) + "\n")
+		printer.lastLine = comment.Line
+		printer.comments = printer.comments[1:]
+	}
+}
+
+// commentsBefore prints the comments before p inside an expression. What
+// follows them goes on the next line, indented once more.
+func (printer *printer) commentsBefore(p position) {
+	for printer.hasCommentBefore(p) {
+		printer.comment(printer.depth + 1)
+	}
+}
+
+// comment prints the next comment after the token it trails in the source,
+// or else on a line of its own indented depth times.
+func (printer *printer) comment(depth int) {
+	comment := printer.comments[0]
+	printer.comments = printer.comments[1:]
+	trailing := printer.lastTokenBefore(positionOf(comment)).line == comment.Line
+	switch {
+	case printer.lineStart:
+		// Nothing precedes the comment on its line, so what follows it
+		// starts the next line at the same depth.
+		depth := printer.lineDepth()
+		printer.out.WriteString(commentText(comment))
+		printer.newline(depth)
+		return
+	case trailing && !printer.commented:
+		printer.out.Truncate(len(bytes.TrimRight(printer.out.Bytes(), " ")))
+		printer.out.WriteString(" " + commentText(comment))
+	default:
+		printer.newline(depth)
+		printer.out.WriteString(commentText(comment))
+	}
+	printer.lineStart = false
+	printer.commented = true
+}
+
+func (printer *printer) hasCommentBefore(end position) bool {
+	return len(printer.comments) > 0 && positionOf(printer.comments[0]).before(end)
+}
+
+// separate keeps a single blank line where the source had one or more.
+func (printer *printer) separate(line int) {
+	if printer.lastLine != 0 && line > printer.lastLine+1 {
+		printer.out.WriteString("\n")
+	}
+}
+
+func (printer *printer) write(text string) {
+	if printer.commented {
+		printer.newline(printer.depth + 1)
+	}
+	if printer.lineStart {
+		text = strings.TrimLeft(text, " ")
+		printer.lineStart = text == ""
+	}
+	printer.out.WriteString(text)
+}
+
+func (printer *printer) newline(depth int) {
+	printer.out.Truncate(len(bytes.TrimRight(printer.out.Bytes(), " ")))
+	printer.out.WriteString("\n" + strings.Repeat(indentation, depth))
+	printer.lineStart = true
+	printer.commented = false
+}
+
+func (printer *printer) writeIndent() {
+	printer.out.WriteString(strings.Repeat(indentation, printer.depth))
+	printer.lineStart = true
+}
+
+// lineDepth returns how many times the current line is indented.
+func (printer *printer) lineDepth() int {
+	line := printer.out.Bytes()[bytes.LastIndexByte(printer.out.Bytes(), '\n')+1:]
+	return (len(line) - len(bytes.TrimLeft(line, " "))) / len(indentation)
+}
+
+func (printer *printer) column() int {
+	line := printer.out.Bytes()[bytes.LastIndexByte(printer.out.Bytes(), '\n')+1:]
+	return utf8.RuneCount(line)
+}
+
+func (printer *printer) lastTokenBefore(end position) position {
+	index := sort.Search(len(printer.tokens), func(i int) bool {
+		return !positionOf(printer.tokens[i]).before(end)
+	})
+	if index == 0 {
+		return position{}
+	}
+	return positionOf(printer.tokens[index-1])
+}
+
+// tokenAfter returns the position of the token following the one at p.
+func (printer *printer) tokenAfter(p position) position {
+	index := sort.Search(len(printer.tokens), func(i int) bool {
+		return p.before(positionOf(printer.tokens[i]))
+	})
+	if index == len(printer.tokens) {
+		return position{}
+	}
+	return positionOf(printer.tokens[index])
+}
+
+// expressionStart returns the position of the first token of expression.
+func expressionStart(expression ast.Expression) position {
+	switch expression := expression.(type) {
+	case *ast.Identifier:
+		return positionOf(expression.Token)
+	case *ast.IntegerLiteral:
+		return positionOf(expression.Token)
+	case *ast.StringLiteral:
+		return positionOf(expression.Token)
+	case *ast.BooleanExpression:
+		return positionOf(expression.Token)
+	case *ast.PrefixExpression:
+		return positionOf(expression.Token)
+	case *ast.InfixExpression:
+		return expressionStart(expression.Left)
+	case *ast.IfExpression:
+		return positionOf(expression.Token)
+	case *ast.FunctionLiteral:
+		return positionOf(&expression.Token)
+	case *ast.MacroLiteral:
+		return positionOf(expression.Token)
+	case *ast.CallExpression:
+		return expressionStart(expression.Function)
+	case *ast.ArrayLiteral:
+		return positionOf(expression.Token)
+	case *ast.IndexExpression:
+		return expressionStart(expression.Expression)
+	}
+	return position{}
+}
+
+func statementStart(statement ast.Statement) position {
+	switch statement := statement.(type) {
+	case *ast.LetStatement:
+		return positionOf(statement.Token)
+	case *ast.ReturnStatement:
+		return positionOf(statement.Token)
+	case *ast.ExpressionStatement:
+		return positionOf(statement.Token)
+	}
+	return position{}
+}
+
+func precedence(expression ast.Expression) int {
+	switch expression := expression.(type) {
+	case *ast.InfixExpression:
+		return ast.Precedences[token.Type(expression.Operator)]
+	case *ast.PrefixExpression:
+		return ast.PREFIX
+	case *ast.CallExpression:
+		return ast.CALL
+	}
+	return ast.INDEX
+}
+
+func commentText(comment *token.Token) string {
+	return strings.TrimRight(comment.Literal, " \t\r")
+}
@@ -0,0 +1,118 @@
+package format
+
+import (
+	"testing"
+	"writing-in-interpreter-in-go/src/monkey/ast"
+	"writing-in-interpreter-in-go/src/monkey/lexer"
+	"writing-in-interpreter-in-go/src/monkey/parser"
+)
+
+var formatTests = []struct {
+	input    string
+	expected string
+}{
+	{"", ""},
+	{"1+2*3", "1 + 2 * 3;\n"},
+	{"(1+2)*3", "(1 + 2) * 3;\n"},
+	{"1-(2-3)", "1 - (2 - 3);\n"},
+	{"(1-2)-3", "1 - 2 - 3;\n"},
+	{"-(a+b)", "-(a + b);\n"},
+	{"!-a", "!-a;\n"},
+	{"(-a)[0]", "(-a)[0];\n"},
+	{"f(1)(2)[3]", "f(1)(2)[3];\n"},
+	{"let x=[1,\"two\",true]", "let x = [1, \"two\", true];\n"},
+	{"return   x", "return x;\n"},
+	{"let add = fn(a,b){a+b}", "let add = fn(a, b) {\n    a + b;\n};\n"},
+	{"let empty = fn(){ }", "let empty = fn() {};\n"},
+	{"let m = macro(a){quote(unquote(a))}", "let m = macro(a) {\n    quote(unquote(a));\n};\n"},
+	{
+		"if(a>b){a}else{if(b>a){b}}",
+		"if (a > b) {\n    a;\n} else {\n    if (b > a) {\n        b;\n    }\n}\n",
+	},
+	{"if (a) { b }; -1; if (a) { b } c", "if (a) {\n    b;\n};\n-1;\nif (a) {\n    b;\n}\nc;\n"},
+	{"let a = 1;\n\n\n\nlet b = 2;\nlet c = 3;", "let a = 1;\n\nlet b = 2;\nlet c = 3;\n"},
+	{"let a = 1; let b = 2;", "let a = 1;\nlet b = 2;\n"},
+	{
+		"// leading\nlet a = 1; // trailing\n\n// before b\nlet b = 2;\n// end",
+		"// leading\nlet a = 1; // trailing\n\n// before b\nlet b = 2;\n// end\n",
+	},
+	{
+		"let f = fn() { // on brace\n  x; // inside\n  // last\n};",
+		"let f = fn() {\n    // on brace\n    x; // inside\n    // last\n};\n",
+	},
+	{"let f = fn() {\n// nothing\n}", "let f = fn() {\n    // nothing\n};\n"},
+	{"let x = 1 + // one\n2;\nlet y = 3;", "let x = 1 + // one\n    2;\nlet y = 3;\n"},
+	{"let x = 1 +\n// two\n2;", "let x = 1 +\n    // two\n    2;\n"},
+	{"f(a, // first\nb)", "f(\n    a, // first\n    b\n);\n"},
+	{"[1,\n// two\n2 // last\n]", "[\n    1,\n    // two\n    2 // last\n];\n"},
+	{"let x = a[ // index\n0];", "let x = a[ // index\n    0];\n"},
+	{
+		"let result = compute(firstArgument, secondArgument, thirdArgument, fourthArgument);",
+		"let result = compute(\n    firstArgument,\n    secondArgument,\n    thirdArgument,\n    fourthArgument\n);\n",
+	},
+	{
+		"let grid = [[one, two, three], [four, five, six], [seven, eight, nine], [ten, eleven]];",
+		"let grid = [\n    [one, two, three],\n    [four, five, six],\n    [seven, eight, nine],\n    [ten, eleven]\n];\n",
+	},
+	{
+		"let f = fn(firstParameter, secondParameter, thirdParameter, fourthParameter, fifth) { x }",
+		"let f = fn(\n    firstParameter,\n    secondParameter,\n    thirdParameter,\n    fourthParameter,\n    fifth\n) {\n    x;\n};\n",
+	},
+	{
+		"map(values, fn(value) { value * factor + offset + someOtherLongName + yetAnotherName })",
+		"map(values, fn(value) {\n    value * factor + offset + someOtherLongName + yetAnotherName;\n});\n",
+	},
+}
+
+func TestFormat(t *testing.T) {
+	for _, tt := range formatTests {
+		formatted, err := Format(tt.input)
+		if err != nil {
+			t.Fatalf("Format(%q) failed: %s", tt.input, err)
+		}
+		if formatted != tt.expected {
+			t.Errorf("Format(%q) wrong.\nwant=%q\ngot= %q", tt.input, tt.expected, formatted)
+		}
+	}
+}
+
+func TestFormatIsIdempotent(t *testing.T) {
+	for _, tt := range formatTests {
+		once, err := Format(tt.input)
+		if err != nil {
+			t.Fatalf("Format(%q) failed: %s", tt.input, err)
+		}
+		twice, err := Format(once)
+		if err != nil {
+			t.Fatalf("Format(%q) failed: %s", once, err)
+		}
+		if once != twice {
+			t.Errorf("Format not idempotent for %q.\nonce= %q\ntwice=%q", tt.input, once, twice)
+		}
+	}
+}
+
+func TestFormatPreservesProgram(t *testing.T) {
+	for _, tt := range formatTests {
+		formatted, err := Format(tt.input)
+		if err != nil {
+			t.Fatalf("Format(%q) failed: %s", tt.input, err)
+		}
+		if !ast.Equal(parse(tt.input), parse(formatted)) {
+			t.Errorf("Format(%q) changed the program. got=%q", tt.input, formatted)
+		}
+	}
+}
+
+func TestFormatParserErrors(t *testing.T) {
+	_, err := Format("let = 5;")
+	if err == nil {
+		t.Fatalf("expected parser error")
+	}
+}
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
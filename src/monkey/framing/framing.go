@@ -0,0 +1,38 @@
+// Package framing reads and writes the messages of the language server and
+// debug adapter protocols: JSON values, each preceded by a Content-Length
+// header and a blank line.
+package framing
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+)
+
+// ReadMessage reads the content of the next message.
+func ReadMessage(in *bufio.Reader) ([]byte, error) {
+	header, err := textproto.NewReader(in).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("bad Content-Length %q", header.Get("Content-Length"))
+	}
+	content := make([]byte, length)
+	_, err = io.ReadFull(in, content)
+	return content, err
+}
+
+// WriteMessage writes message encoded in JSON.
+func WriteMessage(out io.Writer, message any) error {
+	content, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "Content-Length: %d\r\n\r\n%s", len(content), content)
+	return err
+}
@@ -10,9 +10,18 @@ type Lexer struct {
 	currentPosition int
 	readPosition    int
 	character       byte
+	line            int
+	column          int
+	comments        []*token.Token
 }
 
 func (lexer *Lexer) readCharacter() {
+	if lexer.character == '\n' {
+		lexer.line++
+		lexer.column = 1
+	} else {
+		lexer.column++
+	}
 	if lexer.readPosition >= len(lexer.input) {
 		lexer.character = 0
 	} else {
@@ -23,15 +32,23 @@ func (lexer *Lexer) readCharacter() {
 }
 
 func (lexer *Lexer) Peek(count int) byte {
-	if lexer.currentPosition+count > len(lexer.input) {
+	if lexer.currentPosition+count >= len(lexer.input) {
 		return 0
 	}
 	return lexer.input[lexer.currentPosition+count]
 }
 
 func (lexer *Lexer) NextToken() *token.Token {
-	var nextToken *token.Token
 	lexer.skipWhitespace()
+	line, column := lexer.line, lexer.column
+	nextToken := lexer.readToken()
+	nextToken.Line = line
+	nextToken.Column = column
+	return nextToken
+}
+
+func (lexer *Lexer) readToken() *token.Token {
+	var nextToken *token.Token
 
 	switch lexer.character {
 	case '=':
@@ -72,8 +89,14 @@ func (lexer *Lexer) NextToken() *token.Token {
 	case '*':
 		nextToken = token.NewToken(token.ASTERISK, lexer.character)
 	case '<':
+		if lexer.Peek(1) == '=' {
+			return newTwoCharacterToken(lexer, token.LT_EQ)
+		}
 		nextToken = token.NewToken(token.LT, lexer.character)
 	case '>':
+		if lexer.Peek(1) == '=' {
+			return newTwoCharacterToken(lexer, token.GT_EQ)
+		}
 		nextToken = token.NewToken(token.GT, lexer.character)
 	case '"':
 		nextToken = lexer.newStringLiteral()
@@ -94,10 +117,16 @@ func (lexer *Lexer) NextToken() *token.Token {
 	return nextToken
 }
 
+// newStringLiteral reads a string. A string left open at the end of the
+// input is returned whole, quote included, as an ILLEGAL token.
 func (lexer *Lexer) newStringLiteral() *token.Token {
+	quotePosition := lexer.currentPosition
 	lexer.readCharacter()
 	beforeReadPosition := lexer.currentPosition
 	for lexer.character != '"' {
+		if lexer.character == 0 {
+			return token.NewMultiByteToken(token.ILLEGAL, lexer.input[quotePosition:lexer.currentPosition])
+		}
 		lexer.readCharacter()
 	}
 	return token.NewMultiByteToken(token.STRING, lexer.input[beforeReadPosition:lexer.currentPosition])
@@ -134,13 +163,36 @@ func (lexer *Lexer) readIdentifier() string {
 }
 
 func (lexer *Lexer) skipWhitespace() {
-	for lexer.character == ' ' || lexer.character == '\t' || lexer.character == '\n' || lexer.character == '\r' {
+	for {
+		switch {
+		case lexer.character == ' ' || lexer.character == '\t' || lexer.character == '\n' || lexer.character == '\r':
+			lexer.readCharacter()
+		case lexer.character == '/' && lexer.Peek(1) == '/':
+			lexer.readComment()
+		default:
+			return
+		}
+	}
+}
+
+func (lexer *Lexer) readComment() {
+	comment := token.NewMultiByteToken(token.COMMENT, "")
+	comment.Line, comment.Column = lexer.line, lexer.column
+	beforeReadPosition := lexer.currentPosition
+	for lexer.character != '\n' && lexer.character != 0 {
 		lexer.readCharacter()
 	}
+	comment.Literal = lexer.input[beforeReadPosition:lexer.currentPosition]
+	lexer.comments = append(lexer.comments, comment)
+}
+
+// Comments returns the comments skipped so far, in source order.
+func (lexer *Lexer) Comments() []*token.Token {
+	return lexer.comments
 }
 
 func New(input string) *Lexer {
-	lexer := &Lexer{input: input, readPosition: 0}
+	lexer := &Lexer{input: input, readPosition: 0, line: 1}
 	lexer.readCharacter()
 	return lexer
 }
@@ -81,6 +81,12 @@ func TestNextToken(test *testing.T) {
 		token.NewMultiByteToken(token.NOT_EQ, "!="),
 		token.NewToken(token.INT, '9'),
 		token.NewToken(token.SEMICOLON, ';'),
+		token.NewToken(token.INT, '9'),
+		token.NewMultiByteToken(token.LT_EQ, "<="),
+		token.NewMultiByteToken(token.INT, "10"),
+		token.NewMultiByteToken(token.GT_EQ, ">="),
+		token.NewToken(token.INT, '8'),
+		token.NewToken(token.SEMICOLON, ';'),
 		token.NewMultiByteToken(token.STRING, "foobar"),
 		token.NewMultiByteToken(token.STRING, "foo bar"),
 		token.NewToken(token.LBRACKET, '['),
@@ -141,8 +147,101 @@ func inputString() string {
 
 			10 == 10;
 			10 != 9;
+			9 <= 10 >= 8;
 			"foobar"
 			"foo bar"
 			[1, 2];
 			macro(x, y) { x + y; };`
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 5;\n  x + \"ab\";"
+	tests := []struct {
+		literal string
+		line    int
+		column  int
+	}{
+		{"let", 1, 1},
+		{"x", 1, 5},
+		{"=", 1, 7},
+		{"5", 1, 9},
+		{";", 1, 10},
+		{"x", 2, 3},
+		{"+", 2, 5},
+		{"ab", 2, 7},
+		{";", 2, 11},
+	}
+
+	l := lexer.New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Literal != tt.literal {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.literal, tok.Literal)
+		}
+		if tok.Line != tt.line || tok.Column != tt.column {
+			t.Errorf("tests[%d] - position wrong. expected=%d:%d, got=%d:%d",
+				i, tt.line, tt.column, tok.Line, tok.Column)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := "// leading\nlet x = 10 / 2; // trailing\n//"
+	expected := []token.Type{token.LET, token.IDENTIFIER, token.ASSIGN, token.INT, token.SLASH, token.INT, token.SEMICOLON, token.EOF}
+
+	l := lexer.New(input)
+	for i, tokenType := range expected {
+		tok := l.NextToken()
+		if tok.Type != tokenType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tokenType, tok.Type)
+		}
+	}
+
+	comments := l.Comments()
+	if len(comments) != 3 {
+		t.Fatalf("wrong number of comments. got=%d", len(comments))
+	}
+	if comments[0].Literal != "// leading" || comments[0].Line != 1 || comments[0].Column != 1 {
+		t.Errorf("wrong first comment. got=%q at %d:%d", comments[0].Literal, comments[0].Line, comments[0].Column)
+	}
+	if comments[1].Literal != "// trailing" || comments[1].Line != 2 || comments[1].Column != 17 {
+		t.Errorf("wrong second comment. got=%q at %d:%d", comments[1].Literal, comments[1].Line, comments[1].Column)
+	}
+	if comments[2].Literal != "//" || comments[2].Line != 3 {
+		t.Errorf("wrong third comment. got=%q at %d:%d", comments[2].Literal, comments[2].Line, comments[2].Column)
+	}
+}
+
+func TestUnterminatedString(t *testing.T) {
+	l := lexer.New(`let s = "abc`)
+	expected := []*token.Token{
+		token.NewMultiByteToken(token.LET, "let"),
+		token.NewMultiByteToken(token.IDENTIFIER, "s"),
+		token.NewToken(token.ASSIGN, '='),
+		token.NewMultiByteToken(token.ILLEGAL, `"abc`),
+		token.NewToken(token.EOF, 0),
+	}
+	for i, tt := range expected {
+		tok := l.NextToken()
+		if tok.Type != tt.Type || tok.Literal != tt.Literal {
+			t.Fatalf("tests[%d] - wrong token. expected=%s %q, got=%s %q", i, tt.Type, tt.Literal, tok.Type, tok.Literal)
+		}
+	}
+}
+
+// FuzzNextToken checks that lexing reaches EOF. Every token but EOF consumes
+// at least one byte, so a longer stream means the lexer is stuck.
+func FuzzNextToken(f *testing.F) {
+	f.Add(`let add = fn(x, y) { x + y; }; add(1, 2) <= 3 != "three" // done`)
+	f.Add(`"unterminated`)
+	f.Add("")
+	f.Fuzz(func(t *testing.T, input string) {
+		l := lexer.New(input)
+		for i := 0; i <= len(input); i++ {
+			if l.NextToken().Type == token.EOF {
+				return
+			}
+		}
+		t.Fatalf("no EOF after %d tokens for %q", len(input)+1, input)
+	})
+}
@@ -0,0 +1,401 @@
+package lint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"writing-in-interpreter-in-go/src/monkey/ast"
+	"writing-in-interpreter-in-go/src/monkey/compiler"
+	"writing-in-interpreter-in-go/src/monkey/lexer"
+	"writing-in-interpreter-in-go/src/monkey/object"
+	"writing-in-interpreter-in-go/src/monkey/parser"
+	"writing-in-interpreter-in-go/src/monkey/token"
+)
+
+const (
+	UnusedVariable     = "unused-variable"
+	UnusedParameter    = "unused-parameter"
+	ShadowedBuiltin    = "shadowed-builtin"
+	UnreachableCode    = "unreachable-code"
+	UndefinedVariable  = "undefined-variable"
+	WrongArgumentCount = "wrong-argument-count"
+)
+
+// Rules lists every rule ID the linter can report.
+var Rules = []string{
+	UnusedVariable,
+	UnusedParameter,
+	ShadowedBuiltin,
+	UnreachableCode,
+	UndefinedVariable,
+	WrongArgumentCount,
+}
+
+// ignoreDirective suppresses diagnostics on its own line and the line below,
+// e.g. "// lint:ignore unused-variable, shadowed-builtin". Without rule IDs
+// it suppresses every rule.
+const ignoreDirective = "lint:ignore"
+
+var builtinArity = map[string]int{
+	"len":   1,
+	"first": 1,
+	"last":  1,
+	"push":  2,
+}
+
+type Diagnostic struct {
+	Rule    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (diagnostic Diagnostic) String() string {
+	return fmt.Sprintf("%d:%d: %s (%s)", diagnostic.Line, diagnostic.Column, diagnostic.Message, diagnostic.Rule)
+}
+
+// LintSource parses and lints source, honouring lint:ignore comments.
+func LintSource(source string) ([]Diagnostic, error) {
+	l := lexer.New(source)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors) != 0 {
+		return nil, fmt.Errorf("parser errors:\n\t%s", strings.Join(p.Errors, "\n\t"))
+	}
+
+	ignored := ignoredRules(l.Comments())
+	var diagnostics []Diagnostic
+	for _, diagnostic := range Lint(program) {
+		if isIgnored(ignored[diagnostic.Line], diagnostic.Rule) || isIgnored(ignored[diagnostic.Line-1], diagnostic.Rule) {
+			continue
+		}
+		diagnostics = append(diagnostics, diagnostic)
+	}
+	return diagnostics, nil
+}
+
+// Lint resolves every identifier in program the way the compiler does and
+// reports the problems it finds, ordered by position.
+func Lint(program *ast.Program) []Diagnostic {
+	symbolTable := compiler.NewSymbolTable()
+	linter := &linter{scope: newScope(symbolTable, nil)}
+	for index, builtin := range object.Builtins {
+		symbolTable.DefineBuiltin(index, builtin.Name)
+		arity, ok := builtinArity[builtin.Name]
+		if !ok {
+			arity = -1
+		}
+		linter.scope.define(&binding{name: builtin.Name, kind: builtinBinding, arity: arity, used: true})
+	}
+
+	linter.statements(program.Statements)
+	linter.reportUnused()
+
+	sort.SliceStable(linter.diagnostics, func(i, j int) bool {
+		left, right := linter.diagnostics[i], linter.diagnostics[j]
+		if left.Line != right.Line {
+			return left.Line < right.Line
+		}
+		return left.Column < right.Column
+	})
+	return linter.diagnostics
+}
+
+type bindingKind int
+
+const (
+	letBinding bindingKind = iota
+	parameterBinding
+	functionNameBinding
+	builtinBinding
+)
+
+type binding struct {
+	name  string
+	kind  bindingKind
+	token *token.Token
+	arity int
+	used  bool
+}
+
+type scope struct {
+	symbolTable *compiler.SymbolTable
+	bindings    map[string]*binding
+	defined     []*binding
+	outer       *scope
+}
+
+func newScope(symbolTable *compiler.SymbolTable, outer *scope) *scope {
+	return &scope{symbolTable: symbolTable, bindings: make(map[string]*binding), outer: outer}
+}
+
+func (scope *scope) define(binding *binding) {
+	scope.bindings[binding.name] = binding
+	scope.defined = append(scope.defined, binding)
+}
+
+func (scope *scope) lookUp(name string) *binding {
+	for current := scope; current != nil; current = current.outer {
+		if binding, ok := current.bindings[name]; ok {
+			return binding
+		}
+	}
+	return nil
+}
+
+type linter struct {
+	scope       *scope
+	diagnostics []Diagnostic
+}
+
+func (linter *linter) report(rule string, t *token.Token, format string, a ...interface{}) {
+	diagnostic := Diagnostic{Rule: rule, Message: fmt.Sprintf(format, a...)}
+	if t != nil {
+		diagnostic.Line, diagnostic.Column = t.Line, t.Column
+	}
+	linter.diagnostics = append(linter.diagnostics, diagnostic)
+}
+
+func (linter *linter) statements(statements []ast.Statement) {
+	returned := false
+	for _, statement := range statements {
+		if returned {
+			linter.report(UnreachableCode, statementToken(statement), "unreachable code after return")
+			returned = false
+		}
+		linter.statement(statement)
+		if _, ok := statement.(*ast.ReturnStatement); ok {
+			returned = true
+		}
+	}
+}
+
+func (linter *linter) statement(statement ast.Statement) {
+	switch statement := statement.(type) {
+	case *ast.LetStatement:
+		// As in the compiler, the value is walked before the name is defined,
+		// so it refers to the enclosing binding.
+		identifier := statement.Identifier
+		linter.checkShadowedBuiltin(identifier)
+		linter.expression(statement.Value)
+		linter.scope.symbolTable.Define(identifier.Value)
+		linter.scope.define(&binding{
+			name:  identifier.Value,
+			kind:  letBinding,
+			token: identifier.Token,
+			arity: arityOf(statement.Value),
+		})
+	case *ast.ReturnStatement:
+		linter.expression(statement.ReturnValue)
+	case *ast.ExpressionStatement:
+		linter.expression(statement.Expression)
+	}
+}
+
+func (linter *linter) expression(expression ast.Expression) {
+	switch expression := expression.(type) {
+	case *ast.Identifier:
+		linter.resolve(expression)
+	case *ast.PrefixExpression:
+		linter.expression(expression.Operand)
+	case *ast.InfixExpression:
+		linter.expression(expression.Left)
+		linter.expression(expression.Right)
+	case *ast.IfExpression:
+		linter.expression(expression.Condition)
+		if expression.Consequence != nil {
+			linter.statements(expression.Consequence.Statements)
+		}
+		if expression.Alternative != nil {
+			linter.statements(expression.Alternative.Statements)
+		}
+	case *ast.FunctionLiteral:
+		linter.function(expression.Name, len(expression.Parameters), expression.Parameters, expression.Body)
+	case *ast.MacroLiteral:
+		linter.function("", len(expression.Parameters), expression.Parameters, expression.Body)
+	case *ast.CallExpression:
+		linter.call(expression)
+	case *ast.ArrayLiteral:
+		for _, element := range expression.Elements {
+			linter.expression(element)
+		}
+	case *ast.IndexExpression:
+		linter.expression(expression.Expression)
+		linter.expression(expression.Index)
+	}
+}
+
+func (linter *linter) function(name string, arity int, parameters []ast.Expression, body *ast.BlockStatement) {
+	symbolTable := compiler.NewEnclosedSymbolTable(linter.scope.symbolTable)
+	linter.scope = newScope(symbolTable, linter.scope)
+
+	if name != "" {
+		symbolTable.DefineFunctionName(name)
+		linter.scope.define(&binding{name: name, kind: functionNameBinding, arity: arity, used: true})
+	}
+	for _, parameter := range parameters {
+		identifier, ok := parameter.(*ast.Identifier)
+		if !ok {
+			continue
+		}
+		linter.checkShadowedBuiltin(identifier)
+		symbolTable.Define(identifier.Value)
+		linter.scope.define(&binding{name: identifier.Value, kind: parameterBinding, token: identifier.Token, arity: -1})
+	}
+
+	if body != nil {
+		linter.statements(body.Statements)
+	}
+	linter.reportUnused()
+	linter.scope = linter.scope.outer
+}
+
+// reportUnused reports the bindings of the current scope that are never used.
+func (linter *linter) reportUnused() {
+	for _, defined := range linter.scope.defined {
+		if defined.used || strings.HasPrefix(defined.name, "_") {
+			continue
+		}
+		switch defined.kind {
+		case letBinding:
+			linter.report(UnusedVariable, defined.token, "%s declared and not used", defined.name)
+		case parameterBinding:
+			linter.report(UnusedParameter, defined.token, "parameter %s is not used", defined.name)
+		}
+	}
+}
+
+func (linter *linter) call(call *ast.CallExpression) {
+	if identifier, ok := call.Function.(*ast.Identifier); ok && identifier.Value == "quote" {
+		for _, argument := range call.Arguments {
+			linter.unquotedExpressions(argument)
+		}
+		return
+	}
+
+	linter.expression(call.Function)
+	for _, argument := range call.Arguments {
+		linter.expression(argument)
+	}
+
+	name, arity := linter.calleeArity(call.Function)
+	if arity >= 0 && arity != len(call.Arguments) {
+		linter.report(WrongArgumentCount, calleeToken(call), "wrong number of arguments to %s: want=%d, got=%d",
+			name, arity, len(call.Arguments))
+	}
+}
+
+// unquotedExpressions lints only the unquote calls inside quoted code; the
+// rest is not evaluated until a macro expands it.
+func (linter *linter) unquotedExpressions(quoted ast.Node) {
+	ast.Inspect(quoted, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpression)
+		if !ok {
+			return true
+		}
+		identifier, ok := call.Function.(*ast.Identifier)
+		if !ok || identifier.Value != "unquote" {
+			return true
+		}
+		for _, argument := range call.Arguments {
+			linter.expression(argument)
+		}
+		return false
+	})
+}
+
+func (linter *linter) resolve(identifier *ast.Identifier) {
+	if identifier.Value == "quote" || identifier.Value == "unquote" {
+		return
+	}
+
+	_, ok := linter.scope.symbolTable.Resolve(identifier.Value)
+	if !ok {
+		linter.report(UndefinedVariable, identifier.Token, "undefined variable %s", identifier.Value)
+		return
+	}
+
+	if resolved := linter.scope.lookUp(identifier.Value); resolved != nil {
+		resolved.used = true
+	}
+}
+
+func (linter *linter) calleeArity(function ast.Expression) (string, int) {
+	switch function := function.(type) {
+	case *ast.Identifier:
+		if resolved := linter.scope.lookUp(function.Value); resolved != nil {
+			return function.Value, resolved.arity
+		}
+	case *ast.FunctionLiteral:
+		return "function literal", len(function.Parameters)
+	}
+	return "", -1
+}
+
+func (linter *linter) checkShadowedBuiltin(identifier *ast.Identifier) {
+	if object.GetBuiltinByName(identifier.Value) != nil {
+		linter.report(ShadowedBuiltin, identifier.Token, "%s shadows a builtin function", identifier.Value)
+	}
+}
+
+func arityOf(expression ast.Expression) int {
+	switch expression := expression.(type) {
+	case *ast.FunctionLiteral:
+		return len(expression.Parameters)
+	case *ast.MacroLiteral:
+		return len(expression.Parameters)
+	}
+	return -1
+}
+
+func calleeToken(call *ast.CallExpression) *token.Token {
+	if identifier, ok := call.Function.(*ast.Identifier); ok {
+		return identifier.Token
+	}
+	return &call.Token
+}
+
+func statementToken(statement ast.Statement) *token.Token {
+	switch statement := statement.(type) {
+	case *ast.LetStatement:
+		return statement.Token
+	case *ast.ReturnStatement:
+		return statement.Token
+	case *ast.ExpressionStatement:
+		return statement.Token
+	}
+	return nil
+}
+
+func ignoredRules(comments []*token.Token) map[int][]string {
+	ignored := make(map[int][]string)
+	for _, comment := range comments {
+		text := strings.TrimSpace(strings.TrimPrefix(comment.Literal, "//"))
+		if !strings.HasPrefix(text, ignoreDirective) {
+			continue
+		}
+		rules := []string{}
+		for _, rule := range strings.Split(strings.TrimPrefix(text, ignoreDirective), ",") {
+			if rule = strings.TrimSpace(rule); rule != "" {
+				rules = append(rules, rule)
+			}
+		}
+		ignored[comment.Line] = rules
+	}
+	return ignored
+}
+
+func isIgnored(rules []string, rule string) bool {
+	if rules == nil {
+		return false
+	}
+	if len(rules) == 0 {
+		return true
+	}
+	for _, ignored := range rules {
+		if ignored == rule {
+			return true
+		}
+	}
+	return false
+}
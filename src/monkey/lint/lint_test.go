@@ -0,0 +1,129 @@
+package lint
+
+import (
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{
+			`let add = fn(a, b) { a + b }; add(1, 2);`,
+			nil,
+		},
+		{
+			`let f = fn() { let x = 1; 2 }; f();`,
+			[]string{"1:20: x declared and not used (unused-variable)"},
+		},
+		{
+			`let f = fn(a, b) { a }; f(1, 2);`,
+			[]string{"1:15: parameter b is not used (unused-parameter)"},
+		},
+		{
+			`let f = fn(_a) { let _b = 1; 2 }; f(1);`,
+			nil,
+		},
+		{
+			`let len = fn(x) { x }; len(1);`,
+			[]string{"1:5: len shadows a builtin function (shadowed-builtin)"},
+		},
+		{
+			`let f = fn(first) { first }; f(1);`,
+			[]string{"1:12: first shadows a builtin function (shadowed-builtin)"},
+		},
+		{
+			"let f = fn() {\n return 1;\n 2;\n}; f();",
+			[]string{"3:2: unreachable code after return (unreachable-code)"},
+		},
+		{
+			`let f = fn() { y }; f(); z;`,
+			[]string{
+				"1:16: undefined variable y (undefined-variable)",
+				"1:26: undefined variable z (undefined-variable)",
+			},
+		},
+		{
+			`let add = fn(a, b) { a + b }; add(1); len([], 1); fn(x) { x }(1, 2);`,
+			[]string{
+				"1:31: wrong number of arguments to add: want=2, got=1 (wrong-argument-count)",
+				"1:39: wrong number of arguments to len: want=1, got=2 (wrong-argument-count)",
+				"1:62: wrong number of arguments to function literal: want=1, got=2 (wrong-argument-count)",
+			},
+		},
+		{
+			`let countDown = fn(x) { if (x == 0) { 0 } else { countDown(x - 1, 1) } }; countDown(3);`,
+			[]string{"1:50: wrong number of arguments to countDown: want=1, got=2 (wrong-argument-count)"},
+		},
+		{
+			`let f = fn(a) { fn() { a } }; f(1)();`,
+			nil,
+		},
+		{
+			`let unused = 5; let f = fn() { 1 }; let _ignored = 1;`,
+			[]string{
+				"1:5: unused declared and not used (unused-variable)",
+				"1:21: f declared and not used (unused-variable)",
+			},
+		},
+		{
+			`let f = fn() { let x = x; 1 }; f();`,
+			[]string{
+				"1:20: x declared and not used (unused-variable)",
+				"1:24: undefined variable x (undefined-variable)",
+			},
+		},
+		{
+			`let x = x + 1; puts(x);`,
+			[]string{"1:9: undefined variable x (undefined-variable)"},
+		},
+		{
+			`let x = 1; let f = fn() { let x = x + 1; x }; f();`,
+			nil,
+		},
+		{
+			`let unless = macro(condition, body) { quote(if (!(unquote(condition))) { unquote(body) }) }; unless(true, 1);`,
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		diagnostics, err := LintSource(tt.input)
+		if err != nil {
+			t.Fatalf("LintSource(%q) failed: %s", tt.input, err)
+		}
+		if len(diagnostics) != len(tt.expected) {
+			t.Errorf("wrong number of diagnostics for %q. want=%q, got=%q", tt.input, tt.expected, diagnostics)
+			continue
+		}
+		for i, expected := range tt.expected {
+			if diagnostics[i].String() != expected {
+				t.Errorf("wrong diagnostic for %q. want=%q, got=%q", tt.input, expected, diagnostics[i].String())
+			}
+		}
+	}
+}
+
+func TestLintIgnoreComments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int
+	}{
+		{"let f = fn() { let x = 1; 2 }; f(); // lint:ignore unused-variable", 0},
+		{"// lint:ignore unused-variable\nlet f = fn() { let x = 1; 2 }; f();", 0},
+		{"// lint:ignore\nlet f = fn(a) { let x = 1; 2 }; f(1);", 0},
+		{"// lint:ignore unused-parameter\nlet f = fn(a) { let x = 1; 2 }; f(1);", 1},
+		{"// lint:ignore unused-variable\n\nlet f = fn() { let x = 1; 2 }; f();", 1},
+	}
+
+	for _, tt := range tests {
+		diagnostics, err := LintSource(tt.input)
+		if err != nil {
+			t.Fatalf("LintSource(%q) failed: %s", tt.input, err)
+		}
+		if len(diagnostics) != tt.expected {
+			t.Errorf("wrong number of diagnostics for %q. want=%d, got=%q", tt.input, tt.expected, diagnostics)
+		}
+	}
+}
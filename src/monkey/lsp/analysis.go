@@ -0,0 +1,453 @@
+package lsp
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"writing-in-interpreter-in-go/src/monkey/ast"
+	"writing-in-interpreter-in-go/src/monkey/compiler"
+	"writing-in-interpreter-in-go/src/monkey/lexer"
+	"writing-in-interpreter-in-go/src/monkey/object"
+	"writing-in-interpreter-in-go/src/monkey/parser"
+	"writing-in-interpreter-in-go/src/monkey/token"
+)
+
+// builtinHelp documents the builtins, shown when hovering over them.
+var builtinHelp = map[string]struct{ signature, doc string }{
+	"len":   {"len(value)", "The number of elements of an array, or of bytes of a string."},
+	"puts":  {"puts(values...)", "Prints every value on its own line and returns null."},
+	"first": {"first(array)", "The first element of array, or null if it is empty."},
+	"last":  {"last(array)", "The last element of array, or null if it is empty."},
+	"push":  {"push(array, value)", "A new array: the elements of array followed by value."},
+}
+
+// position is a place in a source, with a line and a byte column from 1 as
+// in tokens.
+type position struct {
+	line   int
+	column int
+}
+
+func positionOf(t *token.Token) position {
+	return position{line: t.Line, column: t.Column}
+}
+
+func (p position) before(other position) bool {
+	if p.line != other.line {
+		return p.line < other.line
+	}
+	return p.column < other.column
+}
+
+type diagnostic struct {
+	start   position
+	end     position
+	message string
+}
+
+type symbolKind int
+
+const (
+	builtinSymbol symbolKind = iota
+	globalSymbol
+	localSymbol
+	parameterSymbol
+)
+
+// symbol is a variable as the compiler resolves it: the bindings of a name
+// that share a slot, and the identifiers referring to them.
+type symbol struct {
+	name        string
+	kind        symbolKind
+	owner       *scope
+	definitions []*token.Token
+	references  []*token.Token
+	// function is the function literal last bound to the symbol, if any.
+	function *ast.FunctionLiteral
+}
+
+// scope holds the symbols defined in a function, or at the top level, or
+// the builtins. start and end delimit the function.
+type scope struct {
+	symbols map[string]*symbol
+	defined []*symbol
+	outer   *scope
+	start   position
+	end     position
+}
+
+func newScope(outer *scope, start, end position) *scope {
+	return &scope{symbols: make(map[string]*symbol), outer: outer, start: start, end: end}
+}
+
+func (scope *scope) lookUp(name string) *symbol {
+	for current := scope; current != nil; current = current.outer {
+		if symbol, ok := current.symbols[name]; ok {
+			return symbol
+		}
+	}
+	return nil
+}
+
+// occurrence is an identifier that resolves to a symbol.
+type occurrence struct {
+	token  *token.Token
+	symbol *symbol
+}
+
+// analysis is what the server knows of a source: its problems and, if it
+// parses, where its identifiers are bound and used.
+type analysis struct {
+	parsed      bool
+	diagnostics []diagnostic
+	occurrences []occurrence
+	// scopes holds the builtins, the top level and then every function.
+	scopes []*scope
+}
+
+func analyze(source string) *analysis {
+	analysis := &analysis{}
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors) > 0 {
+		for i, message := range p.Errors {
+			at := p.ErrorTokens[i]
+			start := positionOf(at)
+			end := position{line: start.line, column: start.column + len(at.Literal)}
+			analysis.diagnostics = append(analysis.diagnostics, diagnostic{start: start, end: end, message: message})
+		}
+		return analysis
+	}
+	analysis.parsed = true
+
+	resolver := &resolver{analysis: analysis, braces: matchBraces(source)}
+	resolver.resolve(program)
+
+	err := compiler.New().Compile(program)
+	if resolutionErrors, ok := compiler.AsResolutionErrors(err); ok {
+		for _, resolutionError := range resolutionErrors {
+			message := "undefined variable " + resolutionError.Name
+			if resolutionError.Suggestion != "" {
+				message += fmt.Sprintf(", did you mean `%s`?", resolutionError.Suggestion)
+			}
+			span := resolutionError.Span
+			analysis.diagnostics = append(analysis.diagnostics, diagnostic{
+				start:   position{line: span.Line, column: span.Column},
+				end:     position{line: span.EndLine, column: span.EndColumn},
+				message: message,
+			})
+		}
+	} else if err != nil {
+		// Other compilation errors have no position.
+		first := position{line: 1, column: 1}
+		analysis.diagnostics = append(analysis.diagnostics, diagnostic{start: first, end: first, message: err.Error()})
+	}
+	return analysis
+}
+
+// matchBraces maps the position of every opening brace in source to the
+// position of the brace closing it.
+func matchBraces(source string) map[position]position {
+	braces := make(map[position]position)
+	var open []position
+	l := lexer.New(source)
+	for t := l.NextToken(); t.Type != token.EOF; t = l.NextToken() {
+		switch t.Type {
+		case token.LBRACE:
+			open = append(open, positionOf(t))
+		case token.RBRACE:
+			if len(open) > 0 {
+				braces[open[len(open)-1]] = positionOf(t)
+				open = open[:len(open)-1]
+			}
+		}
+	}
+	return braces
+}
+
+// occurrenceAt returns the identifier at p, which may also be just after
+// it, where the cursor is once the identifier is typed.
+func (analysis *analysis) occurrenceAt(p position) (occurrence, bool) {
+	for _, occurrence := range analysis.occurrences {
+		t := occurrence.token
+		if t.Line == p.line && t.Column <= p.column && p.column <= t.Column+len(t.Literal) {
+			return occurrence, true
+		}
+	}
+	return occurrence{}, false
+}
+
+// visible returns the symbols in scope at p, the innermost first, and then
+// the builtins.
+func (analysis *analysis) visible(p position) []*symbol {
+	seen := make(map[string]bool)
+	var symbols []*symbol
+	for current := analysis.scopeAt(p); current != nil; current = current.outer {
+		for _, symbol := range current.defined {
+			if seen[symbol.name] || !symbol.definedBefore(p) {
+				continue
+			}
+			seen[symbol.name] = true
+			symbols = append(symbols, symbol)
+		}
+	}
+	return symbols
+}
+
+// scopeAt returns the innermost scope around p.
+func (analysis *analysis) scopeAt(p position) *scope {
+	if len(analysis.scopes) == 0 {
+		return nil
+	}
+	// Functions come after the functions enclosing them, so the last scope
+	// around p is the innermost one.
+	innermost := analysis.scopes[0]
+	for _, scope := range analysis.scopes[1:] {
+		if !p.before(scope.start) && !scope.end.before(p) {
+			innermost = scope
+		}
+	}
+	return innermost
+}
+
+func (symbol *symbol) definedBefore(p position) bool {
+	return symbol.kind == builtinSymbol || positionOf(symbol.definitions[0]).before(p)
+}
+
+// inScope reports whether symbol is in scope at p, even if a symbol of the
+// same name hides it there.
+func (analysis *analysis) inScope(symbol *symbol, p position) bool {
+	return symbol.definedBefore(p) && symbol.owner.encloses(analysis.scopeAt(p))
+}
+
+// encloses reports whether inner is scope or is nested in it.
+func (scope *scope) encloses(inner *scope) bool {
+	for current := inner; current != nil; current = current.outer {
+		if current == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// renameConflict returns an identifier that would refer to another symbol
+// if renamed were called name: one of renamed's, where a symbol called name
+// is visible, or one of a symbol called name, which renamed would rebind or
+// shadow.
+func (analysis *analysis) renameConflict(renamed *symbol, name string) (*token.Token, bool) {
+	for _, occurrence := range analysis.occurrences {
+		at := positionOf(occurrence.token)
+		switch occurrence.symbol {
+		case renamed:
+			for _, symbol := range analysis.visible(at) {
+				if symbol.name == name {
+					return occurrence.token, true
+				}
+			}
+		default:
+			other := occurrence.symbol
+			if other.name == name && other.owner.encloses(renamed.owner) && analysis.inScope(renamed, at) {
+				return occurrence.token, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// description is the Monkey code shown when hovering over a symbol.
+func (symbol *symbol) description() string {
+	switch {
+	case symbol.kind == builtinSymbol:
+		return builtinHelp[symbol.name].signature
+	case symbol.kind == parameterSymbol:
+		return "parameter " + symbol.name
+	case symbol.function != nil:
+		return fmt.Sprintf("let %s = %s", symbol.name, signature(symbol.function))
+	}
+	return "let " + symbol.name
+}
+
+func signature(function *ast.FunctionLiteral) string {
+	parameters := make([]string, len(function.Parameters))
+	for i, parameter := range function.Parameters {
+		parameters[i] = parameter.String()
+	}
+	return "fn(" + strings.Join(parameters, ", ") + ")"
+}
+
+// resolver binds identifiers to symbols the way the compiler does.
+type resolver struct {
+	analysis *analysis
+	braces   map[position]position
+	scope    *scope
+}
+
+func (resolver *resolver) resolve(program *ast.Program) {
+	everywhere := position{line: math.MaxInt, column: math.MaxInt}
+	builtins := newScope(nil, position{}, everywhere)
+	for _, builtin := range object.Builtins {
+		symbol := &symbol{name: builtin.Name, kind: builtinSymbol, owner: builtins}
+		builtins.symbols[builtin.Name] = symbol
+		builtins.defined = append(builtins.defined, symbol)
+	}
+	resolver.scope = newScope(builtins, position{}, everywhere)
+	resolver.analysis.scopes = append(resolver.analysis.scopes, builtins, resolver.scope)
+	resolver.statements(program.Statements)
+}
+
+func (resolver *resolver) statements(statements []ast.Statement) {
+	for _, statement := range statements {
+		switch statement := statement.(type) {
+		case *ast.LetStatement:
+			resolver.let(statement)
+		case *ast.ReturnStatement:
+			resolver.expression(statement.ReturnValue)
+		case *ast.ExpressionStatement:
+			resolver.expression(statement.Expression)
+		}
+	}
+}
+
+// let resolves the value before binding the name, which refers to an outer
+// binding meanwhile, except in a function the value names after it.
+func (resolver *resolver) let(statement *ast.LetStatement) {
+	name := statement.Identifier.Value
+	bound, ok := resolver.scope.symbols[name]
+	fresh := !ok || bound.owner != resolver.scope
+	if fresh {
+		kind := localSymbol
+		if resolver.scope.outer.outer == nil {
+			kind = globalSymbol
+		}
+		bound = &symbol{name: name, kind: kind, owner: resolver.scope}
+	}
+
+	function, ok := statement.Value.(*ast.FunctionLiteral)
+	if ok && function.Name == name {
+		resolver.function(&function.Token, function.Parameters, function.Body, bound)
+	} else {
+		resolver.expression(statement.Value)
+	}
+	bound.function = function
+
+	if fresh {
+		resolver.scope.defined = append(resolver.scope.defined, bound)
+	}
+	resolver.scope.symbols[name] = bound
+	resolver.define(bound, statement.Identifier.Token)
+}
+
+func (resolver *resolver) define(symbol *symbol, t *token.Token) {
+	symbol.definitions = append(symbol.definitions, t)
+	resolver.analysis.occurrences = append(resolver.analysis.occurrences, occurrence{token: t, symbol: symbol})
+}
+
+func (resolver *resolver) expression(expression ast.Expression) {
+	switch expression := expression.(type) {
+	case *ast.Identifier:
+		resolver.identifier(expression)
+	case *ast.PrefixExpression:
+		resolver.expression(expression.Operand)
+	case *ast.InfixExpression:
+		resolver.expression(expression.Left)
+		resolver.expression(expression.Right)
+	case *ast.IfExpression:
+		resolver.expression(expression.Condition)
+		if expression.Consequence != nil {
+			resolver.statements(expression.Consequence.Statements)
+		}
+		if expression.Alternative != nil {
+			resolver.statements(expression.Alternative.Statements)
+		}
+	case *ast.FunctionLiteral:
+		resolver.function(&expression.Token, expression.Parameters, expression.Body, nil)
+	case *ast.MacroLiteral:
+		resolver.function(expression.Token, expression.Parameters, expression.Body, nil)
+	case *ast.CallExpression:
+		resolver.call(expression)
+	case *ast.ArrayLiteral:
+		for _, element := range expression.Elements {
+			resolver.expression(element)
+		}
+	case *ast.IndexExpression:
+		resolver.expression(expression.Expression)
+		resolver.expression(expression.Index)
+	}
+}
+
+// function resolves a function or macro literal. self is the symbol its
+// name refers to in its body, if it has one.
+func (resolver *resolver) function(start *token.Token, parameters []ast.Expression, body *ast.BlockStatement, self *symbol) {
+	end := position{line: math.MaxInt, column: math.MaxInt}
+	if body != nil {
+		if closing, ok := resolver.braces[positionOf(&body.Token)]; ok {
+			end = closing
+		}
+	}
+	resolver.scope = newScope(resolver.scope, positionOf(start), end)
+	resolver.analysis.scopes = append(resolver.analysis.scopes, resolver.scope)
+
+	if self != nil {
+		resolver.scope.symbols[self.name] = self
+	}
+	for _, parameter := range parameters {
+		identifier, ok := parameter.(*ast.Identifier)
+		if !ok {
+			continue
+		}
+		bound := &symbol{name: identifier.Value, kind: parameterSymbol, owner: resolver.scope}
+		resolver.scope.symbols[identifier.Value] = bound
+		resolver.scope.defined = append(resolver.scope.defined, bound)
+		resolver.define(bound, identifier.Token)
+	}
+
+	if body != nil {
+		resolver.statements(body.Statements)
+	}
+	resolver.scope = resolver.scope.outer
+}
+
+func (resolver *resolver) call(call *ast.CallExpression) {
+	if identifier, ok := call.Function.(*ast.Identifier); ok && identifier.Value == "quote" {
+		for _, argument := range call.Arguments {
+			resolver.unquotedExpressions(argument)
+		}
+		return
+	}
+
+	resolver.expression(call.Function)
+	for _, argument := range call.Arguments {
+		resolver.expression(argument)
+	}
+}
+
+// unquotedExpressions resolves only the unquote calls inside quoted code;
+// the rest is bound where a macro expands it.
+func (resolver *resolver) unquotedExpressions(quoted ast.Node) {
+	ast.Inspect(quoted, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpression)
+		if !ok {
+			return true
+		}
+		identifier, ok := call.Function.(*ast.Identifier)
+		if !ok || identifier.Value != "unquote" {
+			return true
+		}
+		for _, argument := range call.Arguments {
+			resolver.expression(argument)
+		}
+		return false
+	})
+}
+
+func (resolver *resolver) identifier(identifier *ast.Identifier) {
+	if identifier.Value == "quote" || identifier.Value == "unquote" || identifier.Token == nil {
+		return
+	}
+	symbol := resolver.scope.lookUp(identifier.Value)
+	if symbol == nil {
+		return
+	}
+	symbol.references = append(symbol.references, identifier.Token)
+	resolver.analysis.occurrences = append(resolver.analysis.occurrences, occurrence{token: identifier.Token, symbol: symbol})
+}
@@ -0,0 +1,320 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+	"writing-in-interpreter-in-go/src/monkey/framing"
+)
+
+const uri = "file:///program.mk"
+
+// client is a scripted language client.
+type client struct {
+	t   *testing.T
+	in  *bufio.Reader
+	out io.Writer
+	id  int
+	// notifications holds the notifications received and not expected yet.
+	notifications []map[string]any
+}
+
+func startServer(t *testing.T) *client {
+	clientIn, serverOut := io.Pipe()
+	serverIn, clientOut := io.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- NewServer(serverIn, serverOut).Serve()
+		serverOut.Close()
+	}()
+	t.Cleanup(func() {
+		go func() { _, _ = io.Copy(io.Discard, clientIn) }()
+		clientOut.Close()
+		if err := <-done; err != nil {
+			t.Errorf("server failed: %s", err)
+		}
+	})
+	client := &client{t: t, in: bufio.NewReader(clientIn), out: clientOut}
+	client.request("initialize", map[string]any{"capabilities": map[string]any{}})
+	client.notify("initialized", map[string]any{})
+	return client
+}
+
+func (client *client) read() map[string]any {
+	client.t.Helper()
+	content, err := framing.ReadMessage(client.in)
+	if err != nil {
+		client.t.Fatalf("reading a message failed: %s", err)
+	}
+	var message map[string]any
+	err = json.Unmarshal(content, &message)
+	if err != nil {
+		client.t.Fatalf("malformed message %s: %s", content, err)
+	}
+	return message
+}
+
+func (client *client) send(message map[string]any) {
+	client.t.Helper()
+	message["jsonrpc"] = "2.0"
+	err := framing.WriteMessage(client.out, message)
+	if err != nil {
+		client.t.Fatalf("sending %v failed: %s", message["method"], err)
+	}
+}
+
+func (client *client) notify(method string, params any) {
+	client.t.Helper()
+	client.send(map[string]any{"method": method, "params": params})
+}
+
+// call sends a request and returns its response, keeping the notifications
+// received meanwhile.
+func (client *client) call(method string, params any) map[string]any {
+	client.t.Helper()
+	client.id++
+	client.send(map[string]any{"id": client.id, "method": method, "params": params})
+	for {
+		message := client.read()
+		if _, ok := message["id"]; !ok {
+			client.notifications = append(client.notifications, message)
+			continue
+		}
+		if message["id"] != float64(client.id) {
+			client.t.Fatalf("unexpected response %v to %s", message, method)
+		}
+		return message
+	}
+}
+
+// request returns the result of a request, which must succeed.
+func (client *client) request(method string, params any) any {
+	client.t.Helper()
+	response := client.call(method, params)
+	if response["error"] != nil {
+		client.t.Fatalf("%s failed: %v", method, response["error"])
+	}
+	return response["result"]
+}
+
+// diagnostics returns the messages and ranges of the next diagnostics
+// published.
+func (client *client) diagnostics() []string {
+	client.t.Helper()
+	var message map[string]any
+	if len(client.notifications) > 0 {
+		message, client.notifications = client.notifications[0], client.notifications[1:]
+	} else {
+		message = client.read()
+	}
+	if message["method"] != "textDocument/publishDiagnostics" {
+		client.t.Fatalf("got %v, want diagnostics", message)
+	}
+	var diagnostics []string
+	for _, diagnostic := range message["params"].(map[string]any)["diagnostics"].([]any) {
+		diagnostic := diagnostic.(map[string]any)
+		diagnostics = append(diagnostics, rangeString(diagnostic["range"])+" "+diagnostic["message"].(string))
+	}
+	return diagnostics
+}
+
+func (client *client) open(text string) {
+	client.t.Helper()
+	client.notify("textDocument/didOpen", map[string]any{
+		"textDocument": map[string]any{"uri": uri, "languageId": "monkey", "version": 1, "text": text},
+	})
+}
+
+func at(line, character int) map[string]any {
+	return map[string]any{
+		"textDocument": map[string]any{"uri": uri},
+		"position":     map[string]any{"line": line, "character": character},
+	}
+}
+
+func rangeString(r any) string {
+	encoded, _ := json.Marshal(r)
+	var decoded protocolRange
+	_ = json.Unmarshal(encoded, &decoded)
+	return fmt.Sprintf("%d:%d-%d:%d", decoded.Start.Line, decoded.Start.Character, decoded.End.Line, decoded.End.Character)
+}
+
+// ranges returns the ranges of locations or of edits.
+func ranges(items any) []string {
+	var ranges []string
+	list, _ := items.([]any)
+	for _, item := range list {
+		ranges = append(ranges, rangeString(item.(map[string]any)["range"]))
+	}
+	return ranges
+}
+
+func TestDiagnostics(t *testing.T) {
+	client := startServer(t)
+	client.open("let x = 1;\nlet = 2;\n")
+	expected := []string{
+		"1:4-1:5 expected next token to be IDENTIFIER, got = instead",
+		"1:4-1:5 no prefix parse function for = found",
+	}
+	if got := client.diagnostics(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong parser diagnostics.\nwant=%q\ngot=%q", expected, got)
+	}
+
+	client.notify("textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": uri, "version": 2},
+		"contentChanges": []any{map[string]any{"text": "let total = 1;\nputs(\"é\", totl);\n"}},
+	})
+	expected = []string{"1:10-1:14 undefined variable totl, did you mean `total`?"}
+	if got := client.diagnostics(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong compiler diagnostics.\nwant=%q\ngot=%q", expected, got)
+	}
+
+	client.notify("textDocument/didClose", map[string]any{"textDocument": map[string]any{"uri": uri}})
+	if got := client.diagnostics(); len(got) != 0 {
+		t.Errorf("diagnostics not cleared on close: %q", got)
+	}
+	client.request("shutdown", nil)
+	client.notify("exit", nil)
+}
+
+func TestNavigation(t *testing.T) {
+	client := startServer(t)
+	client.open(`let scale = 2;
+let weigh = fn(values, factor) {
+  let total = first(values) + last(values);
+  total * factor * scale
+};
+let scale = weigh([1, 2], scale);
+`)
+	if got := client.diagnostics(); len(got) != 0 {
+		t.Fatalf("unexpected diagnostics %q", got)
+	}
+
+	definitions := ranges(client.request("textDocument/definition", at(3, 4)))
+	if expected := []string{"2:6-2:11"}; !reflect.DeepEqual(definitions, expected) {
+		t.Errorf("wrong definition of total. want=%q, got=%q", expected, definitions)
+	}
+	// Both lets of scale bind the same global.
+	definitions = ranges(client.request("textDocument/definition", at(3, 22)))
+	if expected := []string{"0:4-0:9", "5:4-5:9"}; !reflect.DeepEqual(definitions, expected) {
+		t.Errorf("wrong definitions of scale. want=%q, got=%q", expected, definitions)
+	}
+
+	params := at(1, 17)
+	params["context"] = map[string]any{"includeDeclaration": true}
+	references := ranges(client.request("textDocument/references", params))
+	if expected := []string{"1:15-1:21", "2:20-2:26", "2:35-2:41"}; !reflect.DeepEqual(references, expected) {
+		t.Errorf("wrong references to values. want=%q, got=%q", expected, references)
+	}
+
+	hover := client.request("textDocument/hover", at(2, 16)).(map[string]any)
+	value := hover["contents"].(map[string]any)["value"].(string)
+	if expected := "```monkey\nfirst(array)\n```\n\nThe first element of array, or null if it is empty."; value != expected {
+		t.Errorf("wrong hover over first. want=%q, got=%q", expected, value)
+	}
+	hover = client.request("textDocument/hover", at(5, 13)).(map[string]any)
+	if value := hover["contents"].(map[string]any)["value"]; value != "```monkey\nlet weigh = fn(values, factor)\n```" {
+		t.Errorf("wrong hover over weigh: %q", value)
+	}
+	if result := client.request("textDocument/hover", at(5, 0)); result != nil {
+		t.Errorf("hover over a keyword: %v", result)
+	}
+
+	var labels []string
+	for _, item := range client.request("textDocument/completion", at(3, 2)).([]any) {
+		labels = append(labels, item.(map[string]any)["label"].(string))
+	}
+	expected := []string{"values", "factor", "total", "scale", "weigh", "len", "puts", "first", "last", "push"}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Errorf("wrong completion.\nwant=%q\ngot=%q", expected, labels)
+	}
+
+	edit := client.request("textDocument/rename", func() map[string]any {
+		params := at(3, 10)
+		params["newName"] = "weight"
+		return params
+	}()).(map[string]any)
+	edits := ranges(edit["changes"].(map[string]any)[uri])
+	if expected := []string{"1:23-1:29", "3:10-3:16"}; !reflect.DeepEqual(edits, expected) {
+		t.Errorf("wrong rename edits. want=%q, got=%q", expected, edits)
+	}
+
+	for _, newName := range []string{"let", "two words", "1st"} {
+		params := at(3, 10)
+		params["newName"] = newName
+		if response := client.call("textDocument/rename", params); response["error"] == nil {
+			t.Errorf("renaming to %q succeeded", newName)
+		}
+	}
+	params = at(2, 16)
+	params["newName"] = "head"
+	if response := client.call("textDocument/rename", params); response["error"] == nil {
+		t.Errorf("renaming a builtin succeeded")
+	}
+}
+
+func TestRenameConflicts(t *testing.T) {
+	tests := []struct {
+		text     string
+		line     int
+		column   int
+		newName  string
+		conflict bool
+	}{
+		// The parameter x would capture the reference to a.
+		{"let a = 1; let f = fn(x) { x + a };", 0, 4, "x", true},
+		// The later let a would rebind the global f reads.
+		{"let b = 1; let f = fn() { b }; let a = 2;", 0, 4, "a", true},
+		// A local b would hide the global f reads.
+		{"let b = 1; let f = fn() { let c = 2; c + b };", 0, 30, "b", true},
+		{"let f = fn(x) { x }; let a = 1; a;", 0, 25, "x", false},
+		{"let a = 1; let f = fn(x) { x + a };", 0, 22, "y", false},
+	}
+
+	for _, tt := range tests {
+		client := startServer(t)
+		client.open(tt.text)
+		if got := client.diagnostics(); len(got) != 0 {
+			t.Fatalf("unexpected diagnostics %q", got)
+		}
+		params := at(tt.line, tt.column)
+		params["newName"] = tt.newName
+		response := client.call("textDocument/rename", params)
+		if conflict := response["error"] != nil; conflict != tt.conflict {
+			t.Errorf("renaming at %d:%d in %q to %s: want conflict=%t, got %v",
+				tt.line, tt.column, tt.text, tt.newName, tt.conflict, response)
+		}
+	}
+}
+
+func TestFormatting(t *testing.T) {
+	client := startServer(t)
+	client.open("let x=1;\nputs( x )")
+	client.diagnostics()
+	edits := client.request("textDocument/formatting", map[string]any{"textDocument": map[string]any{"uri": uri}}).([]any)
+	if len(edits) != 1 {
+		t.Fatalf("wrong number of edits. want=1, got=%d", len(edits))
+	}
+	edit := edits[0].(map[string]any)
+	if got := rangeString(edit["range"]); got != "0:0-1:9" {
+		t.Errorf("wrong range. want=0:0-1:9, got=%s", got)
+	}
+	if edit["newText"] != "let x = 1;\nputs(x);\n" {
+		t.Errorf("wrong formatting %q", edit["newText"])
+	}
+
+	client.notify("textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": uri, "version": 2},
+		"contentChanges": []any{map[string]any{"text": "let x = ;"}},
+	})
+	client.diagnostics()
+	if response := client.call("textDocument/formatting", map[string]any{"textDocument": map[string]any{"uri": uri}}); response["error"] == nil {
+		t.Errorf("formatting a document that does not parse succeeded")
+	}
+	if response := client.call("textDocument/unknown", nil); response["error"].(map[string]any)["code"] != float64(methodNotFound) {
+		t.Errorf("wrong error for an unknown method: %v", response["error"])
+	}
+}
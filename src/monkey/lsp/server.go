@@ -0,0 +1,364 @@
+// Package lsp serves the Language Server Protocol, with which editors show
+// the problems of Monkey sources, navigate, complete, format and rename.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"writing-in-interpreter-in-go/src/monkey/format"
+	"writing-in-interpreter-in-go/src/monkey/framing"
+	"writing-in-interpreter-in-go/src/monkey/lexer"
+	"writing-in-interpreter-in-go/src/monkey/token"
+)
+
+// Server serves one client, handling its messages one at a time as they are
+// read. Documents are synchronised in full on every change.
+type Server struct {
+	in        *bufio.Reader
+	out       io.Writer
+	documents map[string]*document
+	shutdown  bool
+}
+
+type document struct {
+	text     string
+	lines    []string
+	analysis *analysis
+	// resolved is the last analysis of the document that parsed, with which
+	// completion goes on while the code being typed does not parse.
+	resolved *analysis
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{in: bufio.NewReader(in), out: out, documents: make(map[string]*document)}
+}
+
+// Serve handles messages until the client sends exit or closes its end.
+func (server *Server) Serve() error {
+	for {
+		content, err := framing.ReadMessage(server.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var message message
+		err = json.Unmarshal(content, &message)
+		if err != nil {
+			return fmt.Errorf("malformed message: %s", err)
+		}
+		if message.Method == "exit" {
+			if !server.shutdown {
+				return errors.New("exit before shutdown")
+			}
+			return nil
+		}
+		if len(message.ID) == 0 {
+			err = server.notified(message)
+		} else {
+			result, handleErr := server.handle(message)
+			err = server.respond(message.ID, result, handleErr)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (server *Server) notified(message message) error {
+	switch message.Method {
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if json.Unmarshal(message.Params, &params) != nil {
+			return nil
+		}
+		return server.update(params.TextDocument.URI, params.TextDocument.Text)
+	case "textDocument/didChange":
+		var params didChangeParams
+		if json.Unmarshal(message.Params, &params) != nil || len(params.ContentChanges) == 0 {
+			return nil
+		}
+		changes := params.ContentChanges
+		return server.update(params.TextDocument.URI, changes[len(changes)-1].Text)
+	case "textDocument/didClose":
+		var params didCloseParams
+		if json.Unmarshal(message.Params, &params) != nil {
+			return nil
+		}
+		delete(server.documents, params.TextDocument.URI)
+		return server.notify("textDocument/publishDiagnostics",
+			publishDiagnosticsParams{URI: params.TextDocument.URI, Diagnostics: []protocolDiagnostic{}})
+	}
+	return nil
+}
+
+// update analyses the new text of a document and publishes its problems.
+func (server *Server) update(uri, text string) error {
+	document := &document{text: text, lines: strings.Split(text, "\n"), analysis: analyze(text)}
+	document.resolved = document.analysis
+	if previous, ok := server.documents[uri]; ok && !document.analysis.parsed {
+		document.resolved = previous.resolved
+	}
+	server.documents[uri] = document
+
+	diagnostics := []protocolDiagnostic{}
+	for _, diagnostic := range document.analysis.diagnostics {
+		diagnostics = append(diagnostics, protocolDiagnostic{
+			Range:    protocolRange{Start: document.toProtocol(diagnostic.start), End: document.toProtocol(diagnostic.end)},
+			Severity: 1,
+			Source:   "monkey",
+			Message:  diagnostic.message,
+		})
+	}
+	return server.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: uri, Diagnostics: diagnostics})
+}
+
+func (server *Server) handle(message message) (any, error) {
+	switch message.Method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync":           1,
+				"definitionProvider":         true,
+				"referencesProvider":         true,
+				"hoverProvider":              true,
+				"completionProvider":         map[string]any{},
+				"documentFormattingProvider": true,
+				"renameProvider":             true,
+			},
+			"serverInfo": map[string]string{"name": "monkey"},
+		}, nil
+	case "shutdown":
+		server.shutdown = true
+		return nil, nil
+	case "textDocument/definition":
+		var params textDocumentPositionParams
+		document, err := server.document(message.Params, &params, &params.TextDocument)
+		if err != nil {
+			return nil, err
+		}
+		occurrence, ok := document.occurrenceAt(params.Position)
+		if !ok {
+			return nil, nil
+		}
+		return document.locations(params.TextDocument.URI, occurrence.symbol.definitions), nil
+	case "textDocument/references":
+		var params referenceParams
+		document, err := server.document(message.Params, &params, &params.TextDocument)
+		if err != nil {
+			return nil, err
+		}
+		occurrence, ok := document.occurrenceAt(params.Position)
+		if !ok {
+			return nil, nil
+		}
+		tokens := occurrence.symbol.references
+		if params.Context.IncludeDeclaration {
+			tokens = append(tokens[:len(tokens):len(tokens)], occurrence.symbol.definitions...)
+		}
+		return document.locations(params.TextDocument.URI, tokens), nil
+	case "textDocument/hover":
+		var params textDocumentPositionParams
+		document, err := server.document(message.Params, &params, &params.TextDocument)
+		if err != nil {
+			return nil, err
+		}
+		occurrence, ok := document.occurrenceAt(params.Position)
+		if !ok {
+			return nil, nil
+		}
+		value := "```monkey\n" + occurrence.symbol.description() + "\n```"
+		if help, ok := builtinHelp[occurrence.symbol.name]; ok && occurrence.symbol.kind == builtinSymbol {
+			value += "\n\n" + help.doc
+		}
+		return hover{Contents: markupContent{Kind: "markdown", Value: value}, Range: document.rangeOf(occurrence.token)}, nil
+	case "textDocument/completion":
+		var params textDocumentPositionParams
+		document, err := server.document(message.Params, &params, &params.TextDocument)
+		if err != nil {
+			return nil, err
+		}
+		items := []completionItem{}
+		if document.resolved == nil {
+			return items, nil
+		}
+		for _, symbol := range document.resolved.visible(document.fromProtocol(params.Position)) {
+			item := completionItem{Label: symbol.name, Kind: variableItem}
+			if symbol.kind == builtinSymbol || symbol.function != nil {
+				item.Kind = functionItem
+				item.Detail = symbol.description()
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case "textDocument/formatting":
+		var params formattingParams
+		document, err := server.document(message.Params, &params, &params.TextDocument)
+		if err != nil {
+			return nil, err
+		}
+		formatted, err := format.Format(document.text)
+		if err != nil {
+			return nil, &responseError{Code: requestFailed, Message: err.Error()}
+		}
+		if formatted == document.text {
+			return []textEdit{}, nil
+		}
+		return []textEdit{{Range: document.wholeRange(), NewText: formatted}}, nil
+	case "textDocument/rename":
+		var params renameParams
+		document, err := server.document(message.Params, &params, &params.TextDocument)
+		if err != nil {
+			return nil, err
+		}
+		return document.rename(params)
+	}
+	return nil, &responseError{Code: methodNotFound, Message: "unsupported method " + message.Method}
+}
+
+// document decodes the parameters of a request about a document and returns
+// the document.
+func (server *Server) document(raw json.RawMessage, params any, identifier *textDocumentIdentifier) (*document, error) {
+	err := json.Unmarshal(raw, params)
+	if err != nil {
+		return nil, &responseError{Code: invalidParams, Message: err.Error()}
+	}
+	document, ok := server.documents[identifier.URI]
+	if !ok {
+		return nil, &responseError{Code: requestFailed, Message: "unknown document " + identifier.URI}
+	}
+	return document, nil
+}
+
+func (server *Server) respond(id json.RawMessage, result any, err error) error {
+	response := response{JSONRPC: "2.0", ID: id}
+	if err != nil {
+		var failure *responseError
+		if !errors.As(err, &failure) {
+			failure = &responseError{Code: requestFailed, Message: err.Error()}
+		}
+		response.Error = failure
+	} else {
+		content, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		response.Result = content
+	}
+	return framing.WriteMessage(server.out, response)
+}
+
+func (server *Server) notify(method string, params any) error {
+	return framing.WriteMessage(server.out, notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// occurrenceAt returns the identifier at a position in the document, if it
+// parses.
+func (document *document) occurrenceAt(at protocolPosition) (occurrence, bool) {
+	return document.analysis.occurrenceAt(document.fromProtocol(at))
+}
+
+func (document *document) rename(params renameParams) (any, error) {
+	if !document.analysis.parsed {
+		return nil, &responseError{Code: requestFailed, Message: "cannot rename in a document that does not parse"}
+	}
+	occurrence, ok := document.occurrenceAt(params.Position)
+	if !ok {
+		return nil, &responseError{Code: requestFailed, Message: "no variable to rename here"}
+	}
+	symbol := occurrence.symbol
+	if symbol.kind == builtinSymbol {
+		return nil, &responseError{Code: requestFailed, Message: "cannot rename builtin " + symbol.name}
+	}
+	l := lexer.New(params.NewName)
+	if t := l.NextToken(); t.Type != token.IDENTIFIER || t.Literal != params.NewName {
+		return nil, &responseError{Code: invalidParams, Message: fmt.Sprintf("%q is not an identifier", params.NewName)}
+	}
+	if params.NewName == symbol.name {
+		return workspaceEdit{Changes: map[string][]textEdit{}}, nil
+	}
+	if t, ok := document.analysis.renameConflict(symbol, params.NewName); ok {
+		message := fmt.Sprintf("renaming %s to %s would change what %s at %d:%d refers to",
+			symbol.name, params.NewName, t.Literal, t.Line, t.Column)
+		return nil, &responseError{Code: requestFailed, Message: message}
+	}
+
+	var edits []textEdit
+	for _, location := range document.locations(params.TextDocument.URI, append(symbol.definitions[:len(symbol.definitions):len(symbol.definitions)], symbol.references...)) {
+		edits = append(edits, textEdit{Range: location.Range, NewText: params.NewName})
+	}
+	return workspaceEdit{Changes: map[string][]textEdit{params.TextDocument.URI: edits}}, nil
+}
+
+// locations returns where tokens are, in order.
+func (document *document) locations(uri string, tokens []*token.Token) []location {
+	sorted := make([]*token.Token, len(tokens))
+	copy(sorted, tokens)
+	sort.Slice(sorted, func(i, j int) bool {
+		return positionOf(sorted[i]).before(positionOf(sorted[j]))
+	})
+	locations := make([]location, len(sorted))
+	for i, t := range sorted {
+		locations[i] = location{URI: uri, Range: document.rangeOf(t)}
+	}
+	return locations
+}
+
+func (document *document) rangeOf(t *token.Token) protocolRange {
+	start := positionOf(t)
+	end := position{line: start.line, column: start.column + len(t.Literal)}
+	return protocolRange{Start: document.toProtocol(start), End: document.toProtocol(end)}
+}
+
+func (document *document) wholeRange() protocolRange {
+	last := len(document.lines) - 1
+	end := protocolPosition{Line: last, Character: utf16Length(document.lines[last])}
+	return protocolRange{End: end}
+}
+
+// toProtocol converts a position in bytes to one in UTF-16 code units.
+func (document *document) toProtocol(p position) protocolPosition {
+	if p.line < 1 || p.line > len(document.lines) {
+		return protocolPosition{Line: max(p.line-1, 0)}
+	}
+	line := document.lines[p.line-1]
+	column := min(max(p.column-1, 0), len(line))
+	return protocolPosition{Line: p.line - 1, Character: utf16Length(line[:column])}
+}
+
+func (document *document) fromProtocol(p protocolPosition) position {
+	if p.Line < 0 || p.Line >= len(document.lines) {
+		return position{line: p.Line + 1, column: 1}
+	}
+	line := document.lines[p.Line]
+	units := 0
+	for offset, character := range line {
+		if units >= p.Character {
+			return position{line: p.Line + 1, column: offset + 1}
+		}
+		units += utf16Units(character)
+	}
+	return position{line: p.Line + 1, column: len(line) + 1}
+}
+
+func utf16Length(text string) int {
+	units := 0
+	for _, character := range text {
+		units += utf16Units(character)
+	}
+	return units
+}
+
+// utf16Units is the number of UTF-16 code units character is encoded in.
+func utf16Units(character rune) int {
+	if character >= 0x10000 {
+		return 2
+	}
+	return 1
+}
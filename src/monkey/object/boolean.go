@@ -12,3 +12,23 @@ type Boolean struct{ Value bool }
 func (b *Boolean) Type() Type { return BOOLEAN }
 
 func (b *Boolean) Inspect() string { return fmt.Sprintf("%t", b.Value) }
+
+func NativeBoolToBooleanObject(boolean bool) Object {
+	if boolean {
+		return TRUE
+	}
+	return FALSE
+}
+
+// IsTruthy reports whether obj counts as true in a condition: anything but
+// false and null does.
+func IsTruthy(obj Object) bool {
+	switch obj := obj.(type) {
+	case *Boolean:
+		return obj.Value
+	case *Null:
+		return false
+	default:
+		return true
+	}
+}
@@ -1,6 +1,13 @@
 package object
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Output is where puts writes.
+var Output io.Writer = os.Stdout
 
 var Builtins = []struct {
 	Name    string
@@ -29,7 +36,7 @@ var Builtins = []struct {
 		"puts",
 		&Builtin{Function: func(args ...Object) Object {
 			for _, arg := range args {
-				fmt.Println(arg.Inspect())
+				_, _ = fmt.Fprintln(Output, arg.Inspect())
 			}
 			return nil
 		}},
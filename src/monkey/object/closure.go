@@ -4,7 +4,7 @@ import "fmt"
 
 type Closure struct {
 	Function      *CompiledFunction
-	FreeVariables []Object
+	FreeVariables []*Upvalue
 }
 
 func (closure *Closure) Type() Type {
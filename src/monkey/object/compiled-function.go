@@ -3,7 +3,17 @@ package object
 import "writing-in-interpreter-in-go/src/monkey/code"
 
 type CompiledFunction struct {
+	// Name is the name the function was bound to by a let statement, if any.
+	Name               string
 	Instructions       code.Instructions
 	ParameterArity     int
 	LocalVariableArity int
+	Captures           []Capture
+	// LocalNames and FreeNames name the locals and the free variables by
+	// index, for debuggers. A local no name refers to any more is unnamed.
+	LocalNames []string
+	FreeNames  []string
+	// SourceMap locates the instructions in the source code, when the
+	// compiler recorded their positions.
+	SourceMap code.SourceMap
 }
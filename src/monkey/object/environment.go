@@ -3,6 +3,9 @@ package object
 type Environment struct {
 	store     map[string]Object
 	enclosing *Environment
+	// tracer is what the evaluator traces the code evaluated in the
+	// environment with, shared by the environments enclosed in it.
+	tracer any
 }
 
 func NewEnvironment() *Environment {
@@ -12,6 +15,7 @@ func NewEnvironment() *Environment {
 func NewEnclosedEnvironment(enclosing *Environment) *Environment {
 	environment := NewEnvironment()
 	environment.enclosing = enclosing
+	environment.tracer = enclosing.tracer
 	return environment
 }
 
@@ -27,3 +31,35 @@ func (environment *Environment) Set(name string, value Object) Object {
 	environment.store[name] = value
 	return value
 }
+
+// Declare binds name without a value, unless the environment binds it
+// already. Get returns nil for it until it is set.
+func (environment *Environment) Declare(name string) {
+	if _, ok := environment.store[name]; !ok {
+		environment.store[name] = nil
+	}
+}
+
+// Names returns every name bound in the environment and those it is
+// enclosed in.
+func (environment *Environment) Names() []string {
+	var names []string
+	for current := environment; current != nil; current = current.enclosing {
+		for name := range current.store {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Tracer returns what SetTracer set on the environment or the one it is
+// enclosed in when it was created.
+func (environment *Environment) Tracer() any {
+	return environment.tracer
+}
+
+// SetTracer sets what the evaluator traces code evaluated in the
+// environment, and in environments enclosed in it from now on, with.
+func (environment *Environment) SetTracer(tracer any) {
+	environment.tracer = tracer
+}
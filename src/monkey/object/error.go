@@ -2,8 +2,22 @@ package object
 
 type Error struct {
 	Message string
+	// Line and Column locate the call that raised the error, when known.
+	Line   int
+	Column int
 }
 
 func (e *Error) Type() Type { return ERROR }
 
 func (e *Error) Inspect() string { return "ERROR: " + e.Message }
+
+// Stop ends a program that its host stopped, such as when a tracer limits
+// how long it runs. It unwinds the program like an Error, but is not one the
+// program raised, so tests cannot expect it.
+type Stop struct {
+	Message string
+}
+
+func (s *Stop) Type() Type { return ERROR }
+
+func (s *Stop) Inspect() string { return "STOPPED: " + s.Message }
@@ -10,6 +10,8 @@ type Function struct {
 	Parameters  []ast.Expression
 	Body        *ast.BlockStatement
 	Environment *Environment
+	// Name is the name the function literal was bound to by let, if any.
+	Name string
 }
 
 func (function *Function) Type() Type {
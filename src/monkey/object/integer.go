@@ -13,3 +13,27 @@ func (integer *Integer) Type() Type {
 func (integer *Integer) Inspect() string {
 	return fmt.Sprintf("%d", integer.Value)
 }
+
+// Integers from SmallIntegerMin to SmallIntegerMax are allocated once and
+// shared by every result NewInteger returns.
+const (
+	SmallIntegerMin = -128
+	SmallIntegerMax = 1024
+)
+
+var smallIntegers = func() []*Integer {
+	integers := make([]*Integer, SmallIntegerMax-SmallIntegerMin+1)
+	for i := range integers {
+		integers[i] = &Integer{Value: int64(i + SmallIntegerMin)}
+	}
+	return integers
+}()
+
+// NewInteger returns an Integer holding value, the shared one when value is
+// small.
+func NewInteger(value int64) *Integer {
+	if value >= SmallIntegerMin && value <= SmallIntegerMax {
+		return smallIntegers[value-SmallIntegerMin]
+	}
+	return &Integer{Value: value}
+}
@@ -0,0 +1,42 @@
+package object
+
+// Upvalue is a variable captured by a closure. While it is open it refers to
+// the variable's slot in the frame that defined it, so every closure
+// capturing the variable sees the same value; closing it moves the value
+// into the upvalue when that frame returns.
+type Upvalue struct {
+	Location *Object
+	closed   Object
+}
+
+func NewClosedUpvalue(value Object) *Upvalue {
+	upvalue := &Upvalue{closed: value}
+	upvalue.Location = &upvalue.closed
+	return upvalue
+}
+
+func (upvalue *Upvalue) Get() Object {
+	return *upvalue.Location
+}
+
+func (upvalue *Upvalue) Close() {
+	upvalue.closed = *upvalue.Location
+	upvalue.Location = &upvalue.closed
+}
+
+type CaptureKind int
+
+const (
+	// CaptureLocal captures a local of the frame creating the closure.
+	CaptureLocal CaptureKind = iota
+	// CaptureFree shares one of the creating closure's own upvalues.
+	CaptureFree
+	// CaptureClosure captures the creating closure itself.
+	CaptureClosure
+)
+
+// Capture tells OpClosure where to find one free variable of the function.
+type Capture struct {
+	Kind  CaptureKind
+	Index int
+}
@@ -20,6 +20,8 @@ type Parser struct {
 	prefixFns    map[token.Type]prefixParseFns
 	infixFns     map[token.Type]infixParseFns
 	Errors       []string
+	// ErrorTokens holds, for each error, the token it was found at.
+	ErrorTokens []*token.Token
 }
 
 func New(lexer *lexer.Lexer) *Parser {
@@ -51,6 +53,8 @@ func New(lexer *lexer.Lexer) *Parser {
 	parser.registerInfix(token.NOT_EQ, parser.parseInfixExpression)
 	parser.registerInfix(token.LT, parser.parseInfixExpression)
 	parser.registerInfix(token.GT, parser.parseInfixExpression)
+	parser.registerInfix(token.LT_EQ, parser.parseInfixExpression)
+	parser.registerInfix(token.GT_EQ, parser.parseInfixExpression)
 	parser.registerInfix(token.LPAREN, parser.parseCallExpression)
 	parser.registerInfix(token.LBRACKET, parser.parseIndexExpression)
 
@@ -91,6 +95,7 @@ func (parser *Parser) parseStatement() *ast.Statement {
 func (parser *Parser) parseExpression(precedence int) ast.Expression {
 	prefixFun := parser.prefixFns[parser.currentToken.Type]
 	if prefixFun == nil {
+		parser.noPrefixParseFnError(parser.currentToken.Type)
 		return nil
 	}
 
@@ -125,8 +130,8 @@ func (parser *Parser) parseLetStatement() *ast.LetStatement {
 	if functionLiteral, ok := statement.Value.(*ast.FunctionLiteral); ok {
 		functionLiteral.Name = statement.Identifier.Value
 	}
-	if !parser.expectPeek(token.SEMICOLON) {
-		return &statement
+	if parser.peekTokenIs(token.SEMICOLON) {
+		parser.nextToken()
 	}
 	return &statement
 }
@@ -161,6 +166,8 @@ func (parser *Parser) parseIntegerLiteral() ast.Expression {
 
 	value, err := strconv.ParseInt(parser.currentToken.Literal, 0, 64)
 	if err != nil {
+		msg := fmt.Sprintf("could not parse %q as integer", parser.currentToken.Literal)
+		parser.addError(parser.currentToken, msg)
 		return nil
 	}
 
@@ -265,7 +272,8 @@ func (parser *Parser) parseIfExpression() ast.Expression {
 
 	expression.Consequence = parser.parseBlockStatement()
 
-	if parser.expectPeek(token.ELSE) {
+	if parser.peekTokenIs(token.ELSE) {
+		parser.nextToken()
 		if !parser.expectPeek(token.LBRACE) {
 			return nil
 		}
@@ -301,7 +309,7 @@ func (parser *Parser) parseFunctionParameters() []ast.Expression {
 	}
 
 	parser.nextToken()
-	expressions = append(expressions, parser.parseExpression(ast.LOWEST))
+	expressions = append(expressions, parser.parseFunctionParameter())
 
 	if parser.peekTokenIs(token.RPAREN) {
 		parser.nextToken()
@@ -311,7 +319,7 @@ func (parser *Parser) parseFunctionParameters() []ast.Expression {
 	for parser.peekTokenIs(token.COMMA) {
 		parser.nextToken()
 		parser.nextToken()
-		expressions = append(expressions, parser.parseExpression(ast.LOWEST))
+		expressions = append(expressions, parser.parseFunctionParameter())
 	}
 
 	if !parser.expectPeek(token.RPAREN) {
@@ -321,10 +329,23 @@ func (parser *Parser) parseFunctionParameters() []ast.Expression {
 	return expressions
 }
 
+func (parser *Parser) parseFunctionParameter() ast.Expression {
+	if !parser.currentTokenIs(token.IDENTIFIER) {
+		msg := fmt.Sprintf("expected parameter to be IDENTIFIER, got %s instead", parser.currentToken.Type)
+		parser.addError(parser.currentToken, msg)
+		return nil
+	}
+	return parser.parseIdentifier()
+}
+
 func (parser *Parser) parseBlockStatement() *ast.BlockStatement {
 	expression := ast.BlockStatement{Token: *parser.currentToken}
 	parser.nextToken()
 	for !parser.currentTokenIs(token.RBRACE) {
+		if parser.currentTokenIs(token.EOF) {
+			parser.addError(parser.currentToken, "expected }, got EOF instead")
+			break
+		}
 		expression.Statements = append(expression.Statements, *parser.parseStatement())
 		parser.nextToken()
 	}
@@ -395,7 +416,17 @@ func (parser *Parser) expectPeek(tokenType token.Type) bool {
 func (parser *Parser) peekError(t token.Type) {
 	msg := fmt.Sprintf("expected next token to be %s, got %s instead",
 		t, parser.peekToken.Type)
+	parser.addError(parser.peekToken, msg)
+}
+
+func (parser *Parser) noPrefixParseFnError(t token.Type) {
+	msg := fmt.Sprintf("no prefix parse function for %s found", t)
+	parser.addError(parser.currentToken, msg)
+}
+
+func (parser *Parser) addError(at *token.Token, msg string) {
 	parser.Errors = append(parser.Errors, msg)
+	parser.ErrorTokens = append(parser.ErrorTokens, at)
 }
 
 func (parser *Parser) currentTokenIs(tokenType token.Type) bool {
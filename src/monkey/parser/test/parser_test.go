@@ -225,6 +225,8 @@ func TestInfixExpression(t *testing.T) {
 		{"5 / 5;", 5, "/", 5},
 		{"5 > 5;", 5, ">", 5},
 		{"5 < 5;", 5, "<", 5},
+		{"5 <= 5;", 5, "<=", 5},
+		{"5 >= 5;", 5, ">=", 5},
 		{"5 == 5;", 5, "==", 5},
 		{"5 != 5;", 5, "!=", 5},
 		{"true == true", true, "==", true},
@@ -554,6 +556,10 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"5 < 4 != 3 > 4",
 			"((5 < 4) != (3 > 4))",
 		},
+		{
+			"a + 1 <= b == c >= d * 2",
+			"(((a + 1) <= b) == (c >= (d * 2)))",
+		},
 		{
 			"3 + 4 * 5 == 3 * 1 + 4 * 5",
 			"((3 + (4 * 5)) == ((3 * 1) + (4 * 5)))",
@@ -727,3 +733,60 @@ func checkErrors(t *testing.T, parser *parser.Parser) {
 		t.Errorf("parser error: %q", message)
 	}
 }
+
+func TestParsingErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"if (x) { x }", nil},
+		{"let x = 1\nlet y = 2", nil},
+		{"let = 5;", []string{
+			"expected next token to be IDENTIFIER, got = instead",
+			"no prefix parse function for = found",
+		}},
+		{"@;", []string{"no prefix parse function for ILLEGAL found"}},
+		{"99999999999999999999", []string{`could not parse "99999999999999999999" as integer`}},
+		{"fn(x) { x", []string{"expected }, got EOF instead"}},
+		{"fn(0) { 0 }", []string{"expected parameter to be IDENTIFIER, got INT instead"}},
+		{`"abc`, []string{"no prefix parse function for ILLEGAL found"}},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		p.ParseProgram()
+		if len(p.Errors) != len(tt.expected) {
+			t.Fatalf("wrong number of errors for %q. want=%d, got=%q", tt.input, len(tt.expected), p.Errors)
+		}
+		for i, message := range tt.expected {
+			if p.Errors[i] != message {
+				t.Errorf("wrong error for %q. want=%q, got=%q", tt.input, message, p.Errors[i])
+			}
+		}
+	}
+}
+
+func TestParsingErrorPositions(t *testing.T) {
+	p := parser.New(lexer.New("let x = 1;\nlet = 5;\nfn(x) { x"))
+	p.ParseProgram()
+	expected := []string{"2:5", "2:5", "3:10"}
+	if len(p.ErrorTokens) != len(expected) {
+		t.Fatalf("wrong number of error tokens. want=%d, got=%d (%q)", len(expected), len(p.ErrorTokens), p.Errors)
+	}
+	for i, position := range expected {
+		at := p.ErrorTokens[i]
+		if got := fmt.Sprintf("%d:%d", at.Line, at.Column); got != position {
+			t.Errorf("wrong position for %q. want=%s, got=%s", p.Errors[i], position, got)
+		}
+	}
+}
+
+func FuzzParseProgram(f *testing.F) {
+	f.Add("let add = fn(x, y) { x + y; }; add(1, 2);")
+	f.Add("if (a <= b) { [1, 2][0] } else { return -c; }")
+	f.Add("macro(x) { quote(unquote(x) + 1) }")
+	f.Add("fn(x) {")
+	f.Fuzz(func(t *testing.T, input string) {
+		parser.New(lexer.New(input)).ParseProgram()
+	})
+}
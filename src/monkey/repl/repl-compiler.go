@@ -32,17 +32,28 @@ func StartRepl(in io.Reader, out io.Writer) {
 		l := lexer.New(input)
 		p := parser.New(l)
 		program := p.ParseProgram()
+		// A line that fails to compile may have defined names already;
+		// they are forgotten with the rest of it.
+		saved := symbolTable.Copy()
 		c := compiler.NewWithState(symbolTable, constants)
 		err := c.Compile(program)
 		if err != nil {
-			return
+			symbolTable = saved
+			_, _ = fmt.Fprintf(out, "Compilation failed:\n%s\n", err)
+			continue
 		}
-		virtualMachine := vm.NewWithGlobalsStore(c.ByteCode(), globals)
+		byteCode := c.ByteCode()
+		constants = byteCode.Constants
+		virtualMachine := vm.NewWithGlobalsStore(byteCode, globals)
 		err = virtualMachine.Run()
 		if err != nil {
 			_, _ = fmt.Fprintf(out, "Executing bytecode failed: \n %s\n", err)
+			continue
 		}
 		lastPopped := virtualMachine.LastPopped()
+		if lastPopped == nil {
+			continue
+		}
 		_, _ = io.WriteString(out, lastPopped.Inspect())
 		_, _ = io.WriteString(out, "\n")
 	}
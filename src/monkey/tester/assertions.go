@@ -0,0 +1,120 @@
+package tester
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"writing-in-interpreter-in-go/src/monkey/object"
+)
+
+// Assertions returns the builtins tests call to check their results. A
+// failed assertion is an error, which stops the test. apply calls a function
+// without arguments and returns the *object.Error or *object.Stop it stops
+// with, if any.
+func Assertions(apply func(function object.Object) object.Object) map[string]*object.Builtin {
+	return map[string]*object.Builtin{
+		// assert(condition) or assert(condition, message) fails unless condition
+		// is truthy.
+		"assert": {Function: func(args ...object.Object) object.Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("assert: wrong number of arguments. got=%d, want=1 or 2", len(args))
+			}
+			if object.IsTruthy(args[0]) {
+				return nil
+			}
+			if len(args) == 2 {
+				return newError("assertion failed: %s", message(args[1]))
+			}
+			return newError("assertion failed")
+		}},
+		// assert_eq(actual, expected) fails unless the values are equal.
+		// Arrays are equal when their elements are.
+		"assert_eq": {Function: func(args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return newError("assert_eq: wrong number of arguments. got=%d, want=2", len(args))
+			}
+			if equal(args[0], args[1]) {
+				return nil
+			}
+			return newError("assert_eq: got %s, want %s", describe(args[0]), describe(args[1]))
+		}},
+		// assert_error(function) or assert_error(function, message) calls
+		// function without arguments and fails unless it stops with an error,
+		// with the given message if there is one.
+		"assert_error": {Function: func(args ...object.Object) object.Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("assert_error: wrong number of arguments. got=%d, want=1 or 2", len(args))
+			}
+			if args[0].Type() != object.FUNCTION && args[0].Type() != object.CLOSURE {
+				return newError("assert_error: argument must be FUNCTION, got %s", args[0].Type())
+			}
+			stopped := apply(args[0])
+			switch err := stopped.(type) {
+			case nil:
+				return newError("assert_error: no error")
+			case *object.Error:
+				if len(args) == 2 && err.Message != message(args[1]) {
+					return newError("assert_error: got error %q, want %q", err.Message, message(args[1]))
+				}
+				return nil
+			default:
+				// The host stopped the program, which is not an error the
+				// test can expect.
+				return stopped
+			}
+		}},
+	}
+}
+
+func newError(format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...)}
+}
+
+// message renders a message argument, leaving strings unquoted.
+func message(value object.Object) string {
+	if str, ok := value.(*object.String); ok {
+		return str.Value
+	}
+	return describe(value)
+}
+
+func equal(left, right object.Object) bool {
+	switch left := left.(type) {
+	case *object.Integer:
+		right, ok := right.(*object.Integer)
+		return ok && left.Value == right.Value
+	case *object.String:
+		right, ok := right.(*object.String)
+		return ok && left.Value == right.Value
+	case *object.Array:
+		right, ok := right.(*object.Array)
+		if !ok || len(left.Elements) != len(right.Elements) {
+			return false
+		}
+		for i := range left.Elements {
+			if !equal(left.Elements[i], right.Elements[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return left == right
+	}
+}
+
+// describe renders a value in failure messages, quoting strings so that
+// they are told apart from other values.
+func describe(value object.Object) string {
+	switch value := value.(type) {
+	case *object.String:
+		return strconv.Quote(value.Value)
+	case *object.Array:
+		elements := make([]string, len(value.Elements))
+		for i, element := range value.Elements {
+			elements[i] = describe(element)
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	default:
+		return value.Inspect()
+	}
+}
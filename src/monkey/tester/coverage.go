@@ -0,0 +1,84 @@
+package tester
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"writing-in-interpreter-in-go/src/monkey/vm"
+)
+
+// CoverageSummary describes coverage the way go test -cover does, with the
+// share of conditional jumps that went both ways when there are any.
+func CoverageSummary(coverage *vm.Coverage) string {
+	lines := coverage.Lines()
+	if len(lines) == 0 {
+		return "coverage: [no statements]"
+	}
+	covered := 0
+	for _, count := range lines {
+		if count > 0 {
+			covered++
+		}
+	}
+	summary := fmt.Sprintf("coverage: %.1f%% of lines", percent(covered, len(lines)))
+
+	branches := coverage.Branches()
+	if len(branches) > 0 {
+		covered = 0
+		for _, branch := range branches {
+			if branch.Taken > 0 {
+				covered++
+			}
+			if branch.NotTaken > 0 {
+				covered++
+			}
+		}
+		summary += fmt.Sprintf(", %.1f%% of branches", percent(covered, 2*len(branches)))
+	}
+	return summary
+}
+
+func percent(part, total int) float64 {
+	return 100 * float64(part) / float64(total)
+}
+
+// WriteLCOV writes coverage as the LCOV record of the file at path. Each
+// conditional jump is a block of two branches: 0 when its condition holds,
+// 1 when the jump is taken.
+func WriteLCOV(w io.Writer, path string, coverage *vm.Coverage) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "TN:\nSF:%s\n", path)
+
+	branches := coverage.Branches()
+	branchesHit := 0
+	for block, branch := range branches {
+		for index, count := range []int{branch.NotTaken, branch.Taken} {
+			taken := "-"
+			if branch.Taken+branch.NotTaken > 0 {
+				taken = fmt.Sprint(count)
+			}
+			if count > 0 {
+				branchesHit++
+			}
+			fmt.Fprintf(out, "BRDA:%d,%d,%d,%s\n", branch.Position.Line, block, index, taken)
+		}
+	}
+	fmt.Fprintf(out, "BRF:%d\nBRH:%d\n", 2*len(branches), branchesHit)
+
+	lines := coverage.Lines()
+	numbers := make([]int, 0, len(lines))
+	for line := range lines {
+		numbers = append(numbers, line)
+	}
+	sort.Ints(numbers)
+	linesHit := 0
+	for _, line := range numbers {
+		if lines[line] > 0 {
+			linesHit++
+		}
+		fmt.Fprintf(out, "DA:%d,%d\n", line, lines[line])
+	}
+	fmt.Fprintf(out, "LF:%d\nLH:%d\nend_of_record\n", len(lines), linesHit)
+	return out.Flush()
+}
@@ -0,0 +1,55 @@
+package tester
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCoverage(t *testing.T) {
+	machine, err := NewMachine(parse(t, `let sign = fn(x) {
+  if (x < 0) {
+    -1
+  } else {
+    1
+  }
+};
+let test_positive = fn() {
+  assert_eq(sign(1), 1);
+};`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	if failure := machine.Run("test_positive").Failure; failure != nil {
+		t.Fatalf("test failed: %s", failure.Message)
+	}
+
+	expected := "coverage: 83.3% of lines, 50.0% of branches"
+	if summary := CoverageSummary(machine.Coverage()); summary != expected {
+		t.Errorf("wrong summary. want=%q, got=%q", expected, summary)
+	}
+
+	var profile strings.Builder
+	err = WriteLCOV(&profile, "sign_test.mk", machine.Coverage())
+	if err != nil {
+		t.Fatalf("writing profile failed: %s", err)
+	}
+	expected = `TN:
+SF:sign_test.mk
+BRDA:2,0,0,0
+BRDA:2,0,1,1
+BRF:2
+BRH:1
+DA:1,1
+DA:2,1
+DA:3,0
+DA:5,1
+DA:8,1
+DA:9,1
+LF:6
+LH:5
+end_of_record
+`
+	if profile.String() != expected {
+		t.Errorf("wrong profile.\nwant=%s\ngot =%s", expected, profile.String())
+	}
+}
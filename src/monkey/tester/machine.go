@@ -0,0 +1,94 @@
+package tester
+
+import (
+	"sort"
+	"writing-in-interpreter-in-go/src/monkey/ast"
+	"writing-in-interpreter-in-go/src/monkey/compiler"
+	"writing-in-interpreter-in-go/src/monkey/object"
+	"writing-in-interpreter-in-go/src/monkey/vm"
+)
+
+// Machine runs the tests of a program on the virtual machine, counting what
+// they run. The program is compiled once, and every test runs on a virtual
+// machine of its own.
+type Machine struct {
+	byteCode    *compiler.ByteCode
+	symbolTable *compiler.SymbolTable
+	coverage    *vm.Coverage
+}
+
+// NewMachine compiles program, with the assertions defined as globals.
+func NewMachine(program *ast.Program) (*Machine, error) {
+	symbolTable := compiler.NewSymbolTable()
+	for index, builtin := range object.Builtins {
+		symbolTable.DefineBuiltin(index, builtin.Name)
+	}
+	var names []string
+	for name := range Assertions(nil) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		symbolTable.Define(name)
+	}
+
+	c := compiler.NewWithState(symbolTable, []object.Object{})
+	err := c.Compile(program)
+	if err != nil {
+		return nil, err
+	}
+	byteCode := c.ByteCode()
+	return &Machine{byteCode: byteCode, symbolTable: symbolTable, coverage: vm.NewCoverage(byteCode)}, nil
+}
+
+// Coverage returns what the tests run so far have executed.
+func (machine *Machine) Coverage() *vm.Coverage {
+	return machine.coverage
+}
+
+// Run runs test name like the package-level Run, on a new virtual machine.
+func (machine *Machine) Run(name string) Result {
+	return measure(name, func() *object.Error { return machine.run(name) })
+}
+
+func (machine *Machine) run(name string) *object.Error {
+	globals := make([]object.Object, vm.GlobalsSize)
+	var virtualMachine *vm.VirtualMachine
+	assertions := Assertions(func(function object.Object) object.Object {
+		_, err := virtualMachine.Call(function)
+		if err != nil {
+			return failure(err)
+		}
+		return nil
+	})
+	for name, assertion := range assertions {
+		symbol, _ := machine.symbolTable.Resolve(name)
+		globals[symbol.Index] = assertion
+	}
+
+	virtualMachine = vm.NewWithGlobalsStore(machine.byteCode, globals)
+	virtualMachine.SetCoverage(machine.coverage)
+	err := virtualMachine.Run()
+	if err != nil {
+		return failure(err)
+	}
+	symbol, ok := machine.symbolTable.Resolve(name)
+	if !ok || symbol.Scope != compiler.GlobalScope || globals[symbol.Index] == nil {
+		return &object.Error{Message: "test " + name + " was not defined"}
+	}
+	_, err = virtualMachine.Call(globals[symbol.Index])
+	return failure(err)
+}
+
+// failure converts an error of the virtual machine to the error a test
+// fails with.
+func failure(err error) *object.Error {
+	if err == nil {
+		return nil
+	}
+	failure := &object.Error{Message: err.Error()}
+	if runtimeError, ok := err.(*vm.RuntimeError); ok {
+		failure.Line, failure.Column = runtimeError.Position.Line, runtimeError.Position.Column
+	}
+	return failure
+}
@@ -0,0 +1,96 @@
+// Package tester runs the tests of Monkey programs: the top-level functions
+// named test_* in files named *_test.mk.
+package tester
+
+import (
+	"bytes"
+	"strings"
+	"time"
+	"writing-in-interpreter-in-go/src/monkey/ast"
+	"writing-in-interpreter-in-go/src/monkey/evaluator"
+	"writing-in-interpreter-in-go/src/monkey/object"
+)
+
+// Result is the outcome of a test. Failure is nil when the test passed.
+type Result struct {
+	Name    string
+	Failure *object.Error
+	Output  string
+	Elapsed time.Duration
+}
+
+// IsTestFile reports whether path names a file of tests.
+func IsTestFile(path string) bool {
+	return strings.HasSuffix(path, "_test.mk")
+}
+
+// Discover returns the names of the tests program defines, in source order:
+// the functions bound by its top-level let statements whose names start with
+// test_.
+func Discover(program *ast.Program) []string {
+	var names []string
+	for _, statement := range program.Statements {
+		let, ok := statement.(*ast.LetStatement)
+		if !ok || !strings.HasPrefix(let.Identifier.Value, "test_") {
+			continue
+		}
+		if _, ok := let.Value.(*ast.FunctionLiteral); ok {
+			names = append(names, let.Identifier.Value)
+		}
+	}
+	return names
+}
+
+// Run runs test name of program in isolation: its top-level statements are
+// evaluated afresh, with the assertions defined, before the test is called.
+// What the test prints with puts is captured in the result. Run redirects
+// object.Output and must not be called concurrently.
+func Run(program *ast.Program, name string) Result {
+	return measure(name, func() *object.Error { return run(program, name) })
+}
+
+// measure runs test name with test, capturing its output and timing it.
+func measure(name string, test func() *object.Error) Result {
+	var output bytes.Buffer
+	previous := object.Output
+	object.Output = &output
+	defer func() { object.Output = previous }()
+
+	start := time.Now()
+	result := Result{Name: name, Failure: test()}
+	result.Elapsed = time.Since(start)
+	result.Output = output.String()
+	return result
+}
+
+func run(program *ast.Program, name string) *object.Error {
+	environment := object.NewEnvironment()
+	assertions := Assertions(func(function object.Object) object.Object {
+		if result := evaluator.Apply(function, nil); result.Type() == object.ERROR {
+			return result
+		}
+		return nil
+	})
+	for name, assertion := range assertions {
+		environment.Set(name, assertion)
+	}
+	if err := failed(evaluator.Eval(program, environment)); err != nil {
+		return err
+	}
+	test, ok := environment.Get(name)
+	if !ok || test == nil {
+		return &object.Error{Message: "test " + name + " was not defined"}
+	}
+	return failed(evaluator.Apply(test, nil))
+}
+
+// failed returns the error result stops a test with, if any.
+func failed(result object.Object) *object.Error {
+	switch result := result.(type) {
+	case *object.Error:
+		return result
+	case *object.Stop:
+		return &object.Error{Message: "stopped: " + result.Message}
+	}
+	return nil
+}
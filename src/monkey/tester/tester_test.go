@@ -0,0 +1,177 @@
+package tester
+
+import (
+	"reflect"
+	"testing"
+	"writing-in-interpreter-in-go/src/monkey/ast"
+	"writing-in-interpreter-in-go/src/monkey/lexer"
+	"writing-in-interpreter-in-go/src/monkey/object"
+	"writing-in-interpreter-in-go/src/monkey/parser"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors)
+	}
+	return program
+}
+
+func TestDiscover(t *testing.T) {
+	program := parse(t, `
+let test_first = fn() { };
+let helper = fn() { };
+let test_value = 1;
+fn() { let test_nested = fn() { }; };
+let test_second = fn() { };
+`)
+	expected := []string{"test_first", "test_second"}
+	if names := Discover(program); !reflect.DeepEqual(names, expected) {
+		t.Errorf("wrong tests. want=%v, got=%v", expected, names)
+	}
+}
+
+func TestIsTestFile(t *testing.T) {
+	tests := map[string]bool{
+		"math_test.mk":     true,
+		"lib/math_test.mk": true,
+		"math.mk":          false,
+		"math_test.go":     false,
+	}
+	for path, expected := range tests {
+		if IsTestFile(path) != expected {
+			t.Errorf("IsTestFile(%q) != %t", path, expected)
+		}
+	}
+}
+
+const runInput = `puts("setup");
+let double = fn(x) { x * 2 };
+let test_pass = fn() { assert_eq(double(2), 4); puts("ran"); };
+let test_fail = fn() {
+  assert_eq(double(2), 5);
+};
+let test_error = fn() { double("a") };
+let test_arguments = fn(x) { x };
+return 0;
+let test_late = fn() { };
+`
+
+func TestRun(t *testing.T) {
+	program := parse(t, runInput)
+	tests := []resultTest{
+		{"test_pass", "", 0, 0, "setup\nran\n"},
+		{"test_fail", "assert_eq: got 4, want 5", 5, 3, "setup\n"},
+		{"test_error", "type mismatch: STRING * INTEGER", 0, 0, "setup\n"},
+		{"test_arguments", "wrong number of arguments: want=1, got=0", 0, 0, "setup\n"},
+		{"test_late", "test test_late was not defined", 0, 0, "setup\n"},
+	}
+	testResults(t, tests, func(name string) Result { return Run(program, name) })
+}
+
+func TestMachine(t *testing.T) {
+	machine, err := NewMachine(parse(t, runInput))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	tests := []resultTest{
+		{"test_pass", "", 0, 0, "setup\nran\n"},
+		{"test_fail", "assert_eq: got 4, want 5", 5, 3, "setup\n"},
+		{"test_error", "type mismatch: STRING * INTEGER", 2, 24, "setup\n"},
+		{"test_arguments", "wrong number of arguments: want=1, got=0", 0, 0, "setup\n"},
+		{"test_late", "test test_late was not defined", 0, 0, "setup\n"},
+	}
+	testResults(t, tests, machine.Run)
+}
+
+// resultTest is the expected result of a test: a pass when message is
+// empty.
+type resultTest struct {
+	name    string
+	message string
+	line    int
+	column  int
+	output  string
+}
+
+func testResults(t *testing.T, tests []resultTest, run func(name string) Result) {
+	t.Helper()
+	for _, tt := range tests {
+		result := run(tt.name)
+		if result.Name != tt.name {
+			t.Errorf("wrong name. want=%q, got=%q", tt.name, result.Name)
+		}
+		if result.Output != tt.output {
+			t.Errorf("%s: wrong output. want=%q, got=%q", tt.name, tt.output, result.Output)
+		}
+		if tt.message == "" {
+			if result.Failure != nil {
+				t.Errorf("%s: unexpected failure %q", tt.name, result.Failure.Message)
+			}
+			continue
+		}
+		if result.Failure == nil {
+			t.Errorf("%s: passed, want failure %q", tt.name, tt.message)
+			continue
+		}
+		failure := result.Failure
+		if failure.Message != tt.message || failure.Line != tt.line || failure.Column != tt.column {
+			t.Errorf("%s: wrong failure. want=%d:%d: %s, got=%d:%d: %s", tt.name,
+				tt.line, tt.column, tt.message, failure.Line, failure.Column, failure.Message)
+		}
+	}
+}
+
+func TestAssertions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`assert(true)`, ""},
+		{`assert(1)`, ""},
+		{`assert(false)`, "assertion failed"},
+		{`assert(if (false) { 1 }, "no value")`, "assertion failed: no value"},
+		{`assert()`, "assert: wrong number of arguments. got=0, want=1 or 2"},
+		{`assert_eq(1 + 1, 2)`, ""},
+		{`assert_eq([1, ["a"]], [1, ["a"]])`, ""},
+		{`assert_eq(true, true)`, ""},
+		{`assert_eq(len, len)`, ""},
+		{`assert_eq("1", 1)`, `assert_eq: got "1", want 1`},
+		{`assert_eq([1, 2], [1])`, "assert_eq: got [1, 2], want [1]"},
+		{`assert_error(fn() { 1 / 0 })`, ""},
+		{`assert_error(fn() { 1 / 0 }, "division by zero")`, ""},
+		{`assert_error(fn() { 1 })`, "assert_error: no error"},
+		{`assert_error(fn() { first(1) }, "oops")`,
+			`assert_error: got error "argument to ` + "`first`" + ` must be ARRAY, got INTEGER", want "oops"`},
+		{`assert_error(1)`, "assert_error: argument must be FUNCTION, got INTEGER"},
+	}
+	for _, tt := range tests {
+		program := parse(t, "let test_it = fn() { "+tt.input+" };")
+		machine, err := NewMachine(program)
+		if err != nil {
+			t.Fatalf("%s: compiler error: %s", tt.input, err)
+		}
+		for engine, result := range map[string]Result{"eval": Run(program, "test_it"), "vm": machine.Run("test_it")} {
+			failure := result.Failure
+			switch {
+			case tt.expected == "" && failure != nil:
+				t.Errorf("%s on %s: unexpected failure %q", tt.input, engine, failure.Message)
+			case tt.expected != "" && failure == nil:
+				t.Errorf("%s on %s: passed, want failure %q", tt.input, engine, tt.expected)
+			case tt.expected != "" && failure.Message != tt.expected:
+				t.Errorf("%s on %s: wrong failure. want=%q, got=%q", tt.input, engine, tt.expected, failure.Message)
+			}
+		}
+	}
+}
+
+func TestAssertErrorPassesStopsOn(t *testing.T) {
+	stop := &object.Stop{Message: "node limit reached"}
+	assertErr := Assertions(func(function object.Object) object.Object { return stop })["assert_error"]
+	result := assertErr.Function(&object.Function{})
+	if result != stop {
+		t.Errorf("wrong result. want the stop, got=%v", result)
+	}
+}
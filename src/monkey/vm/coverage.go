@@ -0,0 +1,130 @@
+package vm
+
+import (
+	"sort"
+	"writing-in-interpreter-in-go/src/monkey/code"
+	"writing-in-interpreter-in-go/src/monkey/compiler"
+	"writing-in-interpreter-in-go/src/monkey/object"
+)
+
+// Coverage counts how many times the instructions of a program run, and
+// which way its conditional jumps go, for every virtual machine running the
+// program with SetCoverage. Instructions are located in the source code
+// through the source maps of the bytecode.
+type Coverage struct {
+	main      *functionCoverage
+	functions map[*object.CompiledFunction]*functionCoverage
+}
+
+type functionCoverage struct {
+	function *object.CompiledFunction
+	// counts, taken and notTaken are indexed by instruction offset.
+	counts   []int
+	taken    []int
+	notTaken []int
+}
+
+// Branch is a conditional jump and how many times it was taken or not.
+type Branch struct {
+	Position code.Position
+	Taken    int
+	NotTaken int
+}
+
+func NewCoverage(byteCode *compiler.ByteCode) *Coverage {
+	main := &object.CompiledFunction{Instructions: byteCode.Instructions, SourceMap: byteCode.SourceMap}
+	coverage := &Coverage{
+		main:      newFunctionCoverage(main),
+		functions: make(map[*object.CompiledFunction]*functionCoverage),
+	}
+	for _, constant := range byteCode.Constants {
+		if function, ok := constant.(*object.CompiledFunction); ok {
+			coverage.functions[function] = newFunctionCoverage(function)
+		}
+	}
+	return coverage
+}
+
+func newFunctionCoverage(function *object.CompiledFunction) *functionCoverage {
+	return &functionCoverage{
+		function: function,
+		counts:   make([]int, len(function.Instructions)),
+		taken:    make([]int, len(function.Instructions)),
+		notTaken: make([]int, len(function.Instructions)),
+	}
+}
+
+// SetCoverage makes the virtual machine count what it runs in coverage,
+// which must have been made from the bytecode the machine runs.
+func (virtualMachine *VirtualMachine) SetCoverage(coverage *Coverage) {
+	coverage.functions[virtualMachine.frames[0].closure.Function] = coverage.main
+	virtualMachine.coverage = coverage
+}
+
+func (coverage *Coverage) hit(function *object.CompiledFunction, offset int) {
+	if counted, ok := coverage.functions[function]; ok {
+		counted.counts[offset]++
+	}
+}
+
+func (coverage *Coverage) branch(function *object.CompiledFunction, offset int, jumped bool) {
+	counted, ok := coverage.functions[function]
+	switch {
+	case !ok:
+	case jumped:
+		counted.taken[offset]++
+	default:
+		counted.notTaken[offset]++
+	}
+}
+
+func (coverage *Coverage) all() []*functionCoverage {
+	all := []*functionCoverage{coverage.main}
+	for function, counted := range coverage.functions {
+		if counted.function == function {
+			all = append(all, counted)
+		}
+	}
+	return all
+}
+
+// Lines returns how many times each line with instructions ran: the most
+// any of its instructions did.
+func (coverage *Coverage) Lines() map[int]int {
+	lines := make(map[int]int)
+	for _, counted := range coverage.all() {
+		for _, mapping := range counted.function.SourceMap {
+			line := mapping.Position.Line
+			if line == 0 {
+				continue
+			}
+			if count, ok := lines[line]; !ok || counted.counts[mapping.Offset] > count {
+				lines[line] = counted.counts[mapping.Offset]
+			}
+		}
+	}
+	return lines
+}
+
+// Branches returns the conditional jumps of the program in source order.
+// The jump of an if expression is taken when its condition is false.
+func (coverage *Coverage) Branches() []Branch {
+	var branches []Branch
+	for _, counted := range coverage.all() {
+		for _, mapping := range counted.function.SourceMap {
+			switch code.Opcode(counted.function.Instructions[mapping.Offset]) {
+			case code.OpJumpIfFalse, code.OpJumpIfNotEqual, code.OpJumpIfNotGreater, code.OpJumpIfNotLess:
+				branches = append(branches, Branch{
+					Position: mapping.Position,
+					Taken:    counted.taken[mapping.Offset],
+					NotTaken: counted.notTaken[mapping.Offset],
+				})
+			}
+		}
+	}
+	sort.Slice(branches, func(i, j int) bool {
+		left, right := branches[i].Position, branches[j].Position
+		return left.Line < right.Line || left.Line == right.Line && left.Column < right.Column
+	})
+	return branches
+}
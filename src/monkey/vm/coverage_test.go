@@ -0,0 +1,55 @@
+package vm
+
+import (
+	"reflect"
+	"testing"
+	"writing-in-interpreter-in-go/src/monkey/code"
+	"writing-in-interpreter-in-go/src/monkey/compiler"
+)
+
+func TestCoverage(t *testing.T) {
+	input := `let sign = fn(x) {
+  if (x < 0) {
+    -1
+  } else {
+    1
+  }
+};
+let unused = fn() {
+  0
+};
+sign(1);
+sign(2);`
+
+	for _, level := range []int{0, 2} {
+		comp := compiler.New()
+		comp.SetOptimizationLevel(level)
+		err := comp.Compile(parse(input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		byteCode := comp.ByteCode()
+		coverage := NewCoverage(byteCode)
+		for i := 0; i < 2; i++ {
+			vm := New(byteCode)
+			vm.SetCoverage(coverage)
+			err = vm.Run()
+			if err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
+		}
+
+		expectedLines := map[int]int{1: 2, 2: 4, 3: 0, 5: 4, 8: 2, 9: 0, 11: 2, 12: 2}
+		if lines := coverage.Lines(); !reflect.DeepEqual(lines, expectedLines) {
+			t.Errorf("level %d: wrong lines.\nwant=%v\ngot =%v", level, expectedLines, lines)
+		}
+		expectedBranches := []Branch{{Position: code.Position{Line: 2, Column: 3}, Taken: 4, NotTaken: 0}}
+		if level == 2 {
+			// The comparison is fused into the jump, which takes its position.
+			expectedBranches[0].Position = code.Position{Line: 2, Column: 9}
+		}
+		if branches := coverage.Branches(); !reflect.DeepEqual(branches, expectedBranches) {
+			t.Errorf("level %d: wrong branches.\nwant=%v\ngot =%v", level, expectedBranches, branches)
+		}
+	}
+}
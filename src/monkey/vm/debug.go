@@ -0,0 +1,296 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"writing-in-interpreter-in-go/src/monkey/ast"
+	"writing-in-interpreter-in-go/src/monkey/code"
+	"writing-in-interpreter-in-go/src/monkey/compiler"
+	"writing-in-interpreter-in-go/src/monkey/lexer"
+	"writing-in-interpreter-in-go/src/monkey/object"
+	"writing-in-interpreter-in-go/src/monkey/parser"
+)
+
+// StepMode says how a program paused by a debugger resumes.
+type StepMode int
+
+const (
+	// Continue runs until a breakpoint.
+	Continue StepMode = iota
+	// StepIn stops on the next line, entering calls.
+	StepIn
+	// StepOver stops on the next line of the paused function or of a caller.
+	StepOver
+	// StepOut stops on the next line of a caller.
+	StepOut
+	// Terminate ends the program: Run returns ErrTerminated.
+	Terminate
+)
+
+var ErrTerminated = errors.New("terminated by the debugger")
+
+// Debugger pauses a virtual machine at breakpoints and after steps so that
+// the paused program can be inspected. The machine only pauses when it
+// reaches a line: on the first instruction of a line that the current call
+// was not already on. Each pause is reported to a function that returns how
+// to resume.
+type Debugger struct {
+	pause       func(stop *Stop) StepMode
+	globalNames []string
+	// mutex guards breakpoints, which can be set while the machine runs.
+	mutex       sync.Mutex
+	breakpoints map[int]bool
+	interrupted atomic.Bool
+	mode        StepMode
+	// depth is the number of frames when the machine last paused.
+	depth int
+	// visits holds, for every frame, the line it is on and the last
+	// instruction it ran.
+	visits []visit
+}
+
+type visit struct {
+	line int
+	ip   int
+}
+
+// NewDebugger makes a debugger for virtual machines running byteCode. The
+// machine calls pause every time it pauses, and waits for it to return.
+func NewDebugger(byteCode *compiler.ByteCode, pause func(stop *Stop) StepMode) *Debugger {
+	return &Debugger{pause: pause, globalNames: byteCode.GlobalNames, breakpoints: make(map[int]bool)}
+}
+
+// SetDebugger makes Run pause where debugger says.
+func (virtualMachine *VirtualMachine) SetDebugger(debugger *Debugger) {
+	virtualMachine.debugger = debugger
+}
+
+// SetBreakpoint, ClearBreakpoint, Breakpoints and Interrupt can be called
+// while the machine runs, from another goroutine.
+func (debugger *Debugger) SetBreakpoint(line int) {
+	debugger.mutex.Lock()
+	defer debugger.mutex.Unlock()
+	debugger.breakpoints[line] = true
+}
+
+func (debugger *Debugger) ClearBreakpoint(line int) {
+	debugger.mutex.Lock()
+	defer debugger.mutex.Unlock()
+	delete(debugger.breakpoints, line)
+}
+
+// Breakpoints returns the lines with a breakpoint, in order.
+func (debugger *Debugger) Breakpoints() []int {
+	debugger.mutex.Lock()
+	defer debugger.mutex.Unlock()
+	lines := make([]int, 0, len(debugger.breakpoints))
+	for line := range debugger.breakpoints {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+// Interrupt makes the machine pause on the next line it reaches.
+func (debugger *Debugger) Interrupt() {
+	debugger.interrupted.Store(true)
+}
+
+// reached is called before every instruction while a debugger is set, once
+// the current frame's ip is up to date.
+func (virtualMachine *VirtualMachine) reached() error {
+	debugger := virtualMachine.debugger
+	depth := virtualMachine.framesIndex
+	if len(debugger.visits) > depth {
+		debugger.visits = debugger.visits[:depth]
+	}
+	for len(debugger.visits) < depth {
+		debugger.visits = append(debugger.visits, visit{ip: -1})
+	}
+
+	frame := virtualMachine.frames[depth-1]
+	last := &debugger.visits[depth-1]
+	// A tail call starts the frame over.
+	restarted := frame.ip < last.ip
+	last.ip = frame.ip
+	line := frame.closure.Function.SourceMap.PositionAt(frame.ip).Line
+	if line == 0 || (line == last.line && !restarted) {
+		return nil
+	}
+	last.line = line
+
+	var reason string
+	switch {
+	case debugger.interrupted.Swap(false):
+		reason = "pause"
+	case debugger.hasBreakpoint(line):
+		reason = "breakpoint"
+	case debugger.mode == StepIn,
+		debugger.mode == StepOver && depth <= debugger.depth,
+		debugger.mode == StepOut && depth < debugger.depth:
+		reason = "step"
+	default:
+		return nil
+	}
+
+	debugger.depth = depth
+	// Evaluating expressions runs the machine, which must not pause then.
+	virtualMachine.debugger = nil
+	debugger.mode = debugger.pause(&Stop{Reason: reason, virtualMachine: virtualMachine, debugger: debugger})
+	virtualMachine.debugger = debugger
+	if debugger.mode == Terminate {
+		return ErrTerminated
+	}
+	return nil
+}
+
+func (debugger *Debugger) hasBreakpoint(line int) bool {
+	debugger.mutex.Lock()
+	defer debugger.mutex.Unlock()
+	return debugger.breakpoints[line]
+}
+
+// Stop is a pause of a virtual machine. Its methods may only be called until
+// the pause function returns. Frames are numbered from the innermost, 0.
+type Stop struct {
+	// Reason is why the machine paused: "breakpoint", "step" or "pause".
+	Reason string
+
+	virtualMachine *VirtualMachine
+	debugger       *Debugger
+}
+
+// StackFrame is a call in progress: the function called and where it is.
+type StackFrame struct {
+	Function string
+	Position code.Position
+}
+
+// Variable is a name and the value it is bound to.
+type Variable struct {
+	Name  string
+	Value object.Object
+}
+
+// Frames returns the calls in progress, innermost first.
+func (stop *Stop) Frames() []StackFrame {
+	frames := make([]StackFrame, stop.virtualMachine.framesIndex)
+	for i := range frames {
+		frame := stop.frame(i)
+		function := frame.closure.Function
+		name := "main"
+		if i < len(frames)-1 {
+			name = closureName(function)
+		}
+		frames[i] = StackFrame{Function: name, Position: function.SourceMap.PositionAt(frame.ip)}
+	}
+	return frames
+}
+
+func (stop *Stop) frame(index int) *Frame {
+	return stop.virtualMachine.frames[stop.virtualMachine.framesIndex-1-index]
+}
+
+// Locals returns the locals of a frame that are set, parameters first.
+func (stop *Stop) Locals(frame int) []Variable {
+	current := stop.frame(frame)
+	function := current.closure.Function
+	var locals []Variable
+	for index, name := range function.LocalNames {
+		if index >= function.LocalVariableArity {
+			break
+		}
+		value := stop.virtualMachine.stack[current.basePointer+index]
+		if name != "" && value != nil {
+			locals = append(locals, Variable{Name: name, Value: value})
+		}
+	}
+	return locals
+}
+
+// FreeVariables returns the variables of enclosing functions that the
+// closure of a frame captured.
+func (stop *Stop) FreeVariables(frame int) []Variable {
+	closure := stop.frame(frame).closure
+	var free []Variable
+	for index, name := range closure.Function.FreeNames {
+		if index >= len(closure.FreeVariables) {
+			break
+		}
+		if value := closure.FreeVariables[index].Get(); value != nil {
+			free = append(free, Variable{Name: name, Value: value})
+		}
+	}
+	return free
+}
+
+// Globals returns the globals that are set.
+func (stop *Stop) Globals() []Variable {
+	var globals []Variable
+	for index, name := range stop.debugger.globalNames {
+		if index >= len(stop.virtualMachine.globals) {
+			break
+		}
+		value := stop.virtualMachine.globals[index]
+		if name != "" && value != nil {
+			globals = append(globals, Variable{Name: name, Value: value})
+		}
+	}
+	return globals
+}
+
+// Evaluate runs source, a Monkey expression or statements, as if it were in
+// the function of a frame, and returns its value. It sees the frame's locals
+// and free variables and the globals, and can call functions; assigning
+// with let only binds names for the rest of source.
+func (stop *Stop) Evaluate(frame int, source string) (object.Object, error) {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors) > 0 {
+		return nil, fmt.Errorf("parse errors: %s", strings.Join(p.Errors, "; "))
+	}
+
+	// The source becomes the body of a function taking the variables of the
+	// frame as parameters, the ones shadowing the others coming last.
+	current := stop.frame(frame).closure
+	var variables []Variable
+	if current.Function.Name != "" {
+		variables = append(variables, Variable{Name: current.Function.Name, Value: current})
+	}
+	variables = append(variables, stop.FreeVariables(frame)...)
+	variables = append(variables, stop.Locals(frame)...)
+	literal := &ast.FunctionLiteral{Body: &ast.BlockStatement{Statements: program.Statements}}
+	arguments := make([]object.Object, len(variables))
+	for i, variable := range variables {
+		literal.Parameters = append(literal.Parameters, &ast.Identifier{Value: variable.Name})
+		arguments[i] = variable.Value
+	}
+
+	symbolTable := compiler.NewSymbolTable()
+	for index, builtin := range object.Builtins {
+		symbolTable.DefineBuiltin(index, builtin.Name)
+	}
+	for index, name := range stop.debugger.globalNames {
+		if index >= len(stop.virtualMachine.globals) {
+			break
+		}
+		if name == "" || stop.virtualMachine.globals[index] == nil {
+			// Keep the slot without letting source refer to it.
+			name = fmt.Sprintf("#%d", index)
+		}
+		symbolTable.Define(name)
+	}
+	c := compiler.NewWithState(symbolTable, stop.virtualMachine.constants)
+	err := c.Compile(&ast.Program{Statements: []ast.Statement{&ast.ExpressionStatement{Expression: literal}}})
+	if err != nil {
+		return nil, err
+	}
+	constants := c.ByteCode().Constants
+	stop.virtualMachine.constants = constants
+	function := constants[len(constants)-1].(*object.CompiledFunction)
+	return stop.virtualMachine.Call(&object.Closure{Function: function}, arguments...)
+}
@@ -0,0 +1,160 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"writing-in-interpreter-in-go/src/monkey/compiler"
+)
+
+// debugStep is what a test expects at a pause and how it resumes.
+type debugStep struct {
+	stop     string
+	inspect  map[string]string
+	resumeAs StepMode
+}
+
+func TestDebugger(t *testing.T) {
+	input := `let add = fn(a, b) {
+  let sum = a + b;
+  sum
+};
+let offset = 10;
+let total = add(1, 2);
+let shift = fn(x) { fn(y) { x + y + offset } };
+shift(total)(4);`
+	steps := []debugStep{
+		{"breakpoint add:2 main:6", map[string]string{
+			"locals":                   "a=1 b=2",
+			"globals":                  "add=Closure[...] offset=10",
+			"a * 10 + offset":          "20",
+			"add(a, b) + sum":          "1:13: undefined variable sum",
+			"add(a, b) * 2":            "6",
+			"let twice = b * 2; twice": "4",
+		}, StepOver},
+		{"step add:3 main:6", map[string]string{"locals": "a=1 b=2 sum=3"}, StepOut},
+		{"step main:7", map[string]string{"globals": "add=Closure[...] offset=10 total=3"}, StepIn},
+		{"step main:8", nil, StepIn},
+		{"step shift:7 main:8", map[string]string{"locals": "x=3"}, StepIn},
+		{"step fn@7:7 main:8", map[string]string{
+			"locals": "y=4",
+			"free":   "x=3",
+			"x + y":  "7",
+			"shift":  "Closure[...]",
+		}, Continue},
+	}
+	testDebugger(t, input, []int{2}, false, steps)
+}
+
+func TestDebuggerTailCalls(t *testing.T) {
+	input := `let count = fn(n) { if (n == 0) { 0 } else { count(n - 1) } };
+count(2);`
+	steps := []debugStep{
+		{"breakpoint main:1", nil, Continue},
+		{"breakpoint count:1 main:2", map[string]string{"locals": "n=2"}, Continue},
+		{"breakpoint count:1 main:2", map[string]string{"locals": "n=1"}, Continue},
+		{"breakpoint count:1 main:2", map[string]string{"locals": "n=0"}, Continue},
+	}
+	testDebugger(t, input, []int{1}, false, steps)
+}
+
+func TestDebuggerInterrupt(t *testing.T) {
+	input := `let a = 1;
+let b = a + 1;
+puts(b);`
+	steps := []debugStep{
+		{"pause main:1", nil, StepOver},
+		{"step main:2", map[string]string{"globals": "a=1"}, Terminate},
+	}
+	err := testDebugger(t, input, nil, true, steps)
+	if !errors.Is(err, ErrTerminated) {
+		t.Errorf("wrong error. want=%q, got=%v", ErrTerminated, err)
+	}
+}
+
+func testDebugger(t *testing.T, input string, breakpoints []int, interrupt bool, steps []debugStep) error {
+	t.Helper()
+	comp := compiler.New()
+	err := comp.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	byteCode := comp.ByteCode()
+	paused := 0
+	debugger := NewDebugger(byteCode, func(stop *Stop) StepMode {
+		if paused == len(steps) {
+			t.Errorf("unexpected pause: %s", describeStop(stop))
+			return Continue
+		}
+		step := steps[paused]
+		paused++
+		if got := describeStop(stop); got != step.stop {
+			t.Errorf("pause %d: wrong stop. want=%q, got=%q", paused, step.stop, got)
+		}
+		for request, expected := range step.inspect {
+			if got := inspect(stop, request); got != expected {
+				t.Errorf("pause %d: wrong %s. want=%q, got=%q", paused, request, expected, got)
+			}
+		}
+		return step.resumeAs
+	})
+	for _, line := range breakpoints {
+		debugger.SetBreakpoint(line)
+	}
+	if !reflect.DeepEqual(debugger.Breakpoints(), append([]int{}, breakpoints...)) {
+		t.Errorf("wrong breakpoints. want=%v, got=%v", breakpoints, debugger.Breakpoints())
+	}
+	if interrupt {
+		debugger.Interrupt()
+	}
+	vm := New(byteCode)
+	vm.SetDebugger(debugger)
+	err = vm.Run()
+	if paused != len(steps) {
+		t.Errorf("paused %d times, want %d", paused, len(steps))
+	}
+	return err
+}
+
+func describeStop(stop *Stop) string {
+	description := stop.Reason
+	for _, frame := range stop.Frames() {
+		description += fmt.Sprintf(" %s:%d", frame.Function, frame.Position.Line)
+	}
+	return description
+}
+
+// inspect lists the locals, free variables or globals of the innermost
+// frame, or evaluates an expression there.
+func inspect(stop *Stop, request string) string {
+	var variables []Variable
+	switch request {
+	case "locals":
+		variables = stop.Locals(0)
+	case "free":
+		variables = stop.FreeVariables(0)
+	case "globals":
+		variables = stop.Globals()
+	default:
+		value, err := stop.Evaluate(0, request)
+		if err != nil {
+			return err.Error()
+		}
+		return describeValue(value.Inspect())
+	}
+	described := make([]string, len(variables))
+	for i, variable := range variables {
+		described[i] = variable.Name + "=" + describeValue(variable.Value.Inspect())
+	}
+	return strings.Join(described, " ")
+}
+
+// describeValue hides the addresses closures are inspected with.
+func describeValue(value string) string {
+	if strings.HasPrefix(value, "Closure[") {
+		return "Closure[...]"
+	}
+	return value
+}
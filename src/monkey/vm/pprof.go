@@ -0,0 +1,141 @@
+package vm
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+// The field numbers of the messages of profile.proto, the format read by
+// go tool pprof.
+const (
+	profileSampleType        = 1
+	profileSample            = 2
+	profileMapping           = 3
+	profileLocation          = 4
+	profileFunction          = 5
+	profileStringTable       = 6
+	profileTimeNanos         = 9
+	profileDurationNanos     = 10
+	profilePeriodType        = 11
+	profilePeriod            = 12
+	profileDefaultSampleType = 14
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+	sampleLabel      = 3
+
+	labelKey = 1
+	labelStr = 2
+
+	mappingID             = 1
+	mappingFilename       = 5
+	mappingHasFunctions   = 7
+	mappingHasFilenames   = 8
+	mappingHasLineNumbers = 9
+
+	locationID        = 1
+	locationMappingID = 2
+	locationLine      = 4
+
+	lineFunctionID = 1
+	lineLine       = 2
+
+	functionID        = 1
+	functionName      = 2
+	functionFilename  = 4
+	functionStartLine = 5
+)
+
+// protobuf encodes a protocol buffer message.
+type protobuf struct {
+	data []byte
+}
+
+func (buffer *protobuf) varint(value uint64) {
+	for value >= 0x80 {
+		buffer.data = append(buffer.data, byte(value)|0x80)
+		value >>= 7
+	}
+	buffer.data = append(buffer.data, byte(value))
+}
+
+func (buffer *protobuf) key(field int, wireType int) {
+	buffer.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (buffer *protobuf) int64(field int, value int64) {
+	if value == 0 {
+		return
+	}
+	buffer.key(field, 0)
+	buffer.varint(uint64(value))
+}
+
+func (buffer *protobuf) bytes(field int, value []byte) {
+	buffer.key(field, 2)
+	buffer.varint(uint64(len(value)))
+	buffer.data = append(buffer.data, value...)
+}
+
+func (buffer *protobuf) string(field int, value string) {
+	buffer.bytes(field, []byte(value))
+}
+
+func (buffer *protobuf) packed(field int, values []int64) {
+	var packed protobuf
+	for _, value := range values {
+		packed.varint(uint64(value))
+	}
+	buffer.bytes(field, packed.data)
+}
+
+func (buffer *protobuf) message(field int, encode func(message *protobuf)) {
+	var message protobuf
+	encode(&message)
+	buffer.bytes(field, message.data)
+}
+
+// pprofProfile is a profile ready to be encoded. Strings are kept in a table
+// and referred to by index, the first being the empty string.
+type pprofProfile struct {
+	buffer  protobuf
+	strings []string
+	indexes map[string]int64
+}
+
+func newPprofProfile() *pprofProfile {
+	return &pprofProfile{strings: []string{""}, indexes: map[string]int64{"": 0}}
+}
+
+func (profile *pprofProfile) stringIndex(value string) int64 {
+	index, ok := profile.indexes[value]
+	if !ok {
+		index = int64(len(profile.strings))
+		profile.strings = append(profile.strings, value)
+		profile.indexes[value] = index
+	}
+	return index
+}
+
+func (profile *pprofProfile) valueType(field int, kind, unit string) {
+	profile.buffer.message(field, func(message *protobuf) {
+		message.int64(valueTypeType, profile.stringIndex(kind))
+		message.int64(valueTypeUnit, profile.stringIndex(unit))
+	})
+}
+
+// write writes the profile gzipped, as go tool pprof expects.
+func (profile *pprofProfile) write(w io.Writer) error {
+	for _, value := range profile.strings {
+		profile.buffer.string(profileStringTable, value)
+	}
+	compressed := gzip.NewWriter(w)
+	_, err := compressed.Write(profile.buffer.data)
+	if err != nil {
+		return err
+	}
+	return compressed.Close()
+}
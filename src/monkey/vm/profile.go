@@ -0,0 +1,314 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+	"unsafe"
+	"writing-in-interpreter-in-go/src/monkey/object"
+)
+
+// samplingPeriod is how often the profiler samples the call stack.
+const samplingPeriod = time.Millisecond
+
+// Profiler records where a virtual machine spends its time, how often it
+// calls each function and which objects it allocates, for go tool pprof.
+// Time is sampled: every samplingPeriod the call stack is recorded along
+// with the time elapsed since the previous sample. Calls and allocations are
+// all recorded. Allocations are those of the virtual machine itself; the
+// objects builtins create are not counted.
+type Profiler struct {
+	// File names the source file in the profiles.
+	File string
+
+	main      *object.CompiledFunction
+	start     time.Time
+	duration  time.Duration
+	last      time.Time
+	due       atomic.Bool
+	stop      chan struct{}
+	locations []location
+	ids       map[location]int64
+	// cpu and allocations are keyed by stack and, for allocations, type.
+	cpu         map[string]*stackSample
+	allocations map[string]*stackSample
+}
+
+// location is a line of a function, the unit call stacks are made of.
+type location struct {
+	function *object.CompiledFunction
+	line     int
+}
+
+type stackSample struct {
+	stack  []int64
+	label  string
+	values []int64
+}
+
+// The values of the samples of each profile.
+const (
+	cpuSamples = iota
+	cpuTime
+	cpuCalls
+)
+
+const (
+	allocationObjects = iota
+	allocationSpace
+)
+
+func NewProfiler() *Profiler {
+	return &Profiler{
+		ids:         make(map[location]int64),
+		cpu:         make(map[string]*stackSample),
+		allocations: make(map[string]*stackSample),
+	}
+}
+
+// SetProfiler makes Run record what the virtual machine does in profiler.
+func (virtualMachine *VirtualMachine) SetProfiler(profiler *Profiler) {
+	profiler.main = virtualMachine.frames[0].closure.Function
+	virtualMachine.profiler = profiler
+}
+
+func (profiler *Profiler) startSampling() {
+	now := time.Now()
+	if profiler.start.IsZero() {
+		profiler.start = now
+	}
+	profiler.last = now
+	profiler.stop = make(chan struct{})
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(samplingPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				profiler.due.Store(true)
+			case <-stop:
+				return
+			}
+		}
+	}(profiler.stop)
+}
+
+func (profiler *Profiler) stopSampling() {
+	close(profiler.stop)
+	profiler.duration = time.Since(profiler.start)
+}
+
+// callStack returns the locations of the frames, innermost first. Frames
+// keep their ip up to date while a profiler is set.
+func (virtualMachine *VirtualMachine) callStack() []int64 {
+	profiler := virtualMachine.profiler
+	stack := make([]int64, 0, virtualMachine.framesIndex)
+	for i := virtualMachine.framesIndex - 1; i >= 0; i-- {
+		frame := virtualMachine.frames[i]
+		function := frame.closure.Function
+		line := function.SourceMap.PositionAt(frame.ip).Line
+		if line == 0 {
+			line = startLine(function)
+		}
+		stack = append(stack, profiler.locationID(location{function: function, line: line}))
+	}
+	return stack
+}
+
+func (profiler *Profiler) locationID(location location) int64 {
+	id, ok := profiler.ids[location]
+	if !ok {
+		profiler.locations = append(profiler.locations, location)
+		id = int64(len(profiler.locations))
+		profiler.ids[location] = id
+	}
+	return id
+}
+
+// sample attributes the time since the previous sample to the current
+// call stack.
+func (virtualMachine *VirtualMachine) sample() {
+	profiler := virtualMachine.profiler
+	profiler.due.Store(false)
+	now := time.Now()
+	sample := profiler.cpuSample(virtualMachine.callStack())
+	sample.values[cpuSamples]++
+	sample.values[cpuTime] += int64(now.Sub(profiler.last))
+	profiler.last = now
+}
+
+// called records a call of the function in the current frame.
+func (virtualMachine *VirtualMachine) called() {
+	if virtualMachine.profiler != nil {
+		virtualMachine.profiler.cpuSample(virtualMachine.callStack()).values[cpuCalls]++
+	}
+}
+
+func (profiler *Profiler) cpuSample(stack []int64) *stackSample {
+	key := stackKey("", stack)
+	sample, ok := profiler.cpu[key]
+	if !ok {
+		sample = &stackSample{stack: stack, values: make([]int64, 3)}
+		profiler.cpu[key] = sample
+	}
+	return sample
+}
+
+func stackKey(prefix string, stack []int64) string {
+	key := []byte(prefix)
+	for _, id := range stack {
+		key = strconv.AppendInt(append(key, ' '), id, 10)
+	}
+	return string(key)
+}
+
+// allocated records the allocation of size bytes for an object of type
+// kind by the current frame.
+func (virtualMachine *VirtualMachine) allocated(kind string, size uintptr) {
+	if virtualMachine.profiler == nil {
+		return
+	}
+	stack := virtualMachine.callStack()
+	key := stackKey(kind, stack)
+	sample, ok := virtualMachine.profiler.allocations[key]
+	if !ok {
+		sample = &stackSample{stack: stack, label: kind, values: make([]int64, 2)}
+		virtualMachine.profiler.allocations[key] = sample
+	}
+	sample.values[allocationObjects]++
+	sample.values[allocationSpace] += int64(size)
+}
+
+// allocatedObject records the allocation of value, counting what its
+// elements or characters take.
+func (virtualMachine *VirtualMachine) allocatedObject(value object.Object) {
+	if virtualMachine.profiler == nil {
+		return
+	}
+	switch value := value.(type) {
+	case *object.Integer:
+		virtualMachine.allocated(string(value.Type()), unsafe.Sizeof(*value))
+	case *object.String:
+		virtualMachine.allocated(string(value.Type()), unsafe.Sizeof(*value)+uintptr(len(value.Value)))
+	case *object.Array:
+		virtualMachine.allocated(string(value.Type()),
+			unsafe.Sizeof(*value)+uintptr(len(value.Elements))*unsafe.Sizeof(value.Elements[0]))
+	case *object.Closure:
+		virtualMachine.allocated(string(value.Type()),
+			unsafe.Sizeof(*value)+uintptr(len(value.FreeVariables))*unsafe.Sizeof(&object.Upvalue{}))
+	}
+}
+
+// WriteCPUProfile writes the time spent in and the calls made to each
+// function, as a gzipped pprof profile.
+func (profiler *Profiler) WriteCPUProfile(w io.Writer) error {
+	profile := newPprofProfile()
+	profile.valueType(profileSampleType, "samples", "count")
+	profile.valueType(profileSampleType, "time", "nanoseconds")
+	profile.valueType(profileSampleType, "calls", "count")
+	profile.valueType(profilePeriodType, "time", "nanoseconds")
+	profile.buffer.int64(profilePeriod, int64(samplingPeriod))
+	profile.buffer.int64(profileDefaultSampleType, profile.stringIndex("time"))
+	return profiler.write(w, profile, profiler.cpu)
+}
+
+// WriteAllocationProfile writes the objects each function allocated, as a
+// gzipped pprof profile. Samples are labelled with the type of the objects.
+func (profiler *Profiler) WriteAllocationProfile(w io.Writer) error {
+	profile := newPprofProfile()
+	profile.valueType(profileSampleType, "alloc_objects", "count")
+	profile.valueType(profileSampleType, "alloc_space", "bytes")
+	profile.valueType(profilePeriodType, "space", "bytes")
+	profile.buffer.int64(profilePeriod, 1)
+	return profiler.write(w, profile, profiler.allocations)
+}
+
+func (profiler *Profiler) write(w io.Writer, profile *pprofProfile, samples map[string]*stackSample) error {
+	buffer := &profile.buffer
+	keys := make([]string, 0, len(samples))
+	for key := range samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		sample := samples[key]
+		buffer.message(profileSample, func(message *protobuf) {
+			message.packed(sampleLocationID, sample.stack)
+			message.packed(sampleValue, sample.values)
+			if sample.label != "" {
+				message.message(sampleLabel, func(label *protobuf) {
+					label.int64(labelKey, profile.stringIndex("type"))
+					label.int64(labelStr, profile.stringIndex(sample.label))
+				})
+			}
+		})
+	}
+
+	// A single mapping, already symbolized, stands for the program.
+	buffer.message(profileMapping, func(message *protobuf) {
+		message.int64(mappingID, 1)
+		message.int64(mappingFilename, profile.stringIndex(profiler.File))
+		message.int64(mappingHasFunctions, 1)
+		message.int64(mappingHasFilenames, 1)
+		message.int64(mappingHasLineNumbers, 1)
+	})
+	functionIDs := make(map[*object.CompiledFunction]int64)
+	var functions []*object.CompiledFunction
+	for i, location := range profiler.locations {
+		id, ok := functionIDs[location.function]
+		if !ok {
+			functions = append(functions, location.function)
+			id = int64(len(functions))
+			functionIDs[location.function] = id
+		}
+		buffer.message(profileLocation, func(message *protobuf) {
+			message.int64(locationID, int64(i+1))
+			message.int64(locationMappingID, 1)
+			message.message(locationLine, func(line *protobuf) {
+				line.int64(lineFunctionID, id)
+				line.int64(lineLine, int64(location.line))
+			})
+		})
+	}
+	for i, function := range functions {
+		buffer.message(profileFunction, func(message *protobuf) {
+			message.int64(functionID, int64(i+1))
+			message.int64(functionName, profile.stringIndex(profiler.functionName(function)))
+			message.int64(functionFilename, profile.stringIndex(profiler.File))
+			message.int64(functionStartLine, int64(startLine(function)))
+		})
+	}
+	buffer.int64(profileTimeNanos, profiler.start.UnixNano())
+	buffer.int64(profileDurationNanos, int64(profiler.duration))
+	return profile.write(w)
+}
+
+func (profiler *Profiler) functionName(function *object.CompiledFunction) string {
+	if function == profiler.main {
+		return "main"
+	}
+	return closureName(function)
+}
+
+// closureName names a function after the let statement binding it, or else
+// after the line it starts on.
+func closureName(function *object.CompiledFunction) string {
+	if function.Name != "" {
+		return function.Name
+	}
+	return fmt.Sprintf("fn@%d", startLine(function))
+}
+
+// startLine returns the first line of function, or 0 when it is unknown.
+func startLine(function *object.CompiledFunction) int {
+	first := 0
+	for _, mapping := range function.SourceMap {
+		if line := mapping.Position.Line; line > 0 && (first == 0 || line < first) {
+			first = line
+		}
+	}
+	return first
+}
@@ -0,0 +1,101 @@
+package vm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"reflect"
+	"testing"
+	"writing-in-interpreter-in-go/src/monkey/compiler"
+)
+
+func TestProfiler(t *testing.T) {
+	input := `let countdown = fn(n) {
+  if (n == 0) { 0 } else { countdown(n - 1) }
+};
+let pair = fn(a, b) { [a, b] };
+let join = fn(a, b) { a + b };
+countdown(9);
+pair(1, 2);
+join("mon", "key");
+let adder = fn(x) { fn(y) { x + y } };
+adder(1)(2000) * 3;`
+	comp := compiler.New()
+	err := comp.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.ByteCode())
+	profiler := NewProfiler()
+	profiler.File = "profile.mk"
+	vm.SetProfiler(profiler)
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	calls := make(map[string]int64)
+	for _, sample := range profiler.cpu {
+		if sample.values[cpuCalls] > 0 {
+			function := profiler.locations[sample.stack[0]-1].function
+			calls[profiler.functionName(function)] += sample.values[cpuCalls]
+		}
+	}
+	expectedCalls := map[string]int64{"countdown": 10, "pair": 1, "join": 1, "adder": 1, "fn@9": 1}
+	if !reflect.DeepEqual(calls, expectedCalls) {
+		t.Errorf("wrong calls.\nwant=%v\ngot =%v", expectedCalls, calls)
+	}
+
+	allocations := make(map[string]int64)
+	for _, sample := range profiler.allocations {
+		allocations[sample.label] += sample.values[allocationObjects]
+	}
+	// The closures are countdown, pair, join, adder and the one adder returns;
+	// 2001 and 6003 are the only integers too large to be shared.
+	expectedAllocations := map[string]int64{"CLOSURE": 5, "UPVALUE": 1, "ARRAY": 1, "STRING": 1, "INTEGER": 2}
+	if !reflect.DeepEqual(allocations, expectedAllocations) {
+		t.Errorf("wrong allocations.\nwant=%v\ngot =%v", expectedAllocations, allocations)
+	}
+
+	for name, write := range map[string]func(w io.Writer) error{
+		"cpu":        profiler.WriteCPUProfile,
+		"allocation": profiler.WriteAllocationProfile,
+	} {
+		var file bytes.Buffer
+		err := write(&file)
+		if err != nil {
+			t.Fatalf("%s: writing profile failed: %s", name, err)
+		}
+		reader, err := gzip.NewReader(&file)
+		if err != nil {
+			t.Fatalf("%s: profile is not gzipped: %s", name, err)
+		}
+		profile, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("%s: reading profile failed: %s", name, err)
+		}
+		for _, mention := range []string{"profile.mk", "main", "countdown", "fn@9"} {
+			if !bytes.Contains(profile, []byte(mention)) {
+				t.Errorf("%s profile does not mention %q", name, mention)
+			}
+		}
+	}
+}
+
+func TestProtobuf(t *testing.T) {
+	var buffer protobuf
+	buffer.int64(1, 150)
+	buffer.int64(2, 0)
+	buffer.string(3, "ab")
+	buffer.packed(4, []int64{3, 270})
+	buffer.message(5, func(message *protobuf) { message.int64(1, 1) })
+	expected := []byte{
+		0x08, 0x96, 0x01, // field 1: 150
+		0x1a, 0x02, 'a', 'b', // field 3: "ab"
+		0x22, 0x03, 0x03, 0x8e, 0x02, // field 4: [3, 270]
+		0x2a, 0x02, 0x08, 0x01, // field 5: {1: 1}
+	}
+	if !bytes.Equal(buffer.data, expected) {
+		t.Errorf("wrong encoding.\nwant=% x\ngot =% x", expected, buffer.data)
+	}
+}
@@ -0,0 +1,433 @@
+package register
+
+import (
+	"fmt"
+	"writing-in-interpreter-in-go/src/monkey/ast"
+	symbols "writing-in-interpreter-in-go/src/monkey/compiler"
+	"writing-in-interpreter-in-go/src/monkey/object"
+)
+
+// Program is the result of compiling a Monkey program for the register
+// machine.
+type Program struct {
+	Main      *Function
+	Constants []object.Object
+}
+
+// scope tracks the registers of the function being compiled. Registers below
+// next are in use; temporaries are allocated above the function's bindings
+// and released in stack order.
+type scope struct {
+	instructions Instructions
+	symbolTable  *symbols.SymbolTable
+	next         int
+	registers    int
+	// capturesLocals is set once a closure capturing one of the function's
+	// locals has been emitted.
+	capturesLocals bool
+}
+
+type Compiler struct {
+	constants       []object.Object
+	constantIndexes map[symbols.ConstantKey]int
+	scopes          []*scope
+}
+
+func NewCompiler() *Compiler {
+	symbolTable := symbols.NewSymbolTable()
+	for index, builtin := range object.Builtins {
+		symbolTable.DefineBuiltin(index, builtin.Name)
+	}
+	return &Compiler{
+		constantIndexes: make(map[symbols.ConstantKey]int),
+		scopes:          []*scope{{symbolTable: symbolTable}},
+	}
+}
+
+func (compiler *Compiler) Compile(program *ast.Program) error {
+	for _, statement := range program.Statements {
+		err := compiler.statement(statement)
+		if err != nil {
+			return err
+		}
+	}
+	compiler.emit(OpHalt, 0, 0, 0)
+	return nil
+}
+
+func (compiler *Compiler) Program() *Program {
+	main := compiler.currentScope()
+	return &Program{
+		Main:      &Function{Instructions: main.instructions, RegisterArity: main.registers},
+		Constants: compiler.constants,
+	}
+}
+
+func (compiler *Compiler) currentScope() *scope {
+	return compiler.scopes[len(compiler.scopes)-1]
+}
+
+func (compiler *Compiler) inFunction() bool {
+	return len(compiler.scopes) > 1
+}
+
+func (compiler *Compiler) emit(opcode Opcode, a, b, c int) int {
+	scope := compiler.currentScope()
+	scope.instructions = append(scope.instructions, Instruction{Opcode: opcode, A: a, B: b, C: c})
+	return len(scope.instructions) - 1
+}
+
+func (compiler *Compiler) allocate() int {
+	scope := compiler.currentScope()
+	register := scope.next
+	scope.next++
+	if scope.next > scope.registers {
+		scope.registers = scope.next
+	}
+	return register
+}
+
+func (compiler *Compiler) release(mark int) {
+	compiler.currentScope().next = mark
+}
+
+func (compiler *Compiler) mark() int {
+	return compiler.currentScope().next
+}
+
+func (compiler *Compiler) addConstant(obj object.Object) int {
+	key, ok := symbols.ConstantKeyOf(obj)
+	if ok {
+		if index, found := compiler.constantIndexes[key]; found {
+			return index
+		}
+	}
+	compiler.constants = append(compiler.constants, obj)
+	index := len(compiler.constants) - 1
+	if ok {
+		compiler.constantIndexes[key] = index
+	}
+	return index
+}
+
+func (compiler *Compiler) statement(statement ast.Statement) error {
+	switch statement := statement.(type) {
+	case *ast.LetStatement:
+		// The value is compiled before the name is defined, as it refers to
+		// the enclosing binding. It goes straight to the binding's register
+		// unless it defines names of its own, which would take that register.
+		symbolTable := compiler.currentScope().symbolTable
+		if symbol := symbolTable.Peek(statement.Identifier.Value); symbol.Scope == symbols.LocalScope && countLetStatements(statement.Value) == 0 {
+			err := compiler.expression(statement.Value, symbol.Index, false)
+			symbolTable.Define(statement.Identifier.Value)
+			return err
+		}
+		mark := compiler.mark()
+		defer compiler.release(mark)
+		value := compiler.allocate()
+		err := compiler.expression(statement.Value, value, false)
+		if err != nil {
+			return err
+		}
+		symbol := symbolTable.Define(statement.Identifier.Value)
+		if symbol.Scope == symbols.LocalScope {
+			compiler.emit(OpMove, symbol.Index, value, 0)
+			return nil
+		}
+		if symbol.Index >= GlobalsSize {
+			return fmt.Errorf("too many global bindings: %s is binding %d", statement.Identifier.Value, symbol.Index+1)
+		}
+		compiler.emit(OpSetGlobal, symbol.Index, value, 0)
+	case *ast.ReturnStatement:
+		mark := compiler.mark()
+		defer compiler.release(mark)
+		value := compiler.allocate()
+		if statement.ReturnValue == nil {
+			compiler.emit(OpLoadNull, value, 0, 0)
+		} else {
+			err := compiler.expression(statement.ReturnValue, value, compiler.inFunction())
+			if err != nil {
+				return err
+			}
+		}
+		compiler.closeUpvalues()
+		compiler.emit(OpReturn, value, 0, 0)
+	case *ast.ExpressionStatement:
+		mark := compiler.mark()
+		defer compiler.release(mark)
+		value, err := compiler.operand(statement.Expression)
+		if err != nil {
+			return err
+		}
+		if !compiler.inFunction() {
+			compiler.emit(OpPop, value, 0, 0)
+		}
+	}
+	return nil
+}
+
+// block compiles statements, leaving the value of the last one in target.
+// Blocks that do not end in an expression evaluate to null.
+func (compiler *Compiler) block(block *ast.BlockStatement, target int, tail bool) error {
+	if block == nil || len(block.Statements) == 0 {
+		compiler.emit(OpLoadNull, target, 0, 0)
+		return nil
+	}
+	last := len(block.Statements) - 1
+	for _, statement := range block.Statements[:last] {
+		err := compiler.statement(statement)
+		if err != nil {
+			return err
+		}
+	}
+	if statement, ok := block.Statements[last].(*ast.ExpressionStatement); ok {
+		return compiler.expression(statement.Expression, target, tail)
+	}
+	err := compiler.statement(block.Statements[last])
+	if err != nil {
+		return err
+	}
+	compiler.emit(OpLoadNull, target, 0, 0)
+	return nil
+}
+
+// operand returns the register holding the value of expression. Local
+// bindings are used in place; anything else is computed into a new
+// temporary.
+func (compiler *Compiler) operand(expression ast.Expression) (int, error) {
+	if identifier, ok := expression.(*ast.Identifier); ok {
+		symbol, ok := compiler.currentScope().symbolTable.Resolve(identifier.Value)
+		if ok && symbol.Scope == symbols.LocalScope {
+			return symbol.Index, nil
+		}
+	}
+	register := compiler.allocate()
+	return register, compiler.expression(expression, register, false)
+}
+
+// expression compiles expression so that its value ends up in target. A call
+// in tail position replaces the current frame.
+func (compiler *Compiler) expression(expression ast.Expression, target int, tail bool) error {
+	mark := compiler.mark()
+	defer compiler.release(mark)
+
+	switch node := expression.(type) {
+	case *ast.IntegerLiteral:
+		compiler.emit(OpLoadConstant, target, compiler.addConstant(&object.Integer{Value: node.Value}), 0)
+	case *ast.StringLiteral:
+		compiler.emit(OpLoadConstant, target, compiler.addConstant(&object.String{Value: node.Value}), 0)
+	case *ast.BooleanExpression:
+		if node.Value {
+			compiler.emit(OpLoadTrue, target, 0, 0)
+		} else {
+			compiler.emit(OpLoadFalse, target, 0, 0)
+		}
+	case *ast.Identifier:
+		symbolTable := compiler.currentScope().symbolTable
+		symbol, ok := symbolTable.Resolve(node.Value)
+		if !ok {
+			return symbols.NewResolutionError(node, symbolTable.Names())
+		}
+		compiler.loadSymbol(symbol, target)
+	case *ast.PrefixExpression:
+		operand, err := compiler.operand(node.Operand)
+		if err != nil {
+			return err
+		}
+		switch node.Operator {
+		case "!":
+			compiler.emit(OpBang, target, operand, 0)
+		case "-":
+			compiler.emit(OpNegate, target, operand, 0)
+		default:
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+	case *ast.InfixExpression:
+		left, err := compiler.operand(node.Left)
+		if err != nil {
+			return err
+		}
+		right, err := compiler.operand(node.Right)
+		if err != nil {
+			return err
+		}
+		switch node.Operator {
+		case "+":
+			compiler.emit(OpAdd, target, left, right)
+		case "-":
+			compiler.emit(OpSub, target, left, right)
+		case "*":
+			compiler.emit(OpMul, target, left, right)
+		case "/":
+			compiler.emit(OpDiv, target, left, right)
+		case ">":
+			compiler.emit(OpGreaterThan, target, left, right)
+		case "<":
+			compiler.emit(OpLessThan, target, left, right)
+		case "<=":
+			compiler.emit(OpLessEqual, target, left, right)
+		case ">=":
+			compiler.emit(OpGreaterEqual, target, left, right)
+		case "==":
+			compiler.emit(OpEqual, target, left, right)
+		case "!=":
+			compiler.emit(OpNotEqual, target, left, right)
+		default:
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+	case *ast.IfExpression:
+		condition, err := compiler.operand(node.Condition)
+		if err != nil {
+			return err
+		}
+		compiler.release(mark)
+		jumpIfFalse := compiler.emit(OpJumpIfFalse, condition, 0, 0)
+		err = compiler.block(node.Consequence, target, tail)
+		if err != nil {
+			return err
+		}
+		jump := compiler.emit(OpJump, 0, 0, 0)
+		compiler.currentScope().instructions[jumpIfFalse].B = len(compiler.currentScope().instructions)
+		err = compiler.block(node.Alternative, target, tail)
+		if err != nil {
+			return err
+		}
+		compiler.currentScope().instructions[jump].A = len(compiler.currentScope().instructions)
+	case *ast.FunctionLiteral:
+		return compiler.function(node, target)
+	case *ast.CallExpression:
+		callee := compiler.allocate()
+		err := compiler.expression(node.Function, callee, false)
+		if err != nil {
+			return err
+		}
+		for _, argument := range node.Arguments {
+			err := compiler.expression(argument, compiler.allocate(), false)
+			if err != nil {
+				return err
+			}
+		}
+		if tail {
+			compiler.closeUpvalues()
+			compiler.emit(OpTailCall, target, callee, len(node.Arguments))
+		} else {
+			compiler.emit(OpCall, target, callee, len(node.Arguments))
+		}
+	case *ast.ArrayLiteral:
+		first := compiler.mark()
+		for _, element := range node.Elements {
+			err := compiler.expression(element, compiler.allocate(), false)
+			if err != nil {
+				return err
+			}
+		}
+		compiler.emit(OpArray, target, first, len(node.Elements))
+	case *ast.IndexExpression:
+		left, err := compiler.operand(node.Expression)
+		if err != nil {
+			return err
+		}
+		index, err := compiler.operand(node.Index)
+		if err != nil {
+			return err
+		}
+		compiler.emit(OpIndex, target, left, index)
+	default:
+		return fmt.Errorf("unsupported expression %T", expression)
+	}
+	return nil
+}
+
+func (compiler *Compiler) function(node *ast.FunctionLiteral, target int) error {
+	symbolTable := symbols.NewEnclosedSymbolTable(compiler.currentScope().symbolTable)
+	if node.Name != "" {
+		symbolTable.DefineFunctionName(node.Name)
+	}
+	for _, parameter := range node.Parameters {
+		identifier, ok := parameter.(*ast.Identifier)
+		if !ok {
+			return fmt.Errorf("parameter %s is not an identifier", parameter.String())
+		}
+		symbolTable.DefineParameter(identifier.Value)
+	}
+
+	bindings := len(node.Parameters) + countLetStatements(node.Body)
+	compiler.scopes = append(compiler.scopes, &scope{symbolTable: symbolTable, next: bindings, registers: bindings})
+	result := compiler.allocate()
+	err := compiler.block(node.Body, result, true)
+	if err != nil {
+		return err
+	}
+	compiler.closeUpvalues()
+	compiler.emit(OpReturn, result, 0, 0)
+	functionScope := compiler.currentScope()
+	compiler.scopes = compiler.scopes[:len(compiler.scopes)-1]
+
+	captures := make([]object.Capture, len(symbolTable.FreeSymbols))
+	for i, symbol := range symbolTable.FreeSymbols {
+		captures[i] = compiler.capture(symbol)
+	}
+	function := &Function{
+		Instructions:       functionScope.instructions,
+		ParameterArity:     len(node.Parameters),
+		LocalVariableArity: bindings,
+		RegisterArity:      functionScope.registers,
+		Captures:           captures,
+	}
+	compiler.emit(OpClosure, target, compiler.addConstant(function), 0)
+	return nil
+}
+
+// closeUpvalues emits OpCloseUpvalue ahead of a return once a closure has
+// captured one of the function's locals, as the stack compiler does.
+func (compiler *Compiler) closeUpvalues() {
+	if compiler.currentScope().capturesLocals {
+		compiler.emit(OpCloseUpvalue, 0, 0, 0)
+	}
+}
+
+func (compiler *Compiler) capture(symbol symbols.Symbol) object.Capture {
+	switch symbol.Scope {
+	case symbols.LocalScope:
+		compiler.currentScope().capturesLocals = true
+		return object.Capture{Kind: object.CaptureLocal, Index: symbol.Index}
+	case symbols.FunctionScope:
+		return object.Capture{Kind: object.CaptureClosure}
+	default:
+		return object.Capture{Kind: object.CaptureFree, Index: symbol.Index}
+	}
+}
+
+func (compiler *Compiler) loadSymbol(symbol symbols.Symbol, target int) {
+	switch symbol.Scope {
+	case symbols.GlobalScope:
+		compiler.emit(OpGetGlobal, target, symbol.Index, 0)
+	case symbols.LocalScope:
+		if symbol.Index != target {
+			compiler.emit(OpMove, target, symbol.Index, 0)
+		}
+	case symbols.BuiltinScope:
+		compiler.emit(OpGetBuiltin, target, symbol.Index, 0)
+	case symbols.FreeScope:
+		compiler.emit(OpGetFree, target, symbol.Index, 0)
+	case symbols.FunctionScope:
+		compiler.emit(OpCurrentClosure, target, 0, 0)
+	}
+}
+
+// countLetStatements counts the bindings node defines, which for a function
+// body the symbol table numbers right after the parameters. Nested
+// functions have frames of their own and are skipped.
+func countLetStatements(node ast.Node) int {
+	count := 0
+	ast.Inspect(node, func(node ast.Node) bool {
+		switch node.(type) {
+		case *ast.LetStatement:
+			count++
+		case *ast.FunctionLiteral, *ast.MacroLiteral:
+			return false
+		}
+		return true
+	})
+	return count
+}
@@ -0,0 +1,104 @@
+package register
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"writing-in-interpreter-in-go/src/monkey/lexer"
+	"writing-in-interpreter-in-go/src/monkey/parser"
+)
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			input: "1 + 2",
+			expected: "0000 OpLoadConstant 1 0\n" +
+				"0001 OpLoadConstant 2 1\n" +
+				"0002 OpAdd 0 1 2\n" +
+				"0003 OpPop 0\n" +
+				"0004 OpHalt\n",
+		},
+		{
+			input: "if (1 < 2) { 3 }",
+			expected: "0000 OpLoadConstant 2 0\n" +
+				"0001 OpLoadConstant 3 1\n" +
+				"0002 OpLessThan 1 2 3\n" +
+				"0003 OpJumpIfFalse 1 6\n" +
+				"0004 OpLoadConstant 0 2\n" +
+				"0005 OpJump 7\n" +
+				"0006 OpLoadNull 0\n" +
+				"0007 OpPop 0\n" +
+				"0008 OpHalt\n",
+		},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		compiler := NewCompiler()
+		err := compiler.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		actual := compiler.Program().Main.Instructions.String()
+		if actual != tt.expected {
+			t.Errorf("wrong instructions for %q.\nwant=\n%s\ngot=\n%s", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+// Parameters and let bindings live in fixed registers, so binary operations
+// on them need no moves.
+func TestLocalsAreUsedInPlace(t *testing.T) {
+	program := parser.New(lexer.New("fn(a) { let b = 2; a * b }")).ParseProgram()
+	compiler := NewCompiler()
+	err := compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	function, ok := compiler.Program().Constants[1].(*Function)
+	if !ok {
+		t.Fatalf("constant 1 is not a function: %T", compiler.Program().Constants[1])
+	}
+	expected := "0000 OpLoadConstant 1 0\n" +
+		"0001 OpMul 2 0 1\n" +
+		"0002 OpReturn 2\n"
+	if actual := function.Instructions.String(); actual != expected {
+		t.Errorf("wrong instructions.\nwant=\n%s\ngot=\n%s", expected, actual)
+	}
+	if function.RegisterArity != 3 {
+		t.Errorf("wrong register arity. want=3, got=%d", function.RegisterArity)
+	}
+}
+
+func TestTooManyGlobals(t *testing.T) {
+	var source strings.Builder
+	for i := 0; i <= GlobalsSize; i++ {
+		fmt.Fprintf(&source, "let %s = %d;\n", globalName(i), i)
+	}
+	p := parser.New(lexer.New(source.String()))
+	program := p.ParseProgram()
+	if len(p.Errors) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors)
+	}
+	err := NewCompiler().Compile(program)
+	expected := fmt.Sprintf("too many global bindings: %s is binding %d", globalName(GlobalsSize), GlobalsSize+1)
+	if err == nil || err.Error() != expected {
+		t.Errorf("wrong error. want=%q, got=%v", expected, err)
+	}
+}
+
+// globalName spells i in letters, as identifiers cannot contain digits,
+// after a g that keeps it from being a keyword.
+func globalName(i int) string {
+	name := ""
+	for {
+		name = string(rune('a'+i%26)) + name
+		i /= 26
+		if i == 0 {
+			return "g" + name
+		}
+	}
+}
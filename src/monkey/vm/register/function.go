@@ -0,0 +1,34 @@
+package register
+
+import (
+	"fmt"
+	"writing-in-interpreter-in-go/src/monkey/object"
+)
+
+// Function is a function compiled for the register machine. Its parameters
+// occupy the first registers of its frame, followed by its let bindings and
+// the temporaries the compiler allocated.
+type Function struct {
+	Instructions       Instructions
+	ParameterArity     int
+	LocalVariableArity int
+	RegisterArity      int
+	Captures           []object.Capture
+}
+
+func (function *Function) Type() object.Type { return object.COMPILED_FUNCTION }
+
+func (function *Function) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", function)
+}
+
+type Closure struct {
+	Function      *Function
+	FreeVariables []*object.Upvalue
+}
+
+func (closure *Closure) Type() object.Type { return object.CLOSURE }
+
+func (closure *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", closure)
+}
@@ -0,0 +1,133 @@
+package register
+
+import (
+	"bytes"
+	"fmt"
+)
+
+type Opcode byte
+
+const (
+	OpLoadConstant Opcode = iota
+	OpLoadTrue
+	OpLoadFalse
+	OpLoadNull
+	OpMove
+	OpGetGlobal
+	OpSetGlobal
+	OpGetBuiltin
+	OpGetFree
+	OpCloseUpvalue
+	OpCurrentClosure
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpEqual
+	OpNotEqual
+	OpGreaterThan
+	OpLessThan
+	OpLessEqual
+	OpGreaterEqual
+	OpNegate
+	OpBang
+	OpJump
+	OpJumpIfFalse
+	OpArray
+	OpIndex
+	OpClosure
+	OpCall
+	OpTailCall
+	OpReturn
+	OpPop
+	OpHalt
+)
+
+// Instruction is a three-address instruction. Unless noted otherwise A is
+// the register receiving the result and B and C are the registers, constant
+// or binding indices it is computed from. Registers are numbered from the
+// start of the current frame.
+type Instruction struct {
+	Opcode Opcode
+	A      int
+	B      int
+	C      int
+}
+
+type Instructions []Instruction
+
+type Definition struct {
+	Name     string
+	Operands int
+}
+
+var definitions = map[Opcode]*Definition{
+	OpLoadConstant:   {"OpLoadConstant", 2},
+	OpLoadTrue:       {"OpLoadTrue", 1},
+	OpLoadFalse:      {"OpLoadFalse", 1},
+	OpLoadNull:       {"OpLoadNull", 1},
+	OpMove:           {"OpMove", 2},
+	OpGetGlobal:      {"OpGetGlobal", 2},
+	OpSetGlobal:      {"OpSetGlobal", 2}, // A is the global, B the register.
+	OpGetBuiltin:     {"OpGetBuiltin", 2},
+	OpGetFree:        {"OpGetFree", 2},
+	OpCloseUpvalue:   {"OpCloseUpvalue", 0},
+	OpCurrentClosure: {"OpCurrentClosure", 1},
+	OpAdd:            {"OpAdd", 3},
+	OpSub:            {"OpSub", 3},
+	OpMul:            {"OpMul", 3},
+	OpDiv:            {"OpDiv", 3},
+	OpEqual:          {"OpEqual", 3},
+	OpNotEqual:       {"OpNotEqual", 3},
+	OpGreaterThan:    {"OpGreaterThan", 3},
+	OpLessThan:       {"OpLessThan", 3},
+	OpLessEqual:      {"OpLessEqual", 3},
+	OpGreaterEqual:   {"OpGreaterEqual", 3},
+	OpNegate:         {"OpNegate", 2},
+	OpBang:           {"OpBang", 2},
+	OpJump:           {"OpJump", 1},        // A is the target instruction.
+	OpJumpIfFalse:    {"OpJumpIfFalse", 2}, // A is the condition, B the target.
+	// OpArray builds an array from the C registers starting at B.
+	OpArray: {"OpArray", 3},
+	OpIndex: {"OpIndex", 3},
+	// OpClosure closes function constant B over the variables it captures.
+	OpClosure: {"OpClosure", 2},
+	// OpCall calls the function in B with the C arguments in the registers
+	// following it.
+	OpCall:     {"OpCall", 3},
+	OpTailCall: {"OpTailCall", 3},
+	OpReturn:   {"OpReturn", 1},
+	// OpPop records the value of a top-level expression statement.
+	OpPop:  {"OpPop", 1},
+	OpHalt: {"OpHalt", 0},
+}
+
+func LookUp(opcode Opcode) (*Definition, error) {
+	definition, ok := definitions[opcode]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", opcode)
+	}
+	return definition, nil
+}
+
+func (instruction Instruction) String() string {
+	definition, err := LookUp(instruction.Opcode)
+	if err != nil {
+		return fmt.Sprintf("ERROR: %s", err)
+	}
+	operands := []int{instruction.A, instruction.B, instruction.C}[:definition.Operands]
+	var out bytes.Buffer
+	out.WriteString(definition.Name)
+	for _, operand := range operands {
+		fmt.Fprintf(&out, " %d", operand)
+	}
+	return out.String()
+}
+
+func (instructions Instructions) String() string {
+	var out bytes.Buffer
+	for i, instruction := range instructions {
+		fmt.Fprintf(&out, "%04d %s\n", i, instruction)
+	}
+	return out.String()
+}
@@ -0,0 +1,456 @@
+package register
+
+import (
+	"fmt"
+	"writing-in-interpreter-in-go/src/monkey/code"
+	"writing-in-interpreter-in-go/src/monkey/object"
+)
+
+const RegisterFileSize = 65536
+const GlobalsSize = 65536
+const MaxFrames = 1024
+
+// frame is a function activation. Its registers start at base in the
+// register file; result is the caller's register receiving the return value.
+type frame struct {
+	closure *Closure
+	ip      int
+	base    int
+	result  int
+}
+
+type VirtualMachine struct {
+	constants  []object.Object
+	globals    []object.Object
+	registers  []object.Object
+	frames     []frame
+	lastPopped object.Object
+	// openUpvalues holds the upvalues still referring to the register file,
+	// ordered by the register they refer to.
+	openUpvalues []openUpvalue
+}
+
+type openUpvalue struct {
+	register int
+	upvalue  *object.Upvalue
+}
+
+func New(program *Program) *VirtualMachine {
+	frames := make([]frame, 1, MaxFrames)
+	frames[0] = frame{closure: &Closure{Function: program.Main}}
+	return &VirtualMachine{
+		constants: program.Constants,
+		globals:   make([]object.Object, GlobalsSize),
+		registers: make([]object.Object, RegisterFileSize),
+		frames:    frames,
+	}
+}
+
+func NewWithGlobalsStore(program *Program, globals []object.Object) *VirtualMachine {
+	virtualMachine := New(program)
+	virtualMachine.globals = globals
+	return virtualMachine
+}
+
+// LastPopped returns the value of the last top-level expression statement,
+// matching the stack machine's LastPopped.
+func (virtualMachine *VirtualMachine) LastPopped() object.Object {
+	return virtualMachine.lastPopped
+}
+
+func (virtualMachine *VirtualMachine) currentFrame() *frame {
+	return &virtualMachine.frames[len(virtualMachine.frames)-1]
+}
+
+// Run executes the main function until it halts or returns. Like the stack
+// machine it keeps the current frame's instructions, registers and ip in
+// locals and reloads them whenever another frame is entered or left.
+func (virtualMachine *VirtualMachine) Run() error {
+	if virtualMachine.currentFrame().closure.Function.RegisterArity > RegisterFileSize {
+		return fmt.Errorf("stack overflow")
+	}
+
+	frame := virtualMachine.currentFrame()
+	instructions := frame.closure.Function.Instructions
+	registers := virtualMachine.registers[frame.base:]
+	ip := frame.ip
+	defer func() {
+		frame.ip = ip
+	}()
+
+	for {
+		instruction := instructions[ip]
+		ip++
+		switch instruction.Opcode {
+		case OpLoadConstant:
+			registers[instruction.A] = virtualMachine.constants[instruction.B]
+		case OpLoadTrue:
+			registers[instruction.A] = object.TRUE
+		case OpLoadFalse:
+			registers[instruction.A] = object.FALSE
+		case OpLoadNull:
+			registers[instruction.A] = object.NULL
+		case OpMove:
+			if registers[instruction.B] == nil {
+				return errUnset()
+			}
+			registers[instruction.A] = registers[instruction.B]
+		case OpGetGlobal:
+			if virtualMachine.globals[instruction.B] == nil {
+				return errUnset()
+			}
+			registers[instruction.A] = virtualMachine.globals[instruction.B]
+		case OpSetGlobal:
+			if registers[instruction.B] == nil {
+				return errUnset()
+			}
+			virtualMachine.globals[instruction.A] = registers[instruction.B]
+		case OpGetBuiltin:
+			registers[instruction.A] = object.Builtins[instruction.B].Builtin
+		case OpGetFree:
+			value := frame.closure.FreeVariables[instruction.B].Get()
+			if value == nil {
+				return errUnset()
+			}
+			registers[instruction.A] = value
+		case OpCloseUpvalue:
+			virtualMachine.closeUpvalues(frame.base)
+		case OpCurrentClosure:
+			registers[instruction.A] = frame.closure
+		case OpAdd, OpSub, OpMul, OpDiv:
+			if registers[instruction.B] == nil || registers[instruction.C] == nil {
+				return errUnset()
+			}
+			result, err := executeBinaryOperation(instruction.Opcode, registers[instruction.B], registers[instruction.C])
+			if err != nil {
+				return err
+			}
+			registers[instruction.A] = result
+		case OpEqual, OpNotEqual, OpGreaterThan, OpLessThan, OpLessEqual, OpGreaterEqual:
+			if registers[instruction.B] == nil || registers[instruction.C] == nil {
+				return errUnset()
+			}
+			result, err := executeComparison(instruction.Opcode, registers[instruction.B], registers[instruction.C])
+			if err != nil {
+				return err
+			}
+			registers[instruction.A] = result
+		case OpNegate:
+			if registers[instruction.B] == nil {
+				return errUnset()
+			}
+			value, ok := registers[instruction.B].(*object.Integer)
+			if !ok {
+				return fmt.Errorf("unknown operator: -%s", registers[instruction.B].Type())
+			}
+			registers[instruction.A] = object.NewInteger(-value.Value)
+		case OpBang:
+			if registers[instruction.B] == nil {
+				return errUnset()
+			}
+			registers[instruction.A] = object.NativeBoolToBooleanObject(!object.IsTruthy(registers[instruction.B]))
+		case OpJump:
+			ip = instruction.A
+		case OpJumpIfFalse:
+			if registers[instruction.A] == nil {
+				return errUnset()
+			}
+			if !object.IsTruthy(registers[instruction.A]) {
+				ip = instruction.B
+			}
+		case OpArray:
+			elements := make([]object.Object, instruction.C)
+			copy(elements, registers[instruction.B:instruction.B+instruction.C])
+			if unset(elements) {
+				return errUnset()
+			}
+			registers[instruction.A] = &object.Array{Elements: elements}
+		case OpIndex:
+			if registers[instruction.B] == nil || registers[instruction.C] == nil {
+				return errUnset()
+			}
+			result, err := executeIndexExpression(registers[instruction.B], registers[instruction.C])
+			if err != nil {
+				return err
+			}
+			registers[instruction.A] = result
+		case OpClosure:
+			registers[instruction.A] = virtualMachine.newClosure(frame, instruction.B)
+		case OpCall, OpTailCall:
+			frame.ip = ip
+			var err error
+			if instruction.Opcode == OpTailCall {
+				err = virtualMachine.tailCall(instruction)
+			} else {
+				err = virtualMachine.call(instruction)
+			}
+			if err != nil {
+				return err
+			}
+			frame = virtualMachine.currentFrame()
+			instructions = frame.closure.Function.Instructions
+			registers = virtualMachine.registers[frame.base:]
+			ip = frame.ip
+		case OpReturn:
+			value := registers[instruction.A]
+			if value == nil {
+				return errUnset()
+			}
+			if len(virtualMachine.frames) == 1 {
+				virtualMachine.lastPopped = value
+				return nil
+			}
+			virtualMachine.registers[frame.result] = value
+			virtualMachine.frames = virtualMachine.frames[:len(virtualMachine.frames)-1]
+			frame = virtualMachine.currentFrame()
+			instructions = frame.closure.Function.Instructions
+			registers = virtualMachine.registers[frame.base:]
+			ip = frame.ip
+		case OpPop:
+			if registers[instruction.A] == nil {
+				return errUnset()
+			}
+			virtualMachine.lastPopped = registers[instruction.A]
+		case OpHalt:
+			return nil
+		default:
+			return fmt.Errorf("unknown opcode %d", instruction.Opcode)
+		}
+	}
+}
+
+// call enters the closure in register B of the current frame, whose
+// arguments become the first registers of the new frame. Builtins are
+// called directly and store their result in register A.
+func (virtualMachine *VirtualMachine) call(instruction Instruction) error {
+	caller := virtualMachine.currentFrame()
+	callee := virtualMachine.registers[caller.base+instruction.B]
+	arguments := caller.base + instruction.B + 1
+	if callee == nil || unset(virtualMachine.registers[arguments:arguments+instruction.C]) {
+		return errUnset()
+	}
+	switch callee := callee.(type) {
+	case *Closure:
+		if instruction.C != callee.Function.ParameterArity {
+			return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
+				callee.Function.ParameterArity, instruction.C)
+		}
+		if len(virtualMachine.frames) >= MaxFrames {
+			return fmt.Errorf("stack overflow: more than %d nested calls", MaxFrames-1)
+		}
+		if arguments+callee.Function.RegisterArity > RegisterFileSize {
+			return fmt.Errorf("stack overflow")
+		}
+		virtualMachine.frames = append(virtualMachine.frames, frame{
+			closure: callee,
+			base:    arguments,
+			result:  caller.base + instruction.A,
+		})
+		clearLocals(virtualMachine.registers[arguments:], callee.Function)
+		return nil
+	case *object.Builtin:
+		result := callee.Function(virtualMachine.registers[arguments : arguments+instruction.C]...)
+		if err, ok := result.(*object.Error); ok {
+			return fmt.Errorf("%s", err.Message)
+		}
+		if result == nil {
+			result = object.NULL
+		}
+		virtualMachine.registers[caller.base+instruction.A] = result
+		return nil
+	default:
+		return fmt.Errorf("not a function: %s", callee.Type())
+	}
+}
+
+// tailCall runs a closure in the current frame, so recursion in tail
+// position runs in constant space. Other callees, and calls from the main
+// frame, are made as usual.
+func (virtualMachine *VirtualMachine) tailCall(instruction Instruction) error {
+	current := virtualMachine.currentFrame()
+	closure, ok := virtualMachine.registers[current.base+instruction.B].(*Closure)
+	if !ok || len(virtualMachine.frames) == 1 {
+		return virtualMachine.call(instruction)
+	}
+	if instruction.C != closure.Function.ParameterArity {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
+			closure.Function.ParameterArity, instruction.C)
+	}
+	if current.base+closure.Function.RegisterArity > RegisterFileSize {
+		return fmt.Errorf("stack overflow")
+	}
+	arguments := current.base + instruction.B + 1
+	if unset(virtualMachine.registers[arguments : arguments+instruction.C]) {
+		return errUnset()
+	}
+	copy(virtualMachine.registers[current.base:], virtualMachine.registers[arguments:arguments+instruction.C])
+	current.closure = closure
+	current.ip = 0
+	clearLocals(virtualMachine.registers[current.base:], closure.Function)
+	return nil
+}
+
+// clearLocals unsets the let bindings of function in registers, so that
+// reading one before it is set fails rather than returning a value left by
+// an earlier call.
+func clearLocals(registers []object.Object, function *Function) {
+	clear(registers[function.ParameterArity:function.LocalVariableArity])
+}
+
+// unset reports whether any of values is a binding that was never set.
+func unset(values []object.Object) bool {
+	for _, value := range values {
+		if value == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func errUnset() error {
+	return fmt.Errorf("variable used before it is set")
+}
+
+func (virtualMachine *VirtualMachine) newClosure(frame *frame, constantIndex int) *Closure {
+	function := virtualMachine.constants[constantIndex].(*Function)
+	freeVariables := make([]*object.Upvalue, len(function.Captures))
+	for i, capture := range function.Captures {
+		switch capture.Kind {
+		case object.CaptureLocal:
+			freeVariables[i] = virtualMachine.captureUpvalue(frame.base + capture.Index)
+		case object.CaptureFree:
+			freeVariables[i] = frame.closure.FreeVariables[capture.Index]
+		case object.CaptureClosure:
+			freeVariables[i] = object.NewClosedUpvalue(frame.closure)
+		}
+	}
+	return &Closure{Function: function, FreeVariables: freeVariables}
+}
+
+func (virtualMachine *VirtualMachine) captureUpvalue(register int) *object.Upvalue {
+	position := len(virtualMachine.openUpvalues)
+	for position > 0 && virtualMachine.openUpvalues[position-1].register >= register {
+		if virtualMachine.openUpvalues[position-1].register == register {
+			return virtualMachine.openUpvalues[position-1].upvalue
+		}
+		position--
+	}
+	upvalue := &object.Upvalue{Location: &virtualMachine.registers[register]}
+	virtualMachine.openUpvalues = append(virtualMachine.openUpvalues, openUpvalue{})
+	copy(virtualMachine.openUpvalues[position+1:], virtualMachine.openUpvalues[position:])
+	virtualMachine.openUpvalues[position] = openUpvalue{register: register, upvalue: upvalue}
+	return upvalue
+}
+
+// closeUpvalues closes the open upvalues referring to register or above.
+func (virtualMachine *VirtualMachine) closeUpvalues(register int) {
+	open := virtualMachine.openUpvalues
+	for len(open) > 0 && open[len(open)-1].register >= register {
+		open[len(open)-1].upvalue.Close()
+		open = open[:len(open)-1]
+	}
+	virtualMachine.openUpvalues = open
+}
+
+func executeBinaryOperation(opcode Opcode, left, right object.Object) (object.Object, error) {
+	switch left := left.(type) {
+	case *object.Integer:
+		if right, ok := right.(*object.Integer); ok {
+			return executeBinaryIntegerOperation(opcode, left.Value, right.Value)
+		}
+	case *object.String:
+		if right, ok := right.(*object.String); ok {
+			if opcode != OpAdd {
+				return nil, fmt.Errorf("unknown operator: STRING %s STRING", operators[opcode])
+			}
+			return &object.String{Value: left.Value + right.Value}, nil
+		}
+	}
+	return nil, fmt.Errorf("type mismatch: %s %s %s", left.Type(), operators[opcode], right.Type())
+}
+
+func executeBinaryIntegerOperation(opcode Opcode, left, right int64) (object.Object, error) {
+	switch opcode {
+	case OpAdd:
+		return object.NewInteger(left + right), nil
+	case OpSub:
+		return object.NewInteger(left - right), nil
+	case OpMul:
+		return object.NewInteger(left * right), nil
+	case OpDiv:
+		if right == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return object.NewInteger(left / right), nil
+	default:
+		return nil, fmt.Errorf("unknown integer operator: %d", opcode)
+	}
+}
+
+func executeComparison(opcode Opcode, left, right object.Object) (object.Object, error) {
+	switch left := left.(type) {
+	case *object.Integer:
+		if right, ok := right.(*object.Integer); ok {
+			return compare(opcode, left.Value, right.Value), nil
+		}
+	case *object.String:
+		if right, ok := right.(*object.String); ok {
+			return compare(opcode, left.Value, right.Value), nil
+		}
+	}
+	switch opcode {
+	case OpEqual:
+		return object.NativeBoolToBooleanObject(left == right), nil
+	case OpNotEqual:
+		return object.NativeBoolToBooleanObject(left != right), nil
+	default:
+		return nil, fmt.Errorf("type mismatch: %s %s %s", left.Type(), operators[opcode], right.Type())
+	}
+}
+
+func compare[T int64 | string](opcode Opcode, left, right T) object.Object {
+	switch opcode {
+	case OpEqual:
+		return object.NativeBoolToBooleanObject(left == right)
+	case OpNotEqual:
+		return object.NativeBoolToBooleanObject(left != right)
+	case OpGreaterThan:
+		return object.NativeBoolToBooleanObject(left > right)
+	case OpLessThan:
+		return object.NativeBoolToBooleanObject(left < right)
+	case OpLessEqual:
+		return object.NativeBoolToBooleanObject(left <= right)
+	default:
+		return object.NativeBoolToBooleanObject(left >= right)
+	}
+}
+
+func executeIndexExpression(expression, index object.Object) (object.Object, error) {
+	array, ok := expression.(*object.Array)
+	integer, isInteger := index.(*object.Integer)
+	if !ok || !isInteger {
+		return nil, fmt.Errorf("index operator not supported: %s", expression.Type())
+	}
+	if integer.Value < 0 || integer.Value >= int64(len(array.Elements)) {
+		return object.NULL, nil
+	}
+	return array.Elements[integer.Value], nil
+}
+
+// operators maps the opcodes of binary operations to the operators they
+// implement, for error messages. They are those of the stack machine's
+// opcodes of the same name.
+var operators = func() map[Opcode]string {
+	byName := make(map[string]string, len(code.Operators))
+	for opcode, operator := range code.Operators {
+		definition, _ := code.LookUp(byte(opcode))
+		byName[definition.Name] = operator
+	}
+	operators := make(map[Opcode]string)
+	for opcode, definition := range definitions {
+		if operator, ok := byName[definition.Name]; ok {
+			operators[opcode] = operator
+		}
+	}
+	return operators
+}()
@@ -0,0 +1,174 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"writing-in-interpreter-in-go/src/monkey/code"
+	"writing-in-interpreter-in-go/src/monkey/object"
+)
+
+// Tracer is told what a virtual machine does as it runs, to log it, measure
+// it or limit it. An error returned by OnInstruction or OnCall stops the
+// program: Run returns it as a *RuntimeError.
+type Tracer interface {
+	// OnInstruction is called before each instruction is executed. stack
+	// holds the values the current call has pushed, the top last, and is
+	// only valid until OnInstruction returns.
+	OnInstruction(instruction Instruction, stack []object.Object) error
+	// OnCall is called when a closure or builtin is called. depth is the
+	// number of calls in progress with this one, the top level being the
+	// first. A tail call replaces the call it is made from, so it has the
+	// same depth and the call it replaces gets no OnReturn.
+	OnCall(function object.Object, arguments []object.Object, depth int) error
+	// OnReturn is called when a call returns value.
+	OnReturn(function object.Object, value object.Object, depth int)
+	// OnError is called with the error Run or Call returns.
+	OnError(err *RuntimeError)
+}
+
+// Instruction is an instruction about to be executed. Instructions prefixed
+// with OpWide are given with their own opcode and their widened operands.
+type Instruction struct {
+	// Function names the function the instruction is in, "main" at the top
+	// level.
+	Function string
+	Offset   int
+	Opcode   code.Opcode
+	Operands []int
+	Position code.Position
+	Depth    int
+}
+
+func (instruction Instruction) String() string {
+	name := fmt.Sprintf("Op%d", instruction.Opcode)
+	if definition, err := code.LookUp(byte(instruction.Opcode)); err == nil {
+		name = definition.Name
+	}
+	for _, operand := range instruction.Operands {
+		name += fmt.Sprintf(" %d", operand)
+	}
+	return name
+}
+
+// SetTracer makes Run and Call report what the virtual machine does to
+// tracer.
+func (virtualMachine *VirtualMachine) SetTracer(tracer Tracer) {
+	virtualMachine.tracer = tracer
+}
+
+// traceInstruction reports the instruction at ip in the current frame.
+func (virtualMachine *VirtualMachine) traceInstruction(ip int) error {
+	frame := virtualMachine.currentFrame()
+	function := frame.closure.Function
+	decoded, err := code.ReadInstruction(function.Instructions, ip)
+	if err != nil {
+		return err
+	}
+
+	name := "main"
+	if virtualMachine.framesIndex > 1 {
+		name = closureName(function)
+	}
+	instruction := Instruction{
+		Function: name,
+		Offset:   ip,
+		Opcode:   decoded.Opcode,
+		Operands: decoded.Operands,
+		Position: function.SourceMap.PositionAt(ip),
+		Depth:    virtualMachine.framesIndex,
+	}
+	bottom := min(frame.basePointer+function.LocalVariableArity, virtualMachine.sp)
+	return virtualMachine.tracer.OnInstruction(instruction, virtualMachine.stack[bottom:virtualMachine.sp])
+}
+
+func (virtualMachine *VirtualMachine) traceCall(function object.Object, arguments []object.Object, depth int) error {
+	if virtualMachine.tracer == nil {
+		return nil
+	}
+	return virtualMachine.tracer.OnCall(function, arguments, depth)
+}
+
+func (virtualMachine *VirtualMachine) traceReturn(function object.Object, value object.Object, depth int) {
+	if virtualMachine.tracer != nil {
+		virtualMachine.tracer.OnReturn(function, value, depth)
+	}
+}
+
+// traceError reports err, which locate returned, and returns it.
+func (virtualMachine *VirtualMachine) traceError(err error) error {
+	if runtimeError, ok := err.(*RuntimeError); ok && virtualMachine.tracer != nil {
+		virtualMachine.tracer.OnError(runtimeError)
+	}
+	return err
+}
+
+// calleeName names a closure or builtin in traces.
+func calleeName(function object.Object) string {
+	switch function := function.(type) {
+	case *object.Closure:
+		return closureName(function.Function)
+	case *object.Builtin:
+		for _, builtin := range object.Builtins {
+			if builtin.Builtin == function {
+				return builtin.Name
+			}
+		}
+		return "builtin"
+	}
+	return function.Inspect()
+}
+
+// traceWriter writes a trace a line per event, indented by call depth.
+type traceWriter struct {
+	out io.Writer
+}
+
+// NewTraceWriter returns a tracer writing every instruction, with the stack
+// of its call, and every call, return and error to out.
+func NewTraceWriter(out io.Writer) Tracer {
+	return &traceWriter{out: out}
+}
+
+func (writer *traceWriter) OnInstruction(instruction Instruction, stack []object.Object) error {
+	position := "-"
+	if instruction.Position.Line > 0 {
+		position = fmt.Sprintf("%d:%d", instruction.Position.Line, instruction.Position.Column)
+	}
+	_, err := fmt.Fprintf(writer.out, "%s%s %04d %-24s %-7s [%s]\n", indent(instruction.Depth),
+		instruction.Function, instruction.Offset, instruction, position, inspectAll(stack))
+	return err
+}
+
+func (writer *traceWriter) OnCall(function object.Object, arguments []object.Object, depth int) error {
+	_, err := fmt.Fprintf(writer.out, "%s-> %s(%s)\n", indent(depth), calleeName(function), inspectAll(arguments))
+	return err
+}
+
+func (writer *traceWriter) OnReturn(function object.Object, value object.Object, depth int) {
+	_, _ = fmt.Fprintf(writer.out, "%s<- %s = %s\n", indent(depth), calleeName(function), value.Inspect())
+}
+
+func (writer *traceWriter) OnError(err *RuntimeError) {
+	if err.Position.Line > 0 {
+		_, _ = fmt.Fprintf(writer.out, "error at %d:%d: %s\n", err.Position.Line, err.Position.Column, err.Err)
+		return
+	}
+	_, _ = fmt.Fprintf(writer.out, "error: %s\n", err.Err)
+}
+
+func indent(depth int) string {
+	return strings.Repeat("  ", max(depth-1, 0))
+}
+
+func inspectAll(values []object.Object) string {
+	inspected := make([]string, len(values))
+	for i, value := range values {
+		if value == nil {
+			inspected[i] = "<unset>"
+			continue
+		}
+		inspected[i] = value.Inspect()
+	}
+	return strings.Join(inspected, ", ")
+}
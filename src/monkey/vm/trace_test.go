@@ -0,0 +1,119 @@
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"writing-in-interpreter-in-go/src/monkey/compiler"
+	"writing-in-interpreter-in-go/src/monkey/object"
+)
+
+// recordingTracer records calls, returns and errors, and fails the program
+// once it has seen limit instructions, when limit is positive.
+type recordingTracer struct {
+	events       []string
+	instructions int
+	limit        int
+}
+
+var errLimit = errors.New("instruction limit reached")
+
+func (tracer *recordingTracer) OnInstruction(instruction Instruction, stack []object.Object) error {
+	tracer.instructions++
+	if tracer.limit > 0 && tracer.instructions >= tracer.limit {
+		return errLimit
+	}
+	return nil
+}
+
+func (tracer *recordingTracer) OnCall(function object.Object, arguments []object.Object, depth int) error {
+	tracer.events = append(tracer.events, fmt.Sprintf("call %s(%s) %d", calleeName(function), inspectAll(arguments), depth))
+	return nil
+}
+
+func (tracer *recordingTracer) OnReturn(function object.Object, value object.Object, depth int) {
+	tracer.events = append(tracer.events, fmt.Sprintf("return %s %s %d", calleeName(function), value.Inspect(), depth))
+}
+
+func (tracer *recordingTracer) OnError(err *RuntimeError) {
+	tracer.events = append(tracer.events, fmt.Sprintf("error %d:%d %s", err.Position.Line, err.Position.Column, err.Err))
+}
+
+func runTraced(t *testing.T, input string, tracer Tracer) error {
+	t.Helper()
+	c := compiler.New()
+	err := c.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	virtualMachine := New(c.ByteCode())
+	virtualMachine.SetTracer(tracer)
+	return virtualMachine.Run()
+}
+
+func TestTracer(t *testing.T) {
+	input := `let count = fn(n) { if (n == 0) { len("ab") } else { count(n - 1) } };
+let twice = fn(x) { count(x) * 2 };
+twice(1);
+twice(first([]) + 1);`
+	tracer := &recordingTracer{}
+	err := runTraced(t, input, tracer)
+	if err == nil {
+		t.Fatal("expected a runtime error")
+	}
+	expected := []string{
+		"call twice(1) 2",
+		"call count(1) 3",
+		// The tail call replaces count(1).
+		"call count(0) 3",
+		"call len(ab) 4",
+		"return len 2 4",
+		"return count 2 3",
+		"return twice 4 2",
+		"call first([]) 2",
+		"return first null 2",
+		"error 4:17 type mismatch: NULL_OBJ + INTEGER",
+	}
+	if !reflect.DeepEqual(tracer.events, expected) {
+		t.Errorf("wrong events.\nwant=%q\ngot=%q", expected, tracer.events)
+	}
+	if tracer.instructions == 0 {
+		t.Errorf("no instructions traced")
+	}
+}
+
+func TestTracerLimit(t *testing.T) {
+	tracer := &recordingTracer{limit: 100}
+	err := runTraced(t, "let loop = fn(n) { loop(n + 1) }; loop(0);", tracer)
+	if !errors.Is(err, errLimit) {
+		t.Fatalf("wrong error. want=%s, got=%v", errLimit, err)
+	}
+	if tracer.instructions != 100 {
+		t.Errorf("wrong number of instructions. want=100, got=%d", tracer.instructions)
+	}
+}
+
+func TestTraceWriter(t *testing.T) {
+	var out bytes.Buffer
+	err := runTraced(t, "let add = fn(a, b) { a + b };\nlen([add(1, 2)]);", NewTraceWriter(&out))
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	expected := `  -> add(1, 2)
+  add 0000 OpGetLocal 0             1:22    []
+  add 0002 OpGetLocal 1             1:26    [1]
+  add 0004 OpAdd                    1:24    [1, 2]
+  add 0005 OpReturnValue            1:22    [3]
+  <- add = 3
+main 0019 OpArray 1                2:5     [builtin function, 3]
+main 0022 OpCall 1                 2:1     [builtin function, [3]]
+  -> len([3])
+  <- len = 1
+`
+	if !strings.Contains(out.String(), expected) {
+		t.Errorf("trace does not contain\n%s\ngot\n%s", expected, out.String())
+	}
+}
@@ -0,0 +1,332 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"writing-in-interpreter-in-go/src/monkey/code"
+	"writing-in-interpreter-in-go/src/monkey/compiler"
+	"writing-in-interpreter-in-go/src/monkey/object"
+)
+
+// Load reads a bytecode file and verifies it, so that it can be run safely.
+func Load(r io.Reader) (*compiler.ByteCode, error) {
+	byteCode, err := compiler.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	err = Verify(byteCode)
+	if err != nil {
+		return nil, err
+	}
+	return byteCode, nil
+}
+
+// Verify checks that Run can execute byteCode without failing on anything
+// but the values it computes: every opcode is defined and complete, every
+// constant, global, local, free variable and builtin it names exists, jumps
+// go forward and land on instructions, and each instruction is reached with
+// the same number of values on the stack whichever way control comes to it.
+// Source maps must map instructions, in order.
+func Verify(byteCode *compiler.ByteCode) error {
+	mainFunction := &object.CompiledFunction{Instructions: byteCode.Instructions, SourceMap: byteCode.SourceMap}
+	err := verifyFunction("main", mainFunction, true, byteCode.Constants)
+	if err != nil {
+		return err
+	}
+	for index, constant := range byteCode.Constants {
+		switch constant := constant.(type) {
+		case *object.Integer, *object.String:
+		case *object.CompiledFunction:
+			err := verifyFunction(fmt.Sprintf("constant %d", index), constant, false, byteCode.Constants)
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("constant %d: unexpected %s constant", index, constant.Type())
+		}
+	}
+	return nil
+}
+
+// instruction is a decoded instruction and where it starts.
+type instruction struct {
+	position int
+	opcode   code.Opcode
+	operands []int
+}
+
+type verifier struct {
+	name      string
+	function  *object.CompiledFunction
+	isMain    bool
+	constants []object.Object
+}
+
+func verifyFunction(name string, function *object.CompiledFunction, isMain bool, constants []object.Object) error {
+	if function.ParameterArity < 0 || function.LocalVariableArity < function.ParameterArity {
+		return fmt.Errorf("%s: %d parameters but %d locals", name, function.ParameterArity, function.LocalVariableArity)
+	}
+	if function.LocalVariableArity >= StackSize {
+		return fmt.Errorf("%s: %d locals do not fit the stack", name, function.LocalVariableArity)
+	}
+	verifier := &verifier{name: name, function: function, isMain: isMain, constants: constants}
+	instructions, err := verifier.decode()
+	if err != nil {
+		return err
+	}
+	err = verifier.checkSourceMap(instructions)
+	if err != nil {
+		return err
+	}
+	for _, instruction := range instructions {
+		err := verifier.checkOperands(instruction)
+		if err != nil {
+			return verifier.errorAt(instruction, err)
+		}
+	}
+	return verifier.checkStack(instructions)
+}
+
+func (verifier *verifier) errorAt(instruction instruction, err error) error {
+	definition, _ := code.LookUp(byte(instruction.opcode))
+	return fmt.Errorf("%s: %04d %s: %s", verifier.name, instruction.position, definition.Name, err)
+}
+
+// wideOpcodes are the instructions executeWide supports.
+var wideOpcodes = map[code.Opcode]bool{
+	code.OpConstant:   true,
+	code.OpGetGlobal:  true,
+	code.OpSetGlobal:  true,
+	code.OpGetLocal:   true,
+	code.OpSetLocal:   true,
+	code.OpGetBuiltin: true,
+	code.OpGetFree:    true,
+	code.OpArray:      true,
+	code.OpClosure:    true,
+	code.OpCall:       true,
+	code.OpTailCall:   true,
+}
+
+func (verifier *verifier) decode() ([]instruction, error) {
+	bytes := verifier.function.Instructions
+	var instructions []instruction
+	for ip := 0; ip < len(bytes); {
+		decoded, err := code.ReadInstruction(bytes, ip)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %04d: %s", verifier.name, ip, err)
+		}
+		if decoded.Wide && !wideOpcodes[decoded.Opcode] {
+			return nil, fmt.Errorf("%s: %04d: %s has no wide form", verifier.name, ip, decoded.Definition.Name)
+		}
+		instructions = append(instructions, instruction{position: ip, opcode: decoded.Opcode, operands: decoded.Operands})
+		ip += decoded.Width
+	}
+	return instructions, nil
+}
+
+func (verifier *verifier) checkSourceMap(instructions []instruction) error {
+	starts := make(map[int]bool, len(instructions))
+	for _, instruction := range instructions {
+		starts[instruction.position] = true
+	}
+	previous := -1
+	for _, mapping := range verifier.function.SourceMap {
+		if mapping.Offset <= previous {
+			return fmt.Errorf("%s: source map: offset %d out of order", verifier.name, mapping.Offset)
+		}
+		if !starts[mapping.Offset] {
+			return fmt.Errorf("%s: source map: offset %d is not an instruction", verifier.name, mapping.Offset)
+		}
+		previous = mapping.Offset
+	}
+	return nil
+}
+
+func (verifier *verifier) checkOperands(instruction instruction) error {
+	operands := instruction.operands
+	switch instruction.opcode {
+	case code.OpConstant:
+		return verifier.checkConstant(operands[0])
+	case code.OpGetLocalConst:
+		err := verifier.checkLocal(operands[0])
+		if err != nil {
+			return err
+		}
+		return verifier.checkConstant(operands[1])
+	case code.OpGetGlobal, code.OpSetGlobal:
+		if operands[0] >= GlobalsSize {
+			return fmt.Errorf("global %d out of range", operands[0])
+		}
+	case code.OpGetLocal, code.OpSetLocal:
+		return verifier.checkLocal(operands[0])
+	case code.OpAddLocals:
+		err := verifier.checkLocal(operands[0])
+		if err != nil {
+			return err
+		}
+		return verifier.checkLocal(operands[1])
+	case code.OpGetFree:
+		if operands[0] >= len(verifier.function.Captures) {
+			return fmt.Errorf("free variable %d out of range (function has %d)",
+				operands[0], len(verifier.function.Captures))
+		}
+	case code.OpGetBuiltin:
+		if operands[0] >= len(object.Builtins) {
+			return fmt.Errorf("builtin %d out of range", operands[0])
+		}
+	case code.OpClosure:
+		return verifier.checkClosure(operands[0])
+	case code.OpJump, code.OpJumpIfFalse, code.OpJumpIfNotEqual, code.OpJumpIfNotGreater, code.OpJumpIfNotLess:
+		if operands[0] > len(verifier.function.Instructions) {
+			return fmt.Errorf("jump target %d out of range", operands[0])
+		}
+		// The compiler only jumps forward, which closeUpvalues relies on and
+		// which guarantees that every frame finishes.
+		if operands[0] <= instruction.position {
+			return fmt.Errorf("jump target %d is not after the jump", operands[0])
+		}
+	}
+	return nil
+}
+
+func (verifier *verifier) checkConstant(index int) error {
+	if index >= len(verifier.constants) {
+		return fmt.Errorf("constant %d out of range (pool has %d)", index, len(verifier.constants))
+	}
+	return nil
+}
+
+func (verifier *verifier) checkLocal(index int) error {
+	if index >= verifier.function.LocalVariableArity {
+		return fmt.Errorf("local %d out of range (function has %d)", index, verifier.function.LocalVariableArity)
+	}
+	return nil
+}
+
+// checkClosure checks that a closure over function constant index can be
+// created in the function being verified: every capture names one of its
+// locals or free variables.
+func (verifier *verifier) checkClosure(index int) error {
+	err := verifier.checkConstant(index)
+	if err != nil {
+		return err
+	}
+	function, ok := verifier.constants[index].(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("constant %d is not a function", index)
+	}
+	for _, capture := range function.Captures {
+		switch capture.Kind {
+		case object.CaptureLocal:
+			err := verifier.checkLocal(capture.Index)
+			if err != nil {
+				return fmt.Errorf("captured %s", err)
+			}
+		case object.CaptureFree:
+			if capture.Index >= len(verifier.function.Captures) {
+				return fmt.Errorf("captured free variable %d out of range (function has %d)",
+					capture.Index, len(verifier.function.Captures))
+			}
+		case object.CaptureClosure:
+		default:
+			return fmt.Errorf("unknown capture kind %d", capture.Kind)
+		}
+	}
+	return nil
+}
+
+// stackEffect returns how many values an instruction pops and pushes.
+func stackEffect(instruction instruction) (int, int) {
+	switch instruction.opcode {
+	case code.OpPop, code.OpSetGlobal, code.OpSetLocal, code.OpJumpIfFalse, code.OpReturnValue:
+		return 1, 0
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpEqual, code.OpNotEqual, code.OpGreaterThan,
+		code.OpLessThan, code.OpLessEqual, code.OpGreaterEqual, code.OpIndex:
+		return 2, 1
+	case code.OpNegate, code.OpBang:
+		return 1, 1
+	case code.OpJumpIfNotEqual, code.OpJumpIfNotGreater, code.OpJumpIfNotLess:
+		return 2, 0
+	case code.OpArray:
+		return instruction.operands[0], 1
+	case code.OpCall, code.OpTailCall:
+		return instruction.operands[0] + 1, 1
+	case code.OpGetLocalConst:
+		return 0, 2
+	case code.OpJump, code.OpReturnVoid, code.OpCloseUpvalue:
+		return 0, 0
+	default:
+		return 0, 1
+	}
+}
+
+// successors returns the positions control can reach after instruction,
+// next being the position of the instruction that follows it.
+func successors(instruction instruction, next int) []int {
+	switch instruction.opcode {
+	case code.OpJump:
+		return []int{instruction.operands[0]}
+	case code.OpJumpIfFalse, code.OpJumpIfNotEqual, code.OpJumpIfNotGreater, code.OpJumpIfNotLess:
+		return []int{next, instruction.operands[0]}
+	case code.OpReturnValue, code.OpReturnVoid:
+		return nil
+	default:
+		return []int{next}
+	}
+}
+
+// checkStack follows every path through the instructions from the first,
+// checking that no instruction pops more values than the function pushed,
+// that each instruction and the end of main are reached with one stack
+// height, and that functions return rather than run off their end.
+func (verifier *verifier) checkStack(instructions []instruction) error {
+	end := len(verifier.function.Instructions)
+	indexes := make(map[int]int, len(instructions)+1)
+	for i, instruction := range instructions {
+		indexes[instruction.position] = i
+	}
+	indexes[end] = len(instructions)
+
+	heights := make([]int, len(instructions)+1)
+	for i := range heights {
+		heights[i] = -1
+	}
+	heights[0] = 0
+	work := []int{0}
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+		if i == len(instructions) {
+			if !verifier.isMain {
+				return fmt.Errorf("%s: control reaches the end of the function", verifier.name)
+			}
+			continue
+		}
+		instruction := instructions[i]
+		pops, pushes := stackEffect(instruction)
+		if pops > heights[i] {
+			return verifier.errorAt(instruction, fmt.Errorf("pops %d values from a stack of %d", pops, heights[i]))
+		}
+		height := heights[i] - pops + pushes
+		next := end
+		if i+1 < len(instructions) {
+			next = instructions[i+1].position
+		}
+		for _, successor := range successors(instruction, next) {
+			j, ok := indexes[successor]
+			if !ok {
+				return verifier.errorAt(instruction, fmt.Errorf("jump target %d is inside an instruction", successor))
+			}
+			switch heights[j] {
+			case -1:
+				heights[j] = height
+				work = append(work, j)
+			case height:
+			default:
+				return verifier.errorAt(instruction, fmt.Errorf("reaches %04d with %d values on the stack instead of %d",
+					successor, height, heights[j]))
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package vm
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"writing-in-interpreter-in-go/src/monkey/code"
+	"writing-in-interpreter-in-go/src/monkey/compiler"
+	"writing-in-interpreter-in-go/src/monkey/object"
+)
+
+func concat(instructions ...code.Instructions) code.Instructions {
+	out := code.Instructions{}
+	for _, instruction := range instructions {
+		out = append(out, instruction...)
+	}
+	return out
+}
+
+func TestVerify(t *testing.T) {
+	integer := &object.Integer{Value: 1}
+	function := func(parameters, locals int, captures []object.Capture, instructions ...code.Instructions) *object.CompiledFunction {
+		return &object.CompiledFunction{
+			Instructions:       concat(instructions...),
+			ParameterArity:     parameters,
+			LocalVariableArity: locals,
+			Captures:           captures,
+		}
+	}
+
+	tests := []struct {
+		byteCode *compiler.ByteCode
+		expected string
+	}{
+		{
+			&compiler.ByteCode{
+				Instructions: concat(code.Make(code.OpConstant, 0), code.Make(code.OpJumpIfFalse, 10),
+					code.Make(code.OpConstant, 0), code.Make(code.OpPop)),
+				Constants: []object.Object{integer},
+			},
+			"",
+		},
+		{
+			&compiler.ByteCode{Instructions: code.Instructions{255}},
+			"main: 0000: opcode 255 undefined",
+		},
+		{
+			&compiler.ByteCode{Instructions: code.Make(code.OpConstant, 1)[:2]},
+			"main: 0000: truncated OpConstant",
+		},
+		{
+			&compiler.ByteCode{Instructions: concat(code.Make(code.OpWide), code.Make(code.OpPop))},
+			"main: 0000: OpPop has no wide form",
+		},
+		{
+			&compiler.ByteCode{Instructions: concat(code.Make(code.OpConstant, 1), code.Make(code.OpPop)),
+				Constants: []object.Object{integer}},
+			"main: 0000 OpConstant: constant 1 out of range (pool has 1)",
+		},
+		{
+			&compiler.ByteCode{Instructions: concat(code.Make(code.OpGetLocal, 0), code.Make(code.OpPop))},
+			"main: 0000 OpGetLocal: local 0 out of range (function has 0)",
+		},
+		{
+			&compiler.ByteCode{Instructions: concat(code.Make(code.OpGetBuiltin, 200), code.Make(code.OpPop))},
+			"main: 0000 OpGetBuiltin: builtin 200 out of range",
+		},
+		{
+			&compiler.ByteCode{Instructions: concat(code.Make(code.OpClosure, 0), code.Make(code.OpPop)),
+				Constants: []object.Object{integer}},
+			"main: 0000 OpClosure: constant 0 is not a function",
+		},
+		{
+			&compiler.ByteCode{
+				Instructions: concat(code.Make(code.OpClosure, 0), code.Make(code.OpPop)),
+				Constants: []object.Object{
+					function(0, 0, []object.Capture{{Kind: object.CaptureLocal, Index: 0}},
+						code.Make(code.OpGetFree, 0), code.Make(code.OpReturnValue)),
+				},
+			},
+			"main: 0000 OpClosure: captured local 0 out of range (function has 0)",
+		},
+		{
+			&compiler.ByteCode{
+				Instructions: code.Instructions{},
+				Constants:    []object.Object{function(0, 0, nil, code.Make(code.OpGetFree, 0), code.Make(code.OpReturnValue))},
+			},
+			"constant 0: 0000 OpGetFree: free variable 0 out of range (function has 0)",
+		},
+		{
+			&compiler.ByteCode{Instructions: concat(code.Make(code.OpJump, 100), code.Make(code.OpNull), code.Make(code.OpPop))},
+			"main: 0000 OpJump: jump target 100 out of range",
+		},
+		{
+			&compiler.ByteCode{Instructions: concat(code.Make(code.OpJump, 1), code.Make(code.OpNull), code.Make(code.OpPop))},
+			"main: 0000 OpJump: jump target 1 is inside an instruction",
+		},
+		{
+			&compiler.ByteCode{Instructions: concat(code.Make(code.OpNull), code.Make(code.OpPop), code.Make(code.OpJump, 0))},
+			"main: 0002 OpJump: jump target 0 is not after the jump",
+		},
+		{
+			&compiler.ByteCode{Instructions: code.Make(code.OpPop)},
+			"main: 0000 OpPop: pops 1 values from a stack of 0",
+		},
+		{
+			// The branch taken leaves a value on the stack, the other not.
+			&compiler.ByteCode{
+				Instructions: concat(code.Make(code.OpTrue), code.Make(code.OpJumpIfFalse, 8), code.Make(code.OpNull),
+					code.Make(code.OpJump, 8), code.Make(code.OpNull), code.Make(code.OpPop)),
+			},
+			"main: 0005 OpJump: reaches 0008 with 1 values on the stack instead of 0",
+		},
+		{
+			&compiler.ByteCode{
+				Instructions: code.Instructions{},
+				Constants:    []object.Object{function(0, 0, nil, code.Make(code.OpNull), code.Make(code.OpPop))},
+			},
+			"constant 0: control reaches the end of the function",
+		},
+		{
+			&compiler.ByteCode{
+				Instructions: code.Instructions{},
+				Constants:    []object.Object{function(2, 1, nil, code.Make(code.OpReturnVoid))},
+			},
+			"constant 0: 2 parameters but 1 locals",
+		},
+		{
+			&compiler.ByteCode{
+				Instructions: concat(code.Make(code.OpConstant, 0), code.Make(code.OpPop)),
+				Constants:    []object.Object{integer},
+				SourceMap:    code.SourceMap{{Offset: 0}, {Offset: 1}},
+			},
+			"main: source map: offset 1 is not an instruction",
+		},
+		{
+			&compiler.ByteCode{
+				Instructions: concat(code.Make(code.OpConstant, 0), code.Make(code.OpPop)),
+				Constants:    []object.Object{integer},
+				SourceMap:    code.SourceMap{{Offset: 3}, {Offset: 0}},
+			},
+			"main: source map: offset 0 out of order",
+		},
+	}
+
+	for _, tt := range tests {
+		err := Verify(tt.byteCode)
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("valid bytecode rejected: %s", err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error.\nwant=%q\ngot =%v", tt.expected, err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	comp := compiler.New()
+	err := comp.Compile(parse("let add = fn(a) { fn(b) { a + b } }; add(1)(2)"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	var file bytes.Buffer
+	err = comp.ByteCode().Encode(&file)
+	if err != nil {
+		t.Fatalf("encoding failed: %s", err)
+	}
+
+	byteCode, err := Load(bytes.NewReader(file.Bytes()))
+	if err != nil {
+		t.Fatalf("loading failed: %s", err)
+	}
+	vm := New(byteCode)
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 3, vm.LastPopped())
+
+	corrupt := &compiler.ByteCode{Instructions: code.Make(code.OpPop)}
+	file.Reset()
+	_ = corrupt.Encode(&file)
+	_, err = Load(&file)
+	if err == nil || !strings.Contains(err.Error(), "pops 1 values") {
+		t.Errorf("corrupt bytecode loaded. err=%v", err)
+	}
+}
+
+// FuzzLoad checks that bytecode passing verification runs to completion or
+// fails with an error, however it was produced.
+func FuzzLoad(f *testing.F) {
+	for _, input := range []string{
+		"let add = fn(x, y) { x + y; }; puts(add(1, 2));",
+		"let f = fn(n) { if (n < 2) { return n; } f(n - 1) + f(n - 2) }; f(10)",
+		"let a = 1; let g = fn() { let a = a + 1; fn() { a } }; g()()",
+		`[1, "two", len("three")][-1]`,
+	} {
+		for _, level := range []int{0, 2} {
+			comp := compiler.New()
+			comp.SetOptimizationLevel(level)
+			if comp.Compile(parse(input)) != nil {
+				f.Fatalf("compiler error on %q", input)
+			}
+			var file bytes.Buffer
+			_ = comp.ByteCode().Encode(&file)
+			f.Add(file.Bytes())
+		}
+	}
+	output := object.Output
+	object.Output = io.Discard
+	defer func() { object.Output = output }()
+	f.Fuzz(func(t *testing.T, file []byte) {
+		byteCode, err := Load(bytes.NewReader(file))
+		if err != nil {
+			return
+		}
+		vm := New(byteCode)
+		vm.callBudget = 10000
+		_ = vm.Run()
+	})
+}
@@ -1,8 +1,8 @@
 package vm
 
 import (
-	"encoding/binary"
 	"fmt"
+	"unsafe"
 	"writing-in-interpreter-in-go/src/monkey/code"
 	"writing-in-interpreter-in-go/src/monkey/compiler"
 	"writing-in-interpreter-in-go/src/monkey/object"
@@ -19,10 +19,30 @@ type VirtualMachine struct {
 	stack       []object.Object
 	frames      []*Frame
 	framesIndex int
+	// openUpvalues holds the upvalues still referring to the stack, ordered
+	// by the slot they refer to.
+	openUpvalues []openUpvalue
+	// callBudget, when positive, is the number of closure calls left before
+	// Run gives up. The fuzz tests use it to stop programs that never return.
+	callBudget int
+	// coverage, when set, counts the instructions executed.
+	coverage *Coverage
+	// profiler, when set, records the time spent in each function, the calls
+	// made and the objects allocated.
+	profiler *Profiler
+	// debugger, when set, pauses the program at breakpoints and steps.
+	debugger *Debugger
+	// tracer, when set, is told of every instruction, call and return.
+	tracer Tracer
+}
+
+type openUpvalue struct {
+	slot    int
+	upvalue *object.Upvalue
 }
 
 func New(byteCode *compiler.ByteCode) *VirtualMachine {
-	mainFn := &object.CompiledFunction{Instructions: byteCode.Instructions}
+	mainFn := &object.CompiledFunction{Instructions: byteCode.Instructions, SourceMap: byteCode.SourceMap}
 	mainClosure := &object.Closure{Function: mainFn}
 	mainFrame := NewFrame(mainClosure, 0)
 	frames := make([]*Frame, MaxFrames)
@@ -61,57 +81,182 @@ func (virtualMachine *VirtualMachine) LastPopped() object.Object {
 	return virtualMachine.stack[virtualMachine.sp]
 }
 
+// RuntimeError is an error raised by the program being run. Position locates
+// the instruction that raised it, when the compiler recorded positions.
+type RuntimeError struct {
+	Err      error
+	Position code.Position
+}
+
+func (err *RuntimeError) Error() string {
+	return err.Err.Error()
+}
+
+func (err *RuntimeError) Unwrap() error {
+	return err.Err
+}
+
+// Run executes instructions until the main frame is exhausted or returns,
+// which ends the program as it does in the evaluator. Errors are returned as
+// *RuntimeError.
 func (virtualMachine *VirtualMachine) Run() error {
-	for virtualMachine.currentFrame().ip < len(virtualMachine.currentFrame().Instructions())-1 {
-		instructions := virtualMachine.currentFrame().Instructions()
-		virtualMachine.currentFrame().ip++
-		ip := virtualMachine.currentFrame().ip
+	if virtualMachine.profiler != nil {
+		virtualMachine.profiler.startSampling()
+		defer virtualMachine.profiler.stopSampling()
+	}
+	return virtualMachine.traceError(virtualMachine.locate(virtualMachine.run(0)))
+}
+
+// Call calls function, a closure or builtin, with args and returns its
+// result. It can be used once Run has returned, or by a builtin called by
+// the running program. Errors are returned as *RuntimeError.
+func (virtualMachine *VirtualMachine) Call(function object.Object, args ...object.Object) (object.Object, error) {
+	sp := virtualMachine.sp
+	depth := virtualMachine.framesIndex
+	err := virtualMachine.push(function)
+	for _, arg := range args {
+		if err == nil {
+			err = virtualMachine.push(arg)
+		}
+	}
+	if err == nil {
+		err = virtualMachine.call(len(args))
+	}
+	if err != nil {
+		err = &RuntimeError{Err: err}
+	} else if virtualMachine.framesIndex > depth {
+		err = virtualMachine.locate(virtualMachine.run(depth))
+	}
+	if err != nil {
+		virtualMachine.traceError(err)
+		virtualMachine.closeUpvalues(sp)
+		virtualMachine.sp = sp
+		virtualMachine.framesIndex = depth
+		return nil, err
+	}
+	return virtualMachine.pop(), nil
+}
+
+// locate wraps err in a RuntimeError at the instruction the current frame
+// stopped at.
+func (virtualMachine *VirtualMachine) locate(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*RuntimeError); ok {
+		return err
+	}
+	frame := virtualMachine.currentFrame()
+	return &RuntimeError{Err: err, Position: frame.closure.Function.SourceMap.PositionAt(frame.ip)}
+}
+
+// run executes instructions until the main frame ends, or until a return
+// leaves depth frames. The current frame, its instructions and ip are kept
+// in locals and only written back to the frame when another frame is entered
+// or the loop ends.
+func (virtualMachine *VirtualMachine) run(depth int) error {
+	frame := virtualMachine.currentFrame()
+	instructions := frame.Instructions()
+	ip := frame.ip
+	defer func() {
+		frame.ip = ip
+	}()
+
+	for ip < len(instructions)-1 {
+		ip++
+		if virtualMachine.coverage != nil {
+			virtualMachine.coverage.hit(frame.closure.Function, ip)
+		}
+		if virtualMachine.profiler != nil {
+			frame.ip = ip
+			if virtualMachine.profiler.due.Load() {
+				virtualMachine.sample()
+			}
+		}
+		if virtualMachine.debugger != nil {
+			frame.ip = ip
+			err := virtualMachine.reached()
+			if err != nil {
+				return err
+			}
+		}
+		if virtualMachine.tracer != nil {
+			err := virtualMachine.traceInstruction(ip)
+			if err != nil {
+				return err
+			}
+		}
 		opcode := code.Opcode(instructions[ip])
 		switch opcode {
 		case code.OpConstant:
-			constantIndex := binary.BigEndian.Uint16(instructions[ip+1:])
-			frame := virtualMachine.currentFrame()
-			frame.ip += 2
+			constantIndex := code.ReadUint16(instructions[ip+1:])
+			ip += 2
 			err := virtualMachine.push(virtualMachine.constants[constantIndex])
 			if err != nil {
 				return err
 			}
 		case code.OpGetGlobal:
-			globalIndex := binary.BigEndian.Uint16(instructions[ip+1:])
-			virtualMachine.currentFrame().ip += 2
-			global := virtualMachine.globals[globalIndex]
-			err := virtualMachine.push(global)
+			globalIndex := code.ReadUint16(instructions[ip+1:])
+			ip += 2
+			err := virtualMachine.push(virtualMachine.globals[globalIndex])
 			if err != nil {
 				return err
 			}
 		case code.OpSetGlobal:
-			globalIndex := binary.BigEndian.Uint16(instructions[ip+1:])
-			virtualMachine.currentFrame().ip += 2
+			globalIndex := code.ReadUint16(instructions[ip+1:])
+			ip += 2
 			virtualMachine.globals[globalIndex] = virtualMachine.pop()
 		case code.OpGetLocal:
-			localIndex := instructions[ip+1]
-			virtualMachine.currentFrame().ip += 1
-			frame := virtualMachine.currentFrame()
+			localIndex := code.ReadUint8(instructions[ip+1:])
+			ip += 1
+			err := virtualMachine.push(virtualMachine.stack[frame.basePointer+int(localIndex)])
+			if err != nil {
+				return err
+			}
+		case code.OpGetLocalConst:
+			localIndex := code.ReadUint8(instructions[ip+1:])
+			constantIndex := code.ReadUint16(instructions[ip+2:])
+			ip += 3
 			err := virtualMachine.push(virtualMachine.stack[frame.basePointer+int(localIndex)])
 			if err != nil {
 				return err
 			}
+			err = virtualMachine.push(virtualMachine.constants[constantIndex])
+			if err != nil {
+				return err
+			}
+		case code.OpAddLocals:
+			left := virtualMachine.stack[frame.basePointer+int(code.ReadUint8(instructions[ip+1:]))]
+			right := virtualMachine.stack[frame.basePointer+int(code.ReadUint8(instructions[ip+2:]))]
+			ip += 2
+			err := virtualMachine.push(left)
+			if err != nil {
+				return err
+			}
+			err = virtualMachine.push(right)
+			if err != nil {
+				return err
+			}
+			err = virtualMachine.executeBinaryOperation(code.OpAdd)
+			if err != nil {
+				return err
+			}
 		case code.OpSetLocal:
-			localIndex := instructions[ip+1]
-			virtualMachine.currentFrame().ip += 1
-			frame := virtualMachine.currentFrame()
+			localIndex := code.ReadUint8(instructions[ip+1:])
+			ip += 1
 			virtualMachine.stack[frame.basePointer+int(localIndex)] = virtualMachine.pop()
 		case code.OpGetFree:
-			index := instructions[ip+1]
-			virtualMachine.currentFrame().ip += 1
-			closure := virtualMachine.currentFrame().closure
-			err := virtualMachine.push(closure.FreeVariables[index])
+			index := code.ReadUint8(instructions[ip+1:])
+			ip += 1
+			err := virtualMachine.push(frame.closure.FreeVariables[index].Get())
 			if err != nil {
 				return err
 			}
+		case code.OpCloseUpvalue:
+			virtualMachine.closeUpvalues(frame.basePointer)
 		case code.OpGetBuiltin:
-			builtinIndex := instructions[ip+1]
-			virtualMachine.currentFrame().ip += 1
+			builtinIndex := code.ReadUint8(instructions[ip+1:])
+			ip += 1
 			builtinDefinition := object.Builtins[builtinIndex]
 			err := virtualMachine.push(builtinDefinition.Builtin)
 			if err != nil {
@@ -122,14 +267,14 @@ func (virtualMachine *VirtualMachine) Run() error {
 			if err != nil {
 				return err
 			}
-		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
+		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan, code.OpLessEqual, code.OpGreaterEqual:
 			err := virtualMachine.executeComparison(opcode)
 			if err != nil {
 				return err
 			}
 		case code.OpArray:
-			numElements := int(binary.BigEndian.Uint16(instructions[ip+1:]))
-			virtualMachine.currentFrame().ip += 2
+			numElements := int(code.ReadUint16(instructions[ip+1:]))
+			ip += 2
 			array := virtualMachine.buildArray(virtualMachine.sp-numElements, virtualMachine.sp)
 			virtualMachine.sp = virtualMachine.sp - numElements
 			err := virtualMachine.push(array)
@@ -145,55 +290,72 @@ func (virtualMachine *VirtualMachine) Run() error {
 			}
 		case code.OpReturnValue:
 			returnValue := virtualMachine.pop()
-			frame := virtualMachine.popFrame()
-			virtualMachine.sp = frame.basePointer
+			if virtualMachine.framesIndex == 1 {
+				return nil
+			}
+			returned := virtualMachine.popFrame()
+			virtualMachine.sp = returned.basePointer
 			virtualMachine.pop() // pop compiled function
 			err := virtualMachine.push(returnValue)
 			if err != nil {
 				return err
 			}
+			virtualMachine.traceReturn(returned.closure, returnValue, virtualMachine.framesIndex+1)
+			if virtualMachine.framesIndex == depth {
+				return nil
+			}
+			frame = virtualMachine.currentFrame()
+			instructions = frame.Instructions()
+			ip = frame.ip
 		case code.OpReturnVoid:
-			frame := virtualMachine.popFrame()
-			virtualMachine.sp = frame.basePointer
+			if virtualMachine.framesIndex == 1 {
+				return nil
+			}
+			returned := virtualMachine.popFrame()
+			virtualMachine.sp = returned.basePointer
 			virtualMachine.pop() // pop compiled function
 			err := virtualMachine.push(object.NULL)
 			if err != nil {
 				return err
 			}
+			virtualMachine.traceReturn(returned.closure, object.NULL, virtualMachine.framesIndex+1)
+			if virtualMachine.framesIndex == depth {
+				return nil
+			}
+			frame = virtualMachine.currentFrame()
+			instructions = frame.Instructions()
+			ip = frame.ip
 		case code.OpClosure:
-			constantIndex := binary.BigEndian.Uint16(instructions[ip+1:])
-			freeVariableArity := instructions[ip+3]
-			virtualMachine.currentFrame().ip += 3
-			function := virtualMachine.constants[constantIndex]
-			freeVariables := make([]object.Object, freeVariableArity)
-			for i := 0; i < int(freeVariableArity); i++ {
-				freeVariables[i] = virtualMachine.stack[virtualMachine.sp-int(freeVariableArity)+i]
-			}
-			virtualMachine.sp = virtualMachine.sp - int(freeVariableArity)
-			err := virtualMachine.push(&object.Closure{Function: function.(*object.CompiledFunction), FreeVariables: freeVariables})
+			constantIndex := code.ReadUint16(instructions[ip+1:])
+			ip += 2
+			err := virtualMachine.push(virtualMachine.newClosure(frame, int(constantIndex)))
 			if err != nil {
 				return err
 			}
 		case code.OpCall:
-			argumentArity := instructions[ip+1]
-			virtualMachine.currentFrame().ip += 1
-			function := virtualMachine.stack[virtualMachine.sp-1-int(argumentArity)]
-			switch callee := function.(type) {
-			case *object.Closure:
-				err := virtualMachine.callClosure(callee, int(argumentArity))
-				if err != nil {
-					return err
-				}
-			case *object.Builtin:
-				err := virtualMachine.callBuiltin(callee, int(argumentArity))
-				if err != nil {
-					return err
-				}
-			default:
-				return fmt.Errorf("calling non-function")
+			argumentArity := int(code.ReadUint8(instructions[ip+1:]))
+			ip += 1
+			frame.ip = ip
+			err := virtualMachine.call(argumentArity)
+			if err != nil {
+				return err
+			}
+			frame = virtualMachine.currentFrame()
+			instructions = frame.Instructions()
+			ip = frame.ip
+		case code.OpTailCall:
+			argumentArity := int(code.ReadUint8(instructions[ip+1:]))
+			ip += 1
+			frame.ip = ip
+			err := virtualMachine.tailCall(argumentArity)
+			if err != nil {
+				return err
 			}
+			frame = virtualMachine.currentFrame()
+			instructions = frame.Instructions()
+			ip = frame.ip
 		case code.OpCurrentClosure:
-			err := virtualMachine.push(virtualMachine.currentFrame().closure)
+			err := virtualMachine.push(frame.closure)
 			if err != nil {
 				return err
 			}
@@ -203,14 +365,37 @@ func (virtualMachine *VirtualMachine) Run() error {
 				return err
 			}
 		case code.OpJump:
-			jumpPosition := int(binary.BigEndian.Uint16(instructions[ip+1:]))
-			virtualMachine.currentFrame().ip = jumpPosition - 1
+			ip = int(code.ReadUint16(instructions[ip+1:])) - 1
 		case code.OpJumpIfFalse:
-			jumpPosition := int(binary.BigEndian.Uint16(instructions[ip+1:]))
-			virtualMachine.currentFrame().ip += 2
-			condition := virtualMachine.pop()
-			if !isTruthy(condition) {
-				virtualMachine.currentFrame().ip = jumpPosition - 1
+			jumpPosition := int(code.ReadUint16(instructions[ip+1:]))
+			ip += 2
+			jumped := !object.IsTruthy(virtualMachine.pop())
+			if virtualMachine.coverage != nil {
+				virtualMachine.coverage.branch(frame.closure.Function, ip-2, jumped)
+			}
+			if jumped {
+				ip = jumpPosition - 1
+			}
+		case code.OpJumpIfNotEqual, code.OpJumpIfNotGreater, code.OpJumpIfNotLess:
+			jumpPosition := int(code.ReadUint16(instructions[ip+1:]))
+			ip += 2
+			comparison := code.OpEqual
+			switch opcode {
+			case code.OpJumpIfNotGreater:
+				comparison = code.OpGreaterThan
+			case code.OpJumpIfNotLess:
+				comparison = code.OpLessThan
+			}
+			err := virtualMachine.executeComparison(comparison)
+			if err != nil {
+				return err
+			}
+			jumped := !object.IsTruthy(virtualMachine.pop())
+			if virtualMachine.coverage != nil {
+				virtualMachine.coverage.branch(frame.closure.Function, ip-2, jumped)
+			}
+			if jumped {
+				ip = jumpPosition - 1
 			}
 		case code.OpTrue:
 			err := virtualMachine.push(object.TRUE)
@@ -229,6 +414,20 @@ func (virtualMachine *VirtualMachine) Run() error {
 			}
 		case code.OpPop:
 			virtualMachine.pop()
+		case code.OpWide:
+			wide, err := code.ReadInstruction(instructions, ip)
+			if err != nil {
+				return err
+			}
+			ip += wide.Width - 1
+			frame.ip = ip
+			err = virtualMachine.executeWide(wide.Opcode, wide.Operands)
+			if err != nil {
+				return err
+			}
+			frame = virtualMachine.currentFrame()
+			instructions = frame.Instructions()
+			ip = frame.ip
 		default:
 			return fmt.Errorf("unknown opcode %b", opcode)
 		}
@@ -236,10 +435,97 @@ func (virtualMachine *VirtualMachine) Run() error {
 	return nil
 }
 
+// executeWide executes an instruction whose operands did not fit their usual
+// widths. Only instructions the compiler can emit with large operands are
+// supported.
+func (virtualMachine *VirtualMachine) executeWide(opcode code.Opcode, operands []int) error {
+	frame := virtualMachine.currentFrame()
+	switch opcode {
+	case code.OpConstant:
+		return virtualMachine.push(virtualMachine.constants[operands[0]])
+	case code.OpGetGlobal:
+		return virtualMachine.push(virtualMachine.globals[operands[0]])
+	case code.OpSetGlobal:
+		virtualMachine.globals[operands[0]] = virtualMachine.pop()
+		return nil
+	case code.OpGetLocal:
+		return virtualMachine.push(virtualMachine.stack[frame.basePointer+operands[0]])
+	case code.OpSetLocal:
+		virtualMachine.stack[frame.basePointer+operands[0]] = virtualMachine.pop()
+		return nil
+	case code.OpGetBuiltin:
+		return virtualMachine.push(object.Builtins[operands[0]].Builtin)
+	case code.OpGetFree:
+		return virtualMachine.push(frame.closure.FreeVariables[operands[0]].Get())
+	case code.OpArray:
+		array := virtualMachine.buildArray(virtualMachine.sp-operands[0], virtualMachine.sp)
+		virtualMachine.sp = virtualMachine.sp - operands[0]
+		return virtualMachine.push(array)
+	case code.OpClosure:
+		return virtualMachine.push(virtualMachine.newClosure(frame, operands[0]))
+	case code.OpCall:
+		return virtualMachine.call(operands[0])
+	case code.OpTailCall:
+		return virtualMachine.tailCall(operands[0])
+	default:
+		return fmt.Errorf("opcode %d has no wide form", opcode)
+	}
+}
+
+// newClosure closes function constant constantIndex over the variables its
+// captures name, sharing an upvalue with every other closure capturing the
+// same variable.
+func (virtualMachine *VirtualMachine) newClosure(frame *Frame, constantIndex int) *object.Closure {
+	function := virtualMachine.constants[constantIndex].(*object.CompiledFunction)
+	freeVariables := make([]*object.Upvalue, len(function.Captures))
+	for i, capture := range function.Captures {
+		switch capture.Kind {
+		case object.CaptureLocal:
+			freeVariables[i] = virtualMachine.captureUpvalue(frame.basePointer + capture.Index)
+		case object.CaptureFree:
+			freeVariables[i] = frame.closure.FreeVariables[capture.Index]
+		case object.CaptureClosure:
+			freeVariables[i] = object.NewClosedUpvalue(frame.closure)
+		}
+	}
+	closure := &object.Closure{Function: function, FreeVariables: freeVariables}
+	virtualMachine.allocatedObject(closure)
+	return closure
+}
+
+func (virtualMachine *VirtualMachine) captureUpvalue(slot int) *object.Upvalue {
+	position := len(virtualMachine.openUpvalues)
+	for position > 0 && virtualMachine.openUpvalues[position-1].slot >= slot {
+		if virtualMachine.openUpvalues[position-1].slot == slot {
+			return virtualMachine.openUpvalues[position-1].upvalue
+		}
+		position--
+	}
+	upvalue := &object.Upvalue{Location: &virtualMachine.stack[slot]}
+	virtualMachine.allocated("UPVALUE", unsafe.Sizeof(*upvalue))
+	virtualMachine.openUpvalues = append(virtualMachine.openUpvalues, openUpvalue{})
+	copy(virtualMachine.openUpvalues[position+1:], virtualMachine.openUpvalues[position:])
+	virtualMachine.openUpvalues[position] = openUpvalue{slot: slot, upvalue: upvalue}
+	return upvalue
+}
+
+// closeUpvalues closes the open upvalues referring to slot or above.
+func (virtualMachine *VirtualMachine) closeUpvalues(slot int) {
+	open := virtualMachine.openUpvalues
+	for len(open) > 0 && open[len(open)-1].slot >= slot {
+		open[len(open)-1].upvalue.Close()
+		open = open[:len(open)-1]
+	}
+	virtualMachine.openUpvalues = open
+}
+
 func (virtualMachine *VirtualMachine) push(object object.Object) error {
 	if virtualMachine.sp >= StackSize {
 		return fmt.Errorf("stack overflow")
 	}
+	if object == nil {
+		return fmt.Errorf("variable used before it is set")
+	}
 
 	virtualMachine.stack[virtualMachine.sp] = object
 	virtualMachine.sp++
@@ -263,7 +549,7 @@ func (virtualMachine *VirtualMachine) executeBinaryOperation(op code.Opcode) err
 	if leftType == object.STRING && rightType == object.STRING {
 		return virtualMachine.executeBinaryStringOperation(op, *left.(*object.String), *right.(*object.String))
 	}
-	return fmt.Errorf("unsupported types for binary operation: %s %s", leftType, rightType)
+	return fmt.Errorf("type mismatch: %s %s %s", leftType, code.Operators[op], rightType)
 }
 
 func (virtualMachine *VirtualMachine) executeBinaryIntegerOperation(op code.Opcode, left, right object.Integer) error {
@@ -276,11 +562,14 @@ func (virtualMachine *VirtualMachine) executeBinaryIntegerOperation(op code.Opco
 	case code.OpMul:
 		result = left.Value * right.Value
 	case code.OpDiv:
+		if right.Value == 0 {
+			return fmt.Errorf("division by zero")
+		}
 		result = left.Value / right.Value
 	default:
 		return fmt.Errorf("unknown integer operator: %d", op)
 	}
-	return virtualMachine.push(&object.Integer{Value: result})
+	return virtualMachine.push(virtualMachine.newInteger(result))
 }
 
 func (virtualMachine *VirtualMachine) executeBinaryStringOperation(op code.Opcode, left, right object.String) error {
@@ -289,9 +578,11 @@ func (virtualMachine *VirtualMachine) executeBinaryStringOperation(op code.Opcod
 	case code.OpAdd:
 		result = left.Value + right.Value
 	default:
-		return fmt.Errorf("unknown string operator %T", op)
+		return fmt.Errorf("unknown operator: STRING %s STRING", code.Operators[op])
 	}
-	return virtualMachine.push(&object.String{Value: result})
+	str := &object.String{Value: result}
+	virtualMachine.allocatedObject(str)
+	return virtualMachine.push(str)
 }
 
 func (virtualMachine *VirtualMachine) executeUnaryOperation(opcode code.Opcode) error {
@@ -312,13 +603,16 @@ func (virtualMachine *VirtualMachine) executeComparison(op code.Opcode) error {
 	if left.Type() == object.INTEGER && right.Type() == object.INTEGER {
 		return virtualMachine.executeIntegerComparison(op, left, right)
 	}
+	if left.Type() == object.STRING && right.Type() == object.STRING {
+		return virtualMachine.executeStringComparison(op, left, right)
+	}
 	switch op {
 	case code.OpEqual:
-		return virtualMachine.push(nativeBoolToBooleanObject(right == left))
+		return virtualMachine.push(object.NativeBoolToBooleanObject(right == left))
 	case code.OpNotEqual:
-		return virtualMachine.push(nativeBoolToBooleanObject(right != left))
+		return virtualMachine.push(object.NativeBoolToBooleanObject(right != left))
 	default:
-		return fmt.Errorf("unknown operator: %d (%s %s)", op, left.Type(), right.Type())
+		return fmt.Errorf("type mismatch: %s %s %s", left.Type(), code.Operators[op], right.Type())
 	}
 }
 
@@ -327,11 +621,40 @@ func (virtualMachine *VirtualMachine) executeIntegerComparison(op code.Opcode, l
 	rightValue := right.(*object.Integer).Value
 	switch op {
 	case code.OpEqual:
-		return virtualMachine.push(nativeBoolToBooleanObject(rightValue == leftValue))
+		return virtualMachine.push(object.NativeBoolToBooleanObject(rightValue == leftValue))
 	case code.OpNotEqual:
-		return virtualMachine.push(nativeBoolToBooleanObject(rightValue != leftValue))
+		return virtualMachine.push(object.NativeBoolToBooleanObject(rightValue != leftValue))
 	case code.OpGreaterThan:
-		return virtualMachine.push(nativeBoolToBooleanObject(leftValue > rightValue))
+		return virtualMachine.push(object.NativeBoolToBooleanObject(leftValue > rightValue))
+	case code.OpLessThan:
+		return virtualMachine.push(object.NativeBoolToBooleanObject(leftValue < rightValue))
+	case code.OpLessEqual:
+		return virtualMachine.push(object.NativeBoolToBooleanObject(leftValue <= rightValue))
+	case code.OpGreaterEqual:
+		return virtualMachine.push(object.NativeBoolToBooleanObject(leftValue >= rightValue))
+	default:
+		return fmt.Errorf("unknown operator: %d", op)
+	}
+}
+
+// executeStringComparison compares strings by value, so strings built at
+// runtime equal the literals they spell.
+func (virtualMachine *VirtualMachine) executeStringComparison(op code.Opcode, left, right object.Object) error {
+	leftValue := left.(*object.String).Value
+	rightValue := right.(*object.String).Value
+	switch op {
+	case code.OpEqual:
+		return virtualMachine.push(object.NativeBoolToBooleanObject(leftValue == rightValue))
+	case code.OpNotEqual:
+		return virtualMachine.push(object.NativeBoolToBooleanObject(leftValue != rightValue))
+	case code.OpGreaterThan:
+		return virtualMachine.push(object.NativeBoolToBooleanObject(leftValue > rightValue))
+	case code.OpLessThan:
+		return virtualMachine.push(object.NativeBoolToBooleanObject(leftValue < rightValue))
+	case code.OpLessEqual:
+		return virtualMachine.push(object.NativeBoolToBooleanObject(leftValue <= rightValue))
+	case code.OpGreaterEqual:
+		return virtualMachine.push(object.NativeBoolToBooleanObject(leftValue >= rightValue))
 	default:
 		return fmt.Errorf("unknown operator: %d", op)
 	}
@@ -353,29 +676,20 @@ func (virtualMachine *VirtualMachine) executeBangOperation(operand object.Object
 func (virtualMachine *VirtualMachine) executeNegateOperation(operand object.Object) error {
 	value, ok := operand.(*object.Integer)
 	if !ok {
-		return fmt.Errorf("expected *object.Integer. Got %T", value)
-	}
-
-	return virtualMachine.push(&object.Integer{Value: -value.Value})
-}
-
-func nativeBoolToBooleanObject(boolean bool) object.Object {
-	if boolean {
-		return object.TRUE
+		return fmt.Errorf("unknown operator: -%s", operand.Type())
 	}
 
-	return object.FALSE
+	return virtualMachine.push(virtualMachine.newInteger(-value.Value))
 }
 
-func isTruthy(obj object.Object) bool {
-	switch obj := obj.(type) {
-	case *object.Boolean:
-		return obj.Value
-	case *object.Null:
-		return false
-	default:
-		return true
+// newInteger is object.NewInteger recording the allocation of integers that
+// are not shared.
+func (virtualMachine *VirtualMachine) newInteger(value int64) *object.Integer {
+	integer := object.NewInteger(value)
+	if virtualMachine.profiler != nil && (value < object.SmallIntegerMin || value > object.SmallIntegerMax) {
+		virtualMachine.allocatedObject(integer)
 	}
+	return integer
 }
 
 func (virtualMachine *VirtualMachine) buildArray(startIndex, endIndex int) object.Object {
@@ -383,7 +697,9 @@ func (virtualMachine *VirtualMachine) buildArray(startIndex, endIndex int) objec
 	for i := startIndex; i < endIndex; i++ {
 		elements[i-startIndex] = virtualMachine.stack[i]
 	}
-	return &object.Array{Elements: elements}
+	array := &object.Array{Elements: elements}
+	virtualMachine.allocatedObject(array)
+	return array
 }
 
 func (virtualMachine *VirtualMachine) executeIndexExpression(expression, index object.Object) error {
@@ -405,23 +721,105 @@ func (virtualMachine *VirtualMachine) executeArrayIndex(array, index object.Obje
 	return virtualMachine.push(arrayObject.Elements[i])
 }
 
+func (virtualMachine *VirtualMachine) call(argumentArity int) error {
+	function := virtualMachine.stack[virtualMachine.sp-1-argumentArity]
+	switch callee := function.(type) {
+	case *object.Closure:
+		return virtualMachine.callClosure(callee, argumentArity)
+	case *object.Builtin:
+		return virtualMachine.callBuiltin(callee, argumentArity)
+	default:
+		return fmt.Errorf("not a function: %s", function.Type())
+	}
+}
+
+// tailCall calls a closure in the current frame, so recursion in tail
+// position runs in constant space. Other callees, and calls from the main
+// frame, are made as usual.
+func (virtualMachine *VirtualMachine) tailCall(argumentArity int) error {
+	closure, ok := virtualMachine.stack[virtualMachine.sp-1-argumentArity].(*object.Closure)
+	if !ok || virtualMachine.framesIndex == 1 {
+		return virtualMachine.call(argumentArity)
+	}
+	if argumentArity != closure.Function.ParameterArity {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
+			closure.Function.ParameterArity, argumentArity)
+	}
+	err := virtualMachine.spendCall()
+	if err != nil {
+		return err
+	}
+
+	frame := virtualMachine.currentFrame()
+	if frame.basePointer+closure.Function.LocalVariableArity >= StackSize {
+		return fmt.Errorf("stack overflow")
+	}
+	copy(virtualMachine.stack[frame.basePointer-1:], virtualMachine.stack[virtualMachine.sp-1-argumentArity:virtualMachine.sp])
+	frame.closure = closure
+	frame.ip = -1
+	virtualMachine.sp = frame.basePointer + closure.Function.LocalVariableArity
+	virtualMachine.clearLocals(frame.basePointer+argumentArity, virtualMachine.sp)
+	virtualMachine.called()
+	arguments := virtualMachine.stack[frame.basePointer : frame.basePointer+argumentArity]
+	return virtualMachine.traceCall(closure, arguments, virtualMachine.framesIndex)
+}
+
+// clearLocals unsets the locals from start to end, so that reading one before
+// it is set fails rather than returning a value left by an earlier call.
+func (virtualMachine *VirtualMachine) clearLocals(start, end int) {
+	clear(virtualMachine.stack[start:end])
+}
+
 func (virtualMachine *VirtualMachine) callClosure(closure *object.Closure, arity int) error {
 	if arity != closure.Function.ParameterArity {
 		return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
 			closure.Function.ParameterArity, arity)
 	}
+	if virtualMachine.framesIndex >= MaxFrames {
+		return fmt.Errorf("stack overflow: more than %d nested calls", MaxFrames-1)
+	}
+	err := virtualMachine.spendCall()
+	if err != nil {
+		return err
+	}
 	frame := NewFrame(closure, virtualMachine.sp-arity)
+	if frame.basePointer+closure.Function.LocalVariableArity >= StackSize {
+		return fmt.Errorf("stack overflow")
+	}
 	virtualMachine.pushFrame(frame)
 	virtualMachine.sp = frame.basePointer + closure.Function.LocalVariableArity
+	virtualMachine.clearLocals(frame.basePointer+arity, virtualMachine.sp)
+	virtualMachine.called()
+	arguments := virtualMachine.stack[frame.basePointer : frame.basePointer+arity]
+	return virtualMachine.traceCall(closure, arguments, virtualMachine.framesIndex)
+}
+
+func (virtualMachine *VirtualMachine) spendCall() error {
+	if virtualMachine.callBudget == 0 {
+		return nil
+	}
+	virtualMachine.callBudget--
+	if virtualMachine.callBudget == 0 {
+		return fmt.Errorf("call budget exhausted")
+	}
 	return nil
 }
 
 func (virtualMachine *VirtualMachine) callBuiltin(builtin *object.Builtin, arity int) error {
 	args := virtualMachine.stack[virtualMachine.sp-arity : virtualMachine.sp]
+	depth := virtualMachine.framesIndex + 1
+	err := virtualMachine.traceCall(builtin, args, depth)
+	if err != nil {
+		return err
+	}
 	result := builtin.Function(args...)
 	virtualMachine.sp = virtualMachine.sp - arity - 1
-	if result != nil {
-		return virtualMachine.push(result)
+	if err, ok := result.(*object.Error); ok {
+		return fmt.Errorf("%s", err.Message)
+	}
+	if result == nil {
+		result = object.NULL
 	}
-	return virtualMachine.push(object.NULL)
+	virtualMachine.traceReturn(builtin, result, depth)
+	return virtualMachine.push(result)
 }
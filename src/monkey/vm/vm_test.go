@@ -2,12 +2,16 @@ package vm
 
 import (
 	"fmt"
+	"io"
+	"strings"
 	"testing"
 	"writing-in-interpreter-in-go/src/monkey/ast"
+	"writing-in-interpreter-in-go/src/monkey/code"
 	"writing-in-interpreter-in-go/src/monkey/compiler"
 	"writing-in-interpreter-in-go/src/monkey/lexer"
 	"writing-in-interpreter-in-go/src/monkey/object"
 	"writing-in-interpreter-in-go/src/monkey/parser"
+	"writing-in-interpreter-in-go/src/monkey/vm/register"
 )
 
 type vmTestCase struct {
@@ -71,6 +75,50 @@ func TestBooleanExpressions(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestComparisons(t *testing.T) {
+	tests := []vmTestCase{
+		{"1 <= 1", true},
+		{"2 <= 1", false},
+		{"1 >= 1", true},
+		{"1 >= 2", false},
+		{`"a" < "b"`, true},
+		{`"b" < "a"`, false},
+		{`"a" <= "a"`, true},
+		{`"b" > "a"`, true},
+		{`"a" >= "b"`, false},
+		{`"mon" + "key" == "monkey"`, true},
+		{`"mon" + "key" != "monkey"`, false},
+		{`let less = fn(a, b) { a < b }; less(1, 2)`, true},
+		{`let less = fn(a, b) { a < b }; less("b", "a")`, false},
+		{`let sign = fn(n) { if (n < 0) { -1 } else { 1 } }; sign(-5) + sign(5) * 10`, 9},
+	}
+
+	runVmTests(t, tests)
+}
+
+// Operands are evaluated left to right, so the left operand's error is the
+// one reported.
+func TestComparisonEvaluationOrder(t *testing.T) {
+	input := `(1 + true) < ("a" - 1)`
+	expected := "type mismatch: INTEGER + BOOLEAN"
+	for _, level := range []int{0, 1, 2} {
+		comp := compiler.New()
+		comp.SetOptimizationLevel(level)
+		err := comp.Compile(parse(input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		err = New(comp.ByteCode()).Run()
+		if err == nil || err.Error() != expected {
+			t.Errorf("wrong VM error at optimization level %d: want=%q, got=%v", level, expected, err)
+		}
+	}
+	_, err := runRegisterVm(input)
+	if err == nil || err.Error() != expected {
+		t.Errorf("wrong register VM error: want=%q, got=%v", expected, err)
+	}
+}
+
 func TestConditionals(t *testing.T) {
 	tests := []vmTestCase{
 		{"if (true) { 10 }", 10},
@@ -81,6 +129,9 @@ func TestConditionals(t *testing.T) {
 		{"if (1 > 2) { 10 }", object.NULL},
 		{"if (false) { 10 }", object.NULL},
 		{"if ((if (false) { 10 })) { 10 } else { 20 }", 20},
+		{"let x = 1; let y = if (x > 0) { }; y", object.NULL},
+		{"let x = 1; let y = if (x > 0) { let z = 2; }; y", object.NULL},
+		{"let x = 1; let y = if (x > 1) { 10 } else { }; y", object.NULL},
 	}
 
 	runVmTests(t, tests)
@@ -177,6 +228,126 @@ earlyExit();
 	runVmTests(t, tests)
 }
 
+func TestTopLevelReturn(t *testing.T) {
+	tests := []vmTestCase{
+		{"1; return 2; 3", 2},
+		{"if (true) { return 10; }; 1", 10},
+	}
+
+	runVmTests(t, tests)
+}
+
+// A local read before it is set does not see a value left on the stack by
+// an earlier call.
+func TestUnsetLocal(t *testing.T) {
+	input := "let f = fn(x) { if (x) { let a = 1; }; a }; f(true); f(false)"
+	comp := compiler.New()
+	err := comp.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	err = New(comp.ByteCode()).Run()
+	if err == nil || err.Error() != "variable used before it is set" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+}
+
+func TestCall(t *testing.T) {
+	symbolTable := compiler.NewSymbolTable()
+	for index, builtin := range object.Builtins {
+		symbolTable.DefineBuiltin(index, builtin.Name)
+	}
+	symbolTable.Define("twice")
+	comp := compiler.NewWithState(symbolTable, []object.Object{})
+	err := comp.Compile(parse(`
+let inc = fn(x) { x + 1 };
+let fail = fn(x) {
+  x / 0
+};
+let result = twice(inc, 1);`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	globals := make([]object.Object, GlobalsSize)
+	vm := NewWithGlobalsStore(comp.ByteCode(), globals)
+	// twice(f, x) returns f(f(x)), calling back into the machine.
+	globals[0] = &object.Builtin{Function: func(args ...object.Object) object.Object {
+		result, err := vm.Call(args[0], args[1])
+		if err == nil {
+			result, err = vm.Call(args[0], result)
+		}
+		if err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+		return result
+	}}
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	global := func(name string) object.Object {
+		symbol, _ := symbolTable.Resolve(name)
+		return globals[symbol.Index]
+	}
+	testExpectedObject(t, 3, global("result"))
+
+	_, err = vm.Call(global("fail"), object.NewInteger(1))
+	runtimeError, ok := err.(*RuntimeError)
+	if !ok || runtimeError.Error() != "division by zero" || runtimeError.Position != (code.Position{Line: 4, Column: 5}) {
+		t.Errorf("wrong error. got=%#v", err)
+	}
+	_, err = vm.Call(global("twice"), global("fail"), object.NewInteger(1))
+	if err == nil || err.Error() != "division by zero" {
+		t.Errorf("wrong error from nested call. got=%v", err)
+	}
+	_, err = vm.Call(global("inc"))
+	if err == nil || err.Error() != "wrong number of arguments: want=1, got=0" {
+		t.Errorf("wrong arity error. got=%v", err)
+	}
+
+	result, err := vm.Call(global("twice"), global("inc"), object.NewInteger(5))
+	if err != nil {
+		t.Fatalf("call failed after errors: %s", err)
+	}
+	testExpectedObject(t, 7, result)
+	if vm.sp != 0 || vm.framesIndex != 1 {
+		t.Errorf("calls left sp=%d, framesIndex=%d", vm.sp, vm.framesIndex)
+	}
+}
+
+func TestRuntimeErrorPosition(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected code.Position
+	}{
+		{"1;\n  -true", code.Position{Line: 2, Column: 3}},
+		{"let f = fn(a) {\n  a[0]\n};\nf(1)", code.Position{Line: 2, Column: 4}},
+		{"let f = fn(a) { a };\n[f(1, 2)]", code.Position{Line: 2, Column: 2}},
+		{"let x = 1;\nx(2)", code.Position{Line: 2, Column: 1}},
+	}
+	for _, tt := range tests {
+		for _, level := range []int{0, 2} {
+			comp := compiler.New()
+			comp.SetOptimizationLevel(level)
+			err := comp.Compile(parse(tt.input))
+			if err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+			err = New(comp.ByteCode()).Run()
+			runtimeError, ok := err.(*RuntimeError)
+			if !ok {
+				t.Errorf("%q: no runtime error. got=%v", tt.input, err)
+				continue
+			}
+			if runtimeError.Position != tt.expected {
+				t.Errorf("%q at level %d: wrong position. want=%v, got=%v", tt.input, level,
+					tt.expected, runtimeError.Position)
+			}
+		}
+	}
+}
+
 func TestFunctionsWithoutReturnValue(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -361,6 +532,11 @@ func TestCallingFunctionsWithWrongArguments(t *testing.T) {
 		if err.Error() != tt.expected {
 			t.Fatalf("wrong VM error: want=%q, got=%q", tt.expected, err)
 		}
+
+		_, err = runRegisterVm(tt.input)
+		if err == nil || err.Error() != tt.expected {
+			t.Fatalf("wrong register VM error: want=%q, got=%v", tt.expected, err)
+		}
 	}
 }
 
@@ -445,6 +621,85 @@ adder(8);
 	runVmTests(t, tests)
 }
 
+// The value of a let statement sees the binding its name had before, as in
+// the evaluator.
+func TestLetValueSeesEnclosingBinding(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = 1; let g = fn() { let a = a + 1; a }; g()", 2},
+		{"let f = fn(a) { fn() { let a = a * 2; a } }; f(3)()", 6},
+		{"fn() { let a = if (true) { let b = 5; b + 1 }; a + b }()", 11},
+	}
+
+	runVmTests(t, tests)
+}
+
+// Closures share the variables they capture with the function defining them
+// and with each other, so rebinding a captured local is visible to them.
+func TestUpvalues(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+let make = fn() {
+let count = 0;
+let get = fn() { count };
+let count = count + 1;
+get
+};
+make()();
+`,
+			expected: 1,
+		},
+		{
+			input: `
+let pair = fn(x) {
+let get = fn() { x };
+let twice = fn() { get() + x };
+let x = x * 10;
+[get(), twice()]
+};
+pair(2);
+`,
+			expected: []int{20, 40},
+		},
+		{
+			input: `
+let outer = fn() {
+let v = 1;
+let middle = fn() { fn() { v } };
+let v = 5;
+middle()()
+};
+outer();
+`,
+			expected: 5,
+		},
+		{
+			input: `
+let f = fn(n, g) {
+if (n == 0) {
+g()
+} else {
+let h = fn() { n };
+f(n - 1, h)
+}
+};
+f(3, fn() { 0 });
+`,
+			expected: 1,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func concatInstructions(instructions ...code.Instructions) code.Instructions {
+	var out code.Instructions
+	for _, instruction := range instructions {
+		out = append(out, instruction...)
+	}
+	return out
+}
+
 func TestRecursiveFunctions(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -496,36 +751,184 @@ wrapper();
 	runVmTests(t, tests)
 }
 
-func runVmTests(t *testing.T, tests []vmTestCase) {
-	t.Helper()
-	for _, tt := range tests {
-		program := parse(tt.input)
+func TestTailCalls(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+let countDown = fn(x) { if (x == 0) { 0 } else { countDown(x - 1) } };
+countDown(1000000);
+`,
+			expected: 0,
+		},
+		{
+			input: `
+let sum = fn(n, total) {
+	if (n == 0) { return total; }
+	return sum(n - 1, total + n);
+};
+sum(1000000, 0);
+`,
+			expected: 500000500000,
+		},
+		{
+			input: `
+let isEven = fn(n, isOdd) { if (n == 0) { true } else { isOdd(n - 1, isEven) } };
+let isOdd = fn(n, isEven) { if (n == 0) { false } else { isEven(n - 1, isOdd) } };
+isEven(1000001, isOdd);
+`,
+			expected: false,
+		},
+		{
+			input: `
+let makeCounter = fn(step) {
+	let loop = fn(n, total) { if (n == 0) { total } else { loop(n - 1, total + step) } };
+	loop
+};
+makeCounter(2)(1000000, 0);
+`,
+			expected: 2000000,
+		},
+		{
+			input:    `let size = fn(array) { len(array) }; size([1, 2, 3]) + 1;`,
+			expected: 4,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestTailCallStackOverflow(t *testing.T) {
+	// A tail call needs room for the locals of the function it calls, as
+	// much as any other call does.
+	var locals strings.Builder
+	for i := 0; i < 81; i++ {
+		fmt.Fprintf(&locals, "let v%c%c = %d; ", 'a'+i/26, 'a'+i%26, i)
+	}
+	input := fmt.Sprintf(`let wide = fn() { %s 0 };
+let deep = fn(n) { if (n == 0) { wide() } else { deep(n - 1) + 1 } };
+deep(1000);`, locals.String())
+
+	for _, level := range []int{0, 2} {
 		comp := compiler.New()
-		err := comp.Compile(program)
+		comp.SetOptimizationLevel(level)
+		err := comp.Compile(parse(input))
 		if err != nil {
 			t.Fatalf("compiler error: %s", err)
 		}
-		vm := New(comp.ByteCode())
-		err = vm.Run()
+		err = New(comp.ByteCode()).Run()
+		if err == nil || err.Error() != "stack overflow" {
+			t.Errorf("wrong error at optimization level %d. want=%q, got=%v", level, "stack overflow", err)
+		}
+	}
+}
+
+func TestWideOperands(t *testing.T) {
+	// Identifiers cannot contain digits, so variable i is named "v" followed
+	// by two letters.
+	name := func(prefix string, i int) string {
+		return fmt.Sprintf("%s%c%c", prefix, 'a'+i/26, 'a'+i%26)
+	}
+
+	var locals, arguments, parameters, sum, constants strings.Builder
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&locals, "let %s = %d; ", name("v", i), i)
+		if i > 0 {
+			arguments.WriteString(", ")
+			parameters.WriteString(", ")
+			sum.WriteString(" + ")
+		}
+		fmt.Fprintf(&arguments, "%d", i)
+		parameters.WriteString(name("p", i))
+		sum.WriteString(name("v", i))
+	}
+	for i := 1; i <= 70000; i++ {
+		fmt.Fprintf(&constants, "%d; ", i)
+	}
+
+	tests := []vmTestCase{
+		{fmt.Sprintf("fn() { %s %s + %s + %s }()", locals.String(), name("v", 0), name("v", 255), name("v", 299)), 554},
+		{fmt.Sprintf("fn(%s) { %s }(%s)", parameters.String(), name("p", 299), arguments.String()), 299},
+		{fmt.Sprintf("fn() { %s fn() { %s } }()()", locals.String(), sum.String()), 44850},
+		{constants.String(), 70000},
+	}
+
+	runVmTests(t, tests)
+}
+
+// runVmTests runs every case both as written and with optimisations enabled,
+// which must not change the result, and then on the register machine.
+func runVmTests(t *testing.T, tests []vmTestCase) {
+	t.Helper()
+	for _, level := range []int{0, 1, 2} {
+		for _, tt := range tests {
+			program := parse(tt.input)
+			comp := compiler.New()
+			comp.SetOptimizationLevel(level)
+			err := comp.Compile(program)
+			if err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+			err = Verify(comp.ByteCode())
+			if err != nil {
+				t.Fatalf("verifier rejected compiled bytecode at optimization level %d: %s", level, err)
+			}
+			vm := New(comp.ByteCode())
+			err = vm.Run()
+			if expected, ok := tt.expected.(*object.Error); ok {
+				testRuntimeError(t, expected, err)
+				continue
+			}
+			if err != nil {
+				t.Fatalf("vm error at optimization level %d: %s", level, err)
+			}
+			stackElem := vm.LastPopped()
+			testExpectedObject(t, tt.expected, stackElem)
+		}
+	}
+
+	for _, tt := range tests {
+		machine, err := runRegisterVm(tt.input)
+		if expected, ok := tt.expected.(*object.Error); ok {
+			testRuntimeError(t, expected, err)
+			continue
+		}
 		if err != nil {
-			t.Fatalf("vm error: %s", err)
+			t.Fatalf("register vm error: %s", err)
 		}
-		stackElem := vm.LastPopped()
-		testExpectedObject(t, tt.expected, stackElem)
+		testExpectedObject(t, tt.expected, machine.LastPopped())
+	}
+}
+
+// testRuntimeError checks that a program expected to produce an error
+// object, such as a failing builtin, stopped with its message.
+func testRuntimeError(t *testing.T, expected *object.Error, err error) {
+	t.Helper()
+	if err == nil || err.Error() != expected.Message {
+		t.Errorf("wrong runtime error. want=%q, got=%v", expected.Message, err)
+	}
+}
+
+func runRegisterVm(input string) (*register.VirtualMachine, error) {
+	comp := register.NewCompiler()
+	err := comp.Compile(parse(input))
+	if err != nil {
+		return nil, err
 	}
+	machine := register.New(comp.Program())
+	return machine, machine.Run()
 }
 
 func testExpectedObject(t *testing.T, expected interface{}, actual object.Object) {
 	t.Helper()
 	switch expected := expected.(type) {
-	case object.Null:
+	case *object.Null:
 		if actual != object.NULL {
 			t.Errorf("object is not Null: %T (%+v)", actual, actual)
 		}
 	case bool:
 		err := testBooleanObject(expected, actual)
 		if err != nil {
-			return
+			t.Errorf("testBooleanObject failed: %s", err)
 		}
 	case int:
 		err := testIntegerObject(int64(expected), actual)
@@ -588,3 +991,37 @@ func testBooleanObject(expected bool, actual object.Object) error {
 	}
 	return nil
 }
+
+// FuzzRun checks that compiled programs run to completion or fail with an
+// error. The call budget stops programs that recurse forever.
+func FuzzRun(f *testing.F) {
+	f.Add("let add = fn(x, y) { x + y; }; puts(add(1, 2));")
+	f.Add("let f = fn(n) { if (n < 2) { return n; } f(n - 1) + f(n - 2) }; f(10)")
+	f.Add("let loop = fn() { loop() }; loop()")
+	f.Add("let a = 1; let g = fn() { let a = a + 1; fn() { a } }; g()()")
+	f.Add(`[1, "two", len("three")][-1] + first(push([], 1 / 0))`)
+	output := object.Output
+	object.Output = io.Discard
+	defer func() { object.Output = output }()
+	f.Fuzz(func(t *testing.T, input string) {
+		p := parser.New(lexer.New(input))
+		program := p.ParseProgram()
+		if len(p.Errors) > 0 {
+			return
+		}
+		for _, level := range []int{0, 1, 2} {
+			comp := compiler.New()
+			comp.SetOptimizationLevel(level)
+			if comp.Compile(program) != nil {
+				return
+			}
+			err := Verify(comp.ByteCode())
+			if err != nil {
+				t.Fatalf("verifier rejected compiled bytecode at optimization level %d: %s", level, err)
+			}
+			vm := New(comp.ByteCode())
+			vm.callBudget = 10000
+			_ = vm.Run()
+		}
+	})
+}
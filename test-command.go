@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"writing-in-interpreter-in-go/src/monkey/ast"
+	"writing-in-interpreter-in-go/src/monkey/evaluator"
+	"writing-in-interpreter-in-go/src/monkey/object"
+	"writing-in-interpreter-in-go/src/monkey/tester"
+)
+
+// testEvent is a line of monkey test -json output, modelled on the events of
+// go test -json. Events without a test are about a whole file.
+type testEvent struct {
+	Action  string
+	File    string
+	Test    string  `json:",omitempty"`
+	Elapsed float64 `json:",omitempty"`
+	Output  string  `json:",omitempty"`
+}
+
+func testCommand(args []string) error {
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	verbose := flags.Bool("v", false, "print the name and output of every test")
+	asJSON := flags.Bool("json", false, "print events as JSON, one per line")
+	cover := flags.Bool("cover", false, "run the tests on the vm and report the lines and branches they cover")
+	coverProfile := flags.String("coverprofile", "", "write an LCOV coverage profile to `file` (implies -cover)")
+	_ = flags.Parse(args)
+	if *coverProfile != "" {
+		*cover = true
+	}
+
+	roots := flags.Args()
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+	paths, err := findTestFiles(roots)
+	if err != nil {
+		return err
+	}
+
+	var profile *os.File
+	if *coverProfile != "" {
+		profile, err = os.Create(*coverProfile)
+		if err != nil {
+			return err
+		}
+		defer profile.Close()
+	}
+
+	failed := 0
+	encoder := json.NewEncoder(os.Stdout)
+	for _, path := range paths {
+		program, err := parseFile(path)
+		if err != nil {
+			return err
+		}
+		macroEnvironment := object.NewEnvironment()
+		evaluator.DefineMacros(program, macroEnvironment)
+		expanded := evaluator.ExpandMacros(program, macroEnvironment).(*ast.Program)
+
+		names := tester.Discover(program)
+		if len(names) == 0 {
+			if *asJSON {
+				_ = encoder.Encode(testEvent{Action: "skip", File: path})
+			} else {
+				fmt.Printf("?   \t%s\t[no tests]\n", path)
+			}
+			continue
+		}
+
+		run := func(name string) tester.Result { return tester.Run(expanded, name) }
+		var machine *tester.Machine
+		if *cover {
+			machine, err = tester.NewMachine(expanded)
+			if err != nil {
+				return fmt.Errorf("%s: compilation failed:\n%s", path, err)
+			}
+			run = machine.Run
+		}
+
+		start := time.Now()
+		fileFailed := false
+		for _, name := range names {
+			result := run(name)
+			report := describeResult(path, result)
+			if result.Failure != nil {
+				fileFailed = true
+				failed++
+			}
+			if *asJSON {
+				_ = encoder.Encode(testEvent{Action: "run", File: path, Test: name})
+				if report != "" {
+					_ = encoder.Encode(testEvent{Action: "output", File: path, Test: name, Output: report})
+				}
+				_ = encoder.Encode(testEvent{Action: status(result.Failure == nil), File: path, Test: name,
+					Elapsed: result.Elapsed.Seconds()})
+				continue
+			}
+			if *verbose {
+				fmt.Printf("=== RUN   %s\n", name)
+			}
+			if *verbose || result.Failure != nil {
+				fmt.Printf("--- %s: %s (%.2fs)\n", strings.ToUpper(status(result.Failure == nil)), name,
+					result.Elapsed.Seconds())
+				fmt.Print(indent(report))
+			}
+		}
+
+		elapsed := time.Since(start)
+		summary := ""
+		if machine != nil {
+			summary = tester.CoverageSummary(machine.Coverage())
+			if profile != nil {
+				err := tester.WriteLCOV(profile, path, machine.Coverage())
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if *asJSON {
+			if summary != "" {
+				_ = encoder.Encode(testEvent{Action: "output", File: path, Output: summary + "\n"})
+			}
+			_ = encoder.Encode(testEvent{Action: status(!fileFailed), File: path, Elapsed: elapsed.Seconds()})
+			continue
+		}
+		if summary != "" {
+			summary = "\t" + summary
+		}
+		if fileFailed {
+			fmt.Printf("FAIL\t%s\t%.3fs%s\n", path, elapsed.Seconds(), summary)
+		} else {
+			fmt.Printf("ok  \t%s\t%.3fs%s\n", path, elapsed.Seconds(), summary)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d test(s) failed", failed)
+	}
+	return nil
+}
+
+// findTestFiles returns the test files among paths and in the directories
+// under them.
+func findTestFiles(roots []string) ([]string, error) {
+	var paths []string
+	for _, root := range roots {
+		err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !entry.IsDir() && (path == root || tester.IsTestFile(path)) {
+				paths = append(paths, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return paths, nil
+}
+
+// describeResult renders what a test printed followed by its failure, if
+// any, prefixed with its position in path.
+func describeResult(path string, result tester.Result) string {
+	report := result.Output
+	if failure := result.Failure; failure != nil {
+		if failure.Line > 0 {
+			report += fmt.Sprintf("%s:%d:%d: %s\n", path, failure.Line, failure.Column, failure.Message)
+		} else {
+			report += fmt.Sprintf("%s: %s\n", path, failure.Message)
+		}
+	}
+	return report
+}
+
+func status(passed bool) string {
+	if passed {
+		return "pass"
+	}
+	return "fail"
+}
+
+func indent(text string) string {
+	if text == "" {
+		return ""
+	}
+	return "    " + strings.ReplaceAll(strings.TrimSuffix(text, "\n"), "\n", "\n    ") + "\n"
+}